@@ -0,0 +1,45 @@
+package helpers
+
+import (
+	"net/http"
+
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+
+	"gitlab.com/gitlab-org/gitlab-runner/common"
+	"gitlab.com/gitlab-org/gitlab-runner/helpers/egress"
+)
+
+const defaultEgressProxyListenAddress = ":3128"
+
+type EgressProxyCommand struct {
+	Listen string `long:"listen" description:"Address on which the proxy listens"`
+	Policy string `long:"policy" description:"JSON encoded egress policy"`
+}
+
+func (c *EgressProxyCommand) Execute(*cli.Context) {
+	policy, err := egress.ParsePolicy(c.Policy)
+	if err != nil {
+		logrus.Fatalln(err)
+	}
+
+	proxy, err := egress.NewProxy(policy, logrus.WithField("module", "egress-proxy"))
+	if err != nil {
+		logrus.Fatalln(err)
+	}
+
+	logrus.WithField("address", c.Listen).Infoln("Starting egress proxy")
+
+	err = http.ListenAndServe(c.Listen, proxy)
+	if err != nil {
+		logrus.Fatalln(err)
+	}
+}
+
+func init() {
+	common.RegisterCommand2(
+		"egress-proxy",
+		"runs the proxy enforcing the job egress policy, used by docker executor (internal)",
+		&EgressProxyCommand{Listen: defaultEgressProxyListenAddress},
+	)
+}
@@ -0,0 +1,41 @@
+package helpers
+
+import (
+	"os"
+
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+
+	"gitlab.com/gitlab-org/gitlab-runner/common"
+	"gitlab.com/gitlab-org/gitlab-runner/helpers/process"
+)
+
+// SandboxInitCommand is the init process of the sandbox of the shell-sandbox
+// executor. It sets the mounts of the sandbox up and runs the command passed
+// after the flags, exiting with its exit code.
+type SandboxInitCommand struct {
+	WritablePaths  []string `long:"writable-path" description:"Path writable in the sandbox"`
+	DisableNetwork bool     `long:"disable-network" description:"Run the command without network access"`
+}
+
+func (c *SandboxInitCommand) Execute(ctx *cli.Context) {
+	options := process.SandboxOptions{
+		WritablePaths:  c.WritablePaths,
+		DisableNetwork: c.DisableNetwork,
+	}
+
+	exitCode, err := process.RunSandboxInit(options, ctx.Args())
+	if err != nil {
+		logrus.Fatalln("Sandbox:", err)
+	}
+
+	os.Exit(exitCode)
+}
+
+func init() {
+	common.RegisterCommand2(
+		process.SandboxInitCommand,
+		"runs a command in the sandbox of the shell-sandbox executor (internal)",
+		&SandboxInitCommand{},
+	)
+}
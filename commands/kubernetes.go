@@ -0,0 +1,107 @@
+package commands
+
+import (
+	"context"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+	clihelpers "gitlab.com/gitlab-org/golang-cli-helpers"
+
+	"gitlab.com/gitlab-org/gitlab-runner/common"
+	"gitlab.com/gitlab-org/gitlab-runner/executors/kubernetes"
+)
+
+//nolint:lll
+type KubernetesCleanupCommand struct {
+	configOptions
+
+	Name        string `short:"n" long:"name" description:"Name of the runner whose resources are cleaned up, all the Kubernetes runners by default"`
+	GracePeriod int    `long:"grace-period" description:"Minimal age (in seconds) of the resources that are deleted. Defaults to 3600"`
+	InstanceID  string `long:"instance-id" description:"Instance ID of the runner process whose resources are cleaned up, all the processes by default"`
+	DryRun      bool   `long:"dry-run" description:"Only list the resources that would be deleted"`
+}
+
+func (c *KubernetesCleanupCommand) runners() []*common.RunnerConfig {
+	if c.Name != "" {
+		runner, err := c.RunnerByName(c.Name)
+		if err != nil {
+			logrus.Fatalln(err)
+		}
+
+		return []*common.RunnerConfig{runner}
+	}
+
+	var runners []*common.RunnerConfig
+	for _, runner := range c.config.Runners {
+		if runner.Kubernetes != nil {
+			runners = append(runners, runner)
+		}
+	}
+
+	return runners
+}
+
+func (c *KubernetesCleanupCommand) gracePeriod(runner *common.RunnerConfig) time.Duration {
+	if c.GracePeriod > 0 {
+		return time.Duration(c.GracePeriod) * time.Second
+	}
+
+	return runner.Kubernetes.Reaper.GetGracePeriod()
+}
+
+// Execute deletes the resources left behind by the jobs of the runners.
+// Because the jobs running on the runners aren't known, it should be used
+// while the runners are stopped or with a grace period longer than the
+// jobs timeout.
+func (c *KubernetesCleanupCommand) Execute(_ *cli.Context) {
+	err := c.loadConfig()
+	if err != nil {
+		logrus.Fatalln(err)
+	}
+
+	failed := false
+	for _, runner := range c.runners() {
+		logger := logrus.WithField("runner", runner.ShortDescription())
+
+		reaped, err := kubernetes.CleanupOrphanedResources(
+			context.Background(),
+			runner,
+			c.gracePeriod(runner),
+			c.InstanceID,
+			c.DryRun,
+		)
+		if err != nil {
+			logger.WithError(err).Errorln("Failed to clean up orphaned resources")
+			failed = true
+		}
+
+		logger = logger.WithField("resources", len(reaped))
+		if c.DryRun {
+			logger.Println("Orphaned resources found")
+			continue
+		}
+		logger.Println("Orphaned resources cleaned up")
+	}
+
+	if failed {
+		logrus.Fatalln("Cleanup of orphaned resources failed")
+	}
+}
+
+func init() {
+	cmd := &KubernetesCleanupCommand{}
+
+	common.RegisterCommand(cli.Command{
+		Name:  "kubernetes",
+		Usage: "manage the resources of the Kubernetes executor",
+		Subcommands: []cli.Command{
+			{
+				Name:   "cleanup",
+				Usage:  "delete the resources left behind by the interrupted jobs",
+				Action: cmd.Execute,
+				Flags:  clihelpers.GetFlagsFromStruct(cmd),
+			},
+		},
+	})
+}
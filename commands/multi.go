@@ -496,7 +496,7 @@ func (mr *RunCommand) processRunner(
 	}
 	defer mr.buildsHelper.releaseBuild(runner)
 
-	buildSession, sessionInfo, err := mr.createSession(provider)
+	buildSession, sessionInfo, err := mr.createSession(provider, runner)
 	if err != nil {
 		return
 	}
@@ -540,10 +540,13 @@ func (mr *RunCommand) traceOutcome(trace common.JobTrace, err error) {
 // createSession checks if debug server is supported by configured executor and if the
 // debug server was configured. If both requirements are met, then it creates a debug session
 // that will be assigned to newly created job.
-func (mr *RunCommand) createSession(provider common.ExecutorProvider) (*session.Session, *common.SessionInfo, error) {
+func (mr *RunCommand) createSession(
+	provider common.ExecutorProvider,
+	runner *common.RunnerConfig,
+) (*session.Session, *common.SessionInfo, error) {
 	var features common.FeaturesInfo
 
-	if err := provider.GetFeatures(&features); err != nil {
+	if err := common.GetExecutorFeatures(provider, runner, &features); err != nil {
 		return nil, nil, err
 	}
 
@@ -745,6 +748,8 @@ func (mr *RunCommand) Stop(_ service.Service) error {
 		if mr.sessionServer != nil {
 			mr.sessionServer.Close()
 		}
+
+		common.StopExecutorProviders()
 	}()
 
 	// On Windows, we convert SIGTERM and SIGINT signals into a SIGQUIT.
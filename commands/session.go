@@ -0,0 +1,190 @@
+package commands
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"strconv"
+
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+	clihelpers "gitlab.com/gitlab-org/golang-cli-helpers"
+
+	"gitlab.com/gitlab-org/gitlab-runner/common"
+	"gitlab.com/gitlab-org/gitlab-runner/session"
+)
+
+//nolint:lll
+type SessionPortForwardCommand struct {
+	URL           string `long:"url" env:"SESSION_URL" description:"URL of the session of the job, for example https://runner.example.com:8093/session/<id>"`
+	Token         string `long:"token" env:"SESSION_TOKEN" description:"Authorization token of the session of the job"`
+	Service       string `long:"service" description:"Name or alias of the service"`
+	Port          string `long:"port" description:"Name or number of the port of the service"`
+	LocalAddress  string `long:"local-address" description:"Local address to listen on. Defaults to 127.0.0.1 with the port number of the service"`
+	TLSCAFile     string `long:"tls-ca-file" description:"File containing the certificate of the session server"`
+	TLSSkipVerify bool   `long:"tls-skip-verify" description:"Don't verify the certificate of the session server"`
+}
+
+func (c *SessionPortForwardCommand) validate() error {
+	options := []struct {
+		name  string
+		value string
+	}{
+		{name: "url", value: c.URL},
+		{name: "token", value: c.Token},
+		{name: "service", value: c.Service},
+		{name: "port", value: c.Port},
+	}
+
+	for _, option := range options {
+		if option.value == "" {
+			return fmt.Errorf("the --%s option is required", option.name)
+		}
+	}
+
+	return nil
+}
+
+func (c *SessionPortForwardCommand) localAddress() string {
+	if c.LocalAddress != "" {
+		return c.LocalAddress
+	}
+
+	port := "0"
+	if _, err := strconv.Atoi(c.Port); err == nil {
+		port = c.Port
+	}
+
+	return net.JoinHostPort("127.0.0.1", port)
+}
+
+func (c *SessionPortForwardCommand) tlsConfig() (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+	}
+
+	if c.TLSSkipVerify {
+		logrus.Warningln("The certificate of the session server isn't verified")
+		tlsConfig.InsecureSkipVerify = true
+
+		return tlsConfig, nil
+	}
+
+	if c.TLSCAFile == "" {
+		return tlsConfig, nil
+	}
+
+	data, err := ioutil.ReadFile(c.TLSCAFile)
+	if err != nil {
+		return nil, err
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificate found in %s", c.TLSCAFile)
+	}
+
+	tlsConfig.RootCAs = pool
+
+	return tlsConfig, nil
+}
+
+func (c *SessionPortForwardCommand) client() (*session.PortForwardClient, error) {
+	err := c.validate()
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig, err := c.tlsConfig()
+	if err != nil {
+		return nil, fmt.Errorf("loading TLS configuration: %w", err)
+	}
+
+	return &session.PortForwardClient{
+		URL:       c.URL,
+		Token:     c.Token,
+		Service:   c.Service,
+		Port:      c.Port,
+		TLSConfig: tlsConfig,
+	}, nil
+}
+
+// serve forwards the connections accepted by the listener until it's closed
+func (c *SessionPortForwardCommand) serve(listener net.Listener, client *session.PortForwardClient) error {
+	for {
+		conn, err := listener.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		go c.forward(conn, client)
+	}
+}
+
+func (c *SessionPortForwardCommand) forward(conn net.Conn, client *session.PortForwardClient) {
+	defer func() { _ = conn.Close() }()
+
+	logger := logrus.WithField("client", conn.RemoteAddr().String())
+	logger.Debugln("Forwarding connection")
+
+	ws, err := client.Dial()
+	if err != nil {
+		logger.WithError(err).Errorln("Failed to forward connection")
+		return
+	}
+	defer func() { _ = ws.Close() }()
+
+	err = session.ForwardWebSocket(ws, conn)
+	if err != nil {
+		logger.WithError(err).Debugln("Connection forwarding finished")
+	}
+}
+
+// Execute forwards a local port to the port of a service of a job, through
+// the session server of the runner running the job
+func (c *SessionPortForwardCommand) Execute(_ *cli.Context) {
+	client, err := c.client()
+	if err != nil {
+		logrus.Fatalln(err)
+	}
+
+	listener, err := net.Listen("tcp", c.localAddress())
+	if err != nil {
+		logrus.Fatalln(err)
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"address": listener.Addr().String(),
+		"service": c.Service,
+		"port":    c.Port,
+	}).Println("Forwarding connections")
+
+	err = c.serve(listener, client)
+	if err != nil {
+		logrus.Fatalln(err)
+	}
+}
+
+func init() {
+	cmd := &SessionPortForwardCommand{}
+
+	common.RegisterCommand(cli.Command{
+		Name:  "session",
+		Usage: "use the session of a running job",
+		Subcommands: []cli.Command{
+			{
+				Name:   "port-forward",
+				Usage:  "forward a local port to the port of a service of the job",
+				Action: cmd.Execute,
+				Flags:  clihelpers.GetFlagsFromStruct(cmd),
+			},
+		},
+	})
+}
@@ -0,0 +1,140 @@
+//go:build !integration
+// +build !integration
+
+package commands
+
+import (
+	"encoding/pem"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-runner/session"
+	"gitlab.com/gitlab-org/gitlab-runner/session/proxy"
+)
+
+func TestSessionPortForwardCommand_Validate(t *testing.T) {
+	cmd := &SessionPortForwardCommand{URL: "https://runner.example.com:8093/session/1234", Token: "token"}
+	assert.EqualError(t, cmd.validate(), "the --service option is required")
+
+	cmd.Service = "postgres"
+	assert.EqualError(t, cmd.validate(), "the --port option is required")
+
+	cmd.Port = "5432"
+	assert.NoError(t, cmd.validate())
+}
+
+func TestSessionPortForwardCommand_LocalAddress(t *testing.T) {
+	tests := map[string]struct {
+		port            string
+		localAddress    string
+		expectedAddress string
+	}{
+		"port number": {
+			port:            "5432",
+			expectedAddress: "127.0.0.1:5432",
+		},
+		"port name": {
+			port:            "db",
+			expectedAddress: "127.0.0.1:0",
+		},
+		"local address": {
+			port:            "5432",
+			localAddress:    "0.0.0.0:15432",
+			expectedAddress: "0.0.0.0:15432",
+		},
+	}
+
+	for tn, tc := range tests {
+		t.Run(tn, func(t *testing.T) {
+			cmd := &SessionPortForwardCommand{Port: tc.port, LocalAddress: tc.localAddress}
+			assert.Equal(t, tc.expectedAddress, cmd.localAddress())
+		})
+	}
+}
+
+type servicePooler struct {
+	pool proxy.Pool
+}
+
+func (p *servicePooler) Pool() proxy.Pool {
+	return p.pool
+}
+
+func TestSessionPortForwardCommand_Forward(t *testing.T) {
+	sess, err := session.NewSession(nil)
+	require.NoError(t, err)
+
+	serviceConn, forwardedConn := net.Pipe()
+	defer serviceConn.Close()
+
+	forwarder := new(proxy.MockPortForwarder)
+	defer forwarder.AssertExpectations(t)
+	forwarder.On("ForwardPort", mock.Anything, proxy.Port{Number: 5432}).Return(forwardedConn, nil).Once()
+
+	sess.SetProxyPool(&servicePooler{
+		pool: proxy.Pool{
+			"postgres": {
+				Settings:      proxy.NewProxySettings("postgres", []proxy.Port{{Number: 5432}}),
+				PortForwarder: forwarder,
+			},
+		},
+	})
+
+	server := httptest.NewTLSServer(sess.Handler())
+	defer server.Close()
+
+	certificate, err := ioutil.TempFile("", "session-certificate")
+	require.NoError(t, err)
+	defer os.Remove(certificate.Name())
+
+	err = pem.Encode(certificate, &pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	require.NoError(t, err)
+	require.NoError(t, certificate.Close())
+
+	cmd := &SessionPortForwardCommand{
+		URL:       server.URL + sess.Endpoint,
+		Token:     sess.Token,
+		Service:   "postgres",
+		Port:      "5432",
+		TLSCAFile: certificate.Name(),
+	}
+
+	client, err := cmd.client()
+	require.NoError(t, err)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	served := make(chan error)
+	go func() { served <- cmd.serve(listener, client) }()
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	_, err = conn.Write([]byte("ping"))
+	require.NoError(t, err)
+
+	data := make([]byte, 4)
+	_, err = io.ReadFull(serviceConn, data)
+	require.NoError(t, err)
+	assert.Equal(t, "ping", string(data))
+
+	_, err = serviceConn.Write([]byte("pong"))
+	require.NoError(t, err)
+
+	_, err = io.ReadFull(conn, data)
+	require.NoError(t, err)
+	assert.Equal(t, "pong", string(data))
+
+	require.NoError(t, listener.Close())
+	assert.NoError(t, <-served)
+}
@@ -1,6 +1,7 @@
 package common
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
@@ -45,6 +46,13 @@ const (
 	gitFetchFlagsNone    = "none"
 )
 
+// The recordings are uploaded as the artifacts archive of the job, GitLab
+// accepts a single archive for a job
+const (
+	sessionRecordingsArtifactName = "terminal-sessions.zip"
+	sessionRecordingsArtifactType = "archive"
+)
+
 type SubmoduleStrategy int
 
 const (
@@ -152,6 +160,11 @@ type Build struct {
 
 	Session *session.Session
 
+	// sessionRecorder records the terminal sessions, when the recording is
+	// enabled but can't be started the terminal is disabled
+	sessionRecorder  *session.Recorder
+	terminalDisabled bool
+
 	logger BuildLogger
 
 	allVariables     JobVariables
@@ -551,6 +564,12 @@ func (b *Build) executeUploadReferees(ctx context.Context, startTime, endTime ti
 			continue
 		}
 
+		if !IsArtifactTypeAccepted(referee.ArtifactType()) {
+			b.Log().WithField("type", referee.ArtifactType()).
+				Warning("Skipping the referee, GitLab doesn't accept its artifact type")
+			continue
+		}
+
 		reader, err := referee.Execute(ctx, startTime, endTime)
 		// keep moving even if a subset of the referees have failed
 		if err != nil {
@@ -566,6 +585,93 @@ func (b *Build) executeUploadReferees(ctx context.Context, startTime, endTime ti
 	}
 }
 
+// startSessionRecording records the terminal sessions of the job, the
+// terminal is disabled when its sessions can't be recorded
+func (b *Build) startSessionRecording(config *session.RecordingConfig) {
+	if b.Session == nil || !config.Enabled() {
+		return
+	}
+
+	variables := b.GetAllVariables()
+	metadata := session.RecordingMetadata{
+		JobID:       b.ID,
+		JobURL:      variables.Get("CI_JOB_URL"),
+		ProjectPath: variables.Get("CI_PROJECT_PATH"),
+		JobUser:     variables.Get("GITLAB_USER_LOGIN"),
+	}
+
+	recorder, err := session.NewRecorder(config, metadata, variables.Masked())
+	if err != nil {
+		b.Log().WithError(err).Warning("Failed to start the terminal session recording, the terminal is disabled")
+		b.terminalDisabled = true
+		return
+	}
+
+	b.sessionRecorder = recorder
+	b.Session.SetRecorder(recorder)
+}
+
+// finishSessionRecording waits for the end of the recorded terminal sessions
+// and uploads their recordings as the artifacts of the job when required. The
+// recordings which can't be uploaded are kept on the disk of the runner
+func (b *Build) finishSessionRecording(config *session.RecordingConfig) {
+	if b.sessionRecorder == nil {
+		return
+	}
+
+	files := b.sessionRecorder.Close()
+	if len(files) == 0 || !config.UploadAsArtifact {
+		b.cleanupSessionRecording()
+		return
+	}
+
+	if !b.uploadSessionRecording() {
+		b.Log().WithField("directory", b.sessionRecorder.Directory()).
+			Error("Failed to upload the terminal session recordings, they are kept in the directory")
+		return
+	}
+
+	b.cleanupSessionRecording()
+}
+
+func (b *Build) uploadSessionRecording() bool {
+	if b.ArtifactUploader == nil {
+		return false
+	}
+
+	archive := new(bytes.Buffer)
+	err := b.sessionRecorder.WriteArchive(archive)
+	if err != nil {
+		b.Log().WithError(err).Warning("Failed to archive the terminal session recordings")
+		return false
+	}
+
+	jobCredentials := JobCredentials{
+		ID:    b.JobResponse.ID,
+		Token: b.JobResponse.Token,
+		URL:   b.Runner.RunnerCredentials.URL,
+	}
+
+	state, _ := b.ArtifactUploader(jobCredentials, ioutil.NopCloser(archive), ArtifactsOptions{
+		BaseName: sessionRecordingsArtifactName,
+		Type:     sessionRecordingsArtifactType,
+		Format:   ArtifactFormatZip,
+	})
+	if state != UploadSucceeded {
+		b.Log().WithField("state", state).Warning("Failed to upload the terminal session recordings")
+		return false
+	}
+
+	return true
+}
+
+func (b *Build) cleanupSessionRecording() {
+	err := b.sessionRecorder.Cleanup()
+	if err != nil {
+		b.Log().WithError(err).Warning("Failed to remove the terminal session recordings")
+	}
+}
+
 func (b *Build) attemptExecuteStage(
 	ctx context.Context,
 	buildStage BuildStage,
@@ -629,7 +735,7 @@ func (b *Build) run(ctx context.Context, executor Executor) (err error) {
 	runContext, runCancel := context.WithCancel(context.Background())
 	defer runCancel()
 
-	if term, ok := executor.(terminal.InteractiveTerminal); b.Session != nil && ok {
+	if term, ok := executor.(terminal.InteractiveTerminal); b.Session != nil && !b.terminalDisabled && ok {
 		b.Session.SetInteractiveTerminal(term)
 	}
 
@@ -903,13 +1009,16 @@ func (b *Build) Run(globalConfig *Config, trace JobTrace) (err error) {
 	trace.SetAbortFunc(cancel)
 	trace.SetMasked(b.GetAllVariables().Masked())
 
+	b.startSessionRecording(globalConfig.SessionServer.Recording)
+	defer b.finishSessionRecording(globalConfig.SessionServer.Recording)
+
 	options := b.createExecutorPrepareOptions(ctx, globalConfig, trace)
 	provider := GetExecutorProvider(b.Runner.Executor)
 	if provider == nil {
 		return errors.New("executor not found")
 	}
 
-	err = provider.GetFeatures(&b.ExecutorFeatures)
+	err = GetExecutorFeatures(provider, b.Runner, &b.ExecutorFeatures)
 	if err != nil {
 		return fmt.Errorf("retrieving executor features: %w", err)
 	}
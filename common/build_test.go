@@ -4,10 +4,13 @@
 package common
 
 import (
+	"archive/zip"
 	"bytes"
 	"context"
 	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
@@ -25,6 +28,7 @@ import (
 
 	"gitlab.com/gitlab-org/gitlab-runner/helpers/docker"
 	"gitlab.com/gitlab-org/gitlab-runner/helpers/featureflags"
+	"gitlab.com/gitlab-org/gitlab-runner/referees"
 	"gitlab.com/gitlab-org/gitlab-runner/session"
 	"gitlab.com/gitlab-org/gitlab-runner/session/terminal"
 )
@@ -2064,3 +2068,158 @@ func TestSetTraceStatus(t *testing.T) {
 		})
 	}
 }
+
+func recordTerminalSession(t *testing.T, sess *session.Session, input string) {
+	mockConn := new(terminal.MockConn)
+	defer mockConn.AssertExpectations(t)
+	mockConn.On("Close").Return(nil).Once()
+	mockConn.
+		On("Start", mock.Anything, mock.Anything, mock.Anything, mock.Anything).
+		Run(func(args mock.Arguments) {
+			upgrader := &websocket.Upgrader{}
+			conn, err := upgrader.Upgrade(args[0].(http.ResponseWriter), args[1].(*http.Request), nil)
+			require.NoError(t, err)
+
+			_, data, err := conn.ReadMessage()
+			require.NoError(t, err)
+			_ = conn.WriteMessage(websocket.BinaryMessage, data)
+			_ = conn.Close()
+		}).Once()
+
+	mockTerminal := new(terminal.MockInteractiveTerminal)
+	defer mockTerminal.AssertExpectations(t)
+	mockTerminal.On("Connect").Return(mockConn, nil).Once()
+	sess.SetInteractiveTerminal(mockTerminal)
+
+	srv := httptest.NewServer(sess.Handler())
+	defer srv.Close()
+
+	u := url.URL{Scheme: "ws", Host: srv.Listener.Addr().String(), Path: sess.Endpoint + "/exec"}
+	conn, _, err := websocket.DefaultDialer.Dial(u.String(), http.Header{"Authorization": []string{sess.Token}})
+	require.NoError(t, err)
+
+	require.NoError(t, conn.WriteMessage(websocket.BinaryMessage, []byte(input)))
+	_, _, err = conn.ReadMessage()
+	require.NoError(t, err)
+	_ = conn.Close()
+}
+
+func TestBuildSessionRecording(t *testing.T) {
+	build := &Build{
+		Runner: &RunnerConfig{
+			RunnerCredentials: RunnerCredentials{URL: "https://gitlab.example.com"},
+		},
+		JobResponse: JobResponse{
+			ID:    1,
+			Token: "job-token",
+			Variables: JobVariables{
+				{Key: "GITLAB_USER_LOGIN", Value: "root", Public: true},
+				{Key: "SECRET", Value: "secret-value", Masked: true},
+			},
+		},
+	}
+	build.logger = NewBuildLogger(nil, build.Log())
+
+	sess, err := session.NewSession(nil)
+	require.NoError(t, err)
+	build.Session = sess
+
+	var uploadedOptions ArtifactsOptions
+	var uploaded []byte
+	build.ArtifactUploader = func(config JobCredentials, reader io.ReadCloser, options ArtifactsOptions) (UploadState, string) {
+		assert.Equal(t, JobCredentials{ID: 1, Token: "job-token", URL: "https://gitlab.example.com"}, config)
+
+		data, err := ioutil.ReadAll(reader)
+		require.NoError(t, err)
+
+		uploadedOptions = options
+		uploaded = data
+
+		return UploadSucceeded, ""
+	}
+
+	config := &session.RecordingConfig{UploadAsArtifact: true}
+	build.startSessionRecording(config)
+	require.False(t, build.terminalDisabled)
+
+	recordTerminalSession(t, sess, "echo secret-value\n")
+
+	build.finishSessionRecording(config)
+
+	assert.Equal(t, ArtifactsOptions{
+		BaseName: "terminal-sessions.zip",
+		Format:   ArtifactFormatZip,
+		Type:     "archive",
+	}, uploadedOptions)
+	assert.True(t, IsArtifactTypeAccepted(uploadedOptions.Type))
+
+	archive, err := zip.NewReader(bytes.NewReader(uploaded), int64(len(uploaded)))
+	require.NoError(t, err)
+	require.Len(t, archive.File, 1)
+
+	file, err := archive.File[0].Open()
+	require.NoError(t, err)
+	defer file.Close()
+
+	recording, err := ioutil.ReadAll(file)
+	require.NoError(t, err)
+	assert.Contains(t, string(recording), `"job_user":"root"`)
+	assert.Contains(t, string(recording), `"echo [MASKED]\n"`)
+	assert.NotContains(t, string(recording), "secret-value")
+}
+
+func TestBuildSessionRecordingUploadFailure(t *testing.T) {
+	build := &Build{Runner: &RunnerConfig{}}
+	build.logger = NewBuildLogger(nil, build.Log())
+
+	sess, err := session.NewSession(nil)
+	require.NoError(t, err)
+	build.Session = sess
+
+	build.ArtifactUploader = func(config JobCredentials, reader io.ReadCloser, options ArtifactsOptions) (UploadState, string) {
+		return UploadFailed, ""
+	}
+
+	config := &session.RecordingConfig{UploadAsArtifact: true}
+	build.startSessionRecording(config)
+	require.False(t, build.terminalDisabled)
+
+	recordTerminalSession(t, sess, "ls\n")
+
+	build.finishSessionRecording(config)
+
+	dir := build.sessionRecorder.Directory()
+	defer os.RemoveAll(dir)
+
+	files, err := ioutil.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Len(t, files, 1, "the recordings which can't be uploaded are kept")
+}
+
+func TestBuildArtifactTypesAccepted(t *testing.T) {
+	assert.True(t, IsArtifactTypeAccepted(sessionRecordingsArtifactType))
+	assert.True(t, IsArtifactTypeAccepted(new(referees.MetricsReferee).ArtifactType()))
+	assert.True(t, IsArtifactTypeAccepted(new(referees.KubernetesMetricsReferee).ArtifactType()))
+	assert.False(t, IsArtifactTypeAccepted("terminal_sessions"))
+}
+
+func TestBuildSessionRecordingFailure(t *testing.T) {
+	file, err := ioutil.TempFile("", "recordings")
+	require.NoError(t, err)
+	_ = file.Close()
+	defer os.Remove(file.Name())
+
+	build := &Build{Runner: &RunnerConfig{}}
+	build.logger = NewBuildLogger(nil, build.Log())
+
+	sess, err := session.NewSession(nil)
+	require.NoError(t, err)
+	build.Session = sess
+
+	// the recordings directory can't be created inside a file
+	config := &session.RecordingConfig{Directory: filepath.Join(file.Name(), "recordings")}
+	build.startSessionRecording(config)
+
+	assert.True(t, build.terminalDisabled)
+	build.finishSessionRecording(config)
+}
@@ -9,6 +9,8 @@ import (
 	"math/big"
 	"os"
 	"path/filepath"
+	"regexp"
+	"strconv"
 	"strings"
 	"time"
 
@@ -26,6 +28,7 @@ import (
 	"gitlab.com/gitlab-org/gitlab-runner/helpers/ssh"
 	"gitlab.com/gitlab-org/gitlab-runner/helpers/timeperiod"
 	"gitlab.com/gitlab-org/gitlab-runner/referees"
+	"gitlab.com/gitlab-org/gitlab-runner/session"
 )
 
 type DockerPullPolicy string
@@ -33,15 +36,24 @@ type DockerSysCtls map[string]string
 
 type KubernetesHookHandlerType string
 
+type KubernetesPodSpecPatchType string
+
 const (
 	PullPolicyAlways       = "always"
 	PullPolicyNever        = "never"
 	PullPolicyIfNotPresent = "if-not-present"
 
+	KeepFailedContainersCommit = "commit"
+	KeepFailedContainersStop   = "stop"
+
 	DNSPolicyNone                    KubernetesDNSPolicy = "none"
 	DNSPolicyDefault                 KubernetesDNSPolicy = "default"
 	DNSPolicyClusterFirst            KubernetesDNSPolicy = "cluster-first"
 	DNSPolicyClusterFirstWithHostNet KubernetesDNSPolicy = "cluster-first-with-host-net"
+
+	PatchTypeJSONPatch           KubernetesPodSpecPatchType = "json"
+	PatchTypeMergePatch          KubernetesPodSpecPatchType = "merge"
+	PatchTypeStrategicMergePatch KubernetesPodSpecPatchType = "strategic"
 )
 
 // InvalidTimePeriodsError represents that the time period specified is not valid.
@@ -134,6 +146,16 @@ type DockerConfig struct {
 	CapDrop                    []string          `toml:"cap_drop" json:"cap_drop" long:"cap-drop" env:"DOCKER_CAP_DROP" description:"Drop Linux capabilities"`
 	OomKillDisable             bool              `toml:"oom_kill_disable,omitzero" json:"oom_kill_disable" long:"oom-kill-disable" env:"DOCKER_OOM_KILL_DISABLE" description:"Do not kill processes in a container if an out-of-memory (OOM) error occurs"`
 	OomScoreAdjust             int               `toml:"oom_score_adjust,omitzero" json:"oom_score_adjust" long:"oom-score-adjust" env:"DOCKER_OOM_SCORE_ADJUST" description:"Adjust OOM score"`
+	Ulimits                    []string          `toml:"ulimits,omitempty" json:"ulimits" long:"ulimits" env:"DOCKER_ULIMITS" description:"Ulimit options for the build container (format: <type>=<soft limit>[:<hard limit>])"`
+	PidsLimit                  int64             `toml:"pids_limit,omitzero" json:"pids_limit" long:"pids-limit" env:"DOCKER_PIDS_LIMIT" description:"Maximum number of processes in the build container. Set to -1 for unlimited"`
+	CgroupParent               string            `toml:"cgroup_parent,omitempty" json:"cgroup_parent" long:"cgroup-parent" env:"DOCKER_CGROUP_PARENT" description:"Parent cgroup for the build container"`
+	BlkioWeight                uint16            `toml:"blkio_weight,omitzero" json:"blkio_weight" long:"blkio-weight" env:"DOCKER_BLKIO_WEIGHT" description:"Block IO relative weight of the build container, between 10 and 1000"`
+	DeviceCgroupRules          []string          `toml:"device_cgroup_rules,omitempty" json:"device_cgroup_rules" long:"device-cgroup-rules" env:"DOCKER_DEVICE_CGROUP_RULES" description:"Rules added to the cgroup allowed devices list of the build container (format: <type> <major>:<minor> <access>)"`
+	ServicesUlimits            []string          `toml:"services_ulimits,omitempty" json:"services_ulimits" long:"services-ulimits" env:"DOCKER_SERVICES_ULIMITS" description:"Ulimit options for the service containers (format: <type>=<soft limit>[:<hard limit>])"`
+	ServicesPidsLimit          int64             `toml:"services_pids_limit,omitzero" json:"services_pids_limit" long:"services-pids-limit" env:"DOCKER_SERVICES_PIDS_LIMIT" description:"Maximum number of processes in each service container. Set to -1 for unlimited"`
+	ServicesCgroupParent       string            `toml:"services_cgroup_parent,omitempty" json:"services_cgroup_parent" long:"services-cgroup-parent" env:"DOCKER_SERVICES_CGROUP_PARENT" description:"Parent cgroup for the service containers"`
+	ServicesBlkioWeight        uint16            `toml:"services_blkio_weight,omitzero" json:"services_blkio_weight" long:"services-blkio-weight" env:"DOCKER_SERVICES_BLKIO_WEIGHT" description:"Block IO relative weight of the service containers, between 10 and 1000"`
+	ServicesDeviceCgroupRules  []string          `toml:"services_device_cgroup_rules,omitempty" json:"services_device_cgroup_rules" long:"services-device-cgroup-rules" env:"DOCKER_SERVICES_DEVICE_CGROUP_RULES" description:"Rules added to the cgroup allowed devices list of the service containers (format: <type> <major>:<minor> <access>)"`
 	SecurityOpt                []string          `toml:"security_opt" json:"security_opt" long:"security-opt" env:"DOCKER_SECURITY_OPT" description:"Security Options"`
 	Devices                    []string          `toml:"devices" json:"devices" long:"devices" env:"DOCKER_DEVICES" description:"Add a host device to the container"`
 	Gpus                       string            `toml:"gpus,omitempty" json:"gpus" long:"gpus" env:"DOCKER_GPUS" description:"Request GPUs to be used by Docker"`
@@ -157,6 +179,21 @@ type DockerConfig struct {
 	HelperImage                string            `toml:"helper_image,omitempty" json:"helper_image" long:"helper-image" env:"DOCKER_HELPER_IMAGE" description:"[ADVANCED] Override the default helper image used to clone repos and upload artifacts"`
 	HelperImageFlavor          string            `toml:"helper_image_flavor,omitempty" json:"helper_image_flavor" long:"helper-image-flavor" env:"DOCKER_HELPER_IMAGE_FLAVOR" description:"Set helper image flavor (alpine, ubuntu), defaults to alpine"`
 	ContainerLabels            map[string]string `toml:"container_labels,omitempty" json:"container_labels" long:"container-labels" description:"A toml table/json object of key-value. Value is expected to be a string. When set, this will create containers with the given container labels. Environment variables will be substituted for values here."`
+	EgressPolicy               *DockerEgress     `toml:"egress_policy,omitempty" json:"egress_policy" description:"Restrict the destinations that the job containers can connect to"`
+	KeepFailedContainers       string            `toml:"keep_failed_containers,omitempty" json:"keep_failed_containers" long:"keep-failed-containers" env:"DOCKER_KEEP_FAILED_CONTAINERS" description:"Keep the build container of a failed job for debugging: 'commit' snapshots it to a local image tagged with the job ID, 'stop' keeps the stopped container. Disabled when empty"`
+	FailedContainersTTL        int               `toml:"failed_containers_ttl,omitzero" json:"failed_containers_ttl" long:"failed-containers-ttl" env:"DOCKER_FAILED_CONTAINERS_TTL" description:"How long (in seconds) kept failed build containers and their images are retained. Defaults to 24 hours"`
+}
+
+//nolint:lll
+type DockerEgress struct {
+	Allowed           []string                       `toml:"allowed,omitempty" json:"allowed" description:"CIDRs, IP addresses and hostnames that the job containers can connect to. Hostnames may start with '*.' to allow all subdomains"`
+	ServiceExceptions []DockerEgressServiceException `toml:"service_exceptions,omitempty" json:"service_exceptions" description:"Additional destinations allowed for specific services"`
+}
+
+//nolint:lll
+type DockerEgressServiceException struct {
+	Image   string   `toml:"image" json:"image" description:"The image of the service, matched like the allowed_services patterns"`
+	Allowed []string `toml:"allowed" json:"allowed" description:"CIDRs, IP addresses and hostnames that the containers of the service can connect to additionally"`
 }
 
 //nolint:lll
@@ -171,6 +208,10 @@ type DockerMachine struct {
 	MachineDriver   string   `long:"machine-driver" env:"MACHINE_DRIVER" description:"The driver to use when creating machine"`
 	MachineName     string   `long:"machine-name" env:"MACHINE_NAME" description:"The template for machine name (needs to include %s)"`
 	MachineOptions  []string `long:"machine-options" env:"MACHINE_OPTIONS" description:"Additional machine creation options"`
+	Plugin          string   `long:"plugin" env:"MACHINE_PLUGIN" description:"Path to the instance group plugin used instead of docker-machine to create the machines"`
+	PluginOptions   []string `long:"plugin-options" env:"MACHINE_PLUGIN_OPTIONS" description:"Arguments passed to the instance group plugin"`
+	PluginTimeout   int      `toml:"PluginTimeout,omitzero" long:"plugin-timeout" env:"MACHINE_PLUGIN_TIMEOUT" description:"Timeout (in seconds) of a single call to the instance group plugin, after which the plugin is restarted. Defaults to 300"`
+	PersistState    bool     `toml:"PersistState,omitzero" long:"persist-state" env:"MACHINE_PERSIST_STATE" description:"Keep the details of the machines in a state file next to the configuration file, to restore them after a restart of the runner"`
 
 	OffPeakPeriods   []string `toml:"OffPeakPeriods,omitempty" description:"Time periods when the scheduler is in the OffPeak mode. DEPRECATED"`                                // DEPRECATED
 	OffPeakTimezone  string   `toml:"OffPeakTimezone,omitempty" description:"Timezone for the OffPeak periods (defaults to Local). DEPRECATED"`                                 // DEPRECATED
@@ -178,6 +219,28 @@ type DockerMachine struct {
 	OffPeakIdleTime  int      `toml:"OffPeakIdleTime,omitzero" description:"Minimum time after machine can be destroyed when the scheduler is in the OffPeak mode. DEPRECATED"` // DEPRECATED
 
 	AutoscalingConfigs []*DockerMachineAutoscaling `toml:"autoscaling" description:"Ordered list of configurations for autoscaling periods (last match wins)"`
+	Predictive         *DockerMachinePredictive    `toml:"predictive,omitempty" description:"(Experimental) Size the pool of Idle machines from the history of job arrivals instead of IdleCount"`
+	HealthCheck        *DockerMachineHealthCheck   `toml:"health_check,omitempty" description:"Periodically check the Idle machines and replace the unhealthy ones"`
+
+	// configDir is the directory of the configuration file the settings were loaded from
+	configDir string
+}
+
+//nolint:lll
+type DockerMachineHealthCheck struct {
+	Interval         int `long:"interval" description:"How often (in seconds) an Idle machine is checked. Defaults to 60"`
+	Timeout          int `long:"timeout" description:"Timeout (in seconds) of a single check. Defaults to 10"`
+	MinDiskFree      int `long:"min-disk-free" description:"Minimal free disk space (in MB) in the Docker root directory. 0 disables the check"`
+	MaxClockSkew     int `long:"max-clock-skew" description:"Maximal difference (in seconds) between the clocks of the machine and the runner. 0 disables the check"`
+	FailureThreshold int `long:"failure-threshold" description:"Number of consecutive failed checks after which the machine is removed. Defaults to 2"`
+}
+
+//nolint:lll
+type DockerMachinePredictive struct {
+	StateFile    string `long:"state-file" description:"File in which the history of job arrivals is stored, relative to the directory of the configuration file. Defaults to autoscaling_history.json"`
+	MinIdleCount int    `long:"min-idle-count" description:"Minimal number of Idle machines, regardless of the prediction"`
+	MaxIdleCount int    `long:"max-idle-count" description:"Maximum number of Idle machines, regardless of the prediction. 0 means no limit"`
+	LookAhead    int    `long:"look-ahead" description:"How far ahead (in seconds) the demand is predicted. Defaults to 900"`
 }
 
 //nolint:lll
@@ -226,10 +289,103 @@ type CustomConfig struct {
 	CleanupArgs        []string `toml:"cleanup_args,omitempty" json:"cleanup_args" long:"cleanup-args" description:"Arguments for the cleanup executable"`
 	CleanupExecTimeout *int     `toml:"cleanup_exec_timeout,omitempty" json:"cleanup_exec_timeout" long:"cleanup-exec-timeout" env:"CUSTOM_CLEANUP_EXEC_TIMEOUT" description:"Timeout for the cleanup executable (in seconds)"`
 
+	DriverExec string   `toml:"driver_exec,omitempty" json:"driver_exec" long:"driver-exec" env:"CUSTOM_DRIVER_EXEC" description:"Executable of a driver started once for each job, which receives the prepare, run, cleanup and cancel calls as JSON-RPC 2.0 requests on its standard input. When set, it replaces the config, prepare, run and cleanup executables"`
+	DriverArgs []string `toml:"driver_args,omitempty" json:"driver_args" long:"driver-args" description:"Arguments for the driver executable"`
+
+	TerminalExec string   `toml:"terminal_exec,omitempty" json:"terminal_exec" long:"terminal-exec" env:"CUSTOM_TERMINAL_EXEC" description:"Executable that attaches its standard input and outputs to an interactive shell in the executor, for the interactive web terminals"`
+	TerminalArgs []string `toml:"terminal_args,omitempty" json:"terminal_args" long:"terminal-args" description:"Arguments for the terminal executable"`
+
 	GracefulKillTimeout *int `toml:"graceful_kill_timeout,omitempty" json:"graceful_kill_timeout" long:"graceful-kill-timeout" env:"CUSTOM_GRACEFUL_KILL_TIMEOUT" description:"Graceful timeout for scripts execution after SIGTERM is sent to the process (in seconds). This limits the time given for scripts to perform the cleanup before exiting"`
 	ForceKillTimeout    *int `toml:"force_kill_timeout,omitempty" json:"force_kill_timeout" long:"force-kill-timeout" env:"CUSTOM_FORCE_KILL_TIMEOUT" description:"Force timeout for scripts execution (in seconds). Counted from the force kill call; if process will be not terminated, Runner will abandon process termination and log an error"`
 }
 
+//nolint:lll
+type ShellSandboxConfig struct {
+	WritablePaths  []string `toml:"writable_paths,omitempty" json:"writable_paths" long:"writable-paths" env:"SHELL_SANDBOX_WRITABLE_PATHS" description:"Additional paths writable by the jobs. The root filesystem is read-only, except for the builds and cache directories"`
+	DisableNetwork bool     `toml:"disable_network,omitzero" json:"disable_network" long:"disable-network" env:"SHELL_SANDBOX_DISABLE_NETWORK" description:"Run the scripts of the jobs without network access. The steps of the runner, like fetching the sources or uploading the artifacts, keep it"`
+	PassEnv        []string `toml:"pass_env,omitempty" json:"pass_env" long:"pass-env" env:"SHELL_SANDBOX_PASS_ENV" description:"Additional variables of the environment of the runner passed to the jobs. Only PATH, HOME, USER, LOGNAME, SHELL, LANG, LANGUAGE, LC_*, TZ and TERM are passed by default"`
+}
+
+//nolint:lll
+type ShellCgroupConfig struct {
+	Parent    string `toml:"parent" json:"parent" long:"parent" env:"SHELL_CGROUP_PARENT" description:"Path of the cgroup v2 delegated to the runner user, in which a cgroup is created for each job"`
+	MemoryMax string `toml:"memory_max,omitempty" json:"memory_max" long:"memory-max" env:"SHELL_CGROUP_MEMORY_MAX" description:"Memory limit of the jobs. Number with optional unit (b, k, m, g)"`
+	CPUMax    string `toml:"cpu_max,omitempty" json:"cpu_max" long:"cpu-max" env:"SHELL_CGROUP_CPU_MAX" description:"Number of CPUs available to the jobs, for example 1.5"`
+	PidsMax   int64  `toml:"pids_max,omitzero" json:"pids_max" long:"pids-max" env:"SHELL_CGROUP_PIDS_MAX" description:"Maximum number of processes of the jobs"`
+}
+
+// GetLimits returns the limits of the cgroups of the jobs
+func (c *ShellCgroupConfig) GetLimits() (process.CgroupLimits, error) {
+	limits := process.CgroupLimits{PidsMax: c.PidsMax}
+
+	if c.MemoryMax != "" {
+		bytes, err := units.RAMInBytes(c.MemoryMax)
+		if err != nil {
+			return limits, fmt.Errorf("parsing memory_max: %w", err)
+		}
+		limits.MemoryMax = bytes
+	}
+
+	if c.CPUMax != "" {
+		cpus, err := strconv.ParseFloat(c.CPUMax, 64)
+		if err != nil || cpus <= 0 {
+			return limits, fmt.Errorf("invalid cpu_max %q, a positive number of CPUs is expected", c.CPUMax)
+		}
+		limits.CPUMax = cpus
+	}
+
+	return limits, nil
+}
+
+const (
+	SSHPoolSelectionLeastBusy  = "least-busy"
+	SSHPoolSelectionRoundRobin = "round-robin"
+	SSHPoolSelectionRandom     = "random"
+)
+
+const (
+	defaultSSHPoolHealthCheckInterval = 30 * time.Second
+	defaultSSHPoolHealthCheckTimeout  = 10 * time.Second
+)
+
+//nolint:lll
+type SSHPoolConfig struct {
+	Hosts               []string `toml:"hosts" json:"hosts" long:"hosts" env:"SSH_POOL_HOSTS" description:"Hosts of the pool, as host or host:port. They replace the host and the port of the [runners.ssh] section"`
+	Selection           string   `toml:"selection,omitempty" json:"selection" long:"selection" env:"SSH_POOL_SELECTION" description:"Selection of the host of a job: least-busy (default), round-robin or random"`
+	MaxJobsPerHost      int      `toml:"max_jobs_per_host,omitzero" json:"max_jobs_per_host" long:"max-jobs-per-host" env:"SSH_POOL_MAX_JOBS_PER_HOST" description:"Maximum number of concurrent jobs on each host, 0 for no limit"`
+	HealthCheckInterval int      `toml:"health_check_interval,omitzero" json:"health_check_interval" long:"health-check-interval" env:"SSH_POOL_HEALTH_CHECK_INTERVAL" description:"Time, in seconds, after which the health of a host is checked again before its next job. Failing hosts are out of rotation during this time. Defaults to 30 seconds"`
+	HealthCheckTimeout  int      `toml:"health_check_timeout,omitzero" json:"health_check_timeout" long:"health-check-timeout" env:"SSH_POOL_HEALTH_CHECK_TIMEOUT" description:"Timeout, in seconds, of the health checks of the hosts. Defaults to 10 seconds"`
+}
+
+// GetSelection returns the selection of the hosts, least-busy by default
+func (c *SSHPoolConfig) GetSelection() (string, error) {
+	switch c.Selection {
+	case "":
+		return SSHPoolSelectionLeastBusy, nil
+	case SSHPoolSelectionLeastBusy, SSHPoolSelectionRoundRobin, SSHPoolSelectionRandom:
+		return c.Selection, nil
+	}
+
+	return "", fmt.Errorf("invalid SSH pool selection %q, one of %s, %s or %s is expected",
+		c.Selection, SSHPoolSelectionLeastBusy, SSHPoolSelectionRoundRobin, SSHPoolSelectionRandom)
+}
+
+func (c *SSHPoolConfig) GetHealthCheckInterval() time.Duration {
+	if c.HealthCheckInterval <= 0 {
+		return defaultSSHPoolHealthCheckInterval
+	}
+
+	return time.Duration(c.HealthCheckInterval) * time.Second
+}
+
+func (c *SSHPoolConfig) GetHealthCheckTimeout() time.Duration {
+	if c.HealthCheckTimeout <= 0 {
+		return defaultSSHPoolHealthCheckTimeout
+	}
+
+	return time.Duration(c.HealthCheckTimeout) * time.Second
+}
+
 type KubernetesPullPolicy string
 
 // GetPullPolicies returns a validated list of pull policies, falling back to a predefined value if empty,
@@ -295,6 +451,7 @@ type KubernetesConfig struct {
 	Image                                             string                             `toml:"image" json:"image" long:"image" env:"KUBERNETES_IMAGE" description:"Default docker image to use for builds when none is specified"`
 	Namespace                                         string                             `toml:"namespace" json:"namespace" long:"namespace" env:"KUBERNETES_NAMESPACE" description:"Namespace to run Kubernetes jobs in"`
 	NamespaceOverwriteAllowed                         string                             `toml:"namespace_overwrite_allowed" json:"namespace_overwrite_allowed" long:"namespace_overwrite_allowed" env:"KUBERNETES_NAMESPACE_OVERWRITE_ALLOWED" description:"Regex to validate 'KUBERNETES_NAMESPACE_OVERWRITE' value"`
+	EphemeralNamespace                                KubernetesEphemeralNamespace       `toml:"ephemeral_namespace,omitempty" json:"ephemeral_namespace" namespace:"ephemeral-namespace" description:"Run each job in its own namespace, created for the job and deleted after it"`
 	Privileged                                        *bool                              `toml:"privileged,omitzero" json:"privileged" long:"privileged" env:"KUBERNETES_PRIVILEGED" description:"Run all containers with the privileged flag enabled"`
 	RuntimeClassName                                  *string                            `toml:"runtime_class_name,omitempty" json:"runtime_class_name" long:"runtime-class-name" env:"KUBERNETES_RUNTIME_CLASS_NAME" description:"A Runtime Class to use for all created pods, errors if the feature is unsupported by the cluster"`
 	AllowPrivilegeEscalation                          *bool                              `toml:"allow_privilege_escalation,omitzero" json:"allow_privilege_escalation" long:"allow-privilege-escalation" env:"KUBERNETES_ALLOW_PRIVILEGE_ESCALATION" description:"Run all containers with the security context allowPrivilegeEscalation flag enabled. When empty, it does not define the allowPrivilegeEscalation flag in the container SecurityContext and allows Kubernetes to use the default privilege escalation behavior."`
@@ -365,6 +522,61 @@ type KubernetesConfig struct {
 	DNSPolicy                                         KubernetesDNSPolicy                `toml:"dns_policy,omitempty" json:"dns_policy" long:"dns-policy" env:"KUBERNETES_DNS_POLICY" description:"How Kubernetes should try to resolve DNS from the created pods. If unset, Kubernetes will use the default 'ClusterFirst'. Valid values are: none, default, cluster-first, cluster-first-with-host-net"`
 	DNSConfig                                         KubernetesDNSConfig                `toml:"dns_config" json:"dns_config" description:"Pod DNS config"`
 	ContainerLifecycle                                KubernetesContainerLifecyle        `toml:"container_lifecycle,omitempty" json:"container_lifecycle,omitempty" description:"Actions that the management system should take in response to container lifecycle events"`
+	PodSpec                                           []KubernetesPodSpec                `toml:"pod_spec,omitempty" json:"pod_spec,omitempty" description:"Patches applied, in order, to the spec of the generated build pod"`
+	Reaper                                            KubernetesReaper                   `toml:"reaper,omitempty" json:"reaper" namespace:"reaper" description:"Periodic cleanup of the resources left behind by jobs that are no longer running"`
+	BuildsDirPVCPool                                  KubernetesPVCPool                  `toml:"builds_dir_pvc_pool,omitempty" json:"builds_dir_pvc_pool" namespace:"builds-dir-pvc-pool" description:"Keep the builds directory of each project in a persistent volume claim reused by its next jobs"`
+	PodSpecOverwriteAllowed                           []string                           `toml:"pod_spec_overwrite_allowed,omitempty" json:"pod_spec_overwrite_allowed" long:"pod-spec-overwrite-allowed" env:"KUBERNETES_POD_SPEC_OVERWRITE_ALLOWED" description:"Fields of the pod spec (for example priorityClassName) that can be changed by the patch of the KUBERNETES_POD_SPEC_PATCH variable"`
+}
+
+//nolint:lll
+type KubernetesEphemeralNamespace struct {
+	Enabled       bool                   `toml:"enabled,omitzero" json:"enabled" long:"enabled" env:"KUBERNETES_EPHEMERAL_NAMESPACE_ENABLED" description:"Create a namespace for each job. The namespace, or its overwrite, is used as the prefix of the name"`
+	ResourceQuota map[string]string      `toml:"resource_quota,omitempty" json:"resource_quota" long:"resource-quota" env:"KUBERNETES_EPHEMERAL_NAMESPACE_RESOURCE_QUOTA" description:"A toml table/json object of resource:quantity. Hard limits of the ResourceQuota of the namespace"`
+	LimitRange    KubernetesLimitRange   `toml:"limit_range,omitempty" json:"limit_range" namespace:"limit-range" description:"Limits of the containers in the namespace"`
+	AllowedEgress []KubernetesEgressRule `toml:"allowed_egress,omitempty" json:"allowed_egress" description:"Egress traffic allowed by the NetworkPolicy of the namespace, all other traffic is denied"`
+}
+
+//nolint:lll
+type KubernetesLimitRange struct {
+	Default        map[string]string `toml:"default,omitempty" json:"default" long:"default" description:"A toml table/json object of resource:quantity. Default limits of the containers"`
+	DefaultRequest map[string]string `toml:"default_request,omitempty" json:"default_request" long:"default-request" description:"A toml table/json object of resource:quantity. Default requests of the containers"`
+	Max            map[string]string `toml:"max,omitempty" json:"max" long:"max" description:"A toml table/json object of resource:quantity. Maximal limits of the containers"`
+	Min            map[string]string `toml:"min,omitempty" json:"min" long:"min" description:"A toml table/json object of resource:quantity. Minimal requests of the containers"`
+}
+
+func (l *KubernetesLimitRange) IsEmpty() bool {
+	return len(l.Default) == 0 && len(l.DefaultRequest) == 0 && len(l.Max) == 0 && len(l.Min) == 0
+}
+
+//nolint:lll
+type KubernetesEgressRule struct {
+	CIDR     string   `toml:"cidr" json:"cidr" description:"Destination IP block, for example 10.0.0.0/8"`
+	Except   []string `toml:"except,omitempty" json:"except" description:"IP blocks excluded from the destination"`
+	Ports    []int32  `toml:"ports,omitempty" json:"ports" description:"Destination ports. All ports are allowed when empty"`
+	Protocol string   `toml:"protocol,omitempty" json:"protocol" description:"Protocol of the ports: TCP (default), UDP or SCTP"`
+}
+
+//nolint:lll
+type KubernetesPVCPool struct {
+	Enabled      bool   `toml:"enabled,omitzero" json:"enabled" long:"enabled" env:"KUBERNETES_BUILDS_DIR_PVC_POOL_ENABLED" description:"Mount a persistent volume claim of the project as the builds directory"`
+	StorageClass string `toml:"storage_class,omitempty" json:"storage_class" long:"storage-class" env:"KUBERNETES_BUILDS_DIR_PVC_POOL_STORAGE_CLASS" description:"Storage class of the claims. The default storage class of the cluster is used when empty"`
+	Size         string `toml:"size,omitempty" json:"size" long:"size" env:"KUBERNETES_BUILDS_DIR_PVC_POOL_SIZE" description:"Requested size of the claims. Defaults to 10Gi"`
+	MaxClaims    int    `toml:"max_claims,omitzero" json:"max_claims" long:"max-claims" env:"KUBERNETES_BUILDS_DIR_PVC_POOL_MAX_CLAIMS" description:"Maximal number of claims of the runner, the least recently used ones are deleted above it. Defaults to 10"`
+}
+
+//nolint:lll
+type KubernetesReaper struct {
+	Interval    int    `toml:"interval,omitzero" json:"interval" long:"interval" env:"KUBERNETES_REAPER_INTERVAL" description:"How often (in seconds) the resources left behind by the jobs of the runner are looked for. 0 disables the cleanup"`
+	GracePeriod int    `toml:"grace_period,omitzero" json:"grace_period" long:"grace-period" env:"KUBERNETES_REAPER_GRACE_PERIOD" description:"Minimal age (in seconds) of the resources that are deleted. Defaults to 3600"`
+	InstanceID  string `toml:"instance_id,omitempty" json:"instance_id" long:"instance-id" env:"KUBERNETES_REAPER_INSTANCE_ID" description:"ID of this runner process, set as a label on the resources of its jobs. Only the resources of this ID are reaped. Defaults to the hostname. Must be unique across the runner processes sharing the runner token"`
+}
+
+//nolint:lll
+type KubernetesPodSpec struct {
+	Name      string                     `toml:"name" json:"name" long:"name" description:"Name of the patch, used in the job log"`
+	PatchPath string                     `toml:"patch_path,omitempty" json:"patch_path,omitempty" long:"patch-path" description:"Path to the file containing the patch. Can't be used together with patch"`
+	Patch     string                     `toml:"patch,omitempty" json:"patch,omitempty" long:"patch" description:"The patch, in JSON or YAML format"`
+	PatchType KubernetesPodSpecPatchType `toml:"patch_type,omitempty" json:"patch_type,omitempty" long:"patch-type" description:"Type of the patch: json, merge or strategic. Defaults to strategic"`
 }
 
 //nolint:lll
@@ -676,6 +888,33 @@ func (h *KubernetesLifecycleHandler) ToKubernetesLifecycleHandler() *api.Handler
 	return kubeHandler
 }
 
+//nolint:lll
+type KubernetesServiceReadinessProbe struct {
+	Exec                *KubernetesLifecycleExecAction `toml:"exec,omitempty" json:"exec" description:"Exec specifies the command executed in the service container to check whether it's ready"`
+	HTTPGet             *KubernetesLifecycleHTTPGet    `toml:"http_get,omitempty" json:"http_get" description:"HTTPGet specifies the http request performed to check whether the service is ready"`
+	TCPSocket           *KubernetesLifecycleTCPSocket  `toml:"tcp_socket,omitempty" json:"tcp_socket" description:"TCPSocket specifies the TCP port connected to check whether the service is ready"`
+	InitialDelaySeconds int32                          `toml:"initial_delay_seconds,omitempty" json:"initial_delay_seconds" description:"Number of seconds after the service container has started before the probe is initiated"`
+	PeriodSeconds       int32                          `toml:"period_seconds,omitempty" json:"period_seconds" description:"How often (in seconds) to perform the probe. Defaults to 10 seconds"`
+	TimeoutSeconds      int32                          `toml:"timeout_seconds,omitempty" json:"timeout_seconds" description:"Number of seconds after which the probe times out. Defaults to 1 second"`
+}
+
+// ToKubernetesProbe converts the readiness probe of a service to the one
+// from the Kubernetes API
+func (p *KubernetesServiceReadinessProbe) ToKubernetesProbe() *api.Probe {
+	handler := &KubernetesLifecycleHandler{
+		Exec:      p.Exec,
+		HTTPGet:   p.HTTPGet,
+		TCPSocket: p.TCPSocket,
+	}
+
+	return &api.Probe{
+		Handler:             *handler.ToKubernetesLifecycleHandler(),
+		InitialDelaySeconds: p.InitialDelaySeconds,
+		PeriodSeconds:       p.PeriodSeconds,
+		TimeoutSeconds:      p.TimeoutSeconds,
+	}
+}
+
 type NodeSelector struct {
 	NodeSelectorTerms []NodeSelectorTerm `toml:"node_selector_terms" json:"node_selector_terms"`
 }
@@ -720,6 +959,15 @@ type Service struct {
 	Alias      string   `toml:"alias,omitempty" long:"alias" description:"The alias of the service"`
 	Command    []string `toml:"command" long:"command" description:"Command or script that should be used as the container’s command. Syntax is similar to https://docs.docker.com/engine/reference/builder/#cmd"`
 	Entrypoint []string `toml:"entrypoint" long:"entrypoint" description:"Command or script that should be executed as the container’s entrypoint. syntax is similar to https://docs.docker.com/engine/reference/builder/#entrypoint"`
+
+	CPULimit                string `toml:"cpu_limit,omitempty" long:"cpu-limit" description:"The CPU allocation given to the service container (Kubernetes executor only)"`
+	CPURequest              string `toml:"cpu_request,omitempty" long:"cpu-request" description:"The CPU allocation requested for the service container (Kubernetes executor only)"`
+	MemoryLimit             string `toml:"memory_limit,omitempty" long:"memory-limit" description:"The amount of memory allocated to the service container (Kubernetes executor only)"`
+	MemoryRequest           string `toml:"memory_request,omitempty" long:"memory-request" description:"The amount of memory requested for the service container (Kubernetes executor only)"`
+	EphemeralStorageLimit   string `toml:"ephemeral_storage_limit,omitempty" long:"ephemeral-storage-limit" description:"The amount of ephemeral storage allocated to the service container (Kubernetes executor only)"`
+	EphemeralStorageRequest string `toml:"ephemeral_storage_request,omitempty" long:"ephemeral-storage-request" description:"The amount of ephemeral storage requested for the service container (Kubernetes executor only)"`
+
+	ReadinessProbe *KubernetesServiceReadinessProbe `toml:"readiness_probe,omitempty" description:"The probe checking whether the service is ready, the job waits for the service to be ready before running its script (Kubernetes executor only)"`
 }
 
 func (s *Service) ToImageDefinition() Image {
@@ -825,6 +1073,10 @@ type RunnerSettings struct {
 	Machine    *DockerMachine    `toml:"machine,omitempty" json:"machine" group:"docker machine provider" namespace:"machine"`
 	Kubernetes *KubernetesConfig `toml:"kubernetes,omitempty" json:"kubernetes" group:"kubernetes executor" namespace:"kubernetes"`
 	Custom     *CustomConfig     `toml:"custom,omitempty" json:"custom" group:"custom executor" namespace:"custom"`
+
+	ShellSandbox *ShellSandboxConfig `toml:"shell_sandbox,omitempty" json:"shell_sandbox" group:"shell-sandbox executor" namespace:"shell_sandbox"`
+	ShellCgroup  *ShellCgroupConfig  `toml:"shell_cgroup,omitempty" json:"shell_cgroup" group:"shell executors cgroup" namespace:"shell_cgroup"`
+	SSHPool      *SSHPoolConfig      `toml:"ssh_pool,omitempty" json:"ssh_pool" group:"ssh executor hosts pool" namespace:"ssh_pool"`
 }
 
 //nolint:lll
@@ -843,6 +1095,8 @@ type SessionServer struct {
 	ListenAddress    string `toml:"listen_address,omitempty" json:"listen_address" description:"Address that the runner will communicate directly with"`
 	AdvertiseAddress string `toml:"advertise_address,omitempty" json:"advertise_address" description:"Address the runner will expose to the world to connect to the session server"`
 	SessionTimeout   int    `toml:"session_timeout,omitempty" json:"session_timeout" description:"How long a terminal session can be active after a build completes, in seconds"`
+
+	Recording *session.RecordingConfig `toml:"recording,omitempty" json:"recording" description:"Record the terminal sessions"`
 }
 
 //nolint:lll
@@ -997,6 +1251,106 @@ func (c *DockerConfig) GetOomKillDisable() *bool {
 	return &c.OomKillDisable
 }
 
+// GetKeepFailedContainers returns the validated mode of keeping failed build containers.
+// An empty string means that failed build containers are removed as usual.
+func (c *DockerConfig) GetKeepFailedContainers() (string, error) {
+	switch c.KeepFailedContainers {
+	case "", KeepFailedContainersCommit, KeepFailedContainersStop:
+		return c.KeepFailedContainers, nil
+	default:
+		return "", fmt.Errorf(
+			"unsupported keep_failed_containers %q, expected %q or %q",
+			c.KeepFailedContainers,
+			KeepFailedContainersCommit,
+			KeepFailedContainersStop,
+		)
+	}
+}
+
+func (c *DockerConfig) GetFailedContainersTTL() time.Duration {
+	if c.FailedContainersTTL <= 0 {
+		return DefaultFailedContainersTTL
+	}
+
+	return time.Duration(c.FailedContainersTTL) * time.Second
+}
+
+// DockerResourceLimits groups the kernel level limits that are applied to
+// a single container, on top of the memory and CPU settings.
+type DockerResourceLimits struct {
+	Ulimits           []*units.Ulimit
+	PidsLimit         *int64
+	CgroupParent      string
+	BlkioWeight       uint16
+	DeviceCgroupRules []string
+}
+
+var deviceCgroupRuleRegex = regexp.MustCompile(`^([acb]) ([0-9]+|\*):([0-9]+|\*) ([rwm]{1,3})$`)
+
+// GetResourceLimits returns the validated resource limits for the build container
+func (c *DockerConfig) GetResourceLimits() (DockerResourceLimits, error) {
+	return newDockerResourceLimits(c.Ulimits, c.PidsLimit, c.CgroupParent, c.BlkioWeight, c.DeviceCgroupRules, "")
+}
+
+// GetServicesResourceLimits returns the validated resource limits for the service containers
+func (c *DockerConfig) GetServicesResourceLimits() (DockerResourceLimits, error) {
+	return newDockerResourceLimits(
+		c.ServicesUlimits,
+		c.ServicesPidsLimit,
+		c.ServicesCgroupParent,
+		c.ServicesBlkioWeight,
+		c.ServicesDeviceCgroupRules,
+		"services_",
+	)
+}
+
+func newDockerResourceLimits(
+	ulimits []string,
+	pidsLimit int64,
+	cgroupParent string,
+	blkioWeight uint16,
+	deviceCgroupRules []string,
+	fieldPrefix string,
+) (DockerResourceLimits, error) {
+	limits := DockerResourceLimits{
+		CgroupParent:      cgroupParent,
+		BlkioWeight:       blkioWeight,
+		DeviceCgroupRules: deviceCgroupRules,
+	}
+
+	for _, ulimit := range ulimits {
+		parsed, err := units.ParseUlimit(ulimit)
+		if err != nil {
+			return DockerResourceLimits{}, fmt.Errorf("parsing docker %sulimits %q: %w", fieldPrefix, ulimit, err)
+		}
+
+		limits.Ulimits = append(limits.Ulimits, parsed)
+	}
+
+	switch {
+	case pidsLimit < -1:
+		return DockerResourceLimits{}, fmt.Errorf("invalid docker %spids_limit %d: must be -1 or greater", fieldPrefix, pidsLimit)
+	case pidsLimit != 0:
+		limits.PidsLimit = &pidsLimit
+	}
+
+	if blkioWeight != 0 && (blkioWeight < 10 || blkioWeight > 1000) {
+		return DockerResourceLimits{}, fmt.Errorf(
+			"invalid docker %sblkio_weight %d: must be between 10 and 1000",
+			fieldPrefix,
+			blkioWeight,
+		)
+	}
+
+	for _, rule := range deviceCgroupRules {
+		if !deviceCgroupRuleRegex.MatchString(rule) {
+			return DockerResourceLimits{}, fmt.Errorf("invalid docker %sdevice_cgroup_rules entry %q", fieldPrefix, rule)
+		}
+	}
+
+	return limits, nil
+}
+
 func (c *KubernetesConfig) GetPollAttempts() int {
 	if c.PollTimeout <= 0 {
 		c.PollTimeout = KubernetesPollTimeout
@@ -1131,6 +1485,70 @@ func (c *KubernetesConfig) GetDNSConfig() *api.PodDNSConfig {
 	return &config
 }
 
+func (p *KubernetesPVCPool) GetSize() string {
+	if p.Size == "" {
+		return DefaultKubernetesPVCPoolSize
+	}
+
+	return p.Size
+}
+
+func (p *KubernetesPVCPool) GetMaxClaims() int {
+	if p.MaxClaims <= 0 {
+		return DefaultKubernetesPVCPoolMaxClaims
+	}
+
+	return p.MaxClaims
+}
+
+func (r *KubernetesReaper) GetInterval() time.Duration {
+	return time.Duration(r.Interval) * time.Second
+}
+
+func (r *KubernetesReaper) GetGracePeriod() time.Duration {
+	if r.GracePeriod <= 0 {
+		return DefaultKubernetesReaperGracePeriod
+	}
+
+	return time.Duration(r.GracePeriod) * time.Second
+}
+
+// GetInstanceID returns the ID of the runner process used to tell apart the
+// resources of the processes sharing the runner token, the hostname by default
+func (r *KubernetesReaper) GetInstanceID() string {
+	if r.InstanceID != "" {
+		return r.InstanceID
+	}
+
+	hostname, _ := os.Hostname()
+
+	return hostname
+}
+
+// PatchAndType returns the content of the patch, read from PatchPath when
+// it's set, and the type of the patch
+func (s *KubernetesPodSpec) PatchAndType() ([]byte, KubernetesPodSpecPatchType, error) {
+	if s.PatchPath != "" && s.Patch != "" {
+		return nil, "", fmt.Errorf("pod spec patch %q: patch_path and patch can't be used together", s.Name)
+	}
+
+	patch := []byte(s.Patch)
+	if s.PatchPath != "" {
+		var err error
+		patch, err = ioutil.ReadFile(s.PatchPath)
+		if err != nil {
+			return nil, "", fmt.Errorf("pod spec patch %q: %w", s.Name, err)
+		}
+	}
+
+	patchType := s.PatchType
+	if patchType == "" {
+		patchType = PatchTypeStrategicMergePatch
+	}
+
+	return patch, patchType, nil
+}
+
 func (c *KubernetesConfig) GetNodeAffinity() *api.NodeAffinity {
 	var nodeAffinity api.NodeAffinity
 
@@ -1339,6 +1757,16 @@ func (c *DockerMachine) GetIdleTime() int {
 	return c.IdleTime
 }
 
+// ResolvePath returns the path relative to the directory of the configuration
+// file, unless it's absolute or the settings weren't loaded from a file
+func (c *DockerMachine) ResolvePath(path string) string {
+	if path == "" || filepath.IsAbs(path) || c.configDir == "" {
+		return path
+	}
+
+	return filepath.Join(c.configDir, path)
+}
+
 // getActiveAutoscalingConfig returns the autoscaling config matching the current time.
 // It goes through the [[docker.machine.autoscaling]] entries and returns the last one to match.
 // Returns nil on no matching entries.
@@ -1366,6 +1794,59 @@ func (c *DockerMachine) CompilePeriods() error {
 	return nil
 }
 
+func (c *DockerMachinePredictive) GetStateFile() string {
+	if c.StateFile == "" {
+		return DefaultPredictiveStateFile
+	}
+
+	return c.StateFile
+}
+
+func (c *DockerMachinePredictive) GetLookAhead() time.Duration {
+	if c.LookAhead <= 0 {
+		return DefaultPredictiveLookAhead
+	}
+
+	return time.Duration(c.LookAhead) * time.Second
+}
+
+// GetIdleCount bounds the number of Idle machines derived from the prediction
+func (c *DockerMachinePredictive) GetIdleCount(predicted int) int {
+	if c.MaxIdleCount > 0 && predicted > c.MaxIdleCount {
+		predicted = c.MaxIdleCount
+	}
+
+	if predicted < c.MinIdleCount {
+		predicted = c.MinIdleCount
+	}
+
+	return predicted
+}
+
+func (c *DockerMachineHealthCheck) GetInterval() time.Duration {
+	if c.Interval <= 0 {
+		return DefaultHealthCheckInterval
+	}
+
+	return time.Duration(c.Interval) * time.Second
+}
+
+func (c *DockerMachineHealthCheck) GetTimeout() time.Duration {
+	if c.Timeout <= 0 {
+		return DefaultHealthCheckTimeout
+	}
+
+	return time.Duration(c.Timeout) * time.Second
+}
+
+func (c *DockerMachineHealthCheck) GetFailureThreshold() int {
+	if c.FailureThreshold <= 0 {
+		return DefaultHealthCheckFailureThreshold
+	}
+
+	return c.FailureThreshold
+}
+
 var periodTimer = time.Now
 
 func (a *DockerMachineAutoscaling) compilePeriods() error {
@@ -1528,6 +2009,7 @@ func (c *Config) LoadConfig(configFile string) error {
 			return err
 		}
 		runner.Machine.logDeprecationWarning()
+		runner.Machine.configDir = filepath.Dir(configFile)
 	}
 
 	c.ModTime = info.ModTime()
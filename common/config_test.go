@@ -5,10 +5,12 @@ package common
 
 import (
 	"fmt"
+	"path/filepath"
 	"testing"
 	"time"
 
 	"github.com/BurntSushi/toml"
+	"github.com/docker/go-units"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	api "k8s.io/api/core/v1"
@@ -1117,6 +1119,44 @@ func TestDockerMachine(t *testing.T) {
 	}
 }
 
+func TestDockerMachine_ResolvePath(t *testing.T) {
+	configDir := filepath.Join("etc", "gitlab-runner")
+	absolute, err := filepath.Abs(filepath.Join("var", "lib", "state.json"))
+	require.NoError(t, err)
+
+	tests := map[string]struct {
+		configDir    string
+		path         string
+		expectedPath string
+	}{
+		"empty path": {
+			configDir:    configDir,
+			expectedPath: "",
+		},
+		"relative path": {
+			configDir:    configDir,
+			path:         "state.json",
+			expectedPath: filepath.Join(configDir, "state.json"),
+		},
+		"absolute path": {
+			configDir:    configDir,
+			path:         absolute,
+			expectedPath: absolute,
+		},
+		"not loaded from a file": {
+			path:         "state.json",
+			expectedPath: "state.json",
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			c := &DockerMachine{configDir: tt.configDir}
+			assert.Equal(t, tt.expectedPath, c.ResolvePath(tt.path))
+		})
+	}
+}
+
 func TestRunnerSettings_GetGracefulKillTimeout_GetForceKillTimeout(t *testing.T) {
 	tests := map[string]struct {
 		config                      RunnerSettings
@@ -1202,6 +1242,111 @@ func TestDockerConfig_GetPullPolicies(t *testing.T) {
 	}
 }
 
+func TestDockerConfig_GetResourceLimits(t *testing.T) {
+	pidsLimit := int64(512)
+	unlimitedPids := int64(-1)
+
+	tests := map[string]struct {
+		config         DockerConfig
+		expectedLimits DockerResourceLimits
+		expectedErr    bool
+	}{
+		"no limits": {
+			config:         DockerConfig{},
+			expectedLimits: DockerResourceLimits{},
+		},
+		"all limits set": {
+			config: DockerConfig{
+				Ulimits:           []string{"nofile=1024:2048", "nproc=256"},
+				PidsLimit:         512,
+				CgroupParent:      "/gitlab-runner",
+				BlkioWeight:       300,
+				DeviceCgroupRules: []string{"c 1:3 mr", "b *:* rwm"},
+			},
+			expectedLimits: DockerResourceLimits{
+				Ulimits: []*units.Ulimit{
+					{Name: "nofile", Soft: 1024, Hard: 2048},
+					{Name: "nproc", Soft: 256, Hard: 256},
+				},
+				PidsLimit:         &pidsLimit,
+				CgroupParent:      "/gitlab-runner",
+				BlkioWeight:       300,
+				DeviceCgroupRules: []string{"c 1:3 mr", "b *:* rwm"},
+			},
+		},
+		"unlimited pids": {
+			config:         DockerConfig{PidsLimit: -1},
+			expectedLimits: DockerResourceLimits{PidsLimit: &unlimitedPids},
+		},
+		"invalid ulimit type": {
+			config:      DockerConfig{Ulimits: []string{"unknown=1"}},
+			expectedErr: true,
+		},
+		"invalid ulimit soft limit above hard limit": {
+			config:      DockerConfig{Ulimits: []string{"nofile=2048:1024"}},
+			expectedErr: true,
+		},
+		"invalid pids limit": {
+			config:      DockerConfig{PidsLimit: -2},
+			expectedErr: true,
+		},
+		"blkio weight too low": {
+			config:      DockerConfig{BlkioWeight: 5},
+			expectedErr: true,
+		},
+		"blkio weight too high": {
+			config:      DockerConfig{BlkioWeight: 1001},
+			expectedErr: true,
+		},
+		"invalid device cgroup rule": {
+			config:      DockerConfig{DeviceCgroupRules: []string{"x 1:3 rwm"}},
+			expectedErr: true,
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			limits, err := tt.config.GetResourceLimits()
+
+			if tt.expectedErr {
+				assert.Error(t, err)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expectedLimits, limits)
+		})
+	}
+}
+
+func TestDockerConfig_GetServicesResourceLimits(t *testing.T) {
+	pidsLimit := int64(64)
+
+	config := DockerConfig{
+		Ulimits:                   []string{"nofile=1024"},
+		PidsLimit:                 512,
+		ServicesUlimits:           []string{"nproc=128"},
+		ServicesPidsLimit:         64,
+		ServicesCgroupParent:      "/gitlab-runner-services",
+		ServicesBlkioWeight:       100,
+		ServicesDeviceCgroupRules: []string{"c 1:3 r"},
+	}
+
+	limits, err := config.GetServicesResourceLimits()
+	require.NoError(t, err)
+	assert.Equal(t, DockerResourceLimits{
+		Ulimits:           []*units.Ulimit{{Name: "nproc", Soft: 128, Hard: 128}},
+		PidsLimit:         &pidsLimit,
+		CgroupParent:      "/gitlab-runner-services",
+		BlkioWeight:       100,
+		DeviceCgroupRules: []string{"c 1:3 r"},
+	}, limits)
+
+	config.ServicesBlkioWeight = 1
+	_, err = config.GetServicesResourceLimits()
+	assert.EqualError(t, err, "invalid docker services_blkio_weight 1: must be between 10 and 1000")
+}
+
 func TestKubernetesConfig_GetPullPolicies(t *testing.T) {
 	tests := map[string]struct {
 		config               KubernetesConfig
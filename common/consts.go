@@ -24,6 +24,15 @@ const DefaultCacheRequestTimeout = 10
 const DefaultNetworkClientTimeout = 60 * time.Minute
 const DefaultSessionTimeout = 30 * time.Minute
 const WaitForBuildFinishTimeout = 5 * time.Minute
+const DefaultFailedContainersTTL = 24 * time.Hour
+const DefaultPredictiveLookAhead = 15 * time.Minute
+const DefaultPredictiveStateFile = "autoscaling_history.json"
+const DefaultHealthCheckInterval = time.Minute
+const DefaultHealthCheckTimeout = 10 * time.Second
+const DefaultHealthCheckFailureThreshold = 2
+const DefaultKubernetesReaperGracePeriod = time.Hour
+const DefaultKubernetesPVCPoolSize = "10Gi"
+const DefaultKubernetesPVCPoolMaxClaims = 10
 const SecretVariableDefaultsToFile = true
 
 const (
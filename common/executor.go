@@ -132,6 +132,39 @@ func MakeBuildError(format string, args ...interface{}) error {
 
 var executorProviders map[string]ExecutorProvider
 
+// RunnerFeaturesProvider is implemented by the executor providers whose
+// features depend on the configuration of the runner
+type RunnerFeaturesProvider interface {
+	// GetRunnerFeatures returns the features the executor supports with the
+	// configuration of the runner
+	GetRunnerFeatures(config *RunnerConfig, features *FeaturesInfo) error
+}
+
+// GetExecutorFeatures returns the features of the executor of the runner
+func GetExecutorFeatures(provider ExecutorProvider, config *RunnerConfig, features *FeaturesInfo) error {
+	if p, ok := provider.(RunnerFeaturesProvider); ok && config != nil {
+		return p.GetRunnerFeatures(config, features)
+	}
+
+	return provider.GetFeatures(features)
+}
+
+// StoppableExecutorProvider is implemented by the executor providers running
+// background work, which must be stopped when the runner shuts down
+type StoppableExecutorProvider interface {
+	Stop()
+}
+
+// StopExecutorProviders stops the background work of the registered executor
+// providers
+func StopExecutorProviders() {
+	for _, provider := range executorProviders {
+		if p, ok := provider.(StoppableExecutorProvider); ok {
+			p.Stop()
+		}
+	}
+}
+
 func validateExecutorProvider(provider ExecutorProvider) error {
 	if provider.GetDefaultShell() == "" {
 		return errors.New("default shell not implemented")
@@ -261,6 +261,45 @@ const (
 	ArtifactFormatRaw     ArtifactFormat = "raw"
 )
 
+// artifactTypes are the file types of the job artifacts accepted by GitLab,
+// they mirror Ci::JobArtifact.file_types. A job has a single artifact of
+// each type
+var artifactTypes = map[string]bool{
+	"archive":                true,
+	"metadata":               true,
+	"trace":                  true,
+	"junit":                  true,
+	"metrics":                true,
+	"metrics_referee":        true,
+	"network_referee":        true,
+	"dotenv":                 true,
+	"cobertura":              true,
+	"terraform":              true,
+	"accessibility":          true,
+	"cluster_applications":   true,
+	"secret_detection":       true,
+	"requirements":           true,
+	"coverage_fuzzing":       true,
+	"browser_performance":    true,
+	"load_performance":       true,
+	"api_fuzzing":            true,
+	"cluster_image_scanning": true,
+	"cyclonedx":              true,
+	"sast":                   true,
+	"dependency_scanning":    true,
+	"container_scanning":     true,
+	"dast":                   true,
+	"codequality":            true,
+	"license_scanning":       true,
+	"performance":            true,
+	"lsif":                   true,
+}
+
+// IsArtifactTypeAccepted returns whether GitLab accepts the artifacts of the type
+func IsArtifactTypeAccepted(artifactType string) bool {
+	return artifactTypes[artifactType]
+}
+
 type Artifact struct {
 	Name      string          `json:"name"`
 	Untracked bool            `json:"untracked"`
@@ -5,6 +5,11 @@ package api
 // This should be used to pass the configuration values from Custom Executor
 // driver to the Runner.
 type ConfigExecOutput struct {
+	// APIVersion is the version of the output. The fields of the later
+	// versions are only used when the version is set, the driver can read
+	// the latest version supported by the Runner from APIVersionVariable.
+	APIVersion *int `json:"api_version,omitempty"`
+
 	Driver *DriverInfo `json:"driver,omitempty"`
 
 	Hostname  *string `json:"hostname,omitempty"`
@@ -14,6 +19,31 @@ type ConfigExecOutput struct {
 	BuildsDirIsShared *bool `json:"builds_dir_is_shared,omitempty"`
 
 	JobEnv *map[string]string `json:"job_env,omitempty"`
+
+	// Since version 2
+
+	Features *Features `json:"features,omitempty"`
+	Shell    *string   `json:"shell,omitempty"`
+
+	GracefulKillTimeout *int `json:"graceful_kill_timeout,omitempty"`
+	ForceKillTimeout    *int `json:"force_kill_timeout,omitempty"`
+}
+
+// HasVersion2Fields returns true when the fields introduced in version 2 are
+// set
+func (c *ConfigExecOutput) HasVersion2Fields() bool {
+	return c.Features != nil ||
+		c.Shell != nil ||
+		c.GracefulKillTimeout != nil ||
+		c.ForceKillTimeout != nil
+}
+
+// Features wraps the features of the environments created by the Custom
+// Executor driver
+type Features struct {
+	Services    *bool `json:"services,omitempty"`
+	Terminal    *bool `json:"terminal,omitempty"`
+	SharedCache *bool `json:"shared_cache,omitempty"`
 }
 
 // DriverInfo wraps the information about Custom Executor driver details
@@ -12,4 +12,21 @@ const (
 	// The name of the variable used to pass the value of path to the file that
 	// contains JSON encoded content of job API received from GitLab's API
 	JobResponseFileVariable = "JOB_RESPONSE_FILE"
+
+	// The name of the variable used to pass the latest version of the
+	// config_exec output supported by Runner
+	APIVersionVariable = "CUSTOM_EXECUTOR_API_VERSION"
+)
+
+const (
+	// APIVersion1 is the initial version of the config_exec output
+	APIVersion1 = 1
+
+	// APIVersion2 adds the features of the environments, the shell and the
+	// kill timeouts to the config_exec output
+	APIVersion2 = 2
+
+	// LatestAPIVersion is the latest version of the config_exec output
+	// supported by Runner
+	LatestAPIVersion = APIVersion2
 )
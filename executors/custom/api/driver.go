@@ -0,0 +1,94 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// DriverProtocolVersion is the version of the JSON-RPC protocol used between
+// the Runner and the driver started by driver_exec
+const DriverProtocolVersion = "2.0"
+
+// The methods of the driver protocol. The prepare, run and cleanup requests
+// are sent by the Runner and answered by the driver, the cancel notification
+// is sent by the Runner and the output notifications are sent by the driver.
+const (
+	DriverMethodPrepare = "prepare"
+	DriverMethodRun     = "run"
+	DriverMethodCleanup = "cleanup"
+	DriverMethodCancel  = "cancel"
+	DriverMethodOutput  = "output"
+)
+
+// The streams of the output notifications
+const (
+	DriverStreamStdout = "stdout"
+	DriverStreamStderr = "stderr"
+)
+
+// DriverMessage is a JSON-RPC 2.0 request, notification or response
+// exchanged with the driver. The messages are written on a single line.
+type DriverMessage struct {
+	JSONRPC string `json:"jsonrpc"`
+
+	// ID is set for the requests and their responses, it's nil for the
+	// notifications
+	ID *int64 `json:"id,omitempty"`
+
+	Method string          `json:"method,omitempty"`
+	Params json.RawMessage `json:"params,omitempty"`
+
+	Result json.RawMessage `json:"result,omitempty"`
+	Error  *DriverError    `json:"error,omitempty"`
+}
+
+// DriverError is the error of a response of the driver. It's reported as a
+// system failure of the job.
+type DriverError struct {
+	Code    int             `json:"code"`
+	Message string          `json:"message"`
+	Data    json.RawMessage `json:"data,omitempty"`
+}
+
+func (e *DriverError) Error() string {
+	return fmt.Sprintf("driver error %d: %s", e.Code, e.Message)
+}
+
+// DriverPrepareResult is the result of the prepare request, it has the same
+// semantics as the output of config_exec
+type DriverPrepareResult = ConfigExecOutput
+
+// DriverRunParams are the parameters of the run request. Env holds the
+// job_env variables of the prepare result, the driver must set them in the
+// environment of the script.
+type DriverRunParams struct {
+	Stage  string            `json:"stage"`
+	Script string            `json:"script"`
+	Env    map[string]string `json:"env,omitempty"`
+}
+
+// DriverCleanupParams are the parameters of the cleanup request, Env holds
+// the job_env variables of the prepare result
+type DriverCleanupParams struct {
+	Env map[string]string `json:"env,omitempty"`
+}
+
+// DriverRunResult is the result of the run request. A non-zero exit code is
+// reported as a failure of the script of the job.
+type DriverRunResult struct {
+	ExitCode int `json:"exit_code"`
+}
+
+// DriverCancelParams are the parameters of the cancel notification, sent when
+// the job is canceled or times out during a request
+type DriverCancelParams struct {
+	RequestID int64 `json:"request_id"`
+}
+
+// DriverOutputParams are the parameters of the output notifications, which
+// stream the output of a request to the job log
+type DriverOutputParams struct {
+	RequestID *int64 `json:"request_id,omitempty"`
+	Stream    string `json:"stream"`
+	Data      string `json:"data"`
+}
@@ -48,18 +48,7 @@ func New(
 	cmdOpts process.CommandOptions,
 	options Options,
 ) Command {
-	defaultVariables := map[string]string{
-		"TMPDIR":                          cmdOpts.Dir,
-		api.BuildFailureExitCodeVariable:  strconv.Itoa(BuildFailureExitCode),
-		api.SystemFailureExitCodeVariable: strconv.Itoa(SystemFailureExitCode),
-		api.JobResponseFileVariable:       options.JobResponseFile,
-	}
-
-	env := os.Environ()
-	for key, value := range defaultVariables {
-		env = append(env, fmt.Sprintf("%s=%s", key, value))
-	}
-	cmdOpts.Env = append(env, cmdOpts.Env...)
+	cmdOpts.Env = Env(cmdOpts.Dir, cmdOpts.Env, options)
 
 	return &command{
 		context:             ctx,
@@ -71,6 +60,26 @@ func New(
 	}
 }
 
+// Env returns the environment of the driver executables: the environment of
+// the runner, the variables of the custom executor API and the variables set
+// by the executor
+func Env(dir string, env []string, options Options) []string {
+	defaultVariables := map[string]string{
+		"TMPDIR":                          dir,
+		api.BuildFailureExitCodeVariable:  strconv.Itoa(BuildFailureExitCode),
+		api.SystemFailureExitCodeVariable: strconv.Itoa(SystemFailureExitCode),
+		api.JobResponseFileVariable:       options.JobResponseFile,
+		api.APIVersionVariable:            strconv.Itoa(api.LatestAPIVersion),
+	}
+
+	result := os.Environ()
+	for key, value := range defaultVariables {
+		result = append(result, fmt.Sprintf("%s=%s", key, value))
+	}
+
+	return append(result, env...)
+}
+
 func (c *command) Run() error {
 	err := c.cmd.Start()
 	if err != nil {
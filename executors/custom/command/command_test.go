@@ -158,3 +158,14 @@ func TestCommand_Run(t *testing.T) {
 		})
 	}
 }
+
+func TestEnv(t *testing.T) {
+	env := Env("/tmp/dir", []string{"CUSTOM_ENV_CI_JOB_ID=1"}, Options{JobResponseFile: "/tmp/dir/response.json"})
+
+	assert.Contains(t, env, "TMPDIR=/tmp/dir")
+	assert.Contains(t, env, "BUILD_FAILURE_EXIT_CODE=1")
+	assert.Contains(t, env, "SYSTEM_FAILURE_EXIT_CODE=2")
+	assert.Contains(t, env, "JOB_RESPONSE_FILE=/tmp/dir/response.json")
+	assert.Contains(t, env, "CUSTOM_EXECUTOR_API_VERSION=2")
+	assert.Equal(t, "CUSTOM_ENV_CI_JOB_ID=1", env[len(env)-1])
+}
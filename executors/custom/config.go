@@ -9,6 +9,11 @@ import (
 
 type config struct {
 	*common.CustomConfig
+
+	// the kill timeouts set by config_exec override the ones of the
+	// configuration
+	gracefulKillTimeout *int
+	forceKillTimeout    *int
 }
 
 func (c *config) GetConfigExecTimeout() time.Duration {
@@ -24,10 +29,18 @@ func (c *config) GetCleanupScriptTimeout() time.Duration {
 }
 
 func (c *config) GetGracefulKillTimeout() time.Duration {
+	if c.gracefulKillTimeout != nil {
+		return getDuration(c.gracefulKillTimeout, process.GracefulTimeout)
+	}
+
 	return getDuration(c.GracefulKillTimeout, process.GracefulTimeout)
 }
 
 func (c *config) GetForceKillTimeout() time.Duration {
+	if c.forceKillTimeout != nil {
+		return getDuration(c.forceKillTimeout, process.KillTimeout)
+	}
+
 	return getDuration(c.ForceKillTimeout, process.KillTimeout)
 }
 
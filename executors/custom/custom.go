@@ -9,6 +9,7 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"runtime"
 
 	"github.com/sirupsen/logrus"
 
@@ -42,7 +43,20 @@ type jsonService struct {
 	Command    []string `json:"command"`
 }
 
-func (c *ConfigExecOutput) InjectInto(executor *executor) {
+func (c *ConfigExecOutput) InjectInto(executor *executor) error {
+	version := api.APIVersion1
+	if c.APIVersion != nil {
+		version = *c.APIVersion
+	}
+
+	if version < api.APIVersion1 || version > api.LatestAPIVersion {
+		return fmt.Errorf(
+			"unsupported config_exec API version %d, the latest supported version is %d",
+			version,
+			api.LatestAPIVersion,
+		)
+	}
+
 	if c.Hostname != nil {
 		executor.Build.Hostname = *c.Hostname
 	}
@@ -64,6 +78,36 @@ func (c *ConfigExecOutput) InjectInto(executor *executor) {
 	if c.JobEnv != nil {
 		executor.jobEnv = *c.JobEnv
 	}
+
+	if version < api.APIVersion2 {
+		if c.HasVersion2Fields() {
+			executor.Warningln("The features, shell and kill timeouts set by the driver are ignored, " +
+				"they require api_version to be set to 2")
+		}
+
+		return nil
+	}
+
+	return c.injectVersion2Into(executor)
+}
+
+func (c *ConfigExecOutput) injectVersion2Into(executor *executor) error {
+	if c.Shell != nil {
+		if common.GetShell(*c.Shell) == nil {
+			return fmt.Errorf("the shell %q set by the driver isn't supported", *c.Shell)
+		}
+
+		executor.Config.Shell = *c.Shell
+	}
+
+	executor.config.gracefulKillTimeout = c.GracefulKillTimeout
+	executor.config.forceKillTimeout = c.ForceKillTimeout
+
+	if c.Features != nil {
+		executor.updateFeatures(c.Features)
+	}
+
+	return nil
 }
 
 type executor struct {
@@ -76,6 +120,15 @@ type executor struct {
 	driverInfo *api.DriverInfo
 
 	jobEnv map[string]string
+
+	// servicesUnsupported is set when the driver declares that its
+	// environment can't run the services of the jobs
+	servicesUnsupported bool
+
+	// driver is the driver_exec process of the job and driverStderr receives
+	// its standard error
+	driver       driverClient
+	driverStderr io.WriteCloser
 }
 
 func (e *executor) Prepare(options common.ExecutorPrepareOptions) error {
@@ -96,6 +149,10 @@ func (e *executor) Prepare(options common.ExecutorPrepareOptions) error {
 		return err
 	}
 
+	if e.config.DriverExec != "" {
+		return e.prepareDriver()
+	}
+
 	err = e.dynamicConfig()
 	if err != nil {
 		return err
@@ -130,12 +187,12 @@ func (e *executor) prepareConfig() error {
 		return common.MakeBuildError("custom executor not configured")
 	}
 
-	e.config = &config{
-		CustomConfig: e.Config.Custom,
+	if e.Config.Custom.RunExec == "" && e.Config.Custom.DriverExec == "" {
+		return common.MakeBuildError("custom executor is missing RunExec")
 	}
 
-	if e.config.RunExec == "" {
-		return common.MakeBuildError("custom executor is missing RunExec")
+	e.config = &config{
+		CustomConfig: e.Config.Custom,
 	}
 
 	return nil
@@ -195,9 +252,40 @@ func (e *executor) dynamicConfig() error {
 		return fmt.Errorf("error while parsing JSON output: %w", err)
 	}
 
-	config.InjectInto(e)
+	return config.InjectInto(e)
+}
 
-	return nil
+// updateFeatures updates the features of the build with the features of the
+// environment declared by the driver
+func (e *executor) updateFeatures(features *api.Features) {
+	executorFeatures := &e.Build.ExecutorFeatures
+
+	if features.Services != nil {
+		executorFeatures.Services = *features.Services
+		e.servicesUnsupported = !executorFeatures.Services
+		if e.servicesUnsupported && len(e.Build.Services) > 0 {
+			e.Warningln("The driver doesn't support services, the services of the job are ignored")
+		}
+	}
+
+	if features.Terminal != nil {
+		executorFeatures.Terminal = *features.Terminal
+	}
+
+	if features.SharedCache != nil {
+		executorFeatures.Cache = *features.SharedCache
+
+		// the local cache would be lost with the environment of the job
+		if !executorFeatures.Cache && len(e.Build.Cache) > 0 && !e.hasDistributedCache() {
+			e.Warningln("The cache of the job is skipped, the driver doesn't share the cache directory " +
+				"between the jobs and no distributed cache is configured")
+			e.Build.Cache = nil
+		}
+	}
+}
+
+func (e *executor) hasDistributedCache() bool {
+	return e.Config.Cache != nil && e.Config.Cache.Type != ""
 }
 
 func (e *executor) logStartupMessage() {
@@ -227,38 +315,53 @@ func (e *executor) defaultCommandOutputs() commandOutputs {
 var commandFactory = command.New
 
 func (e *executor) prepareCommand(ctx context.Context, opts prepareCommandOpts) command.Command {
+	cmdOpts := e.processOptions(opts.out)
+
+	return commandFactory(ctx, opts.executable, opts.args, cmdOpts, e.commandOptions())
+}
+
+func (e *executor) processOptions(out commandOutputs) process.CommandOptions {
 	logger := common.NewProcessLoggerAdapter(e.BuildLogger)
 
 	cmdOpts := process.CommandOptions{
 		Dir:                             e.tempDir,
-		Env:                             make([]string, 0),
-		Stdout:                          opts.out.stdout,
-		Stderr:                          opts.out.stderr,
+		Stdout:                          out.stdout,
+		Stderr:                          out.stderr,
 		Logger:                          logger,
 		GracefulKillTimeout:             e.config.GetGracefulKillTimeout(),
 		ForceKillTimeout:                e.config.GetForceKillTimeout(),
 		UseWindowsLegacyProcessStrategy: e.Build.IsFeatureFlagOn(featureflags.UseWindowsLegacyProcessStrategy),
 	}
+	cmdOpts.Env = e.commandEnv()
+
+	return cmdOpts
+}
+
+// commandEnv returns the variables passed to the driver executables
+func (e *executor) commandEnv() []string {
+	env := make([]string, 0)
 
 	// Append job_env defined variable first to avoid overwriting any CI/CD or predefined variables.
 	for k, v := range e.jobEnv {
-		cmdOpts.Env = append(cmdOpts.Env, fmt.Sprintf("%s=%s", k, v))
+		env = append(env, fmt.Sprintf("%s=%s", k, v))
 	}
 
 	variables := append(e.Build.GetAllVariables(), e.getCIJobServicesEnv())
 	for _, variable := range variables {
-		cmdOpts.Env = append(cmdOpts.Env, fmt.Sprintf("CUSTOM_ENV_%s=%s", variable.Key, variable.Value))
+		env = append(env, fmt.Sprintf("CUSTOM_ENV_%s=%s", variable.Key, variable.Value))
 	}
 
-	options := command.Options{
+	return env
+}
+
+func (e *executor) commandOptions() command.Options {
+	return command.Options{
 		JobResponseFile: e.jobResponseFile,
 	}
-
-	return commandFactory(ctx, opts.executable, opts.args, cmdOpts, options)
 }
 
 func (e *executor) getCIJobServicesEnv() common.JobVariable {
-	if len(e.Build.Services) == 0 {
+	if len(e.Build.Services) == 0 || e.servicesUnsupported {
 		return common.JobVariable{Key: "CI_JOB_SERVICES"}
 	}
 
@@ -284,6 +387,10 @@ func (e *executor) getCIJobServicesEnv() common.JobVariable {
 }
 
 func (e *executor) Run(cmd common.ExecutorCommand) error {
+	if e.driver != nil {
+		return e.runDriver(cmd)
+	}
+
 	scriptDir, err := ioutil.TempDir(e.tempDir, "script")
 	if err != nil {
 		return err
@@ -317,16 +424,25 @@ func (e *executor) Run(cmd common.ExecutorCommand) error {
 func (e *executor) Cleanup() {
 	e.AbstractExecutor.Cleanup()
 
-	err := e.prepareConfig()
-	if err != nil {
-		e.Warningln(err)
+	// the configuration is kept from Prepare, with the kill timeouts set by
+	// the driver
+	if e.config == nil {
+		err := e.prepareConfig()
+		if err != nil {
+			e.Warningln(err)
 
-		// at this moment we don't care about the errors
-		return
+			// at this moment we don't care about the errors
+			return
+		}
 	}
 
 	defer func() { _ = os.RemoveAll(e.tempDir) }()
 
+	if e.driver != nil {
+		e.cleanupDriver()
+		return
+	}
+
 	// nothing to do, as there's no cleanup_script
 	if e.config.CleanupExec == "" {
 		return
@@ -349,7 +465,7 @@ func (e *executor) Cleanup() {
 		out:        outputs,
 	}
 
-	err = e.prepareCommand(ctx, opts).Run()
+	err := e.prepareCommand(ctx, opts).Run()
 	if err != nil {
 		e.Warningln("Cleanup script failed:", err)
 	}
@@ -379,9 +495,32 @@ func init() {
 		features.Shared = true
 	}
 
-	common.RegisterExecutorProvider("custom", executors.DefaultExecutorProvider{
-		Creator:          creator,
-		FeaturesUpdater:  featuresUpdater,
-		DefaultShellName: options.Shell.Shell,
+	common.RegisterExecutorProvider("custom", executorProvider{
+		DefaultExecutorProvider: executors.DefaultExecutorProvider{
+			Creator:          creator,
+			FeaturesUpdater:  featuresUpdater,
+			DefaultShellName: options.Shell.Shell,
+		},
 	})
 }
+
+// executorProvider advertises the terminals only for the runners whose
+// driver starts them with terminal_exec
+type executorProvider struct {
+	executors.DefaultExecutorProvider
+}
+
+func (p executorProvider) GetRunnerFeatures(config *common.RunnerConfig, features *common.FeaturesInfo) error {
+	err := p.GetFeatures(features)
+	if err != nil {
+		return err
+	}
+
+	// the terminals are started by terminal_exec of the driver with a PTY
+	if runtime.GOOS != "windows" && config.Custom != nil && config.Custom.TerminalExec != "" {
+		features.Session = true
+		features.Terminal = true
+	}
+
+	return nil
+}
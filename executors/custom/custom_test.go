@@ -15,12 +15,14 @@ import (
 	"runtime"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
 
 	"gitlab.com/gitlab-org/gitlab-runner/common"
+	"gitlab.com/gitlab-org/gitlab-runner/executors/custom/api"
 	"gitlab.com/gitlab-org/gitlab-runner/executors/custom/command"
 	"gitlab.com/gitlab-org/gitlab-runner/helpers/process"
 )
@@ -398,6 +400,66 @@ func TestExecutor_Prepare(t *testing.T) {
 				assert.Equal(t, "/some/cache/directory/project-0", b.CacheDir)
 			},
 		},
+		"custom executor set with ConfigExec with API version 2": {
+			config: getRunnerConfig(&common.CustomConfig{
+				RunExec:    "bash",
+				ConfigExec: "echo",
+			}),
+			commandStdoutContent: `{
+				"api_version": 2,
+				"builds_dir": "/some/build/directory",
+				"shell": "sh",
+				"features": {
+					"services": true,
+					"terminal": false,
+					"shared_cache": true
+				},
+				"graceful_kill_timeout": 5,
+				"force_kill_timeout": 2
+			}`,
+			assertExecutor: func(t *testing.T, e *executor) {
+				assert.Equal(t, "sh", e.Shell().Shell)
+				assert.Equal(t, 5*time.Second, e.config.GetGracefulKillTimeout())
+				assert.Equal(t, 2*time.Second, e.config.GetForceKillTimeout())
+				assert.True(t, e.Build.ExecutorFeatures.Services)
+				assert.False(t, e.Build.ExecutorFeatures.Terminal)
+				assert.True(t, e.Build.ExecutorFeatures.Cache)
+			},
+		},
+		"custom executor set with ConfigExec with API version 2 fields without version": {
+			config: getRunnerConfig(&common.CustomConfig{
+				RunExec:    "bash",
+				ConfigExec: "echo",
+			}),
+			commandStdoutContent: `{
+				"builds_dir": "/some/build/directory",
+				"shell": "sh",
+				"graceful_kill_timeout": 5
+			}`,
+			assertOutput: func(t *testing.T, output string) {
+				assert.Contains(t, output, "they require api_version to be set to 2")
+			},
+			assertExecutor: func(t *testing.T, e *executor) {
+				assert.Equal(t, "bash", e.Shell().Shell)
+				assert.Equal(t, process.GracefulTimeout, e.config.GetGracefulKillTimeout())
+			},
+		},
+		"custom executor set with ConfigExec with unsupported API version": {
+			config: getRunnerConfig(&common.CustomConfig{
+				RunExec:    "bash",
+				ConfigExec: "echo",
+			}),
+			commandStdoutContent: `{"api_version": 3}`,
+			expectedError:        "unsupported config_exec API version 3, the latest supported version is 2",
+		},
+		"custom executor set with ConfigExec with unsupported shell": {
+			config: getRunnerConfig(&common.CustomConfig{
+				RunExec:    "bash",
+				ConfigExec: "echo",
+			}),
+			commandStdoutContent: `{"api_version": 2, "shell": "fish"}`,
+			expectedError:        `the shell "fish" set by the driver isn't supported`,
+		},
 		"custom executor set with PrepareExec": {
 			config: getRunnerConfig(&common.CustomConfig{
 				RunExec:     "bash",
@@ -1072,3 +1134,93 @@ func TestExecutor_ServicesEnv(t *testing.T) {
 		})
 	}
 }
+
+func TestExecutorProviderTerminalFeatures(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("terminals aren't supported on Windows")
+	}
+
+	provider := common.GetExecutorProvider("custom")
+	require.NotNil(t, provider)
+
+	tests := map[string]struct {
+		config           *common.CustomConfig
+		expectedTerminal bool
+	}{
+		"without terminal_exec": {
+			config: &common.CustomConfig{RunExec: "bash"},
+		},
+		"with terminal_exec": {
+			config:           &common.CustomConfig{RunExec: "bash", TerminalExec: "attach"},
+			expectedTerminal: true,
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			config := getRunnerConfig(tt.config)
+
+			var features common.FeaturesInfo
+			require.NoError(t, common.GetExecutorFeatures(provider, &config, &features))
+
+			assert.True(t, features.Variables)
+			assert.Equal(t, tt.expectedTerminal, features.Session)
+			assert.Equal(t, tt.expectedTerminal, features.Terminal)
+		})
+	}
+}
+
+func TestExecutor_UpdateFeatures(t *testing.T) {
+	unsupported := false
+	supported := true
+
+	tests := map[string]struct {
+		features         api.Features
+		cacheType        string
+		expectedServices string
+		expectedCache    bool
+		expectedOutput   string
+	}{
+		"features not declared": {
+			expectedServices: `[{"name":"postgres:14","alias":"db","entrypoint":null,"command":null}]`,
+			expectedCache:    true,
+		},
+		"services and shared cache supported": {
+			features:         api.Features{Services: &supported, SharedCache: &supported},
+			expectedServices: `[{"name":"postgres:14","alias":"db","entrypoint":null,"command":null}]`,
+			expectedCache:    true,
+		},
+		"services and shared cache not supported": {
+			features:       api.Features{Services: &unsupported, SharedCache: &unsupported},
+			expectedOutput: "The cache of the job is skipped",
+		},
+		"shared cache not supported with distributed cache": {
+			features:         api.Features{SharedCache: &unsupported},
+			cacheType:        "s3",
+			expectedServices: `[{"name":"postgres:14","alias":"db","entrypoint":null,"command":null}]`,
+			expectedCache:    true,
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			e, out := prepareExecutorForCleanup(t, executorTestCase{
+				config: getRunnerConfig(&common.CustomConfig{RunExec: "bash"}),
+			})
+			if tt.cacheType != "" {
+				e.Config.Cache = &common.CacheConfig{Type: tt.cacheType}
+			}
+
+			e.Build.Services = common.Services{{Name: "postgres:14", Alias: "db"}}
+			e.Build.Cache = common.Caches{{Key: "deps", Paths: common.ArtifactPaths{"vendor"}}}
+
+			e.updateFeatures(&tt.features)
+
+			assert.Equal(t, tt.expectedServices, e.getCIJobServicesEnv().Value)
+			assert.Equal(t, tt.expectedCache, len(e.Build.Cache) > 0)
+			if tt.expectedOutput != "" {
+				assert.Contains(t, out.String(), tt.expectedOutput)
+			}
+		})
+	}
+}
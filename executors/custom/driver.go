@@ -0,0 +1,140 @@
+package custom
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+
+	"gitlab.com/gitlab-org/gitlab-runner/common"
+	"gitlab.com/gitlab-org/gitlab-runner/executors/custom/api"
+	"gitlab.com/gitlab-org/gitlab-runner/executors/custom/command"
+	"gitlab.com/gitlab-org/gitlab-runner/executors/custom/driver"
+	"gitlab.com/gitlab-org/gitlab-runner/helpers/process"
+)
+
+type driverClient interface {
+	Start() error
+	Call(ctx context.Context, method string, params interface{}, result interface{}, outputs driver.Outputs) error
+	Close() error
+}
+
+var driverFactory = func(executable string, args []string, cmdOpts process.CommandOptions) (driverClient, error) {
+	d, err := driver.New(executable, args, cmdOpts)
+	if err != nil {
+		return nil, err
+	}
+
+	return d, nil
+}
+
+// prepareDriver starts the driver_exec process of the job and sends it the
+// prepare request, whose result is handled as the output of config_exec
+func (e *executor) prepareDriver() error {
+	// the standard output of the driver is reserved for the protocol and its
+	// standard error may outlive the job log, so it goes to the runner logs
+	stderrLogger := e.BuildLogger.WithFields(logrus.Fields{"driver_std": "err"})
+	stderr := stderrLogger.WriterLevel(logrus.WarnLevel)
+
+	cmdOpts := e.processOptions(commandOutputs{stdout: e.Trace, stderr: stderr})
+	cmdOpts.Env = command.Env(cmdOpts.Dir, cmdOpts.Env, e.commandOptions())
+
+	d, err := driverFactory(e.config.DriverExec, e.config.DriverArgs, cmdOpts)
+	if err != nil {
+		_ = stderr.Close()
+		return err
+	}
+
+	err = d.Start()
+	if err != nil {
+		_ = stderr.Close()
+		return err
+	}
+
+	e.driver = d
+	e.driverStderr = stderr
+
+	ctx, cancelFunc := context.WithTimeout(e.Context, e.config.GetPrepareExecTimeout())
+	defer cancelFunc()
+
+	config := new(ConfigExecOutput)
+
+	err = d.Call(ctx, api.DriverMethodPrepare, struct{}{}, &config.ConfigExecOutput, e.driverOutputs())
+	if err != nil {
+		return fmt.Errorf("driver prepare: %w", err)
+	}
+
+	err = config.InjectInto(e)
+	if err != nil {
+		return err
+	}
+
+	e.logStartupMessage()
+
+	return e.AbstractExecutor.PrepareBuildAndShell()
+}
+
+func (e *executor) driverOutputs() driver.Outputs {
+	return driver.Outputs{
+		Stdout: e.Trace,
+		Stderr: e.Trace,
+	}
+}
+
+// runDriver sends the script of the stage to the driver, the exit code of the
+// script is reported as a build failure and the errors of the driver as
+// system failures
+func (e *executor) runDriver(cmd common.ExecutorCommand) error {
+	// the driver is started before the job_env of the prepare result is
+	// known, so the variables are sent with each request
+	params := api.DriverRunParams{
+		Stage:  string(cmd.Stage),
+		Script: cmd.Script,
+		Env:    e.jobEnv,
+	}
+
+	var result api.DriverRunResult
+
+	err := e.driver.Call(cmd.Context, api.DriverMethodRun, params, &result, e.driverOutputs())
+	if err != nil {
+		return fmt.Errorf("driver run: %w", err)
+	}
+
+	if result.ExitCode != 0 {
+		return &common.BuildError{
+			Inner:    fmt.Errorf("exit code %d", result.ExitCode),
+			ExitCode: result.ExitCode,
+		}
+	}
+
+	return nil
+}
+
+// cleanupDriver sends the cleanup request to the driver and stops it
+func (e *executor) cleanupDriver() {
+	defer func() { _ = e.driverStderr.Close() }()
+
+	ctx, cancelFunc := context.WithTimeout(context.Background(), e.config.GetCleanupScriptTimeout())
+	defer cancelFunc()
+
+	stdoutLogger := e.BuildLogger.WithFields(logrus.Fields{"cleanup_std": "out"})
+	stderrLogger := e.BuildLogger.WithFields(logrus.Fields{"cleanup_std": "err"})
+
+	stdout := stdoutLogger.WriterLevel(logrus.DebugLevel)
+	defer func() { _ = stdout.Close() }()
+
+	stderr := stderrLogger.WriterLevel(logrus.WarnLevel)
+	defer func() { _ = stderr.Close() }()
+
+	params := api.DriverCleanupParams{Env: e.jobEnv}
+
+	err := e.driver.Call(ctx, api.DriverMethodCleanup, params, nil, driver.Outputs{Stdout: stdout, Stderr: stderr})
+	if err != nil {
+		e.Warningln("Driver cleanup failed:", err)
+	}
+
+	err = e.driver.Close()
+	if err != nil {
+		e.Warningln("Stopping the driver failed:", err)
+	}
+}
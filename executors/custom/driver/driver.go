@@ -0,0 +1,374 @@
+package driver
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"gitlab.com/gitlab-org/gitlab-runner/executors/custom/api"
+	"gitlab.com/gitlab-org/gitlab-runner/helpers/process"
+)
+
+const (
+	// maxMessageSize limits the size of the lines written by the driver
+	maxMessageSize = 16 * 1024 * 1024
+
+	// readTimeout is the time given to read the last messages once the
+	// driver has exited
+	readTimeout = 5 * time.Second
+)
+
+var (
+	newProcessKillWaiter = process.NewOSKillWait
+	newCommander         = process.NewOSCmd
+)
+
+// ErrDriverExited is returned by the calls when the driver process exits
+// before answering them
+var ErrDriverExited = errors.New("the driver exited")
+
+// Outputs are the writers of the output notifications of a call
+type Outputs struct {
+	Stdout io.Writer
+	Stderr io.Writer
+}
+
+func (o Outputs) writer(stream string) io.Writer {
+	if stream == api.DriverStreamStderr {
+		return o.Stderr
+	}
+
+	return o.Stdout
+}
+
+type call struct {
+	outputs  Outputs
+	response chan *api.DriverMessage
+}
+
+// Driver is the process started by driver_exec for a job. The calls are sent
+// as JSON-RPC requests on its standard input and it answers them on its
+// standard output, which is reserved for the protocol.
+type Driver struct {
+	cmd process.Commander
+
+	// the ends of the pipes of the runner, and the ends of the driver, which
+	// are closed once it exits
+	stdin       *os.File
+	stdout      *os.File
+	childStdin  *os.File
+	childStdout *os.File
+
+	// outputs receive the output notifications which don't belong to a
+	// pending call
+	outputs Outputs
+
+	writeLock sync.Mutex
+
+	lock   sync.Mutex
+	nextID int64
+	calls  map[int64]*call
+
+	done    chan struct{}
+	exitErr error
+
+	logger process.Logger
+
+	gracefulKillTimeout time.Duration
+	forceKillTimeout    time.Duration
+}
+
+// New creates the driver process, cmdOpts.Stdout receives the output
+// notifications which don't belong to a pending call
+func New(executable string, args []string, cmdOpts process.CommandOptions) (*Driver, error) {
+	childStdin, stdin, err := os.Pipe()
+	if err != nil {
+		return nil, fmt.Errorf("creating the driver stdin: %w", err)
+	}
+
+	stdout, childStdout, err := os.Pipe()
+	if err != nil {
+		_ = childStdin.Close()
+		_ = stdin.Close()
+		return nil, fmt.Errorf("creating the driver stdout: %w", err)
+	}
+
+	d := &Driver{
+		stdin:               stdin,
+		stdout:              stdout,
+		childStdin:          childStdin,
+		childStdout:         childStdout,
+		outputs:             Outputs{Stdout: cmdOpts.Stdout, Stderr: cmdOpts.Stderr},
+		calls:               make(map[int64]*call),
+		done:                make(chan struct{}),
+		logger:              cmdOpts.Logger,
+		gracefulKillTimeout: cmdOpts.GracefulKillTimeout,
+		forceKillTimeout:    cmdOpts.ForceKillTimeout,
+	}
+
+	cmdOpts.Stdin = childStdin
+	cmdOpts.Stdout = childStdout
+	d.cmd = newCommander(executable, args, cmdOpts)
+
+	return d, nil
+}
+
+// Start starts the driver process and the reading of its messages
+func (d *Driver) Start() error {
+	err := d.cmd.Start()
+	if err != nil {
+		d.closePipes()
+		return fmt.Errorf("failed to start driver: %w", err)
+	}
+
+	readDone := make(chan struct{})
+	go func() {
+		defer close(readDone)
+		d.read()
+	}()
+
+	go func() {
+		err := d.cmd.Wait()
+
+		// the reader gets to the end of the output once it has read the last
+		// messages, unless the output is kept open by the children of the
+		// driver
+		_ = d.childStdout.Close()
+		select {
+		case <-readDone:
+		case <-time.After(readTimeout):
+			_ = d.stdout.Close()
+			<-readDone
+		}
+
+		d.closePipes()
+
+		d.exitErr = err
+		close(d.done)
+	}()
+
+	return nil
+}
+
+func (d *Driver) read() {
+	scanner := bufio.NewScanner(d.stdout)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxMessageSize)
+
+	for scanner.Scan() {
+		var msg api.DriverMessage
+
+		err := json.Unmarshal(scanner.Bytes(), &msg)
+		if err != nil {
+			d.logger.Warn("Invalid message from the driver:", err)
+			continue
+		}
+
+		d.handle(&msg)
+	}
+
+	if err := scanner.Err(); err != nil && !errors.Is(err, os.ErrClosed) {
+		d.logger.Warn("Reading the messages of the driver:", err)
+	}
+}
+
+func (d *Driver) handle(msg *api.DriverMessage) {
+	if msg.Method == api.DriverMethodOutput {
+		d.handleOutput(msg)
+		return
+	}
+
+	if msg.ID == nil || msg.Method != "" {
+		d.logger.Warn("Unexpected message from the driver:", msg.Method)
+		return
+	}
+
+	d.lock.Lock()
+	c, ok := d.calls[*msg.ID]
+	delete(d.calls, *msg.ID)
+	d.lock.Unlock()
+
+	if !ok {
+		d.logger.Warn("Response from the driver to an unknown request:", *msg.ID)
+		return
+	}
+
+	c.response <- msg
+}
+
+func (d *Driver) handleOutput(msg *api.DriverMessage) {
+	var params api.DriverOutputParams
+
+	err := json.Unmarshal(msg.Params, &params)
+	if err != nil {
+		d.logger.Warn("Invalid output notification from the driver:", err)
+		return
+	}
+
+	outputs := d.outputs
+	if params.RequestID != nil {
+		d.lock.Lock()
+		if c, ok := d.calls[*params.RequestID]; ok {
+			outputs = c.outputs
+		}
+		d.lock.Unlock()
+	}
+
+	w := outputs.writer(params.Stream)
+	if w != nil {
+		_, _ = io.WriteString(w, params.Data)
+	}
+}
+
+// Call sends the request and waits for its response, which is decoded into
+// result. The output notifications of the request are written to outputs.
+//
+// When ctx is done, the driver is notified with a cancel notification and
+// given the graceful kill timeout to answer, after which it's killed.
+func (d *Driver) Call(ctx context.Context, method string, params interface{}, result interface{}, outputs Outputs) error {
+	c := &call{
+		outputs:  outputs,
+		response: make(chan *api.DriverMessage, 1),
+	}
+
+	d.lock.Lock()
+	d.nextID++
+	id := d.nextID
+	d.calls[id] = c
+	d.lock.Unlock()
+
+	err := d.send(&id, method, params)
+	if err != nil {
+		d.lock.Lock()
+		delete(d.calls, id)
+		d.lock.Unlock()
+
+		return err
+	}
+
+	select {
+	case msg := <-c.response:
+		return decodeResponse(msg, result)
+	case <-d.done:
+		return d.exitError()
+	case <-ctx.Done():
+	}
+
+	err = d.send(nil, api.DriverMethodCancel, api.DriverCancelParams{RequestID: id})
+	if err != nil {
+		d.logger.Warn("Sending the cancel notification to the driver:", err)
+	}
+
+	select {
+	case msg := <-c.response:
+		return decodeResponse(msg, result)
+	case <-d.done:
+		return d.exitError()
+	case <-time.After(d.gracefulKillTimeout):
+	}
+
+	return d.kill()
+}
+
+func (d *Driver) send(id *int64, method string, params interface{}) error {
+	rawParams, err := json.Marshal(params)
+	if err != nil {
+		return fmt.Errorf("encoding the %s parameters: %w", method, err)
+	}
+
+	data, err := json.Marshal(api.DriverMessage{
+		JSONRPC: api.DriverProtocolVersion,
+		ID:      id,
+		Method:  method,
+		Params:  rawParams,
+	})
+	if err != nil {
+		return fmt.Errorf("encoding the %s request: %w", method, err)
+	}
+
+	d.writeLock.Lock()
+	defer d.writeLock.Unlock()
+
+	_, err = d.stdin.Write(append(data, '\n'))
+	if err != nil {
+		return fmt.Errorf("sending the %s request: %w", method, err)
+	}
+
+	return nil
+}
+
+func decodeResponse(msg *api.DriverMessage, result interface{}) error {
+	if msg.Error != nil {
+		return msg.Error
+	}
+
+	if result == nil || len(msg.Result) == 0 {
+		return nil
+	}
+
+	err := json.Unmarshal(msg.Result, result)
+	if err != nil {
+		return fmt.Errorf("decoding the response of the driver: %w", err)
+	}
+
+	return nil
+}
+
+func (d *Driver) exitError() error {
+	if d.exitErr != nil {
+		return fmt.Errorf("%w: %v", ErrDriverExited, d.exitErr)
+	}
+
+	return ErrDriverExited
+}
+
+func (d *Driver) kill() error {
+	waitCh := make(chan error, 1)
+	go func() {
+		<-d.done
+		waitCh <- d.exitErr
+	}()
+
+	err := newProcessKillWaiter(d.logger, d.forceKillTimeout, d.forceKillTimeout).KillAndWait(d.cmd, waitCh)
+	if err != nil {
+		return fmt.Errorf("the driver didn't answer the cancel notification: %w", err)
+	}
+
+	return errors.New("the driver didn't answer the cancel notification")
+}
+
+// Close closes the standard input of the driver, which must exit, and kills
+// it when it doesn't exit before the graceful kill timeout
+func (d *Driver) Close() error {
+	d.writeLock.Lock()
+	_ = d.stdin.Close()
+	d.writeLock.Unlock()
+
+	select {
+	case <-d.done:
+		return nil
+	case <-time.After(d.gracefulKillTimeout):
+	}
+
+	waitCh := make(chan error, 1)
+	go func() {
+		<-d.done
+		waitCh <- d.exitErr
+	}()
+
+	return newProcessKillWaiter(d.logger, d.forceKillTimeout, d.forceKillTimeout).KillAndWait(d.cmd, waitCh)
+}
+
+func (d *Driver) closePipes() {
+	d.writeLock.Lock()
+	defer d.writeLock.Unlock()
+
+	for _, f := range []*os.File{d.stdin, d.stdout, d.childStdin, d.childStdout} {
+		_ = f.Close()
+	}
+}
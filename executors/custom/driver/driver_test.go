@@ -0,0 +1,245 @@
+//go:build !integration
+// +build !integration
+
+package driver
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-runner/executors/custom/api"
+	"gitlab.com/gitlab-org/gitlab-runner/helpers/process"
+)
+
+// fakeDriver serves the requests of the runner in place of the driver process
+type fakeDriver struct {
+	t   *testing.T
+	in  *bufio.Scanner
+	out io.Writer
+}
+
+func (f *fakeDriver) receive() (api.DriverMessage, bool) {
+	var msg api.DriverMessage
+	if !f.in.Scan() {
+		return msg, false
+	}
+
+	require.NoError(f.t, json.Unmarshal(f.in.Bytes(), &msg))
+	assert.Equal(f.t, api.DriverProtocolVersion, msg.JSONRPC)
+
+	return msg, true
+}
+
+func (f *fakeDriver) send(msg api.DriverMessage) {
+	msg.JSONRPC = api.DriverProtocolVersion
+
+	data, err := json.Marshal(msg)
+	require.NoError(f.t, err)
+
+	_, _ = f.out.Write(append(data, '\n'))
+}
+
+func (f *fakeDriver) output(id *int64, stream string, data string) {
+	params, err := json.Marshal(api.DriverOutputParams{RequestID: id, Stream: stream, Data: data})
+	require.NoError(f.t, err)
+
+	f.send(api.DriverMessage{Method: api.DriverMethodOutput, Params: params})
+}
+
+func (f *fakeDriver) reply(id *int64, result interface{}) {
+	data, err := json.Marshal(result)
+	require.NoError(f.t, err)
+
+	f.send(api.DriverMessage{ID: id, Result: data})
+}
+
+// fakeKillWaiter stops the fake driver in place of killing the process, it
+// isn't a mock as the commander is used concurrently by the driver
+type fakeKillWaiter struct {
+	killed chan struct{}
+}
+
+func (k *fakeKillWaiter) KillAndWait(_ process.Commander, waitCh chan error) error {
+	close(k.killed)
+	<-waitCh
+
+	return errors.New("signal: terminated")
+}
+
+// newTestDriver starts a driver served by serve, the driver is killed when it
+// doesn't answer the cancel notifications
+func newTestDriver(t *testing.T, stdout io.Writer, serve func(f *fakeDriver)) *Driver {
+	commanderMock := new(process.MockCommander)
+	loggerMock := new(process.MockLogger)
+	loggerMock.On("Warn", mock.Anything, mock.Anything).Maybe()
+
+	oldNewCommander := newCommander
+	oldNewProcessKillWaiter := newProcessKillWaiter
+	t.Cleanup(func() {
+		newCommander = oldNewCommander
+		newProcessKillWaiter = oldNewProcessKillWaiter
+
+		commanderMock.AssertExpectations(t)
+	})
+
+	var cmdOpts process.CommandOptions
+	newCommander = func(executable string, args []string, opts process.CommandOptions) process.Commander {
+		assert.Equal(t, "driver", executable)
+		cmdOpts = opts
+		return commanderMock
+	}
+	served := make(chan struct{})
+	killed := make(chan struct{})
+
+	newProcessKillWaiter = func(process.Logger, time.Duration, time.Duration) process.KillWaiter {
+		return &fakeKillWaiter{killed: killed}
+	}
+
+	commanderMock.On("Start").Return(nil).Run(func(mock.Arguments) {
+		go func() {
+			defer close(served)
+			serve(&fakeDriver{t: t, in: bufio.NewScanner(cmdOpts.Stdin), out: cmdOpts.Stdout})
+		}()
+	}).Once()
+	commanderMock.On("Wait").Return(nil).Run(func(mock.Arguments) {
+		select {
+		case <-served:
+		case <-killed:
+		}
+	}).Once()
+
+	d, err := New("driver", nil, process.CommandOptions{
+		Stdout:              stdout,
+		Logger:              loggerMock,
+		GracefulKillTimeout: 100 * time.Millisecond,
+	})
+	require.NoError(t, err)
+	require.NoError(t, d.Start())
+
+	return d
+}
+
+func TestDriver_Call(t *testing.T) {
+	d := newTestDriver(t, nil, func(f *fakeDriver) {
+		msg, ok := f.receive()
+		require.True(t, ok)
+		require.NotNil(t, msg.ID)
+		assert.Equal(t, api.DriverMethodRun, msg.Method)
+		assert.JSONEq(t, `{"stage":"step_script","script":"echo test"}`, string(msg.Params))
+
+		f.output(msg.ID, api.DriverStreamStdout, "test\n")
+		f.output(msg.ID, api.DriverStreamStderr, "warning\n")
+		f.reply(msg.ID, api.DriverRunResult{ExitCode: 42})
+	})
+
+	stdout := new(bytes.Buffer)
+	stderr := new(bytes.Buffer)
+
+	var result api.DriverRunResult
+	err := d.Call(
+		context.Background(),
+		api.DriverMethodRun,
+		api.DriverRunParams{Stage: "step_script", Script: "echo test"},
+		&result,
+		Outputs{Stdout: stdout, Stderr: stderr},
+	)
+	require.NoError(t, err)
+
+	assert.Equal(t, 42, result.ExitCode)
+	assert.Equal(t, "test\n", stdout.String())
+	assert.Equal(t, "warning\n", stderr.String())
+
+	require.NoError(t, d.Close())
+}
+
+func TestDriver_CallError(t *testing.T) {
+	d := newTestDriver(t, nil, func(f *fakeDriver) {
+		msg, ok := f.receive()
+		require.True(t, ok)
+
+		f.send(api.DriverMessage{ID: msg.ID, Error: &api.DriverError{Code: -32000, Message: "no capacity"}})
+	})
+
+	err := d.Call(context.Background(), api.DriverMethodPrepare, struct{}{}, nil, Outputs{})
+
+	var driverErr *api.DriverError
+	require.True(t, errors.As(err, &driverErr), "expected a driver error, got %v", err)
+	assert.Equal(t, -32000, driverErr.Code)
+	assert.Equal(t, "driver error -32000: no capacity", err.Error())
+
+	require.NoError(t, d.Close())
+}
+
+func TestDriver_CallDriverExited(t *testing.T) {
+	d := newTestDriver(t, nil, func(f *fakeDriver) {
+		_, ok := f.receive()
+		require.True(t, ok)
+	})
+
+	err := d.Call(context.Background(), api.DriverMethodPrepare, struct{}{}, nil, Outputs{})
+	assert.True(t, errors.Is(err, ErrDriverExited), "expected ErrDriverExited, got %v", err)
+}
+
+func TestDriver_CallCanceled(t *testing.T) {
+	d := newTestDriver(t, nil, func(f *fakeDriver) {
+		run, ok := f.receive()
+		require.True(t, ok)
+
+		cancel, ok := f.receive()
+		require.True(t, ok)
+		assert.Nil(t, cancel.ID)
+		assert.Equal(t, api.DriverMethodCancel, cancel.Method)
+		assert.JSONEq(t, fmt.Sprintf(`{"request_id":%d}`, *run.ID), string(cancel.Params))
+
+		f.reply(run.ID, api.DriverRunResult{ExitCode: 143})
+	})
+
+	ctx, cancelFunc := context.WithCancel(context.Background())
+	cancelFunc()
+
+	var result api.DriverRunResult
+	err := d.Call(ctx, api.DriverMethodRun, api.DriverRunParams{}, &result, Outputs{})
+	require.NoError(t, err)
+	assert.Equal(t, 143, result.ExitCode)
+
+	require.NoError(t, d.Close())
+}
+
+func TestDriver_CallCancelNotAnswered(t *testing.T) {
+	d := newTestDriver(t, nil, func(f *fakeDriver) {
+		for {
+			if _, ok := f.receive(); !ok {
+				return
+			}
+		}
+	})
+
+	ctx, cancelFunc := context.WithCancel(context.Background())
+	cancelFunc()
+
+	err := d.Call(ctx, api.DriverMethodRun, api.DriverRunParams{}, nil, Outputs{})
+	require.Error(t, err)
+	assert.Equal(t, "the driver didn't answer the cancel notification: signal: terminated", err.Error())
+}
+
+func TestDriver_OutputWithoutRequest(t *testing.T) {
+	stdout := new(bytes.Buffer)
+
+	d := newTestDriver(t, stdout, func(f *fakeDriver) {
+		f.output(nil, api.DriverStreamStdout, "driver started\n")
+	})
+
+	require.NoError(t, d.Close())
+	assert.Equal(t, "driver started\n", stdout.String())
+}
@@ -0,0 +1,143 @@
+//go:build !integration
+// +build !integration
+
+package custom
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-runner/common"
+	"gitlab.com/gitlab-org/gitlab-runner/executors/custom/api"
+	"gitlab.com/gitlab-org/gitlab-runner/executors/custom/driver"
+	"gitlab.com/gitlab-org/gitlab-runner/helpers/process"
+)
+
+func mockDriverFactory(t *testing.T) *mockDriverClient {
+	driverMock := new(mockDriverClient)
+
+	oldFactory := driverFactory
+	t.Cleanup(func() {
+		driverFactory = oldFactory
+		driverMock.AssertExpectations(t)
+	})
+
+	driverFactory = func(executable string, args []string, cmdOpts process.CommandOptions) (driverClient, error) {
+		assert.Equal(t, "driver", executable)
+		assert.Equal(t, []string{"--verbose"}, args)
+		assert.Contains(t, cmdOpts.Env, "CUSTOM_ENV_CI_SERVER=yes")
+
+		return driverMock, nil
+	}
+
+	return driverMock
+}
+
+func TestExecutor_Driver(t *testing.T) {
+	driverMock := mockDriverFactory(t)
+
+	tt := executorTestCase{
+		config: getRunnerConfig(&common.CustomConfig{
+			DriverExec: "driver",
+			DriverArgs: []string{"--verbose"},
+		}),
+	}
+
+	e, options, out := prepareExecutor(t, tt)
+
+	driverMock.On("Start").Return(nil).Once()
+	driverMock.On("Call", mock.Anything, api.DriverMethodPrepare, mock.Anything, mock.Anything, mock.Anything).
+		Run(func(args mock.Arguments) {
+			outputs := args.Get(4).(driver.Outputs)
+			_, _ = fmt.Fprintln(outputs.Stdout, "Preparing the VM")
+
+			result := args.Get(3).(*api.ConfigExecOutput)
+			hostname := "vm-1"
+			buildsDir := "/driver/builds"
+			name := "test driver"
+			result.Hostname = &hostname
+			result.BuildsDir = &buildsDir
+			result.Driver = &api.DriverInfo{Name: &name}
+			result.JobEnv = &map[string]string{"VM_ADDRESS": "10.0.0.2"}
+		}).
+		Return(nil).
+		Once()
+
+	require.NoError(t, e.Prepare(options))
+	assert.Equal(t, "vm-1", e.Build.Hostname)
+	assert.Contains(t, e.Build.BuildDir, "/driver/builds")
+	assert.Contains(t, out.String(), "Preparing the VM")
+	assert.Contains(t, out.String(), "Using Custom executor with driver test driver...")
+
+	driverMock.On("Call", mock.Anything, api.DriverMethodRun, api.DriverRunParams{
+		Stage:  "step_script",
+		Script: "echo test",
+		Env:    map[string]string{"VM_ADDRESS": "10.0.0.2"},
+	}, mock.Anything, mock.Anything).
+		Run(func(args mock.Arguments) {
+			args.Get(3).(*api.DriverRunResult).ExitCode = 3
+		}).
+		Return(nil).
+		Once()
+
+	err := e.Run(common.ExecutorCommand{
+		Context: context.Background(),
+		Stage:   "step_script",
+		Script:  "echo test",
+	})
+
+	var buildErr *common.BuildError
+	require.True(t, errors.As(err, &buildErr), "expected a build error, got %v", err)
+	assert.Equal(t, 3, buildErr.ExitCode)
+
+	driverMock.On("Call", mock.Anything, api.DriverMethodRun, mock.Anything, mock.Anything, mock.Anything).
+		Return(&api.DriverError{Code: 1, Message: "VM is gone"}).
+		Once()
+
+	err = e.Run(common.ExecutorCommand{Context: context.Background(), Stage: "after_script"})
+	require.Error(t, err)
+	assert.False(t, errors.As(err, &buildErr), "expected a system failure, got %v", err)
+	assert.Equal(t, "driver run: driver error 1: VM is gone", err.Error())
+
+	cleanupParams := api.DriverCleanupParams{Env: map[string]string{"VM_ADDRESS": "10.0.0.2"}}
+	driverMock.On("Call", mock.Anything, api.DriverMethodCleanup, cleanupParams, nil, mock.Anything).
+		Return(nil).
+		Once()
+	driverMock.On("Close").Return(nil).Once()
+
+	e.Cleanup()
+}
+
+func TestExecutor_DriverPrepareFailure(t *testing.T) {
+	driverMock := mockDriverFactory(t)
+
+	tt := executorTestCase{
+		config: getRunnerConfig(&common.CustomConfig{
+			DriverExec: "driver",
+			DriverArgs: []string{"--verbose"},
+		}),
+	}
+
+	e, options, _ := prepareExecutor(t, tt)
+
+	driverMock.On("Start").Return(nil).Once()
+	driverMock.On("Call", mock.Anything, api.DriverMethodPrepare, mock.Anything, mock.Anything, mock.Anything).
+		Return(driver.ErrDriverExited).
+		Once()
+
+	err := e.Prepare(options)
+	assert.True(t, errors.Is(err, driver.ErrDriverExited), "expected ErrDriverExited, got %v", err)
+
+	driverMock.On("Call", mock.Anything, api.DriverMethodCleanup, mock.Anything, nil, mock.Anything).
+		Return(driver.ErrDriverExited).
+		Once()
+	driverMock.On("Close").Return(nil).Once()
+
+	e.Cleanup()
+}
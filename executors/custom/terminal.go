@@ -5,10 +5,89 @@ package custom
 
 import (
 	"errors"
+	"net/http"
+	"os"
+	"os/exec"
+	"time"
 
+	"github.com/creack/pty"
+
+	"gitlab.com/gitlab-org/gitlab-runner/executors/custom/command"
 	terminalsession "gitlab.com/gitlab-org/gitlab-runner/session/terminal"
+	terminal "gitlab.com/gitlab-org/gitlab-terminal"
 )
 
+type terminalConn struct {
+	cmd *exec.Cmd
+	tty *os.File
+
+	killTimeout time.Duration
+}
+
+func (t *terminalConn) Start(w http.ResponseWriter, r *http.Request, timeoutCh, disconnectCh chan error) {
+	proxy := terminal.NewFileDescriptorProxy(1) // one stopper: terminal exit handler
+
+	terminalsession.ProxyTerminal(
+		timeoutCh,
+		disconnectCh,
+		proxy.StopCh,
+		func() {
+			terminal.ProxyFileDescriptor(w, r, t.tty, proxy)
+		},
+	)
+}
+
+// Close closes the terminal, which hangs up the terminal_exec process. It's
+// killed when it doesn't exit before the graceful kill timeout.
+func (t *terminalConn) Close() error {
+	err := t.tty.Close()
+
+	go func() {
+		waitCh := make(chan struct{})
+		go func() {
+			_ = t.cmd.Wait()
+			close(waitCh)
+		}()
+
+		select {
+		case <-waitCh:
+		case <-time.After(t.killTimeout):
+			_ = t.cmd.Process.Kill()
+		}
+	}()
+
+	return err
+}
+
+// Connect starts the terminal_exec of the driver with a PTY, the driver
+// attaches it to an interactive shell in the environment of the job
 func (e *executor) Connect() (terminalsession.Conn, error) {
-	return nil, errors.New("not yet supported")
+	if e.config == nil || e.config.TerminalExec == "" {
+		return nil, errors.New("the custom executor driver doesn't support terminals, terminal_exec isn't set")
+	}
+
+	if !e.Build.ExecutorFeatures.Terminal {
+		return nil, errors.New("the custom executor driver disabled the terminals of the job")
+	}
+
+	cmd := exec.Command(e.config.TerminalExec, e.config.TerminalArgs...)
+	cmd.Dir = e.tempDir
+	cmd.Env = e.terminalEnv()
+
+	tty, err := pty.Start(cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	return &terminalConn{
+		cmd:         cmd,
+		tty:         tty,
+		killTimeout: e.config.GetGracefulKillTimeout(),
+	}, nil
+}
+
+// terminalEnv returns the environment of terminal_exec, the same as the
+// environment of the other executables of the driver
+func (e *executor) terminalEnv() []string {
+	return command.Env(e.tempDir, e.commandEnv(), e.commandOptions())
 }
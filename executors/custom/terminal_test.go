@@ -4,9 +4,14 @@
 package custom
 
 import (
+	"bufio"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-runner/common"
+	"gitlab.com/gitlab-org/gitlab-runner/executors"
 )
 
 func TestExecutor_Connect(t *testing.T) {
@@ -14,5 +19,57 @@ func TestExecutor_Connect(t *testing.T) {
 	connection, err := e.Connect()
 
 	assert.Nil(t, connection)
-	assert.EqualError(t, err, "not yet supported")
+	assert.EqualError(t, err, "the custom executor driver doesn't support terminals, terminal_exec isn't set")
+}
+
+func TestExecutor_ConnectTerminalDisabled(t *testing.T) {
+	e := &executor{
+		AbstractExecutor: executors.AbstractExecutor{
+			Build: &common.Build{
+				ExecutorFeatures: common.FeaturesInfo{Terminal: false},
+			},
+		},
+		config: &config{
+			CustomConfig: &common.CustomConfig{TerminalExec: "sh"},
+		},
+	}
+
+	connection, err := e.Connect()
+
+	assert.Nil(t, connection)
+	assert.EqualError(t, err, "the custom executor driver disabled the terminals of the job")
+}
+
+func TestExecutor_ConnectTerminalExec(t *testing.T) {
+	e := &executor{
+		AbstractExecutor: executors.AbstractExecutor{
+			Build: &common.Build{
+				JobResponse: common.JobResponse{
+					Variables: common.JobVariables{{Key: "CI_JOB_ID", Value: "1234"}},
+				},
+				Runner:           &common.RunnerConfig{},
+				ExecutorFeatures: common.FeaturesInfo{Terminal: true},
+			},
+		},
+		config: &config{
+			CustomConfig: &common.CustomConfig{
+				TerminalExec: "sh",
+				TerminalArgs: []string{"-c", `test -t 0 && echo "job $CUSTOM_ENV_CI_JOB_ID"`},
+			},
+		},
+		tempDir:         t.TempDir(),
+		jobResponseFile: "response.json",
+	}
+
+	connection, err := e.Connect()
+	require.NoError(t, err)
+
+	conn, ok := connection.(*terminalConn)
+	require.True(t, ok)
+
+	line, err := bufio.NewReader(conn.tty).ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "job 1234\r\n", line)
+
+	assert.NoError(t, conn.Close())
 }
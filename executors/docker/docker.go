@@ -28,6 +28,7 @@ import (
 	"gitlab.com/gitlab-org/gitlab-runner/executors/docker/internal/labels"
 	"gitlab.com/gitlab-org/gitlab-runner/executors/docker/internal/networks"
 	"gitlab.com/gitlab-org/gitlab-runner/executors/docker/internal/pull"
+	"gitlab.com/gitlab-org/gitlab-runner/executors/docker/internal/snapshots"
 	"gitlab.com/gitlab-org/gitlab-runner/executors/docker/internal/volumes"
 	"gitlab.com/gitlab-org/gitlab-runner/executors/docker/internal/volumes/parser"
 	"gitlab.com/gitlab-org/gitlab-runner/executors/docker/internal/volumes/permission"
@@ -92,8 +93,14 @@ type executor struct {
 	labeler         labels.Labeler
 	pullManager     pull.Manager
 
+	snapshotsManager snapshots.Manager
+
 	networkMode container.NetworkMode
 
+	egressEnvironment []string // proxy variables set when the job egress is restricted
+	// proxy variables of the services with an egress exception, by service index
+	egressServiceEnvironment map[int][]string
+
 	projectUniqRandomizedName string
 }
 
@@ -128,11 +135,11 @@ func init() {
 	}
 }
 
-func (e *executor) getServiceVariables(serviceDefinition common.Image) []string {
+func (e *executor) getServiceVariables(serviceIndex int, serviceDefinition common.Image) []string {
 	variables := e.Build.GetAllVariables().PublicOrInternal()
 	variables = append(variables, serviceDefinition.Variables...)
 
-	return variables.Expand().StringList()
+	return append(variables.Expand().StringList(), e.serviceEgressEnvironment(serviceIndex)...)
 }
 
 func (e *executor) expandAndGetDockerImage(imageName string, allowedImages []string) (*types.ImageInspect, error) {
@@ -372,7 +379,7 @@ func (e *executor) createService(
 	config := &container.Config{
 		Image:  serviceImage.ID,
 		Labels: e.labeler.Labels(labels),
-		Env:    e.getServiceVariables(serviceDefinition),
+		Env:    e.getServiceVariables(serviceIndex, serviceDefinition),
 	}
 
 	if len(serviceDefinition.Command) > 0 {
@@ -380,7 +387,11 @@ func (e *executor) createService(
 	}
 	config.Entrypoint = e.overwriteEntrypoint(&serviceDefinition)
 
-	hostConfig := e.createHostConfigForService()
+	hostConfig, err := e.createHostConfigForService()
+	if err != nil {
+		return nil, err
+	}
+
 	networkConfig := e.networkConfig(linkNames)
 
 	e.Debugln("Creating service container", containerName, "...")
@@ -399,8 +410,14 @@ func (e *executor) createService(
 	return fakeContainer(resp.ID, containerName), nil
 }
 
-func (e *executor) createHostConfigForService() *container.HostConfig {
+func (e *executor) createHostConfigForService() (*container.HostConfig, error) {
+	limits, err := e.Config.Docker.GetServicesResourceLimits()
+	if err != nil {
+		return nil, err
+	}
+
 	return &container.HostConfig{
+		Resources:     createResourcesWithLimits(limits),
 		DNS:           e.Config.Docker.DNS,
 		DNSSearch:     e.Config.Docker.DNSSearch,
 		RestartPolicy: neverRestartPolicy,
@@ -415,6 +432,16 @@ func (e *executor) createHostConfigForService() *container.HostConfig {
 		LogConfig: container.LogConfig{
 			Type: "json-file",
 		},
+	}, nil
+}
+
+func createResourcesWithLimits(limits common.DockerResourceLimits) container.Resources {
+	return container.Resources{
+		Ulimits:           limits.Ulimits,
+		PidsLimit:         limits.PidsLimit,
+		CgroupParent:      limits.CgroupParent,
+		BlkioWeight:       limits.BlkioWeight,
+		DeviceCgroupRules: limits.DeviceCgroupRules,
 	}
 }
 
@@ -684,7 +711,7 @@ func (e *executor) createContainerConfig(
 		AttachStderr: true,
 		OpenStdin:    true,
 		StdinOnce:    true,
-		Env:          e.Build.GetAllVariables().StringList(),
+		Env:          append(e.Build.GetAllVariables().StringList(), e.egressEnvironment...),
 	}
 	config.Entrypoint = e.overwriteEntrypoint(&imageDefinition)
 
@@ -697,18 +724,24 @@ func (e *executor) createHostConfig() (*container.HostConfig, error) {
 		return nil, err
 	}
 
+	limits, err := e.Config.Docker.GetResourceLimits()
+	if err != nil {
+		return nil, err
+	}
+
+	resources := createResourcesWithLimits(limits)
+	resources.Memory = e.Config.Docker.GetMemory()
+	resources.MemorySwap = e.Config.Docker.GetMemorySwap()
+	resources.MemoryReservation = e.Config.Docker.GetMemoryReservation()
+	resources.CpusetCpus = e.Config.Docker.CPUSetCPUs
+	resources.CPUShares = e.Config.Docker.CPUShares
+	resources.NanoCPUs = nanoCPUs
+	resources.Devices = e.devices
+	resources.DeviceRequests = e.deviceRequests
+	resources.OomKillDisable = e.Config.Docker.GetOomKillDisable()
+
 	return &container.HostConfig{
-		Resources: container.Resources{
-			Memory:            e.Config.Docker.GetMemory(),
-			MemorySwap:        e.Config.Docker.GetMemorySwap(),
-			MemoryReservation: e.Config.Docker.GetMemoryReservation(),
-			CpusetCpus:        e.Config.Docker.CPUSetCPUs,
-			CPUShares:         e.Config.Docker.CPUShares,
-			NanoCPUs:          nanoCPUs,
-			Devices:           e.devices,
-			DeviceRequests:    e.deviceRequests,
-			OomKillDisable:    e.Config.Docker.GetOomKillDisable(),
-		},
+		Resources:     resources,
 		DNS:           e.Config.Docker.DNS,
 		DNSSearch:     e.Config.Docker.DNSSearch,
 		Runtime:       e.Config.Docker.Runtime,
@@ -897,6 +930,8 @@ func (e *executor) createDependencies() error {
 		e.createNetworksManager,
 		e.createBuildNetwork,
 		e.createPullManager,
+		e.createSnapshotsManager,
+		e.createEgressProxy,
 		e.bindDevices,
 		e.bindDeviceRequests,
 		e.createVolumesManager,
@@ -114,6 +114,16 @@ func (s *commandExecutor) Run(cmd common.ExecutorCommand) error {
 	return runErr
 }
 
+func (s *commandExecutor) Finish(err error) {
+	if err != nil {
+		if buildContainer := s.getBuildContainer(); buildContainer != nil {
+			s.keepFailedContainer(buildContainer.ID)
+		}
+	}
+
+	s.executor.Finish(err)
+}
+
 func (s *commandExecutor) getContainer(cmd common.ExecutorCommand) (*types.ContainerJSON, error) {
 	if cmd.Predefined {
 		return s.requestNewPredefinedContainer()
@@ -348,10 +358,12 @@ func init() {
 		features.ServiceVariables = true
 	}
 
-	common.RegisterExecutorProvider("docker", executors.DefaultExecutorProvider{
-		Creator:          creator,
-		FeaturesUpdater:  featuresUpdater,
-		ConfigUpdater:    configUpdater,
-		DefaultShellName: options.Shell.Shell,
+	common.RegisterExecutorProvider("docker", executorProvider{
+		DefaultExecutorProvider: executors.DefaultExecutorProvider{
+			Creator:          creator,
+			FeaturesUpdater:  featuresUpdater,
+			ConfigUpdater:    configUpdater,
+			DefaultShellName: options.Shell.Shell,
+		},
 	})
 }
@@ -51,10 +51,12 @@ func init() {
 		features.Terminal = false
 	}
 
-	common.RegisterExecutorProvider("docker-windows", executors.DefaultExecutorProvider{
-		Creator:          creator,
-		FeaturesUpdater:  featuresUpdater,
-		ConfigUpdater:    configUpdater,
-		DefaultShellName: options.Shell.Shell,
+	common.RegisterExecutorProvider("docker-windows", executorProvider{
+		DefaultExecutorProvider: executors.DefaultExecutorProvider{
+			Creator:          creator,
+			FeaturesUpdater:  featuresUpdater,
+			ConfigUpdater:    configUpdater,
+			DefaultShellName: options.Shell.Shell,
+		},
 	})
 }
@@ -120,10 +120,12 @@ func init() {
 		features.Services = true
 	}
 
-	common.RegisterExecutorProvider("docker-ssh", executors.DefaultExecutorProvider{
-		Creator:          creator,
-		FeaturesUpdater:  featuresUpdater,
-		ConfigUpdater:    configUpdater,
-		DefaultShellName: options.Shell.Shell,
+	common.RegisterExecutorProvider("docker-ssh", executorProvider{
+		DefaultExecutorProvider: executors.DefaultExecutorProvider{
+			Creator:          creator,
+			FeaturesUpdater:  featuresUpdater,
+			ConfigUpdater:    configUpdater,
+			DefaultShellName: options.Shell.Shell,
+		},
 	})
 }
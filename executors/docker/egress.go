@@ -0,0 +1,220 @@
+package docker
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+
+	"github.com/bmatcuk/doublestar"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/network"
+
+	"gitlab.com/gitlab-org/gitlab-runner/common"
+	"gitlab.com/gitlab-org/gitlab-runner/helpers"
+	"gitlab.com/gitlab-org/gitlab-runner/helpers/container/services"
+	"gitlab.com/gitlab-org/gitlab-runner/helpers/egress"
+)
+
+const (
+	labelEgressProxyType = "egress-proxy"
+
+	egressProxyAlias = "egress-proxy"
+	egressProxyPort  = 3128
+
+	egressCredentialLength = 16
+)
+
+// newEgressPolicy converts the egress_policy configuration into the policy
+// that is enforced by the egress proxy. The exceptions are matched against the
+// images of the services, which are restricted by allowed_services, and every
+// matching service gets its own credential for the proxy. The credentials are
+// returned by the index of the service.
+func newEgressPolicy(config *common.DockerEgress, definitions common.Services) (egress.Policy, map[int]string, error) {
+	policy := egress.Policy{
+		Allowed:    config.Allowed,
+		Exceptions: make(map[string][]string),
+	}
+
+	for _, exception := range config.ServiceExceptions {
+		if exception.Image == "" {
+			return egress.Policy{}, nil, fmt.Errorf("egress policy service exception without image")
+		}
+
+		_, err := egress.Compile(exception.Allowed)
+		if err != nil {
+			return egress.Policy{}, nil, fmt.Errorf("egress policy service exception for %q: %w", exception.Image, err)
+		}
+	}
+
+	credentials := make(map[int]string)
+	for index, definition := range definitions {
+		allowed := serviceEgressExceptions(config.ServiceExceptions, definition.Name)
+		if len(allowed) == 0 {
+			continue
+		}
+
+		credential, err := helpers.GenerateRandomUUID(egressCredentialLength)
+		if err != nil {
+			return egress.Policy{}, nil, fmt.Errorf("generating egress proxy credential: %w", err)
+		}
+
+		credentials[index] = credential
+		policy.Exceptions[credential] = allowed
+	}
+
+	return policy, credentials, policy.Validate()
+}
+
+func serviceEgressExceptions(exceptions []common.DockerEgressServiceException, image string) []string {
+	var allowed []string
+	for _, exception := range exceptions {
+		ok, _ := doublestar.Match(exception.Image, image)
+		if ok {
+			allowed = append(allowed, exception.Allowed...)
+		}
+	}
+
+	return allowed
+}
+
+// createEgressProxy starts the egress proxy container when an egress policy
+// is configured. The proxy is the only container of the job attached to both
+// the internal per-build network and the default bridge network, so all
+// of the outgoing traffic of the job has to pass through it.
+func (e *executor) createEgressProxy() error {
+	if e.Config.Docker.EgressPolicy == nil {
+		return nil
+	}
+
+	if e.info.OSType == osTypeWindows {
+		return common.MakeBuildError("egress_policy is not supported on Windows")
+	}
+
+	if e.networkMode.UserDefined() == "" || e.Config.Docker.NetworkMode != "" {
+		return common.MakeBuildError("egress_policy can't be used together with network_mode")
+	}
+
+	definitions, err := e.getServicesDefinitions()
+	if err != nil {
+		return err
+	}
+
+	policy, credentials, err := newEgressPolicy(e.Config.Docker.EgressPolicy, definitions)
+	if err != nil {
+		return common.MakeBuildError("invalid egress_policy: %v", err)
+	}
+
+	// the helper needs to reach GitLab to fetch the sources and to upload
+	// the artifacts, so it's always allowed
+	policy.Allowed = append(policy.Allowed, e.getGitLabHosts()...)
+
+	encodedPolicy, err := policy.Encode()
+	if err != nil {
+		return err
+	}
+
+	proxyImage, err := e.getPrebuiltImage()
+	if err != nil {
+		return fmt.Errorf("getPrebuiltImage: %w", err)
+	}
+
+	containerName := e.getProjectUniqRandomizedName() + "-egress-proxy"
+
+	// this will fail potentially some builds if there's name collision
+	_ = e.removeContainer(e.Context, containerName)
+
+	config := &container.Config{
+		Image: proxyImage.ID,
+		Cmd: []string{
+			"gitlab-runner-helper", "egress-proxy",
+			"--listen", ":" + strconv.Itoa(egressProxyPort),
+			"--policy", encodedPolicy,
+		},
+		Labels: e.labeler.Labels(map[string]string{"type": labelEgressProxyType}),
+	}
+
+	hostConfig := &container.HostConfig{
+		RestartPolicy: neverRestartPolicy,
+		NetworkMode:   container.NetworkMode("bridge"),
+		LogConfig: container.LogConfig{
+			Type: "json-file",
+		},
+	}
+
+	e.Debugln("Creating egress proxy container", containerName, "...")
+	resp, err := e.client.ContainerCreate(e.Context, config, hostConfig, nil, containerName)
+	if err != nil {
+		return fmt.Errorf("create egress proxy container: %w", err)
+	}
+	e.temporary = append(e.temporary, resp.ID)
+
+	err = e.client.NetworkConnect(
+		e.Context,
+		e.networkMode.NetworkName(),
+		resp.ID,
+		&network.EndpointSettings{Aliases: []string{egressProxyAlias}},
+	)
+	if err != nil {
+		return fmt.Errorf("connect egress proxy container to the build network: %w", err)
+	}
+
+	e.Debugln(fmt.Sprintf("Starting egress proxy container %s (%s)...", containerName, resp.ID))
+	err = e.client.ContainerStart(e.Context, resp.ID, types.ContainerStartOptions{})
+	if err != nil {
+		return fmt.Errorf("start egress proxy container: %w", err)
+	}
+
+	noProxy := getServiceAliases(definitions)
+
+	proxyURL := egress.ProxyURL(egressProxyAlias, egressProxyPort, "")
+	e.egressEnvironment = egress.ProxyEnvironment(proxyURL, noProxy)
+
+	e.egressServiceEnvironment = make(map[int][]string, len(credentials))
+	for index, credential := range credentials {
+		serviceProxyURL := egress.ProxyURL(egressProxyAlias, egressProxyPort, credential)
+		e.egressServiceEnvironment[index] = egress.ProxyEnvironment(serviceProxyURL, noProxy)
+	}
+
+	e.Println("Job egress restricted by the egress policy, using proxy", proxyURL)
+
+	return nil
+}
+
+func (e *executor) getGitLabHosts() []string {
+	var hosts []string
+	for _, rawURL := range []string{e.Config.URL, e.Config.CloneURL} {
+		u, err := url.Parse(rawURL)
+		if err != nil || u.Hostname() == "" {
+			continue
+		}
+
+		hosts = append(hosts, u.Hostname())
+	}
+
+	return hosts
+}
+
+// serviceEgressEnvironment returns the proxy variables of the service, which
+// include its credential when its image has an egress exception
+func (e *executor) serviceEgressEnvironment(serviceIndex int) []string {
+	if environment, ok := e.egressServiceEnvironment[serviceIndex]; ok {
+		return environment
+	}
+
+	return e.egressEnvironment
+}
+
+// getServiceAliases returns the hostnames of all services of the job, which
+// are reached directly on the per-build network and not through the proxy
+func getServiceAliases(definitions common.Services) []string {
+	var aliases []string
+	for _, definition := range definitions {
+		aliases = append(aliases, services.SplitNameAndVersion(definition.Name).Aliases...)
+		if definition.Alias != "" {
+			aliases = append(aliases, definition.Alias)
+		}
+	}
+
+	return aliases
+}
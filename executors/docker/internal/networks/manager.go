@@ -50,7 +50,8 @@ func (m *manager) Create(ctx context.Context, networkMode string) (container.Net
 		return m.networkMode, nil
 	}
 
-	if !m.build.IsFeatureFlagOn(featureflags.NetworkPerBuild) {
+	internal := m.egressRestricted()
+	if !internal && !m.build.IsFeatureFlagOn(featureflags.NetworkPerBuild) {
 		return m.networkMode, nil
 	}
 
@@ -65,7 +66,10 @@ func (m *manager) Create(ctx context.Context, networkMode string) (container.Net
 	networkResponse, err := m.client.NetworkCreate(
 		ctx,
 		networkName,
-		types.NetworkCreate{Labels: m.labeler.Labels(map[string]string{})},
+		types.NetworkCreate{
+			Labels:   m.labeler.Labels(map[string]string{}),
+			Internal: internal,
+		},
 	)
 	if err != nil {
 		return "", err
@@ -83,6 +87,15 @@ func (m *manager) Create(ctx context.Context, networkMode string) (container.Net
 	return m.networkMode, nil
 }
 
+// egressRestricted tells whether the job egress is limited by a policy. The
+// per-build network is then created as internal, without any outside
+// connectivity, and the traffic goes through the egress proxy only.
+func (m *manager) egressRestricted() bool {
+	return m.build.Runner != nil &&
+		m.build.Runner.Docker != nil &&
+		m.build.Runner.Docker.EgressPolicy != nil
+}
+
 func (m *manager) Inspect(ctx context.Context) (types.NetworkResource, error) {
 	if !m.perBuild {
 		return types.NetworkResource{}, nil
@@ -94,10 +107,6 @@ func (m *manager) Inspect(ctx context.Context) (types.NetworkResource, error) {
 }
 
 func (m *manager) Cleanup(ctx context.Context) error {
-	if !m.build.IsFeatureFlagOn(featureflags.NetworkPerBuild) {
-		return nil
-	}
-
 	if !m.perBuild {
 		return nil
 	}
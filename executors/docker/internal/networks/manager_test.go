@@ -65,6 +65,7 @@ func TestCreateNetwork(t *testing.T) {
 	testCases := map[string]struct {
 		networkMode         string
 		networkPerBuild     string
+		egressPolicy        *common.DockerEgress
 		buildNetwork        types.NetworkResource
 		expectedNetworkMode container.NetworkMode
 		expectedErr         error
@@ -144,6 +145,30 @@ func TestCreateNetwork(t *testing.T) {
 					Once()
 			},
 		},
+		"network create internal per-build network for egress policy": {
+			networkMode:         "",
+			networkPerBuild:     "false",
+			egressPolicy:        &common.DockerEgress{Allowed: []string{"10.0.0.0/8"}},
+			expectedNetworkMode: container.NetworkMode("runner-test-tok-project-0-concurrent-0-job-0-network"),
+			clientAssertions: func(mc *docker.MockClient) {
+				mc.On(
+					"NetworkCreate",
+					mock.Anything,
+					mock.AnythingOfType("string"),
+					mock.MatchedBy(func(options types.NetworkCreate) bool {
+						return options.Internal
+					}),
+				).
+					Return(types.NetworkCreateResponse{ID: "test-network"}, nil).
+					Once()
+				mc.On("NetworkInspect", mock.Anything, mock.AnythingOfType("string")).
+					Return(types.NetworkResource{
+						ID:   "test-network",
+						Name: "test-network",
+					}, nil).
+					Once()
+			},
+		},
 		"networkID already set": {
 			networkMode:     "",
 			networkPerBuild: "true",
@@ -159,6 +184,7 @@ func TestCreateNetwork(t *testing.T) {
 		t.Run(testName, func(t *testing.T) {
 			m := newDefaultManager()
 			m.build.ID = 0
+			m.build.Runner.Docker = &common.DockerConfig{EgressPolicy: testCase.egressPolicy}
 			m.buildNetwork = testCase.buildNetwork
 
 			client := addClient(m)
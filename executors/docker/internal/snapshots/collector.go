@@ -0,0 +1,178 @@
+package snapshots
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"gitlab.com/gitlab-org/gitlab-runner/helpers/docker"
+)
+
+const (
+	minCollectInterval = time.Minute
+	maxCollectInterval = time.Hour
+
+	collectTimeout = 5 * time.Minute
+
+	// maxCollectFailures is the number of consecutive failed collections after
+	// which the loop of a Docker host stops, for example when the host was an
+	// autoscaled machine that has been removed
+	maxCollectFailures = 3
+)
+
+// Collector enforces the retention of failed job snapshots. It runs one
+// loop per Docker host, using the TTL most recently configured for that host,
+// until the host has no snapshots left, its collections keep failing or the
+// collector is stopped.
+type Collector struct {
+	newClient func(credentials docker.Credentials) (docker.Client, error)
+	interval  func(ttl time.Duration) time.Duration
+	logger    logrus.FieldLogger
+
+	lock  sync.Mutex
+	hosts map[string]*collectedHost
+	stopC chan struct{}
+	once  sync.Once
+}
+
+// collectedHost is the state of the loop of a Docker host, starts counts the
+// calls of Start so that the loop doesn't stop while a snapshot is being kept
+type collectedHost struct {
+	ttl    time.Duration
+	starts int
+}
+
+var defaultCollector = NewCollector()
+
+func NewCollector() *Collector {
+	return &Collector{
+		newClient: docker.New,
+		interval:  collectInterval,
+		logger:    logrus.WithField("module", "failed-containers-collector"),
+		hosts:     make(map[string]*collectedHost),
+		stopC:     make(chan struct{}),
+	}
+}
+
+// StopCollector stops the default collector
+func StopCollector() {
+	defaultCollector.Stop()
+}
+
+// StartCollector ensures that the default collector is running for the given Docker host
+func StartCollector(credentials docker.Credentials, ttl time.Duration) {
+	defaultCollector.Start(credentials, ttl)
+}
+
+// Start ensures that a collection loop is running for the given Docker host
+// and updates the TTL it enforces
+func (c *Collector) Start(credentials docker.Credentials, ttl time.Duration) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	host, running := c.hosts[credentials.Host]
+	if !running {
+		host = new(collectedHost)
+		c.hosts[credentials.Host] = host
+	}
+
+	host.ttl = ttl
+	host.starts++
+
+	if running {
+		return
+	}
+
+	go c.run(credentials)
+}
+
+// Stop stops the collection loops of all the Docker hosts
+func (c *Collector) Stop() {
+	c.once.Do(func() { close(c.stopC) })
+}
+
+func (c *Collector) host(name string) (time.Duration, int) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	host := c.hosts[name]
+
+	return host.ttl, host.starts
+}
+
+// finish removes the loop of the host, unless the host was started again
+// since the given number of starts
+func (c *Collector) finish(name string, starts int) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.hosts[name].starts != starts {
+		return false
+	}
+
+	delete(c.hosts, name)
+
+	return true
+}
+
+func (c *Collector) run(credentials docker.Credentials) {
+	logger := c.logger.WithField("host", credentials.Host)
+	failures := 0
+
+	for {
+		ttl, starts := c.host(credentials.Host)
+
+		remaining, err := c.collect(credentials, ttl)
+		if err != nil {
+			failures++
+			logger.WithError(err).Warningln("Failed to remove expired failed job snapshots")
+		} else {
+			failures = 0
+			logger.WithField("remaining", remaining).Debugln("Removed expired failed job snapshots")
+		}
+
+		if (err == nil && remaining == 0) || failures >= maxCollectFailures {
+			if c.finish(credentials.Host, starts) {
+				logger.WithField("failures", failures).Debugln("Stopped the removal of the failed job snapshots")
+				return
+			}
+
+			failures = 0
+		}
+
+		select {
+		case <-c.stopC:
+			return
+		case <-time.After(c.interval(ttl)):
+		}
+	}
+}
+
+func (c *Collector) collect(credentials docker.Credentials, ttl time.Duration) (int, error) {
+	client, err := c.newClient(credentials)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = client.Close() }()
+
+	ctx, cancel := context.WithTimeout(context.Background(), collectTimeout)
+	defer cancel()
+
+	return RemoveExpired(ctx, client, ttl, time.Now())
+}
+
+func collectInterval(ttl time.Duration) time.Duration {
+	interval := ttl / 4
+
+	if interval < minCollectInterval {
+		return minCollectInterval
+	}
+
+	if interval > maxCollectInterval {
+		return maxCollectInterval
+	}
+
+	return interval
+}
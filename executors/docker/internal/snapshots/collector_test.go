@@ -0,0 +1,156 @@
+//go:build !integration
+// +build !integration
+
+package snapshots
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"gitlab.com/gitlab-org/gitlab-runner/helpers/docker"
+)
+
+func TestCollectInterval(t *testing.T) {
+	assert.Equal(t, minCollectInterval, collectInterval(time.Second))
+	assert.Equal(t, 15*time.Minute, collectInterval(time.Hour))
+	assert.Equal(t, maxCollectInterval, collectInterval(7*24*time.Hour))
+}
+
+func TestCollector_RunsPerHostWithConfiguredTTL(t *testing.T) {
+	var lock sync.Mutex
+	collected := make(map[string]int)
+	ttls := make(map[time.Duration]bool)
+
+	c := NewCollector()
+	defer c.Stop()
+
+	c.logger = logrus.New()
+	c.interval = func(ttl time.Duration) time.Duration {
+		lock.Lock()
+		defer lock.Unlock()
+
+		ttls[ttl] = true
+		return time.Millisecond
+	}
+	c.newClient = func(credentials docker.Credentials) (docker.Client, error) {
+		lock.Lock()
+		defer lock.Unlock()
+
+		collected[credentials.Host]++
+		return nil, errors.New("docker not available")
+	}
+
+	local := docker.Credentials{Host: "unix:///var/run/docker.sock"}
+	remote := docker.Credentials{Host: "tcp://docker:2375"}
+
+	c.Start(local, time.Hour)
+	c.Start(remote, 2*time.Hour)
+	// a second start for the same host updates the TTL of its loop
+	c.Start(local, 3*time.Hour)
+
+	assert.Eventually(t, func() bool {
+		lock.Lock()
+		defer lock.Unlock()
+
+		// the loops keep running after a failed collection
+		return collected[local.Host] > 1 && collected[remote.Host] > 1 && ttls[2*time.Hour] && ttls[3*time.Hour]
+	}, time.Second, time.Millisecond)
+
+	// the loops stop when their collections keep failing
+	assert.Eventually(t, func() bool {
+		c.lock.Lock()
+		defer c.lock.Unlock()
+
+		return len(c.hosts) == 0
+	}, time.Second, time.Millisecond)
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	assert.GreaterOrEqual(t, collected[remote.Host], maxCollectFailures)
+}
+
+func TestCollector_StopsWithoutSnapshots(t *testing.T) {
+	client := new(docker.MockClient)
+	defer client.AssertExpectations(t)
+
+	client.On("ImageList", mock.Anything, mock.Anything).Return(nil, nil).Once()
+	client.On("ContainerList", mock.Anything, mock.Anything).Return(nil, nil).Once()
+	client.On("Close").Return(nil).Once()
+
+	c := NewCollector()
+	defer c.Stop()
+
+	c.logger = logrus.New()
+	c.interval = func(time.Duration) time.Duration { return time.Millisecond }
+	c.newClient = func(credentials docker.Credentials) (docker.Client, error) {
+		return client, nil
+	}
+
+	c.Start(docker.Credentials{Host: "unix:///var/run/docker.sock"}, time.Hour)
+
+	assert.Eventually(t, func() bool {
+		c.lock.Lock()
+		defer c.lock.Unlock()
+
+		return len(c.hosts) == 0
+	}, time.Second, time.Millisecond)
+}
+
+func TestCollector_FinishKeepsRestartedHost(t *testing.T) {
+	c := NewCollector()
+	c.hosts["host"] = &collectedHost{ttl: time.Hour, starts: 2}
+
+	// the host was started again while it was collected
+	assert.False(t, c.finish("host", 1))
+	assert.Contains(t, c.hosts, "host")
+
+	assert.True(t, c.finish("host", 2))
+	assert.NotContains(t, c.hosts, "host")
+}
+
+func TestCollector_RemovesExpiredSnapshots(t *testing.T) {
+	client := new(docker.MockClient)
+	defer client.AssertExpectations(t)
+
+	expired := types.ImageSummary{Created: time.Now().Add(-2 * time.Hour).Unix(), RepoTags: []string{"gitlab-runner-failed-job:1"}}
+
+	collected := make(chan struct{}, 1)
+	client.On("ImageList", mock.Anything, mock.Anything).
+		Return([]types.ImageSummary{expired}, nil).
+		Once()
+	client.On("ImageRemove", mock.Anything, "gitlab-runner-failed-job:1", mock.Anything).
+		Return(nil, nil).
+		Once()
+	client.On("ContainerList", mock.Anything, mock.Anything).
+		Return(nil, nil).
+		Once()
+	client.On("Close").
+		Run(func(mock.Arguments) { collected <- struct{}{} }).
+		Return(nil).
+		Once()
+
+	c := NewCollector()
+	c.logger = logrus.New()
+	c.interval = func(time.Duration) time.Duration { return time.Hour }
+	c.newClient = func(credentials docker.Credentials) (docker.Client, error) {
+		return client, nil
+	}
+
+	c.Start(docker.Credentials{Host: "unix:///var/run/docker.sock"}, time.Hour)
+
+	select {
+	case <-collected:
+	case <-time.After(time.Second):
+		assert.Fail(t, "snapshots not collected")
+	}
+
+	c.Stop()
+}
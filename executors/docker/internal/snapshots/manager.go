@@ -0,0 +1,220 @@
+package snapshots
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
+
+	"gitlab.com/gitlab-org/gitlab-runner/common"
+	"gitlab.com/gitlab-org/gitlab-runner/helpers/docker"
+)
+
+const (
+	// ImageRepository is the local repository into which failed build containers are committed.
+	// The tag of each image is the ID of the job that failed.
+	ImageRepository = "gitlab-runner-failed-job"
+
+	// containerNameInfix is inserted into the name of a kept build container,
+	// which allows the collector to find it later on.
+	containerNameInfix = "-failed-job-"
+
+	stopTimeout = 10 * time.Second
+)
+
+var errUnsupportedMode = errors.New("unsupported keep failed containers mode")
+
+// Snapshot describes a failed build container that was kept for debugging.
+type Snapshot struct {
+	// Reference is the image reference in the commit mode or the container name in the stop mode
+	Reference string
+	// ExpiresAt is the time after which the snapshot will be removed by the collector
+	ExpiresAt time.Time
+	// ReproduceCommands are the commands to run on the Docker host to reproduce the job environment
+	ReproduceCommands []string
+	// KeepContainer tells whether the build container must be left in place during the cleanup
+	KeepContainer bool
+}
+
+type Manager interface {
+	Keep(ctx context.Context, containerID string) (Snapshot, error)
+}
+
+type manager struct {
+	logger debugLogger
+	client docker.Client
+	build  *common.Build
+
+	mode string
+	ttl  time.Duration
+}
+
+func NewManager(logger debugLogger, dockerClient docker.Client, build *common.Build, mode string, ttl time.Duration) Manager {
+	return &manager{
+		logger: logger,
+		client: dockerClient,
+		build:  build,
+		mode:   mode,
+		ttl:    ttl,
+	}
+}
+
+func (m *manager) Keep(ctx context.Context, containerID string) (Snapshot, error) {
+	m.logger.Debugln("Stopping failed build container", containerID)
+
+	timeout := stopTimeout
+	err := m.client.ContainerStop(ctx, containerID, &timeout)
+	if err != nil {
+		return Snapshot{}, fmt.Errorf("stopping container %s: %w", containerID, err)
+	}
+
+	switch m.mode {
+	case common.KeepFailedContainersCommit:
+		return m.commit(ctx, containerID)
+	case common.KeepFailedContainersStop:
+		return m.rename(ctx, containerID)
+	default:
+		return Snapshot{}, fmt.Errorf("%w: %q", errUnsupportedMode, m.mode)
+	}
+}
+
+func (m *manager) commit(ctx context.Context, containerID string) (Snapshot, error) {
+	reference := fmt.Sprintf("%s:%d", ImageRepository, m.build.ID)
+
+	m.logger.Debugln("Committing failed build container", containerID, "to", reference)
+
+	_, err := m.client.ContainerCommit(ctx, containerID, types.ContainerCommitOptions{
+		Reference: reference,
+		Comment:   fmt.Sprintf("Snapshot of the build container of failed job %s", m.build.JobURL()),
+	})
+	if err != nil {
+		return Snapshot{}, fmt.Errorf("committing container %s: %w", containerID, err)
+	}
+
+	return Snapshot{
+		Reference:         reference,
+		ExpiresAt:         time.Now().Add(m.ttl),
+		ReproduceCommands: []string{runCommand(reference)},
+	}, nil
+}
+
+func (m *manager) rename(ctx context.Context, containerID string) (Snapshot, error) {
+	name := fmt.Sprintf("%s%s%d", m.build.ProjectUniqueName(), containerNameInfix, m.build.ID)
+
+	m.logger.Debugln("Renaming failed build container", containerID, "to", name)
+
+	err := m.client.ContainerRename(ctx, containerID, name)
+	if err != nil {
+		return Snapshot{}, fmt.Errorf("renaming container %s: %w", containerID, err)
+	}
+
+	// The network and the volumes of the build are removed during the cleanup,
+	// so the stopped container can't be started again. It's committed to an
+	// image when needed instead, which the collector removes with the others.
+	reference := fmt.Sprintf("%s:%d", ImageRepository, m.build.ID)
+
+	return Snapshot{
+		Reference: name,
+		ExpiresAt: time.Now().Add(m.ttl),
+		ReproduceCommands: []string{
+			fmt.Sprintf("docker commit %s %s", name, reference),
+			runCommand(reference),
+		},
+		KeepContainer: true,
+	}, nil
+}
+
+func runCommand(reference string) string {
+	return fmt.Sprintf("docker run --rm -it %s", reference)
+}
+
+// RemoveExpired removes the failed job images and kept containers that are older than ttl.
+// It returns the number of snapshots that are still retained.
+func RemoveExpired(ctx context.Context, client docker.Client, ttl time.Duration, now time.Time) (int, error) {
+	remainingImages, err := removeExpiredImages(ctx, client, ttl, now)
+	if err != nil {
+		return 0, err
+	}
+
+	remainingContainers, err := removeExpiredContainers(ctx, client, ttl, now)
+	if err != nil {
+		return 0, err
+	}
+
+	return remainingImages + remainingContainers, nil
+}
+
+func removeExpiredImages(ctx context.Context, client docker.Client, ttl time.Duration, now time.Time) (int, error) {
+	images, err := client.ImageList(ctx, types.ImageListOptions{
+		Filters: filters.NewArgs(filters.Arg("reference", ImageRepository)),
+	})
+	if err != nil {
+		return 0, fmt.Errorf("listing failed job images: %w", err)
+	}
+
+	remaining := 0
+	for _, image := range images {
+		if time.Unix(image.Created, 0).Add(ttl).After(now) {
+			remaining++
+			continue
+		}
+
+		for _, tag := range image.RepoTags {
+			_, err = client.ImageRemove(ctx, tag, types.ImageRemoveOptions{PruneChildren: true})
+			if err != nil && !docker.IsErrNotFound(err) {
+				return 0, fmt.Errorf("removing failed job image %s: %w", tag, err)
+			}
+		}
+	}
+
+	return remaining, nil
+}
+
+func removeExpiredContainers(ctx context.Context, client docker.Client, ttl time.Duration, now time.Time) (int, error) {
+	containers, err := client.ContainerList(ctx, types.ContainerListOptions{
+		All:     true,
+		Filters: filters.NewArgs(filters.Arg("name", containerNameInfix+`[0-9]+$`)),
+	})
+	if err != nil {
+		return 0, fmt.Errorf("listing failed job containers: %w", err)
+	}
+
+	remaining := 0
+	for _, c := range containers {
+		keptAt, err := containerFinishedAt(ctx, client, c)
+		if err != nil {
+			return 0, err
+		}
+
+		if keptAt.Add(ttl).After(now) {
+			remaining++
+			continue
+		}
+
+		err = client.ContainerRemove(ctx, c.ID, types.ContainerRemoveOptions{RemoveVolumes: true, Force: true})
+		if err != nil && !docker.IsErrNotFound(err) {
+			return 0, fmt.Errorf("removing failed job container %s: %w", c.ID, err)
+		}
+	}
+
+	return remaining, nil
+}
+
+func containerFinishedAt(ctx context.Context, client docker.Client, c types.Container) (time.Time, error) {
+	inspect, err := client.ContainerInspect(ctx, c.ID)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("inspecting failed job container %s: %w", c.ID, err)
+	}
+
+	if inspect.ContainerJSONBase != nil && inspect.State != nil {
+		finishedAt, err := time.Parse(time.RFC3339Nano, inspect.State.FinishedAt)
+		if err == nil && !finishedAt.IsZero() {
+			return finishedAt, nil
+		}
+	}
+
+	return time.Unix(c.Created, 0), nil
+}
@@ -0,0 +1,218 @@
+//go:build !integration
+// +build !integration
+
+package snapshots
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-runner/common"
+	"gitlab.com/gitlab-org/gitlab-runner/helpers/docker"
+)
+
+func newDebugLoggerMock() *mockDebugLogger {
+	loggerMock := new(mockDebugLogger)
+	loggerMock.On("Debugln", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Maybe()
+	loggerMock.On("Debugln", mock.Anything, mock.Anything).Maybe()
+
+	return loggerMock
+}
+
+func newTestBuild() *common.Build {
+	return &common.Build{
+		Runner: &common.RunnerConfig{
+			RunnerCredentials: common.RunnerCredentials{Token: "test-token"},
+		},
+		JobResponse: common.JobResponse{
+			ID: 1234,
+		},
+	}
+}
+
+func TestNewManager(t *testing.T) {
+	m := NewManager(newDebugLoggerMock(), nil, nil, common.KeepFailedContainersCommit, time.Hour)
+	assert.IsType(t, &manager{}, m)
+}
+
+func TestManager_Keep(t *testing.T) {
+	testErr := errors.New("test error")
+
+	tests := map[string]struct {
+		mode               string
+		clientAssertions   func(c *docker.MockClient)
+		expectedReference  string
+		expectedCommands   []string
+		expectedKeep       bool
+		expectedErr        error
+		expectedErrMessage string
+	}{
+		"commit": {
+			mode: common.KeepFailedContainersCommit,
+			clientAssertions: func(c *docker.MockClient) {
+				c.On("ContainerStop", mock.Anything, "container-id", mock.Anything).
+					Return(nil).
+					Once()
+				c.On(
+					"ContainerCommit",
+					mock.Anything,
+					"container-id",
+					mock.MatchedBy(func(options types.ContainerCommitOptions) bool {
+						return options.Reference == "gitlab-runner-failed-job:1234"
+					}),
+				).
+					Return(types.IDResponse{ID: "image-id"}, nil).
+					Once()
+			},
+			expectedReference: "gitlab-runner-failed-job:1234",
+			expectedCommands:  []string{"docker run --rm -it gitlab-runner-failed-job:1234"},
+		},
+		"stop": {
+			mode: common.KeepFailedContainersStop,
+			clientAssertions: func(c *docker.MockClient) {
+				c.On("ContainerStop", mock.Anything, "container-id", mock.Anything).
+					Return(nil).
+					Once()
+				c.On(
+					"ContainerRename",
+					mock.Anything,
+					"container-id",
+					"runner-test-tok-project-0-concurrent-0-failed-job-1234",
+				).
+					Return(nil).
+					Once()
+			},
+			expectedReference: "runner-test-tok-project-0-concurrent-0-failed-job-1234",
+			expectedCommands: []string{
+				"docker commit runner-test-tok-project-0-concurrent-0-failed-job-1234 gitlab-runner-failed-job:1234",
+				"docker run --rm -it gitlab-runner-failed-job:1234",
+			},
+			expectedKeep: true,
+		},
+		"stop failure": {
+			mode: common.KeepFailedContainersCommit,
+			clientAssertions: func(c *docker.MockClient) {
+				c.On("ContainerStop", mock.Anything, "container-id", mock.Anything).
+					Return(testErr).
+					Once()
+			},
+			expectedErr: testErr,
+		},
+		"commit failure": {
+			mode: common.KeepFailedContainersCommit,
+			clientAssertions: func(c *docker.MockClient) {
+				c.On("ContainerStop", mock.Anything, "container-id", mock.Anything).
+					Return(nil).
+					Once()
+				c.On("ContainerCommit", mock.Anything, "container-id", mock.Anything).
+					Return(types.IDResponse{}, testErr).
+					Once()
+			},
+			expectedErr: testErr,
+		},
+		"unsupported mode": {
+			mode: "unknown",
+			clientAssertions: func(c *docker.MockClient) {
+				c.On("ContainerStop", mock.Anything, "container-id", mock.Anything).
+					Return(nil).
+					Once()
+			},
+			expectedErr: errUnsupportedMode,
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			client := new(docker.MockClient)
+			defer client.AssertExpectations(t)
+
+			tt.clientAssertions(client)
+
+			m := NewManager(newDebugLoggerMock(), client, newTestBuild(), tt.mode, time.Hour)
+
+			snapshot, err := m.Keep(context.Background(), "container-id")
+			if tt.expectedErr != nil {
+				assert.ErrorIs(t, err, tt.expectedErr)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.expectedReference, snapshot.Reference)
+			assert.Equal(t, tt.expectedCommands, snapshot.ReproduceCommands)
+			assert.Equal(t, tt.expectedKeep, snapshot.KeepContainer)
+			assert.WithinDuration(t, time.Now().Add(time.Hour), snapshot.ExpiresAt, time.Minute)
+		})
+	}
+}
+
+func TestRemoveExpired(t *testing.T) {
+	now := time.Now()
+	ttl := time.Hour
+
+	client := new(docker.MockClient)
+	defer client.AssertExpectations(t)
+
+	client.On("ImageList", mock.Anything, mock.Anything).
+		Return([]types.ImageSummary{
+			{
+				ID:       "expired-image",
+				Created:  now.Add(-2 * time.Hour).Unix(),
+				RepoTags: []string{"gitlab-runner-failed-job:1"},
+			},
+			{
+				ID:       "fresh-image",
+				Created:  now.Add(-time.Minute).Unix(),
+				RepoTags: []string{"gitlab-runner-failed-job:2"},
+			},
+		}, nil).
+		Once()
+	client.On("ImageRemove", mock.Anything, "gitlab-runner-failed-job:1", mock.Anything).
+		Return(nil, nil).
+		Once()
+
+	client.On("ContainerList", mock.Anything, mock.Anything).
+		Return([]types.Container{{ID: "expired-container"}, {ID: "fresh-container"}}, nil).
+		Once()
+	client.On("ContainerInspect", mock.Anything, "expired-container").
+		Return(types.ContainerJSON{
+			ContainerJSONBase: &types.ContainerJSONBase{
+				State: &types.ContainerState{FinishedAt: now.Add(-3 * time.Hour).Format(time.RFC3339Nano)},
+			},
+		}, nil).
+		Once()
+	client.On("ContainerInspect", mock.Anything, "fresh-container").
+		Return(types.ContainerJSON{
+			ContainerJSONBase: &types.ContainerJSONBase{
+				State: &types.ContainerState{FinishedAt: now.Add(-time.Minute).Format(time.RFC3339Nano)},
+			},
+		}, nil).
+		Once()
+	client.On("ContainerRemove", mock.Anything, "expired-container", mock.Anything).
+		Return(nil).
+		Once()
+
+	remaining, err := RemoveExpired(context.Background(), client, ttl, now)
+	require.NoError(t, err)
+	assert.Equal(t, 2, remaining)
+}
+
+func TestRemoveExpired_ListError(t *testing.T) {
+	testErr := errors.New("test error")
+
+	client := new(docker.MockClient)
+	defer client.AssertExpectations(t)
+
+	client.On("ImageList", mock.Anything, mock.Anything).
+		Return(nil, testErr).
+		Once()
+
+	_, err := RemoveExpired(context.Background(), client, time.Hour, time.Now())
+	assert.ErrorIs(t, err, testErr)
+}
@@ -0,0 +1,5 @@
+package snapshots
+
+type debugLogger interface {
+	Debugln(args ...interface{})
+}
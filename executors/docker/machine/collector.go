@@ -19,9 +19,11 @@ func (m *machineProvider) collectDetails() (data machinesData) {
 // Describe implements prometheus.Collector.
 func (m *machineProvider) Describe(ch chan<- *prometheus.Desc) {
 	m.totalActions.Describe(ch)
+	m.healthChecks.Describe(ch)
 	m.creationHistogram.Describe(ch)
 	m.stoppingHistogram.Describe(ch)
 	m.removalHistogram.Describe(ch)
+	m.predictions.Describe(ch)
 	ch <- m.currentStatesDesc
 }
 
@@ -66,7 +68,9 @@ func (m *machineProvider) Collect(ch chan<- prometheus.Metric) {
 	)
 
 	m.totalActions.Collect(ch)
+	m.healthChecks.Collect(ch)
 	m.creationHistogram.Collect(ch)
 	m.stoppingHistogram.Collect(ch)
 	m.removalHistogram.Collect(ch)
+	m.predictions.Collect(ch)
 }
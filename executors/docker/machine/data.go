@@ -16,6 +16,10 @@ type machinesData struct {
 	Used            int
 	Removing        int
 	StuckOnRemoving int
+
+	// PredictedDemand is the number of machines expected to be in use,
+	// set only in the predictive mode
+	PredictedDemand int
 }
 
 func (d *machinesData) InUse() int {
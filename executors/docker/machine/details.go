@@ -11,14 +11,18 @@ import (
 )
 
 type machineDetails struct {
-	Name       string
-	Created    time.Time `yaml:"-"`
-	Used       time.Time `yaml:"-"`
-	UsedCount  int
-	State      machineState
-	Reason     string
-	RetryCount int
-	LastSeen   time.Time
+	Name       string       `json:"name"`
+	Created    time.Time    `yaml:"-" json:"created"`
+	Used       time.Time    `yaml:"-" json:"used"`
+	UsedCount  int          `json:"used_count"`
+	State      machineState `json:"state"`
+	Reason     string       `json:"reason,omitempty"`
+	RetryCount int          `json:"retry_count"`
+	LastSeen   time.Time    `json:"-"`
+
+	HealthChecked  time.Time `json:"-"`
+	HealthChecking bool      `json:"-"`
+	HealthFailures int       `json:"-"`
 }
 
 func (m *machineDetails) isPersistedOnDisk() bool {
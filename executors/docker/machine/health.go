@@ -0,0 +1,217 @@
+package machine
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"gitlab.com/gitlab-org/gitlab-runner/common"
+	"gitlab.com/gitlab-org/gitlab-runner/helpers/docker"
+)
+
+var newHealthCheckClient = docker.New
+
+type healthCheckResult string
+
+const (
+	healthCheckHealthy     healthCheckResult = "healthy"
+	healthCheckUnreachable healthCheckResult = "unreachable"
+	healthCheckClockSkew   healthCheckResult = "clock_skew"
+	healthCheckLowDisk     healthCheckResult = "low_disk"
+)
+
+// healthCheckTick is how often the health checker looks for the Idle
+// machines that are due to be checked
+var healthCheckTick = 10 * time.Second
+
+// watchHealth registers the runner for the health checks of its Idle
+// machines. The checks run in a background loop owned by the provider,
+// which is started with the first registered runner, so that the machines
+// are checked even when the runner doesn't ask for jobs.
+func (m *machineProvider) watchHealth(config *common.RunnerConfig) {
+	m.healthLock.Lock()
+	defer m.healthLock.Unlock()
+
+	if config.Machine.HealthCheck == nil {
+		delete(m.healthConfigs, config.GetToken())
+		return
+	}
+
+	m.healthConfigs[config.GetToken()] = config
+	m.healthOnce.Do(func() {
+		go m.runHealthChecker(healthCheckTick)
+	})
+}
+
+func (m *machineProvider) runHealthChecker(tick time.Duration) {
+	ticker := time.NewTicker(tick)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		m.checkWatchedHealth()
+	}
+}
+
+// checkWatchedHealth checks the Idle machines of all registered runners
+func (m *machineProvider) checkWatchedHealth() {
+	m.healthLock.Lock()
+	configs := make([]*common.RunnerConfig, 0, len(m.healthConfigs))
+	for _, config := range m.healthConfigs {
+		configs = append(configs, config)
+	}
+	m.healthLock.Unlock()
+
+	for _, config := range configs {
+		m.checkHealth(config)
+	}
+}
+
+// checkHealth starts in background the health checks of the Idle machines
+// of the runner that weren't checked within the configured interval
+func (m *machineProvider) checkHealth(config *common.RunnerConfig) {
+	healthCheck := config.Machine.HealthCheck
+	if healthCheck == nil {
+		return
+	}
+
+	filter := machineFilter(config)
+	now := time.Now()
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	for _, details := range m.details {
+		if !details.match(filter) || details.State != machineStateIdle || details.HealthChecking {
+			continue
+		}
+
+		if now.Sub(details.HealthChecked) < healthCheck.GetInterval() {
+			continue
+		}
+
+		details.HealthChecking = true
+		go m.runHealthCheck(config, details)
+	}
+}
+
+func (m *machineProvider) runHealthCheck(config *common.RunnerConfig, details *machineDetails) {
+	healthCheck := config.Machine.HealthCheck
+
+	result, err := m.checkMachineHealth(healthCheck, details.Name)
+	m.healthChecks.WithLabelValues(string(result)).Inc()
+
+	m.lock.Lock()
+	details.HealthChecking = false
+	details.HealthChecked = time.Now()
+	if err == nil {
+		details.HealthFailures = 0
+		m.lock.Unlock()
+		return
+	}
+	details.HealthFailures++
+	failures := details.HealthFailures
+	m.lock.Unlock()
+
+	logger := details.logger().
+		WithError(err).
+		WithField("result", result).
+		WithField("failures", failures)
+
+	if failures < healthCheck.GetFailureThreshold() {
+		logger.Warningln("Machine health check failed")
+		return
+	}
+
+	// A job could have acquired the machine while it was being checked,
+	// only the machines that are still Idle are removed
+	if m.tryAcquireMachineDetails(details) == nil {
+		return
+	}
+
+	logger.Warningln("Removing unhealthy machine")
+	if m.remove(details.Name, "Unhealthy machine: ", result) != nil {
+		return
+	}
+
+	m.replaceMachine(config)
+}
+
+// replaceMachine creates a new Idle machine in place of a removed one,
+// when the autoscaling parameters still allow it
+func (m *machineProvider) replaceMachine(config *common.RunnerConfig) {
+	filter := machineFilter(config)
+	data := machinesData{Runner: config.ShortDescription()}
+
+	m.lock.RLock()
+	for _, details := range m.details {
+		if details.match(filter) {
+			data.Add(details)
+		}
+	}
+	m.lock.RUnlock()
+
+	if canCreateIdle(config, &data) {
+		m.create(config, machineStateIdle)
+	}
+}
+
+// checkMachineHealth pings the Docker daemon of the machine and checks its
+// clock and the free disk space of the Docker root directory
+func (m *machineProvider) checkMachineHealth(
+	healthCheck *common.DockerMachineHealthCheck,
+	name string,
+) (healthCheckResult, error) {
+	machine := m.machineOf(name)
+
+	credentials, err := machine.Credentials(name)
+	if err != nil {
+		return healthCheckUnreachable, err
+	}
+
+	client, err := newHealthCheckClient(credentials)
+	if err != nil {
+		return healthCheckUnreachable, err
+	}
+	defer func() { _ = client.Close() }()
+
+	ctx, cancel := context.WithTimeout(context.Background(), healthCheck.GetTimeout())
+	defer cancel()
+
+	info, err := client.Info(ctx)
+	if err != nil {
+		return healthCheckUnreachable, fmt.Errorf("pinging Docker daemon: %w", err)
+	}
+
+	if healthCheck.MaxClockSkew > 0 {
+		systemTime, err := time.Parse(time.RFC3339Nano, info.SystemTime)
+		if err != nil {
+			return healthCheckClockSkew, fmt.Errorf("parsing system time: %w", err)
+		}
+
+		skew := time.Since(systemTime)
+		if skew < 0 {
+			skew = -skew
+		}
+
+		maxSkew := time.Duration(healthCheck.MaxClockSkew) * time.Second
+		if skew > maxSkew {
+			return healthCheckClockSkew, fmt.Errorf("clock skew of %v exceeds %v", skew.Round(time.Second), maxSkew)
+		}
+	}
+
+	diskSpace, ok := machine.(docker.MachineDiskSpace)
+	if healthCheck.MinDiskFree > 0 && ok {
+		free, err := diskSpace.DiskFree(name, info.DockerRootDir)
+		if err != nil {
+			return healthCheckLowDisk, fmt.Errorf("checking free disk space: %w", err)
+		}
+
+		minFree := uint64(healthCheck.MinDiskFree) * 1024 * 1024
+		if free < minFree {
+			return healthCheckLowDisk, fmt.Errorf("%d MB free in %s, %d MB required",
+				free/1024/1024, info.DockerRootDir, healthCheck.MinDiskFree)
+		}
+	}
+
+	return healthCheckHealthy, nil
+}
@@ -0,0 +1,189 @@
+//go:build !integration
+// +build !integration
+
+package machine
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-runner/common"
+	"gitlab.com/gitlab-org/gitlab-runner/helpers/docker"
+)
+
+func mockHealthCheckClient(t *testing.T, info types.Info, err error) func() {
+	client := new(docker.MockClient)
+	client.On("Info", mock.Anything).Return(info, err)
+	client.On("Close").Return(nil)
+
+	oldNewHealthCheckClient := newHealthCheckClient
+	newHealthCheckClient = func(docker.Credentials) (docker.Client, error) {
+		return client, nil
+	}
+
+	return func() {
+		newHealthCheckClient = oldNewHealthCheckClient
+		client.AssertExpectations(t)
+	}
+}
+
+func TestCheckMachineHealth(t *testing.T) {
+	healthCheck := &common.DockerMachineHealthCheck{
+		MinDiskFree:  1024,
+		MaxClockSkew: 60,
+	}
+
+	tests := map[string]struct {
+		machine        string
+		info           types.Info
+		infoErr        error
+		expectedResult healthCheckResult
+		expectedErr    string
+	}{
+		"healthy": {
+			machine:        "machine",
+			info:           types.Info{SystemTime: time.Now().Format(time.RFC3339Nano)},
+			expectedResult: healthCheckHealthy,
+		},
+		"daemon unreachable": {
+			machine:        "machine",
+			infoErr:        errors.New("connection refused"),
+			expectedResult: healthCheckUnreachable,
+			expectedErr:    "pinging Docker daemon: connection refused",
+		},
+		"clock skew": {
+			machine:        "machine",
+			info:           types.Info{SystemTime: time.Now().Add(-time.Hour).Format(time.RFC3339Nano)},
+			expectedResult: healthCheckClockSkew,
+			expectedErr:    "clock skew of 1h0m0s exceeds 1m0s",
+		},
+		"low disk": {
+			machine: "low-disk",
+			info: types.Info{
+				SystemTime:    time.Now().Format(time.RFC3339Nano),
+				DockerRootDir: "/var/lib/docker",
+			},
+			expectedResult: healthCheckLowDisk,
+			expectedErr:    "1 MB free in /var/lib/docker, 1024 MB required",
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			defer mockHealthCheckClient(t, tt.info, tt.infoErr)()
+
+			p, _ := testMachineProvider()
+
+			result, err := p.checkMachineHealth(healthCheck, tt.machine)
+			assert.Equal(t, tt.expectedResult, result)
+			if tt.expectedErr != "" {
+				assert.EqualError(t, err, tt.expectedErr)
+				return
+			}
+
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestCheckMachineHealthCredentialsFailure(t *testing.T) {
+	p, _ := testMachineProvider()
+
+	result, err := p.checkMachineHealth(&common.DockerMachineHealthCheck{}, "no-connect")
+	assert.Equal(t, healthCheckUnreachable, result)
+	assert.Error(t, err)
+}
+
+func TestHealthCheckReplacesUnhealthyMachine(t *testing.T) {
+	defer mockHealthCheckClient(t, types.Info{}, errors.New("connection refused"))()
+
+	p, m := testMachineProvider("test-machine-1")
+
+	config := createMachineConfig(t, 1, 5)
+	config.Machine.HealthCheck = &common.DockerMachineHealthCheck{FailureThreshold: 2}
+
+	details := p.machineDetails("test-machine-1", false)
+
+	// the first failure is tolerated
+	p.checkHealth(config)
+	assert.Eventually(t, func() bool {
+		p.lock.RLock()
+		defer p.lock.RUnlock()
+		return details.HealthFailures == 1 && !details.HealthChecking
+	}, time.Second, 10*time.Millisecond)
+
+	// the machine isn't checked again before the interval passes
+	p.checkHealth(config)
+	p.lock.RLock()
+	assert.False(t, details.HealthChecking)
+	p.lock.RUnlock()
+
+	p.lock.Lock()
+	details.HealthChecked = time.Now().Add(-config.Machine.HealthCheck.GetInterval())
+	p.lock.Unlock()
+
+	p.checkHealth(config)
+
+	select {
+	case <-m.Removed:
+	case <-time.After(time.Second):
+		require.Fail(t, "unhealthy machine is not removed")
+	}
+
+	select {
+	case <-m.Created:
+	case <-time.After(time.Second):
+		require.Fail(t, "unhealthy machine is not replaced")
+	}
+}
+
+func TestHealthCheckSkipsUsedMachines(t *testing.T) {
+	p, _ := testMachineProvider("test-machine-1")
+
+	config := createMachineConfig(t, 1, 5)
+	config.Machine.HealthCheck = &common.DockerMachineHealthCheck{}
+
+	details := p.machineDetails("test-machine-1", true)
+	p.checkHealth(config)
+
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	assert.False(t, details.HealthChecking)
+	assert.True(t, details.HealthChecked.IsZero())
+}
+
+func TestHealthCheckerRunsWithoutJobRequests(t *testing.T) {
+	defer mockHealthCheckClient(t, types.Info{}, errors.New("connection refused"))()
+
+	oldTick := healthCheckTick
+	healthCheckTick = 10 * time.Millisecond
+	defer func() { healthCheckTick = oldTick }()
+
+	p, m := testMachineProvider("test-machine-1")
+
+	config := createMachineConfig(t, 1, 5)
+	config.Machine.HealthCheck = &common.DockerMachineHealthCheck{FailureThreshold: 1}
+
+	p.machineDetails("test-machine-1", false)
+	p.watchHealth(config)
+
+	select {
+	case <-m.Removed:
+	case <-time.After(time.Second):
+		require.Fail(t, "unhealthy machine is not removed by the health checker")
+	}
+
+	// the runner stops being checked when its health checks are disabled
+	disabled := createMachineConfig(t, 1, 5)
+	p.watchHealth(disabled)
+
+	p.healthLock.Lock()
+	assert.Empty(t, p.healthConfigs)
+	p.healthLock.Unlock()
+}
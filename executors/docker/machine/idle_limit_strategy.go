@@ -106,7 +106,19 @@ func (ils *idleLimitStrategy) composedIdleMachinesExceeded() bool {
 // idleMachinesExceeded checks whether runner reached the defined IdleCount
 // which is the maximum number of Idle machines that can exist.
 func (ils *idleLimitStrategy) idleMachinesExceeded() bool {
-	return ils.data.Available() >= ils.config.Machine.GetIdleCount()
+	return ils.data.Available() >= getIdleCount(ils.config, ils.data)
+}
+
+// getIdleCount returns the number of Idle machines that should be maintained.
+// In the predictive mode it's the part of the predicted demand that is not
+// covered by the machines in use, within the configured bounds.
+func getIdleCount(config *common.RunnerConfig, data *machinesData) int {
+	predictive := config.Machine.Predictive
+	if predictive == nil {
+		return config.Machine.GetIdleCount()
+	}
+
+	return predictive.GetIdleCount(data.PredictedDemand - data.InUse())
 }
 
 // idleCountMinFulfilled checks if the IdleCountMin setting is fulfilled.
@@ -300,3 +300,53 @@ func TestShouldRemoveIdle(t *testing.T) {
 		})
 	}
 }
+
+func TestGetIdleCountPredictive(t *testing.T) {
+	tests := map[string]struct {
+		predictive        *common.DockerMachinePredictive
+		predictedDemand   int
+		used              int
+		expectedIdleCount int
+	}{
+		"predictive mode disabled": {
+			predictedDemand:   10,
+			expectedIdleCount: 2,
+		},
+		"predicted demand not covered by used machines": {
+			predictive:        &common.DockerMachinePredictive{},
+			predictedDemand:   10,
+			used:              4,
+			expectedIdleCount: 6,
+		},
+		"predicted demand covered by used machines": {
+			predictive:        &common.DockerMachinePredictive{},
+			predictedDemand:   3,
+			used:              4,
+			expectedIdleCount: 0,
+		},
+		"below minimum": {
+			predictive:        &common.DockerMachinePredictive{MinIdleCount: 1},
+			predictedDemand:   0,
+			expectedIdleCount: 1,
+		},
+		"above maximum": {
+			predictive:        &common.DockerMachinePredictive{MinIdleCount: 1, MaxIdleCount: 5},
+			predictedDemand:   20,
+			used:              2,
+			expectedIdleCount: 5,
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			config := ilsNewRunnerConfig(ilsRunnerConfig{idleCount: 2})
+			config.Machine.Predictive = tt.predictive
+
+			data := ilsNewMachinesData(ilsMachinesData{used: tt.used})
+			data.PredictedDemand = tt.predictedDemand
+
+			assert.Equal(t, tt.expectedIdleCount, getIdleCount(config, data))
+			assert.Equal(t, tt.expectedIdleCount > 0, canCreateIdle(config, data))
+		})
+	}
+}
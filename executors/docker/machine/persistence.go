@@ -0,0 +1,240 @@
+package machine
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"gitlab.com/gitlab-org/gitlab-runner/common"
+)
+
+// machinesState is the content of the state file keeping the details of the
+// machines between the restarts of the runner
+type machinesState struct {
+	Machines []machineDetails `json:"machines"`
+}
+
+func defaultStateFile(name string) string {
+	return strings.NewReplacer("+", "_", "-", "_").Replace(name) + "_state.json"
+}
+
+// writeFileAtomically replaces the file with the data, so that the readers
+// never see a partially written file, even when the process is killed
+func writeFileAtomically(path string, data []byte) error {
+	file, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer func() { _ = os.Remove(file.Name()) }()
+
+	_, err = file.Write(data)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(file.Name(), path)
+}
+
+// saveState writes the details of the machines of the runners that enabled
+// the persistence to the state file. The machines loaded from the file but
+// not yet reconciled are kept as well.
+func (m *machineProvider) saveState() {
+	m.stateLock.Lock()
+	defer m.stateLock.Unlock()
+
+	if m.stateFile == "" {
+		return
+	}
+
+	var state machinesState
+
+	m.lock.RLock()
+	for _, details := range m.details {
+		if m.isPersisted(details) {
+			state.Machines = append(state.Machines, *details)
+		}
+	}
+	for _, details := range m.pending {
+		state.Machines = append(state.Machines, *details)
+	}
+	m.lock.RUnlock()
+
+	sort.Slice(state.Machines, func(i, j int) bool {
+		return state.Machines[i].Name < state.Machines[j].Name
+	})
+
+	data, err := json.Marshal(state)
+	if err == nil && bytes.Equal(data, m.lastState) {
+		return
+	}
+	if err == nil {
+		err = writeFileAtomically(m.stateFile, data)
+	}
+	if err != nil {
+		logrus.WithError(err).
+			WithField("file", m.stateFile).
+			Warningln("Failed to save the state of machines")
+		return
+	}
+
+	m.lastState = data
+}
+
+// isPersisted tells whether the machine belongs to a runner that enabled
+// the persistence. Must be called with the lock held.
+func (m *machineProvider) isPersisted(details *machineDetails) bool {
+	for _, filter := range m.persisted {
+		if details.match(filter) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// loadState reads the state file written before the restart of the runner.
+// The machines are reconciled by restoreMachines when the runner using them
+// requests a machine for the first time.
+func (m *machineProvider) loadState() {
+	data, err := ioutil.ReadFile(m.stateFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return
+	}
+
+	var state machinesState
+	if err == nil {
+		err = json.Unmarshal(data, &state)
+	}
+	if err != nil {
+		logrus.WithError(err).
+			WithField("file", m.stateFile).
+			Warningln("Failed to load the state of machines")
+		return
+	}
+
+	m.stateLock.Lock()
+	m.lastState = data
+	m.stateLock.Unlock()
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	for i := range state.Machines {
+		details := state.Machines[i]
+		m.pending[details.Name] = &details
+	}
+}
+
+// restoreMachines reconciles the machines of the runner loaded from the state
+// file with the ones that still exist. Machines interrupted while being
+// created are adopted when they're reachable, the ones interrupted while
+// being removed are removed again.
+func (m *machineProvider) restoreMachines(config *common.RunnerConfig) {
+	if !config.Machine.PersistState {
+		m.lock.Lock()
+		delete(m.persisted, config.GetToken())
+		m.lock.Unlock()
+
+		return
+	}
+
+	m.restoreOnce.Do(func() {
+		m.stateLock.Lock()
+		if m.stateFile == "" {
+			m.stateFile = config.Machine.ResolvePath(defaultStateFile(m.name))
+		}
+		m.stateLock.Unlock()
+
+		m.loadState()
+	})
+
+	m.lock.Lock()
+	m.persisted[config.GetToken()] = machineFilter(config)
+	restored := m.restored[config.GetToken()]
+	m.lock.Unlock()
+	if restored {
+		return
+	}
+
+	machines, err := m.machineFor(config).List()
+	if err != nil {
+		logrus.WithError(err).
+			WithField("runner", config.ShortDescription()).
+			Warningln("Failed to list machines, the state of machines will be restored later")
+		return
+	}
+
+	existing := make(map[string]bool, len(machines))
+	for _, name := range machines {
+		existing[name] = true
+	}
+
+	var creating, removing []*machineDetails
+	filter := machineFilter(config)
+	now := time.Now()
+
+	m.lock.Lock()
+	for name, details := range m.pending {
+		if !details.match(filter) {
+			continue
+		}
+
+		delete(m.pending, name)
+
+		if !existing[name] {
+			details.logger().Warningln("Forgetting machine that no longer exists")
+			continue
+		}
+
+		if _, ok := m.details[name]; ok {
+			continue
+		}
+
+		switch details.State {
+		case machineStateCreating:
+			creating = append(creating, details)
+		case machineStateRemoving:
+			removing = append(removing, details)
+		case machineStateAcquired, machineStateUsed:
+			details.logger().Warningln("Releasing machine used by a job interrupted by a runner restart")
+			details.State = machineStateIdle
+		}
+
+		details.LastSeen = now
+		m.details[name] = details
+	}
+	m.restored[config.GetToken()] = true
+	m.lock.Unlock()
+
+	for _, details := range creating {
+		if !m.machineOf(details.Name).CanConnect(details.Name, false) {
+			_ = m.remove(details.Name, "Creation interrupted by a runner restart")
+			continue
+		}
+
+		m.lock.Lock()
+		details.State = machineStateIdle
+		m.lock.Unlock()
+		details.logger().Infoln("Adopting machine created before a runner restart")
+	}
+
+	for _, details := range removing {
+		_ = m.remove(details.Name, details.Reason)
+	}
+
+	m.saveState()
+}
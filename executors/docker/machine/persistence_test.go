@@ -0,0 +1,190 @@
+//go:build !integration
+// +build !integration
+
+package machine
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-runner/common"
+)
+
+func readMachinesState(t *testing.T, stateFile string) map[string]machineDetails {
+	data, err := ioutil.ReadFile(stateFile)
+	require.NoError(t, err)
+
+	var state machinesState
+	require.NoError(t, json.Unmarshal(data, &state))
+
+	machines := make(map[string]machineDetails)
+	for _, details := range state.Machines {
+		machines[details.Name] = details
+	}
+
+	return machines
+}
+
+func TestWriteFileAtomically(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "state.json")
+
+	require.NoError(t, writeFileAtomically(path, []byte("first")))
+	require.NoError(t, writeFileAtomically(path, []byte("second")))
+
+	data, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "second", string(data))
+
+	files, err := ioutil.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Len(t, files, 1, "temporary files are removed")
+
+	assert.Error(t, writeFileAtomically(filepath.Join(dir, "missing", "state.json"), nil))
+}
+
+func TestMachineStateSaveAndLoad(t *testing.T) {
+	stateFile := filepath.Join(t.TempDir(), "state.json")
+
+	p, _ := testMachineProvider()
+	p.stateFile = stateFile
+	p.persisted["runner-token"] = "test-machine-%s"
+
+	details := p.machineDetails("test-machine-1", false)
+	details.UsedCount = 5
+	details.Created = time.Now().Add(-time.Hour).Round(time.Second)
+	p.machineDetails("other-machine-1", false)
+	p.saveState()
+
+	machines := readMachinesState(t, stateFile)
+	assert.NotContains(t, machines, "other-machine-1", "runner without persistence")
+	require.Contains(t, machines, "test-machine-1")
+	assert.Equal(t, 5, machines["test-machine-1"].UsedCount)
+	assert.Equal(t, machineStateIdle, machines["test-machine-1"].State)
+
+	loaded, _ := testMachineProvider()
+	loaded.stateFile = stateFile
+	loaded.loadState()
+
+	require.Contains(t, loaded.pending, "test-machine-1")
+	assert.Equal(t, 5, loaded.pending["test-machine-1"].UsedCount)
+	assert.True(t, details.Created.Equal(loaded.pending["test-machine-1"].Created))
+	assert.Empty(t, loaded.details, "machines are known only after being reconciled")
+}
+
+func TestRestoreMachines(t *testing.T) {
+	stateFile := filepath.Join(t.TempDir(), "state.json")
+	created := time.Now().Add(-time.Hour).Round(time.Second)
+
+	state := machinesState{
+		Machines: []machineDetails{
+			{Name: "test-machine-idle", Created: created, UsedCount: 3, State: machineStateIdle},
+			{Name: "test-machine-used", Created: created, UsedCount: 2, State: machineStateUsed},
+			{Name: "test-machine-creating", Created: created, State: machineStateCreating},
+			{Name: "test-machine-no-can-connect", Created: created, State: machineStateCreating},
+			{Name: "test-machine-removing", Created: created, State: machineStateRemoving, Reason: "Too many builds"},
+			{Name: "test-machine-gone", Created: created, State: machineStateIdle},
+			{Name: "other-machine-idle", Created: created, State: machineStateIdle},
+		},
+	}
+	data, err := json.Marshal(state)
+	require.NoError(t, err)
+	require.NoError(t, ioutil.WriteFile(stateFile, data, 0600))
+
+	p, m := testMachineProvider(
+		"test-machine-idle",
+		"test-machine-used",
+		"test-machine-creating",
+		"test-machine-no-can-connect",
+		"test-machine-removing",
+		"other-machine-idle",
+	)
+	p.stateFile = stateFile
+
+	config := createMachineConfig(t, 0, 5)
+	config.Machine.PersistState = true
+	p.restoreMachines(config)
+
+	p.lock.RLock()
+	assert.Equal(t, machineStateIdle, p.details["test-machine-idle"].State)
+	assert.Equal(t, 3, p.details["test-machine-idle"].UsedCount)
+	assert.True(t, created.Equal(p.details["test-machine-idle"].Created))
+	assert.Equal(t, machineStateIdle, p.details["test-machine-used"].State, "interrupted job releases the machine")
+	assert.Equal(t, 2, p.details["test-machine-used"].UsedCount)
+	assert.Equal(t, machineStateIdle, p.details["test-machine-creating"].State, "reachable machine is adopted")
+	assert.NotContains(t, p.details, "test-machine-gone")
+	assert.NotContains(t, p.details, "other-machine-idle")
+	assert.Contains(t, p.pending, "other-machine-idle", "machines of other runners wait for their runner")
+	p.lock.RUnlock()
+
+	for i := 0; i < 2; i++ {
+		select {
+		case <-m.Removed:
+		case <-time.After(time.Second):
+			require.Fail(t, "interrupted machines are not removed")
+		}
+	}
+	assert.False(t, m.Exist("test-machine-no-can-connect"))
+	assert.False(t, m.Exist("test-machine-removing"))
+
+	assert.Eventually(t, func() bool {
+		machines := readMachinesState(t, stateFile)
+		_, removing := machines["test-machine-removing"]
+		_, pending := machines["other-machine-idle"]
+		return len(machines) == 4 && !removing && pending
+	}, time.Second, 10*time.Millisecond)
+
+	// the machines are reconciled only once per runner
+	p.lock.Lock()
+	p.pending["test-machine-late"] = &machineDetails{Name: "test-machine-late", State: machineStateIdle}
+	p.lock.Unlock()
+	p.restoreMachines(config)
+	assert.NotContains(t, p.details, "test-machine-late")
+}
+
+func TestRestoreMachinesPersistenceDisabled(t *testing.T) {
+	dir := t.TempDir()
+
+	p, _ := testMachineProvider("test-machine-idle")
+	p.stateFile = filepath.Join(dir, "state.json")
+
+	config := createMachineConfig(t, 0, 5)
+	p.restoreMachines(config)
+	p.machineDetails("test-machine-idle", false)
+	p.saveState()
+
+	assert.Empty(t, readMachinesState(t, p.stateFile), "machines of runners without persistence aren't saved")
+	assert.Empty(t, p.persisted)
+}
+
+func TestMachineStateFileNextToConfig(t *testing.T) {
+	dir := t.TempDir()
+	configFile := filepath.Join(dir, "config.toml")
+
+	configData := `
+[[runners]]
+  token = "runner-token"
+  executor = "docker+machine"
+  [runners.machine]
+    MachineName = "test-machine-%s"
+    PersistState = true
+`
+	require.NoError(t, ioutil.WriteFile(configFile, []byte(configData), 0600))
+
+	config := common.NewConfig()
+	require.NoError(t, config.LoadConfig(configFile))
+	require.Len(t, config.Runners, 1)
+
+	p, _ := testMachineProvider()
+	p.restoreMachines(config.Runners[0])
+	p.saveState()
+
+	assert.Equal(t, filepath.Join(dir, "docker_machine_state.json"), p.stateFile)
+	assert.FileExists(t, p.stateFile)
+}
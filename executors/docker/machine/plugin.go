@@ -0,0 +1,117 @@
+package machine
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"gitlab.com/gitlab-org/gitlab-runner/common"
+	"gitlab.com/gitlab-org/gitlab-runner/helpers/docker"
+	"gitlab.com/gitlab-org/gitlab-runner/helpers/instancegroup"
+)
+
+var newPluginMachine = func(path string, options []string, timeout time.Duration) docker.Machine {
+	return instancegroup.NewMachine(instancegroup.NewPlugin(path, timeout, options...))
+}
+
+// pluginMachines keeps the instance group plugins configured for the runners
+// and remembers which of them manages each of the machines
+type pluginMachines struct {
+	lock    sync.Mutex
+	plugins map[string]*pluginMachine
+	owners  map[string]*pluginMachine
+}
+
+func newPluginMachines() *pluginMachines {
+	return &pluginMachines{
+		plugins: make(map[string]*pluginMachine),
+		owners:  make(map[string]*pluginMachine),
+	}
+}
+
+func (p *pluginMachines) forConfig(config *common.DockerMachine) docker.Machine {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	timeout := time.Duration(config.PluginTimeout) * time.Second
+	key := strings.Join(append([]string{config.Plugin, timeout.String()}, config.PluginOptions...), "\x00")
+
+	plugin, ok := p.plugins[key]
+	if !ok {
+		plugin = &pluginMachine{
+			Machine: newPluginMachine(config.Plugin, config.PluginOptions, timeout),
+			owners:  p,
+		}
+		p.plugins[key] = plugin
+	}
+
+	return plugin
+}
+
+// owner returns the plugin managing the machine or nil when the machine
+// wasn't created by any of the plugins
+func (p *pluginMachines) owner(name string) docker.Machine {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	plugin, ok := p.owners[name]
+	if !ok {
+		return nil
+	}
+
+	return plugin
+}
+
+func (p *pluginMachines) own(plugin *pluginMachine, names ...string) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	for _, name := range names {
+		p.owners[name] = plugin
+	}
+}
+
+func (p *pluginMachines) disown(name string) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	delete(p.owners, name)
+}
+
+// pluginMachine records the machines of a plugin while they're listed,
+// created and removed
+type pluginMachine struct {
+	docker.Machine
+	owners *pluginMachines
+}
+
+func (m *pluginMachine) Create(driver, name string, opts ...string) error {
+	m.owners.own(m, name)
+
+	err := m.Machine.Create(driver, name, opts...)
+	if err != nil && !m.Machine.Exist(name) {
+		m.owners.disown(name)
+	}
+
+	return err
+}
+
+func (m *pluginMachine) Remove(name string) error {
+	err := m.Machine.Remove(name)
+	if err == nil {
+		m.owners.disown(name)
+	}
+
+	return err
+}
+
+func (m *pluginMachine) List() ([]string, error) {
+	machines, err := m.Machine.List()
+	if err != nil {
+		return nil, err
+	}
+
+	m.owners.own(m, machines...)
+
+	return machines, nil
+}
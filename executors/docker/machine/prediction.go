@@ -0,0 +1,340 @@
+package machine
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"math"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+
+	"gitlab.com/gitlab-org/gitlab-runner/common"
+)
+
+const (
+	// predictionSmoothing is the weight of the latest week when updating the
+	// average number of job arrivals of an hour
+	predictionSmoothing = 0.3
+
+	predictionSaveInterval = 5 * time.Minute
+)
+
+var predictionTimer = time.Now
+
+// arrivalsSlot holds the average number of jobs started in one hour of the week
+type arrivalsSlot struct {
+	Rate    float64 `json:"rate"`
+	Samples int     `json:"samples"`
+}
+
+// jobHistory records the job arrivals of a runner for each weekday and hour
+type jobHistory struct {
+	Slots [7][24]arrivalsSlot `json:"slots"`
+
+	// CurrentSlot is the beginning of the hour in which the arrivals are
+	// being counted, the count is added to Slots when the hour passes
+	CurrentSlot  time.Time `json:"current_slot"`
+	CurrentCount int       `json:"current_count"`
+
+	// JobDuration is the average time (in seconds) a job keeps a machine
+	JobDuration float64 `json:"job_duration"`
+}
+
+func hourOf(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), 0, 0, 0, t.Location())
+}
+
+func smooth(average float64, samples int, value float64) float64 {
+	if samples == 0 {
+		return value
+	}
+
+	return average + predictionSmoothing*(value-average)
+}
+
+// advance closes the hours that passed since the last recorded arrival.
+// The hours without any arrival are recorded as such, up to one week back.
+func (h *jobHistory) advance(now time.Time) {
+	current := hourOf(now)
+	if h.CurrentSlot.IsZero() {
+		h.CurrentSlot = current
+		return
+	}
+
+	if !current.After(h.CurrentSlot) {
+		return
+	}
+
+	h.record(h.CurrentSlot, float64(h.CurrentCount))
+
+	slot := h.CurrentSlot.Add(time.Hour)
+	for i := 0; i < 7*24 && slot.Before(current); i++ {
+		h.record(slot, 0)
+		slot = slot.Add(time.Hour)
+	}
+
+	h.CurrentSlot = current
+	h.CurrentCount = 0
+}
+
+func (h *jobHistory) record(slot time.Time, arrivals float64) {
+	s := &h.Slots[slot.Weekday()][slot.Hour()]
+	s.Rate = smooth(s.Rate, s.Samples, arrivals)
+	s.Samples++
+}
+
+func (h *jobHistory) recordArrival(now time.Time) {
+	h.advance(now)
+	h.CurrentCount++
+}
+
+func (h *jobHistory) recordDuration(duration time.Duration) {
+	samples := 1
+	if h.JobDuration == 0 {
+		samples = 0
+	}
+
+	h.JobDuration = smooth(h.JobDuration, samples, duration.Seconds())
+}
+
+func (h *jobHistory) rate(t time.Time) float64 {
+	return h.Slots[t.Weekday()][t.Hour()].Rate
+}
+
+// predictDemand returns the number of machines expected to be in use at the
+// same time within the look ahead window. Following Little's law, it's the
+// arrival rate multiplied by the average time a job keeps a machine.
+func (h *jobHistory) predictDemand(now time.Time, lookAhead time.Duration) int {
+	h.advance(now)
+
+	rate := math.Max(h.rate(now), h.rate(now.Add(lookAhead)))
+
+	duration := h.JobDuration
+	if duration == 0 {
+		duration = lookAhead.Seconds()
+	}
+
+	return int(math.Ceil(rate * duration / time.Hour.Seconds()))
+}
+
+// runnerPrediction is the last prediction made for a runner, exported
+// together with the actual demand
+type runnerPrediction struct {
+	predicted int
+	actual    int
+}
+
+// predictions keeps the job histories of the runners using the predictive
+// mode and persists them in the configured state files
+type predictions struct {
+	lock      sync.Mutex
+	histories map[string]map[string]*jobHistory
+	saved     map[string]time.Time
+	last      map[string]runnerPrediction
+
+	// saveLock serializes the writes of the state files, which are done
+	// without holding the lock
+	saveLock sync.Mutex
+
+	predictedDesc *prometheus.Desc
+	actualDesc    *prometheus.Desc
+}
+
+func newPredictions(name string) *predictions {
+	return &predictions{
+		histories: make(map[string]map[string]*jobHistory),
+		saved:     make(map[string]time.Time),
+		last:      make(map[string]runnerPrediction),
+		predictedDesc: prometheus.NewDesc(
+			"gitlab_runner_autoscaling_predicted_demand",
+			"The number of machines predicted to be in use from the history of job arrivals.",
+			[]string{"runner"},
+			prometheus.Labels{
+				"executor": name,
+			},
+		),
+		actualDesc: prometheus.NewDesc(
+			"gitlab_runner_autoscaling_actual_demand",
+			"The number of machines in use when the last prediction was made.",
+			[]string{"runner"},
+			prometheus.Labels{
+				"executor": name,
+			},
+		),
+	}
+}
+
+// history returns the job history of the runner, loading the state file when
+// it's used for the first time. Must be called with the lock held.
+func (p *predictions) history(config *common.RunnerConfig) (*jobHistory, string) {
+	stateFile := config.Machine.ResolvePath(config.Machine.Predictive.GetStateFile())
+
+	histories, ok := p.histories[stateFile]
+	if !ok {
+		histories = loadJobHistories(stateFile)
+		p.histories[stateFile] = histories
+	}
+
+	runner := config.ShortDescription()
+	history, ok := histories[runner]
+	if !ok {
+		history = new(jobHistory)
+		histories[runner] = history
+	}
+
+	return history, stateFile
+}
+
+func (p *predictions) recordArrival(config *common.RunnerConfig) {
+	if config.Machine == nil || config.Machine.Predictive == nil {
+		return
+	}
+
+	p.lock.Lock()
+	history, stateFile := p.history(config)
+	history.recordArrival(predictionTimer())
+	data := p.snapshot(stateFile)
+	p.lock.Unlock()
+
+	p.save(stateFile, data)
+}
+
+func (p *predictions) recordDuration(config *common.RunnerConfig, duration time.Duration) {
+	if config.Machine == nil || config.Machine.Predictive == nil {
+		return
+	}
+
+	p.lock.Lock()
+	history, stateFile := p.history(config)
+	history.recordDuration(duration)
+	data := p.snapshot(stateFile)
+	p.lock.Unlock()
+
+	p.save(stateFile, data)
+}
+
+// predict returns the number of machines the runner is expected to use
+func (p *predictions) predict(config *common.RunnerConfig) int {
+	if config.Machine.Predictive == nil {
+		return 0
+	}
+
+	p.lock.Lock()
+	history, stateFile := p.history(config)
+	predicted := history.predictDemand(predictionTimer(), config.Machine.Predictive.GetLookAhead())
+	data := p.snapshot(stateFile)
+	p.lock.Unlock()
+
+	p.save(stateFile, data)
+
+	return predicted
+}
+
+// observe stores the predicted and the actual demand for the metrics
+func (p *predictions) observe(config *common.RunnerConfig, data *machinesData) {
+	if config.Machine.Predictive == nil {
+		return
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.last[config.ShortDescription()] = runnerPrediction{
+		predicted: data.PredictedDemand,
+		actual:    data.InUse(),
+	}
+}
+
+// snapshot encodes the histories of the state file when it's due to be saved,
+// which happens at most once per predictionSaveInterval. It returns nil
+// otherwise. Must be called with the lock held.
+func (p *predictions) snapshot(stateFile string) []byte {
+	now := predictionTimer()
+	if now.Sub(p.saved[stateFile]) < predictionSaveInterval {
+		return nil
+	}
+
+	data, err := json.Marshal(p.histories[stateFile])
+	if err != nil {
+		logrus.WithError(err).
+			WithField("file", stateFile).
+			Warningln("Failed to encode the history of job arrivals")
+		return nil
+	}
+
+	// a failed write is retried only after the next interval, so that a
+	// broken file doesn't slow down every request
+	p.saved[stateFile] = now
+
+	return data
+}
+
+// save writes the snapshot of the histories to the state file. It's called
+// without the lock held, so that the disk isn't accessed while the machines
+// are being updated.
+func (p *predictions) save(stateFile string, data []byte) {
+	if data == nil {
+		return
+	}
+
+	p.saveLock.Lock()
+	defer p.saveLock.Unlock()
+
+	err := writeFileAtomically(stateFile, data)
+	if err != nil {
+		logrus.WithError(err).
+			WithField("file", stateFile).
+			Warningln("Failed to save the history of job arrivals")
+	}
+}
+
+func (p *predictions) Describe(ch chan<- *prometheus.Desc) {
+	ch <- p.predictedDesc
+	ch <- p.actualDesc
+}
+
+func (p *predictions) Collect(ch chan<- prometheus.Metric) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	for runner, prediction := range p.last {
+		ch <- prometheus.MustNewConstMetric(
+			p.predictedDesc,
+			prometheus.GaugeValue,
+			float64(prediction.predicted),
+			runner,
+		)
+		ch <- prometheus.MustNewConstMetric(
+			p.actualDesc,
+			prometheus.GaugeValue,
+			float64(prediction.actual),
+			runner,
+		)
+	}
+}
+
+func loadJobHistories(stateFile string) map[string]*jobHistory {
+	histories := make(map[string]*jobHistory)
+
+	data, err := ioutil.ReadFile(stateFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return histories
+	}
+
+	if err == nil {
+		err = json.Unmarshal(data, &histories)
+	}
+
+	if err != nil {
+		logrus.WithError(err).
+			WithField("file", stateFile).
+			Warningln("Failed to load the history of job arrivals, starting a new one")
+		return make(map[string]*jobHistory)
+	}
+
+	return histories
+}
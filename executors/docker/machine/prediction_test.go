@@ -0,0 +1,147 @@
+//go:build !integration
+// +build !integration
+
+package machine
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-runner/common"
+)
+
+// monday is 2021-11-01 10:00 UTC
+var monday = time.Date(2021, time.November, 1, 10, 0, 0, 0, time.UTC)
+
+func TestJobHistory_RecordArrivals(t *testing.T) {
+	h := new(jobHistory)
+
+	for i := 0; i < 6; i++ {
+		h.recordArrival(monday.Add(time.Duration(i) * time.Minute))
+	}
+	assert.Equal(t, 6, h.CurrentCount)
+	assert.Equal(t, arrivalsSlot{}, h.Slots[time.Monday][10], "current hour is not recorded yet")
+
+	// two hours later: 10:00 is closed with 6 jobs, 11:00 with none
+	h.recordArrival(monday.Add(2 * time.Hour))
+	assert.Equal(t, arrivalsSlot{Rate: 6, Samples: 1}, h.Slots[time.Monday][10])
+	assert.Equal(t, arrivalsSlot{Rate: 0, Samples: 1}, h.Slots[time.Monday][11])
+	assert.Equal(t, 1, h.CurrentCount)
+
+	// one week later the same hour is smoothed with the new count
+	h.advance(monday.Add(7 * 24 * time.Hour))
+	h.CurrentCount = 16
+	h.advance(monday.Add(7*24*time.Hour + time.Hour))
+	assert.Equal(t, 2, h.Slots[time.Monday][10].Samples)
+	assert.InDelta(t, 9, h.Slots[time.Monday][10].Rate, 0.001)
+}
+
+func TestJobHistory_PredictDemand(t *testing.T) {
+	h := new(jobHistory)
+	h.Slots[time.Monday][10] = arrivalsSlot{Rate: 30, Samples: 4}
+	h.Slots[time.Monday][11] = arrivalsSlot{Rate: 90, Samples: 4}
+
+	lookAhead := 15 * time.Minute
+
+	// no job duration known yet, the look ahead window is used instead
+	assert.Equal(t, 8, h.predictDemand(monday, lookAhead))
+
+	h.recordDuration(10 * time.Minute)
+	assert.Equal(t, 5, h.predictDemand(monday, lookAhead))
+
+	// the next hour is within the look ahead window
+	assert.Equal(t, 15, h.predictDemand(monday.Add(50*time.Minute), lookAhead))
+
+	assert.Equal(t, 0, h.predictDemand(monday.Add(3*time.Hour), lookAhead))
+}
+
+func TestPredictions(t *testing.T) {
+	now := monday
+	oldPredictionTimer := predictionTimer
+	defer func() { predictionTimer = oldPredictionTimer }()
+	predictionTimer = func() time.Time { return now }
+
+	stateFile := filepath.Join(t.TempDir(), "history.json")
+	config := &common.RunnerConfig{
+		RunnerCredentials: common.RunnerCredentials{Token: "runner-token"},
+		RunnerSettings: common.RunnerSettings{
+			Machine: &common.DockerMachine{
+				Predictive: &common.DockerMachinePredictive{StateFile: stateFile},
+			},
+		},
+	}
+
+	p := newPredictions("docker+machine")
+	for i := 0; i < 12; i++ {
+		p.recordArrival(config)
+	}
+	p.recordDuration(config, 20*time.Minute)
+
+	now = monday.Add(time.Hour + predictionSaveInterval)
+	data := machinesData{Used: 2}
+	data.PredictedDemand = p.predict(config)
+	p.observe(config, &data)
+
+	// last week's Monday 10:00 had 12 jobs of 20 minutes, so 4 machines are needed
+	now = monday.Add(7*24*time.Hour + 5*time.Minute)
+	reloaded := newPredictions("docker+machine")
+	assert.Equal(t, 4, reloaded.predict(config))
+
+	metrics := make(chan prometheus.Metric, 10)
+	p.Collect(metrics)
+	close(metrics)
+
+	var values []float64
+	for metric := range metrics {
+		var m dto.Metric
+		require.NoError(t, metric.Write(&m))
+		values = append(values, m.GetGauge().GetValue())
+	}
+	assert.Equal(t, []float64{0, 2}, values, "predicted and actual demand")
+}
+
+func TestPredictionsStateFileNextToConfig(t *testing.T) {
+	dir := t.TempDir()
+	configFile := filepath.Join(dir, "config.toml")
+
+	configData := `
+[[runners]]
+  token = "runner-token"
+  executor = "docker+machine"
+  [runners.machine]
+    MachineName = "auto-scale-%s"
+    [runners.machine.predictive]
+      MinIdleCount = 1
+`
+	require.NoError(t, ioutil.WriteFile(configFile, []byte(configData), 0600))
+
+	config := common.NewConfig()
+	require.NoError(t, config.LoadConfig(configFile))
+	require.Len(t, config.Runners, 1)
+
+	p := newPredictions("docker+machine")
+	p.recordArrival(config.Runners[0])
+
+	assert.FileExists(t, filepath.Join(dir, common.DefaultPredictiveStateFile))
+}
+
+func TestPredictionsDisabled(t *testing.T) {
+	config := &common.RunnerConfig{
+		RunnerSettings: common.RunnerSettings{
+			Machine: &common.DockerMachine{},
+		},
+	}
+
+	p := newPredictions("docker+machine")
+	p.recordArrival(config)
+	p.recordDuration(config, time.Minute)
+	assert.Equal(t, 0, p.predict(config))
+	assert.Empty(t, p.histories)
+}
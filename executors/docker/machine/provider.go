@@ -16,6 +16,7 @@ import (
 type machineProvider struct {
 	name        string
 	machine     docker.Machine
+	plugins     *pluginMachines
 	details     machinesDetails
 	runners     runnersDetails
 	lock        sync.RWMutex
@@ -23,10 +24,32 @@ type machineProvider struct {
 	// provider stores a real executor that is used to start run the builds
 	provider common.ExecutorProvider
 
+	predictions *predictions
+
+	// stateFile keeps the machine details between the restarts of the runner,
+	// it's located next to the configuration file of the first runner that
+	// enabled the persistence. pending holds the loaded details not yet
+	// reconciled for their runner, persisted the machine filters of the runners
+	// that enabled the persistence.
+	stateFile   string
+	stateLock   sync.Mutex
+	lastState   []byte
+	restoreOnce sync.Once
+	pending     machinesDetails
+	restored    map[string]bool
+	persisted   map[string]string
+
 	stuckRemoveLock sync.Mutex
 
+	// healthConfigs are the configurations of the runners whose Idle
+	// machines are checked by the health checker, by runner token
+	healthLock    sync.Mutex
+	healthOnce    sync.Once
+	healthConfigs map[string]*common.RunnerConfig
+
 	// metrics
 	totalActions      *prometheus.CounterVec
+	healthChecks      *prometheus.CounterVec
 	currentStatesDesc *prometheus.Desc
 	creationHistogram prometheus.Histogram
 	stoppingHistogram prometheus.Histogram
@@ -63,6 +86,27 @@ func (m *machineProvider) ensureDetails(name string) *machineDetails {
 	return details
 }
 
+// machineFor returns the backend creating the machines of the runner: the
+// instance group plugin when one is configured, docker-machine otherwise
+func (m *machineProvider) machineFor(config *common.RunnerConfig) docker.Machine {
+	if m.plugins != nil && config.Machine.Plugin != "" {
+		return m.plugins.forConfig(config.Machine)
+	}
+
+	return m.machine
+}
+
+// machineOf returns the backend managing an existing machine
+func (m *machineProvider) machineOf(name string) docker.Machine {
+	if m.plugins != nil {
+		if plugin := m.plugins.owner(name); plugin != nil {
+			return plugin
+		}
+	}
+
+	return m.machine
+}
+
 var errNoConfig = errors.New("no runner config specified")
 
 func (m *machineProvider) runnerMachinesCoordinator(config *common.RunnerConfig) (*runnerMachinesCoordinator, error) {
@@ -91,6 +135,7 @@ func (m *machineProvider) create(config *common.RunnerConfig, state machineState
 	details.RetryCount = 0
 	details.LastSeen = time.Now()
 	m.lock.Unlock()
+	m.saveState()
 	errCh := make(chan error, 1)
 
 	// Create machine with the required configuration asynchronously
@@ -117,7 +162,7 @@ func (m *machineProvider) createWithGrowthCapacity(
 	logger := logrus.WithField("name", details.Name)
 	started := time.Now()
 
-	err := m.machine.Create(config.Machine.MachineDriver, details.Name, config.Machine.MachineOptions...)
+	err := m.machineFor(config).Create(config.Machine.MachineDriver, details.Name, config.Machine.MachineOptions...)
 	if err != nil {
 		logger.WithField("time", time.Since(started)).
 			WithError(err).
@@ -142,6 +187,7 @@ func (m *machineProvider) createWithGrowthCapacity(
 		// ordering of reading from errCh and the availability check.
 		coordinator.addAvailableMachine()
 	}
+	m.saveState()
 	errCh <- err
 }
 
@@ -155,7 +201,7 @@ func (m *machineProvider) findFreeMachine(skipCache bool, machines ...string) (d
 		}
 
 		// Check if node is running
-		canConnect := m.machine.CanConnect(name, skipCache)
+		canConnect := m.machineOf(name).CanConnect(name, skipCache)
 		if !canConnect {
 			_ = m.remove(name, "machine is unavailable")
 			continue
@@ -255,7 +301,8 @@ func (m *machineProvider) retryUseMachine(config *common.RunnerConfig) (details
 }
 
 func (m *machineProvider) removeMachine(details *machineDetails) (err error) {
-	if !m.machine.Exist(details.Name) {
+	machine := m.machineOf(details.Name)
+	if !machine.Exist(details.Name) {
 		details.logger().
 			Warningln("Skipping machine removal, because it doesn't exist")
 		return nil
@@ -269,7 +316,7 @@ func (m *machineProvider) removeMachine(details *machineDetails) (err error) {
 
 	details.logger().Warningln("Stopping machine")
 	err = runHistogramCountedOperation(m.stoppingHistogram, func() error {
-		return m.machine.Stop(details.Name, machineStopCommandTimeout)
+		return machine.Stop(details.Name, machineStopCommandTimeout)
 	})
 	if err != nil {
 		details.logger().
@@ -279,7 +326,7 @@ func (m *machineProvider) removeMachine(details *machineDetails) (err error) {
 
 	details.logger().Warningln("Removing machine")
 	err = runHistogramCountedOperation(m.removalHistogram, func() error {
-		return m.machine.Remove(details.Name)
+		return machine.Remove(details.Name)
 	})
 	if err != nil {
 		details.RetryCount++
@@ -307,15 +354,16 @@ func (m *machineProvider) finalizeRemoval(details *machineDetails) {
 	}
 
 	m.lock.Lock()
-	defer m.lock.Unlock()
 	delete(m.details, details.Name)
 
 	details.logger().
 		WithField("now", time.Now()).
 		WithField("retries", details.RetryCount).
 		Infoln("Machine removed")
+	m.lock.Unlock()
 
 	m.totalActions.WithLabelValues("removed").Inc()
+	m.saveState()
 }
 
 func (m *machineProvider) remove(machineName string, reason ...interface{}) error {
@@ -347,6 +395,7 @@ func (m *machineProvider) updateMachines(
 	config *common.RunnerConfig,
 ) (data machinesData, validMachines []string) {
 	data.Runner = config.ShortDescription()
+	data.PredictedDemand = m.predictions.predict(config)
 	validMachines = make([]string, 0, len(machines))
 
 	for _, name := range machines {
@@ -362,6 +411,9 @@ func (m *machineProvider) updateMachines(
 
 		data.Add(details)
 	}
+
+	m.predictions.observe(config, &data)
+
 	return
 }
 
@@ -415,7 +467,7 @@ func (m *machineProvider) intermediateMachineList(excludedMachines []string) []s
 }
 
 func (m *machineProvider) loadMachines(config *common.RunnerConfig) (machines []string, err error) {
-	machines, err = m.machine.List()
+	machines, err = m.machineFor(config).List()
 	if err != nil {
 		return nil, err
 	}
@@ -434,6 +486,9 @@ func (m *machineProvider) Acquire(config *common.RunnerConfig) (common.ExecutorD
 	m.acquireLock.Lock()
 	defer m.acquireLock.Unlock()
 
+	m.restoreMachines(config)
+	defer m.saveState()
+
 	machines, err := m.loadMachines(config)
 	if err != nil {
 		return nil, err
@@ -445,10 +500,13 @@ func (m *machineProvider) Acquire(config *common.RunnerConfig) (common.ExecutorD
 	// Pre-create machines
 	m.createMachines(config, &machinesData)
 
+	// Check and replace the unhealthy Idle machines in background
+	m.watchHealth(config)
+
 	logger := logrus.WithFields(machinesData.Fields()).
 		WithField("runner", config.ShortDescription()).
 		WithField("idleCountMin", config.Machine.GetIdleCountMin()).
-		WithField("idleCount", config.Machine.GetIdleCount()).
+		WithField("idleCount", getIdleCount(config, &machinesData)).
 		WithField("idleScaleFactor", config.Machine.GetIdleScaleFactor()).
 		WithField("maxMachines", config.Limit).
 		WithField("maxMachineCreate", config.Machine.MaxGrowthRate)
@@ -462,7 +520,7 @@ func (m *machineProvider) Acquire(config *common.RunnerConfig) (common.ExecutorD
 		return details, nil
 	}
 
-	if config.Machine.GetIdleCount() == 0 {
+	if getIdleCount(config, &machinesData) == 0 {
 		logger.Info("IdleCount is set to 0 so the machine will be created on demand in job context")
 	} else if machinesData.Idle == 0 {
 		return nil, &common.NoFreeExecutorError{Message: "no free machines that can process builds"}
@@ -478,7 +536,7 @@ func (m *machineProvider) Use(
 ) (newConfig common.RunnerConfig, newData common.ExecutorData, err error) {
 	// Find a new machine
 	details, _ := data.(*machineDetails)
-	if details == nil || !details.canBeUsed() || !m.machine.CanConnect(details.Name, true) {
+	if details == nil || !details.canBeUsed() || !m.machineOf(details.Name).CanConnect(details.Name, true) {
 		details, err = m.retryUseMachine(config)
 		if err != nil {
 			return
@@ -489,7 +547,7 @@ func (m *machineProvider) Use(
 	}
 
 	// Get machine credentials
-	dc, err := m.machine.Credentials(details.Name)
+	dc, err := m.machineOf(details.Name).Credentials(details.Name)
 	if err != nil {
 		if newData != nil {
 			m.Release(config, newData)
@@ -511,6 +569,8 @@ func (m *machineProvider) Use(
 	details.Used = time.Now()
 	details.UsedCount++
 	m.totalActions.WithLabelValues("used").Inc()
+	m.predictions.recordArrival(config)
+	m.saveState()
 	return
 }
 
@@ -523,11 +583,18 @@ func (m *machineProvider) Release(config *common.RunnerConfig, data common.Execu
 
 	m.lock.Lock()
 	// Mark last used time when is Used
+	var usedFor time.Duration
 	if details.State == machineStateUsed {
+		usedFor = time.Since(details.Used)
 		details.Used = time.Now()
 	}
 	m.lock.Unlock()
 
+	// the history is updated without holding the lock, as it may be written to disk
+	if usedFor > 0 && config != nil {
+		m.predictions.recordDuration(config, usedFor)
+	}
+
 	// Remove machine if we already used it
 	if config != nil && config.Machine != nil &&
 		config.Machine.MaxBuilds > 0 && details.UsedCount >= config.Machine.MaxBuilds {
@@ -539,6 +606,7 @@ func (m *machineProvider) Release(config *common.RunnerConfig, data common.Execu
 	m.lock.Lock()
 	details.State = machineStateIdle
 	m.lock.Unlock()
+	m.saveState()
 
 	// Signal pending builds that a new machine is available.
 	if err := m.signalRelease(config); err != nil {
@@ -588,11 +656,17 @@ func newMachineProvider(name, executor string) *machineProvider {
 	}
 
 	return &machineProvider{
-		name:     name,
-		details:  make(machinesDetails),
-		runners:  make(runnersDetails),
-		machine:  docker.NewMachineCommand(),
-		provider: provider,
+		name:          name,
+		details:       make(machinesDetails),
+		runners:       make(runnersDetails),
+		machine:       docker.NewMachineCommand(),
+		plugins:       newPluginMachines(),
+		provider:      provider,
+		predictions:   newPredictions(name),
+		pending:       make(machinesDetails),
+		restored:      make(map[string]bool),
+		persisted:     make(map[string]string),
+		healthConfigs: make(map[string]*common.RunnerConfig),
 		totalActions: prometheus.NewCounterVec(
 			prometheus.CounterOpts{
 				Name: "gitlab_runner_autoscaling_actions_total",
@@ -603,6 +677,16 @@ func newMachineProvider(name, executor string) *machineProvider {
 			},
 			[]string{"action"},
 		),
+		healthChecks: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "gitlab_runner_autoscaling_machine_health_checks_total",
+				Help: "The total number of health checks of Idle machines by result.",
+				ConstLabels: prometheus.Labels{
+					"executor": name,
+				},
+			},
+			[]string{"result"},
+		),
 		currentStatesDesc: prometheus.NewDesc(
 			"gitlab_runner_autoscaling_machine_states",
 			"The current number of machines per state in this provider.",
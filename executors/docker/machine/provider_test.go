@@ -168,6 +168,13 @@ func (m *testMachine) CanConnect(name string, skipCache bool) bool {
 	return !strings.Contains(name, "no-can-connect")
 }
 
+func (m *testMachine) DiskFree(name, path string) (uint64, error) {
+	if strings.Contains(name, "low-disk") {
+		return 1024 * 1024, nil
+	}
+	return 10 * 1024 * 1024 * 1024, nil
+}
+
 func (m *testMachine) Credentials(name string) (dc docker.Credentials, err error) {
 	if strings.Contains(name, "no-connect") {
 		err = errors.New("failed to connect")
@@ -762,3 +769,48 @@ func TestIntermediateMachineList(t *testing.T) {
 	intermediateMachine := p.intermediateMachineList([]string{"machine1", "machine2"})
 	assert.Equal(t, expectedIntermediateMachines, intermediateMachine)
 }
+
+func TestMachinePlugin(t *testing.T) {
+	provisionRetryInterval = 0
+
+	p, dockerMachine := testMachineProvider("docker-machine-1")
+	plugin := &testMachine{
+		Created: make(chan bool, 10),
+		Removed: make(chan bool, 10),
+		Stopped: make(chan bool, 10),
+	}
+
+	oldNewPluginMachine := newPluginMachine
+	defer func() { newPluginMachine = oldNewPluginMachine }()
+	newPluginMachine = func(path string, options []string, timeout time.Duration) docker.Machine {
+		assert.Equal(t, "/usr/local/bin/fake-plugin", path)
+		assert.Equal(t, []string{"-docker-host", "tcp://127.0.0.1:2375"}, options)
+		assert.Equal(t, time.Minute, timeout)
+		return plugin
+	}
+
+	config := createMachineConfig(t, 0, 5)
+	config.Machine.Plugin = "/usr/local/bin/fake-plugin"
+	config.Machine.PluginOptions = []string{"-docker-host", "tcp://127.0.0.1:2375"}
+	config.Machine.PluginTimeout = 60
+
+	d, err := p.useMachine(config)
+	require.NoError(t, err)
+	require.NotNil(t, d)
+	assert.Equal(t, []string{d.Name}, plugin.machines, "machine created by the plugin")
+	assert.Equal(t, []string{"docker-machine-1"}, dockerMachine.machines)
+
+	p.Release(config, d)
+
+	d2, err := p.useMachine(config)
+	require.NoError(t, err)
+	require.NotNil(t, d2)
+	assert.Equal(t, d.Name, d2.Name, "reuses the machine listed by the plugin")
+
+	err = p.remove(d.Name)
+	require.NoError(t, err)
+	<-plugin.Removed
+	assert.Empty(t, plugin.machines, "machine removed by the plugin")
+	assert.Equal(t, []string{"docker-machine-1"}, dockerMachine.machines)
+	assert.Equal(t, p.machine, p.machineOf(d.Name))
+}
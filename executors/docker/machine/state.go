@@ -1,5 +1,7 @@
 package machine
 
+import "fmt"
+
 type machineState int
 
 const (
@@ -30,3 +32,14 @@ func (t machineState) String() string {
 func (t machineState) MarshalText() ([]byte, error) {
 	return []byte(t.String()), nil
 }
+
+func (t *machineState) UnmarshalText(text []byte) error {
+	for state := machineStateIdle; state <= machineStateRemoving; state++ {
+		if state.String() == string(text) {
+			*t = state
+			return nil
+		}
+	}
+
+	return fmt.Errorf("unknown machine state %q", text)
+}
@@ -0,0 +1,115 @@
+package docker
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"gitlab.com/gitlab-org/gitlab-runner/common"
+	"gitlab.com/gitlab-org/gitlab-runner/executors"
+	"gitlab.com/gitlab-org/gitlab-runner/executors/docker/internal/snapshots"
+)
+
+var createSnapshotsManager = func(e *executor) (snapshots.Manager, error) {
+	mode, err := e.Config.Docker.GetKeepFailedContainers()
+	if err != nil {
+		return nil, err
+	}
+
+	if mode == "" {
+		return nil, nil
+	}
+
+	ttl := e.Config.Docker.GetFailedContainersTTL()
+
+	return snapshots.NewManager(&e.BuildLogger, e.client, e.Build, mode, ttl), nil
+}
+
+var (
+	startSnapshotsCollector = snapshots.StartCollector
+	stopSnapshotsCollector  = snapshots.StopCollector
+)
+
+// executorProvider starts the collector of the failed job snapshots of the
+// Docker host when the runner starts asking for jobs, so that the snapshots
+// kept before a restart of the runner are removed too, and stops it when the
+// runner shuts down
+type executorProvider struct {
+	executors.DefaultExecutorProvider
+}
+
+func (e executorProvider) Acquire(config *common.RunnerConfig) (common.ExecutorData, error) {
+	if config.Docker != nil {
+		mode, err := config.Docker.GetKeepFailedContainers()
+		if err == nil && mode != "" {
+			startSnapshotsCollector(config.Docker.Credentials, config.Docker.GetFailedContainersTTL())
+		}
+	}
+
+	return e.DefaultExecutorProvider.Acquire(config)
+}
+
+func (e executorProvider) Stop() {
+	stopSnapshotsCollector()
+}
+
+func (e *executor) createSnapshotsManager() error {
+	sm, err := createSnapshotsManager(e)
+	if err != nil {
+		return err
+	}
+
+	e.snapshotsManager = sm
+
+	return nil
+}
+
+// keepFailedContainer preserves the build container of a failed job according
+// to the keep_failed_containers setting, so that it can be inspected later on
+func (e *executor) keepFailedContainer(containerID string) {
+	if e.snapshotsManager == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), dockerCleanupTimeout)
+	defer cancel()
+
+	snapshot, err := e.snapshotsManager.Keep(ctx, containerID)
+	if err != nil {
+		e.Warningln("Failed to keep the build container of the failed job:", err)
+		return
+	}
+
+	if snapshot.KeepContainer {
+		e.removeTemporaryContainer(containerID)
+	}
+
+	e.Println(fmt.Sprintf(
+		"Kept the build container of the failed job as %s until %s",
+		snapshot.Reference,
+		snapshot.ExpiresAt.Format(time.RFC3339),
+	))
+	e.Println("To reproduce the job environment locally, run:")
+	for _, command := range snapshot.ReproduceCommands {
+		if e.Config.Docker.Host != "" {
+			command = fmt.Sprintf("DOCKER_HOST=%s %s", e.Config.Docker.Host, command)
+		}
+
+		e.Println("  " + command)
+	}
+
+	// The collector of the Docker host stops once the host has no snapshots
+	// left, or is unreachable like a removed autoscaled machine
+	startSnapshotsCollector(e.Config.Docker.Credentials, e.Config.Docker.GetFailedContainersTTL())
+}
+
+func (e *executor) removeTemporaryContainer(containerID string) {
+	temporary := e.temporary[:0]
+	for _, id := range e.temporary {
+		if id != containerID {
+			temporary = append(temporary, id)
+		}
+	}
+
+	e.temporary = temporary
+}
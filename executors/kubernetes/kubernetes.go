@@ -144,6 +144,22 @@ type executor struct {
 
 	// Flag if a repo mount and emptyDir volume are needed
 	requireDefaultBuildsDirVolume *bool
+
+	// The reaper tracking the job, so that its resources aren't reaped
+	reaper *runnerReaper
+
+	// The namespace created for the job, when the jobs don't share a namespace
+	ephemeralNamespace *api.Namespace
+
+	// The claim of the project used as the builds directory
+	buildsDirPVC *api.PersistentVolumeClaim
+
+	// The events of the build pod already printed into the job trace
+	printedPodEvents printedPodEvents
+
+	// The sampler of the metrics of the build pod, for the Kubernetes
+	// metrics referee
+	metricsSampler *podMetricsSampler
 }
 
 type serviceCreateResponse struct {
@@ -166,6 +182,16 @@ func (s *executor) Prepare(options common.ExecutorPrepareOptions) (err error) {
 
 	s.prepareOptions(options.Build)
 
+	err = s.configurationOverwrites.evaluateServicesResourcesOverwrite(
+		s.Config.Kubernetes,
+		s.options.Services,
+		options.Build.GetAllVariables(),
+		s.BuildLogger,
+	)
+	if err != nil {
+		return fmt.Errorf("couldn't prepare services overwrites: %w", err)
+	}
+
 	// Dynamically configure use of shared build dir allowing
 	// for static build dir when isolated volume is in use.
 	s.SharedBuildsDir = s.isSharedBuildsDirRequired()
@@ -211,9 +237,28 @@ func (s *executor) Prepare(options common.ExecutorPrepareOptions) (err error) {
 		return fmt.Errorf("kubernetes doesn't support shells that require script file")
 	}
 
+	// The ephemeral namespaces are labeled and reaped as a whole
+	var namespace string
+	if s.configurationOverwrites.ephemeralNamespacePrefix == "" {
+		namespace = s.configurationOverwrites.namespace
+	}
+
+	s.reaper = runnerReaperFor(s.Build.Runner.ShortDescription())
+	s.reaper.addJob(jobIDLabelValue(s.Build.ID), namespace)
+
 	return err
 }
 
+// resourceLabels returns the labels that identify the resources created for
+// the job, used to find the resources left behind by interrupted jobs
+func (s *executor) resourceLabels() map[string]string {
+	return map[string]string{
+		runnerIDLabel:   s.Build.Runner.ShortDescription(),
+		jobIDLabel:      jobIDLabelValue(s.Build.ID),
+		instanceIDLabel: instanceIDLabelValue(s.Config.Kubernetes),
+	}
+}
+
 func (s *executor) setupDefaultExecutorOptions(os string) {
 	if os == helperimage.OSTypeWindows {
 		s.DefaultBuildsDir = `C:\builds`
@@ -275,7 +320,17 @@ func (s *executor) Run(cmd common.ExecutorCommand) error {
 
 func (s *executor) runWithExecLegacy(cmd common.ExecutorCommand) error {
 	if s.pod == nil {
-		err := s.setupCredentials()
+		err := s.setupEphemeralNamespace()
+		if err != nil {
+			return err
+		}
+
+		err = s.setupBuildsDirPVC()
+		if err != nil {
+			return err
+		}
+
+		err = s.setupCredentials()
 		if err != nil {
 			return err
 		}
@@ -284,6 +339,11 @@ func (s *executor) runWithExecLegacy(cmd common.ExecutorCommand) error {
 		if err != nil {
 			return err
 		}
+
+		err = s.waitForServicesReadyLegacy()
+		if err != nil {
+			return err
+		}
 	}
 
 	containerName := buildContainerName
@@ -362,7 +422,17 @@ func (s *executor) ensurePodsConfigured(ctx context.Context) error {
 		return nil
 	}
 
-	err := s.setupCredentials()
+	err := s.setupEphemeralNamespace()
+	if err != nil {
+		return fmt.Errorf("setting up ephemeral namespace: %w", err)
+	}
+
+	err = s.setupBuildsDirPVC()
+	if err != nil {
+		return fmt.Errorf("setting up builds directory claim: %w", err)
+	}
+
+	err = s.setupCredentials()
 	if err != nil {
 		return fmt.Errorf("setting up credentials: %w", err)
 	}
@@ -381,7 +451,7 @@ func (s *executor) ensurePodsConfigured(ctx context.Context) error {
 		return fmt.Errorf("setting up build pod: %w", err)
 	}
 
-	status, err := waitForPodRunning(ctx, s.kubeClient, s.pod, s.Trace, s.Config.Kubernetes)
+	status, err := s.waitForPodRunning(ctx)
 	if err != nil {
 		return fmt.Errorf("waiting for pod running: %w", err)
 	}
@@ -390,6 +460,11 @@ func (s *executor) ensurePodsConfigured(ctx context.Context) error {
 		return fmt.Errorf("pod failed to enter running state: %s", status)
 	}
 
+	err = s.waitForServicesReady(ctx)
+	if err != nil {
+		return fmt.Errorf("waiting for services ready: %w", err)
+	}
+
 	go s.processLogs(ctx)
 
 	return nil
@@ -586,6 +661,7 @@ func (s *executor) setupScriptsConfigMap() error {
 		ObjectMeta: metav1.ObjectMeta{
 			GenerateName: fmt.Sprintf("%s-scripts", s.Build.ProjectUniqueName()),
 			Namespace:    s.configurationOverwrites.namespace,
+			Labels:       s.resourceLabels(),
 		},
 		Data: scripts,
 	}
@@ -652,6 +728,9 @@ func (s *executor) Finish(err error) {
 
 func (s *executor) Cleanup() {
 	s.cleanupResources()
+	if s.reaper != nil {
+		s.reaper.removeJob(jobIDLabelValue(s.Build.ID))
+	}
 	closeKubeClient(s.kubeClient)
 	s.AbstractExecutor.Cleanup()
 }
@@ -662,6 +741,8 @@ func (s *executor) Cleanup() {
 // This does not apply for services as they are created with the owner from the start
 // thus deletion of the pod automatically means deletion of the services if any
 func (s *executor) cleanupResources() {
+	s.stopMetricsSampler()
+
 	if s.pod != nil {
 		// TODO: handle the context properly with https://gitlab.com/gitlab-org/gitlab-runner/-/issues/27932
 		err := s.kubeClient.
@@ -698,6 +779,9 @@ func (s *executor) cleanupResources() {
 			s.Errorln(fmt.Sprintf("Error cleaning up configmap: %s", err.Error()))
 		}
 	}
+
+	s.cleanupBuildsDirPVC()
+	s.cleanupEphemeralNamespace()
 }
 
 //nolint:funlen
@@ -923,11 +1007,20 @@ func (s *executor) getVolumes() []api.Volume {
 	volumes := s.getVolumesForConfig()
 
 	if s.isDefaultBuildsDirVolumeRequired() {
+		source := api.VolumeSource{
+			EmptyDir: &api.EmptyDirVolumeSource{},
+		}
+		if s.buildsDirPVC != nil {
+			source = api.VolumeSource{
+				PersistentVolumeClaim: &api.PersistentVolumeClaimVolumeSource{
+					ClaimName: s.buildsDirPVC.Name,
+				},
+			}
+		}
+
 		volumes = append(volumes, api.Volume{
-			Name: "repo",
-			VolumeSource: api.VolumeSource{
-				EmptyDir: &api.EmptyDirVolumeSource{},
-			},
+			Name:         "repo",
+			VolumeSource: source,
 		})
 	}
 
@@ -1188,6 +1281,7 @@ func (s *executor) setupCredentials() error {
 	secret := api.Secret{}
 	secret.GenerateName = s.Build.ProjectUniqueName()
 	secret.Namespace = s.configurationOverwrites.namespace
+	secret.Labels = s.resourceLabels()
 	secret.Type = api.SecretTypeDockercfg
 	secret.Data = map[string][]byte{}
 	secret.Data[api.DockerConfigKey] = dockerCfgContent
@@ -1245,6 +1339,7 @@ func (s *executor) setupBuildPod(initContainers []api.Container) error {
 	}
 
 	s.pod = pod
+	s.startMetricsSampler()
 
 	ownerReferences := s.buildPodReferences()
 	err = s.setOwnerReferencesForResources(ownerReferences)
@@ -1272,6 +1367,9 @@ func (s *executor) createPodConfigPrepareOpts(initContainers []api.Container) (p
 	for k, v := range s.Build.Runner.Kubernetes.PodLabels {
 		labels[k] = sanitizeLabel(s.Build.Variables.ExpandValue(v))
 	}
+	for k, v := range s.resourceLabels() {
+		labels[k] = v
+	}
 
 	annotations := make(map[string]string)
 	for key, val := range s.configurationOverwrites.podAnnotations {
@@ -1327,12 +1425,13 @@ func (s *executor) preparePodServices() ([]api.Container, error) {
 
 	for i, service := range s.options.Services {
 		resolvedImage := s.Build.GetAllVariables().ExpandValue(service.Name)
+		requests, limits := s.configurationOverwrites.serviceResources(i)
 		podServices[i], err = s.buildContainer(containerBuildOpts{
-			name:            fmt.Sprintf("svc-%d", i),
+			name:            serviceContainerName(i),
 			image:           resolvedImage,
 			imageDefinition: service,
-			requests:        s.configurationOverwrites.serviceRequests,
-			limits:          s.configurationOverwrites.serviceLimits,
+			requests:        requests,
+			limits:          limits,
 			securityContext: s.Config.Kubernetes.GetContainerSecurityContext(
 				s.Config.Kubernetes.ServiceContainerSecurityContext,
 				s.defaultCapDrop()...,
@@ -1341,6 +1440,8 @@ func (s *executor) preparePodServices() ([]api.Container, error) {
 		if err != nil {
 			return nil, err
 		}
+
+		podServices[i].ReadinessProbe = s.serviceReadinessProbe(service)
 	}
 
 	return podServices, nil
@@ -1381,6 +1482,11 @@ func (s *executor) preparePodConfig(opts podConfigPrepareOpts) (api.Pod, error)
 		},
 	}
 
+	pod.Spec, err = s.applyPodSpecPatches(pod.Spec)
+	if err != nil {
+		return api.Pod{}, fmt.Errorf("patching pod spec: %w", err)
+	}
+
 	return pod, nil
 }
 
@@ -1540,6 +1646,7 @@ func (s *executor) prepareServiceConfig(
 			GenerateName:    name,
 			Namespace:       s.configurationOverwrites.namespace,
 			OwnerReferences: ownerReferences,
+			Labels:          s.resourceLabels(),
 		},
 		Spec: api.ServiceSpec{
 			Ports:    ports,
@@ -1670,7 +1777,7 @@ func (s *executor) runInContainerWithExecLegacy(
 	go func() {
 		defer close(errCh)
 
-		status, err := waitForPodRunning(ctx, s.kubeClient, s.pod, s.Trace, s.Config.Kubernetes)
+		status, err := s.waitForPodRunning(ctx)
 		if err != nil {
 			errCh <- err
 			return
@@ -1773,6 +1880,15 @@ func (s *executor) checkDefaults() error {
 		}
 	}
 
+	if s.configurationOverwrites.ephemeralNamespacePrefix != "" {
+		if s.Config.Kubernetes.BuildsDirPVCPool.Enabled {
+			return fmt.Errorf("builds_dir_pvc_pool can't be used with ephemeral_namespace")
+		}
+
+		s.Println("Using ephemeral Kubernetes namespace with prefix:", s.configurationOverwrites.ephemeralNamespacePrefix)
+		return nil
+	}
+
 	if s.configurationOverwrites.namespace == "" {
 		s.Warningln("Namespace is empty, therefore assuming 'default'.")
 		s.configurationOverwrites.namespace = "default"
@@ -1830,12 +1946,26 @@ func featuresFn(features *common.FeaturesInfo) {
 	features.ServiceVariables = true
 }
 
+// executorProvider starts the reaper of the orphaned resources of a runner
+// when the runner starts asking for jobs
+type executorProvider struct {
+	executors.DefaultExecutorProvider
+}
+
+func (e executorProvider) Acquire(config *common.RunnerConfig) (common.ExecutorData, error) {
+	runnerReaperFor(config.ShortDescription()).ensureRunning(config)
+
+	return e.DefaultExecutorProvider.Acquire(config)
+}
+
 func init() {
-	common.RegisterExecutorProvider("kubernetes", executors.DefaultExecutorProvider{
-		Creator: func() common.Executor {
-			return newExecutor()
+	common.RegisterExecutorProvider("kubernetes", executorProvider{
+		DefaultExecutorProvider: executors.DefaultExecutorProvider{
+			Creator: func() common.Executor {
+				return newExecutor()
+			},
+			FeaturesUpdater:  featuresFn,
+			DefaultShellName: executorOptions.Shell.Shell,
 		},
-		FeaturesUpdater:  featuresFn,
-		DefaultShellName: executorOptions.Shell.Shell,
 	})
 }
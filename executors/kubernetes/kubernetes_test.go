@@ -1856,6 +1856,7 @@ func TestPrepare(t *testing.T) {
 			e.pullManager = nil
 			e.requireDefaultBuildsDirVolume = nil
 			e.requireSharedBuildsDir = nil
+			e.reaper = nil
 
 			assert.NoError(t, err)
 			assert.Equal(t, test.Expected, e)
@@ -2249,15 +2250,19 @@ func TestSetupBuildPod(t *testing.T) {
 							"another": "label",
 							"var":     "$test",
 						},
+						Reaper: common.KubernetesReaper{InstanceID: "runner-1"},
 					},
 				},
 			},
 			VerifyFn: func(t *testing.T, test setupBuildPodTestDef, pod *api.Pod) {
 				assert.Equal(t, map[string]string{
-					"test":    "label",
-					"another": "label",
-					"var":     "sometestvar",
-					"pod":     pod.GenerateName,
+					"test":          "label",
+					"another":       "label",
+					"var":           "sometestvar",
+					runnerIDLabel:   "",
+					jobIDLabel:      "0",
+					instanceIDLabel: "runner-1",
+					"pod":           pod.GenerateName,
 				}, pod.ObjectMeta.Labels)
 			},
 			Variables: []common.JobVariable{
@@ -2482,6 +2487,7 @@ func TestSetupBuildPod(t *testing.T) {
 							GenerateName:    "build",
 							Namespace:       "default",
 							OwnerReferences: ownerReferences,
+							Labels:          e.resourceLabels(),
 						},
 						Spec: api.ServiceSpec{
 							Ports: []api.ServicePort{
@@ -2500,6 +2506,7 @@ func TestSetupBuildPod(t *testing.T) {
 							GenerateName:    "proxy-svc-0",
 							Namespace:       "default",
 							OwnerReferences: ownerReferences,
+							Labels:          e.resourceLabels(),
 						},
 						Spec: api.ServiceSpec{
 							Ports: []api.ServicePort{
@@ -2523,6 +2530,7 @@ func TestSetupBuildPod(t *testing.T) {
 							GenerateName:    "proxy-svc-1",
 							Namespace:       "default",
 							OwnerReferences: ownerReferences,
+							Labels:          e.resourceLabels(),
 						},
 						Spec: api.ServiceSpec{
 							Ports: []api.ServicePort{
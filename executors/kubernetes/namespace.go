@@ -0,0 +1,308 @@
+package kubernetes
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	api "k8s.io/api/core/v1"
+	networking "k8s.io/api/networking/v1"
+	kubeerrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/kubernetes"
+
+	"gitlab.com/gitlab-org/gitlab-runner/common"
+)
+
+const (
+	ephemeralNamespaceDefaultPrefix = "ci-job"
+
+	ephemeralNamespaceResourceQuotaName = "gitlab-runner"
+	ephemeralNamespaceLimitRangeName    = "gitlab-runner"
+	ephemeralNamespaceNetworkPolicyName = "gitlab-runner-default-deny"
+
+	defaultServiceAccountName = "default"
+)
+
+// ephemeralNamespacePrefix returns the prefix of the name of the namespace
+// created for a job. Kubernetes appends a random suffix to it, so that a new
+// namespace can be created while the previous one of the job is terminating.
+func ephemeralNamespacePrefix(namespace, jobID string) string {
+	if namespace == "" {
+		namespace = ephemeralNamespaceDefaultPrefix
+	}
+
+	prefix := namespace + "-"
+	if jobID != "" {
+		prefix += jobID + "-"
+	}
+
+	return prefix
+}
+
+// setupEphemeralNamespace creates the namespace of the job, when the jobs
+// don't share a namespace, and makes it the namespace of all the resources
+// created for the job
+func (s *executor) setupEphemeralNamespace() error {
+	prefix := s.configurationOverwrites.ephemeralNamespacePrefix
+	if prefix == "" || s.ephemeralNamespace != nil {
+		return nil
+	}
+
+	s.Debugln("Setting up ephemeral namespace")
+
+	// TODO: handle the context properly with https://gitlab.com/gitlab-org/gitlab-runner/-/issues/27932
+	ctx := context.TODO()
+
+	namespace, err := createEphemeralNamespace(
+		ctx,
+		s.kubeClient,
+		prefix,
+		s.resourceLabels(),
+		&s.Config.Kubernetes.EphemeralNamespace,
+	)
+	if namespace != nil {
+		s.ephemeralNamespace = namespace
+		s.configurationOverwrites.namespace = namespace.Name
+	}
+	if err != nil {
+		return err
+	}
+
+	s.Println("Using ephemeral Kubernetes namespace:", namespace.Name)
+
+	serviceAccount := s.configurationOverwrites.serviceAccount
+	if serviceAccount == "" {
+		serviceAccount = defaultServiceAccountName
+	}
+
+	return waitForServiceAccount(ctx, s.kubeClient, namespace.Name, serviceAccount, s.Config.Kubernetes)
+}
+
+// createEphemeralNamespace creates a namespace with the quota, the limits
+// and the network policy of the configuration. The namespace is returned
+// even when the creation of its policies fails, so that it can be deleted.
+func createEphemeralNamespace(
+	ctx context.Context,
+	client kubernetes.Interface,
+	prefix string,
+	labels map[string]string,
+	config *common.KubernetesEphemeralNamespace,
+) (*api.Namespace, error) {
+	namespace, err := client.CoreV1().Namespaces().Create(ctx, &api.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: prefix,
+			Labels:       labels,
+		},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("creating namespace: %w", err)
+	}
+
+	meta := metav1.ObjectMeta{Namespace: namespace.Name, Labels: labels}
+
+	if len(config.ResourceQuota) > 0 {
+		hard, err := parseResourceMap(config.ResourceQuota)
+		if err != nil {
+			return namespace, fmt.Errorf("invalid resource quota: %w", err)
+		}
+
+		quota := &api.ResourceQuota{ObjectMeta: meta, Spec: api.ResourceQuotaSpec{Hard: hard}}
+		quota.Name = ephemeralNamespaceResourceQuotaName
+
+		_, err = client.CoreV1().ResourceQuotas(namespace.Name).Create(ctx, quota, metav1.CreateOptions{})
+		if err != nil {
+			return namespace, fmt.Errorf("creating resource quota: %w", err)
+		}
+	}
+
+	if !config.LimitRange.IsEmpty() {
+		limitRange, err := newLimitRange(meta, &config.LimitRange)
+		if err != nil {
+			return namespace, fmt.Errorf("invalid limit range: %w", err)
+		}
+
+		_, err = client.CoreV1().LimitRanges(namespace.Name).Create(ctx, limitRange, metav1.CreateOptions{})
+		if err != nil {
+			return namespace, fmt.Errorf("creating limit range: %w", err)
+		}
+	}
+
+	policy, err := newDefaultDenyNetworkPolicy(meta, config.AllowedEgress)
+	if err != nil {
+		return namespace, fmt.Errorf("invalid allowed egress: %w", err)
+	}
+
+	_, err = client.NetworkingV1().NetworkPolicies(namespace.Name).Create(ctx, policy, metav1.CreateOptions{})
+	if err != nil {
+		return namespace, fmt.Errorf("creating network policy: %w", err)
+	}
+
+	return namespace, nil
+}
+
+func newLimitRange(meta metav1.ObjectMeta, config *common.KubernetesLimitRange) (*api.LimitRange, error) {
+	item := api.LimitRangeItem{Type: api.LimitTypeContainer}
+
+	var err error
+	for _, list := range []struct {
+		values map[string]string
+		target *api.ResourceList
+	}{
+		{values: config.Default, target: &item.Default},
+		{values: config.DefaultRequest, target: &item.DefaultRequest},
+		{values: config.Max, target: &item.Max},
+		{values: config.Min, target: &item.Min},
+	} {
+		if len(list.values) == 0 {
+			continue
+		}
+
+		*list.target, err = parseResourceMap(list.values)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	limitRange := &api.LimitRange{
+		ObjectMeta: meta,
+		Spec:       api.LimitRangeSpec{Limits: []api.LimitRangeItem{item}},
+	}
+	limitRange.Name = ephemeralNamespaceLimitRangeName
+
+	return limitRange, nil
+}
+
+// newDefaultDenyNetworkPolicy returns a policy that denies the traffic from
+// other namespaces and all the egress traffic, but the allowed one
+func newDefaultDenyNetworkPolicy(
+	meta metav1.ObjectMeta,
+	allowedEgress []common.KubernetesEgressRule,
+) (*networking.NetworkPolicy, error) {
+	egress := make([]networking.NetworkPolicyEgressRule, 0, len(allowedEgress))
+	for _, rule := range allowedEgress {
+		egressRule, err := newEgressRule(rule)
+		if err != nil {
+			return nil, err
+		}
+
+		egress = append(egress, egressRule)
+	}
+
+	policy := &networking.NetworkPolicy{
+		ObjectMeta: meta,
+		Spec: networking.NetworkPolicySpec{
+			PodSelector: metav1.LabelSelector{},
+			PolicyTypes: []networking.PolicyType{networking.PolicyTypeIngress, networking.PolicyTypeEgress},
+			Ingress: []networking.NetworkPolicyIngressRule{
+				{From: []networking.NetworkPolicyPeer{{PodSelector: &metav1.LabelSelector{}}}},
+			},
+			Egress: egress,
+		},
+	}
+	policy.Name = ephemeralNamespaceNetworkPolicyName
+
+	return policy, nil
+}
+
+func newEgressRule(rule common.KubernetesEgressRule) (networking.NetworkPolicyEgressRule, error) {
+	var egressRule networking.NetworkPolicyEgressRule
+
+	if rule.CIDR != "" {
+		egressRule.To = []networking.NetworkPolicyPeer{
+			{IPBlock: &networking.IPBlock{CIDR: rule.CIDR, Except: rule.Except}},
+		}
+	}
+
+	protocol := api.ProtocolTCP
+	if rule.Protocol != "" {
+		protocol = api.Protocol(strings.ToUpper(rule.Protocol))
+	}
+
+	switch protocol {
+	case api.ProtocolTCP, api.ProtocolUDP, api.ProtocolSCTP:
+	default:
+		return networking.NetworkPolicyEgressRule{}, fmt.Errorf("unsupported protocol %q", rule.Protocol)
+	}
+
+	for _, port := range rule.Ports {
+		p := intstr.FromInt(int(port))
+		egressRule.Ports = append(egressRule.Ports, networking.NetworkPolicyPort{
+			Protocol: &protocol,
+			Port:     &p,
+		})
+	}
+
+	return egressRule, nil
+}
+
+func parseResourceMap(values map[string]string) (api.ResourceList, error) {
+	list := make(api.ResourceList, len(values))
+	for name, value := range values {
+		quantity, err := resource.ParseQuantity(value)
+		if err != nil {
+			return nil, fmt.Errorf("parsing %q of %q: %w", value, name, err)
+		}
+
+		list[api.ResourceName(name)] = quantity
+	}
+
+	return list, nil
+}
+
+// waitForServiceAccount waits for the service account of a new namespace, as
+// pods can't be created before it exists
+func waitForServiceAccount(
+	ctx context.Context,
+	client kubernetes.Interface,
+	namespace string,
+	name string,
+	config *common.KubernetesConfig,
+) error {
+	interval := time.Duration(config.GetPollInterval()) * time.Second
+
+	for attempt := config.GetPollAttempts(); ; attempt-- {
+		_, err := client.CoreV1().ServiceAccounts(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err == nil {
+			return nil
+		}
+
+		if !kubeerrors.IsNotFound(err) {
+			return fmt.Errorf("getting service account %q: %w", name, err)
+		}
+
+		if attempt <= 1 {
+			return fmt.Errorf("service account %q wasn't created in namespace %q", name, namespace)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(interval):
+		}
+	}
+}
+
+// cleanupEphemeralNamespace deletes the namespace of the job together with
+// all the resources remaining in it
+func (s *executor) cleanupEphemeralNamespace() {
+	if s.ephemeralNamespace == nil {
+		return
+	}
+
+	// TODO: handle the context properly with https://gitlab.com/gitlab-org/gitlab-runner/-/issues/27932
+	err := s.kubeClient.
+		CoreV1().
+		Namespaces().
+		Delete(context.TODO(), s.ephemeralNamespace.Name, metav1.DeleteOptions{
+			PropagationPolicy: &PropagationPolicy,
+		})
+	if err != nil {
+		s.Errorln(fmt.Sprintf("Error cleaning up namespace: %s", err.Error()))
+	}
+
+	s.ephemeralNamespace = nil
+}
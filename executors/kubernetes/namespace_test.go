@@ -0,0 +1,228 @@
+//go:build !integration
+// +build !integration
+
+package kubernetes
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	api "k8s.io/api/core/v1"
+	networking "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+
+	"gitlab.com/gitlab-org/gitlab-runner/common"
+)
+
+// newNamespaceFakeClientset returns a fake clientset generating the names
+// of the namespaces, like the API server does
+func newNamespaceFakeClientset(objects ...runtime.Object) *fake.Clientset {
+	client := fake.NewSimpleClientset(objects...)
+	client.PrependReactor("create", "namespaces", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		namespace := action.(k8stesting.CreateAction).GetObject().(*api.Namespace)
+		if namespace.Name == "" {
+			namespace.Name = namespace.GenerateName + "abcde"
+		}
+		return false, nil, nil
+	})
+
+	return client
+}
+
+func TestEphemeralNamespacePrefix(t *testing.T) {
+	assert.Equal(t, "gitlab-123-", ephemeralNamespacePrefix("gitlab", "123"))
+	assert.Equal(t, "ci-job-123-", ephemeralNamespacePrefix("", "123"))
+	assert.Equal(t, "gitlab-", ephemeralNamespacePrefix("gitlab", ""))
+}
+
+func TestCreateEphemeralNamespace(t *testing.T) {
+	client := newNamespaceFakeClientset()
+	labels := map[string]string{runnerIDLabel: "runner", jobIDLabel: "123"}
+
+	config := &common.KubernetesEphemeralNamespace{
+		Enabled:       true,
+		ResourceQuota: map[string]string{"requests.cpu": "4", "pods": "10"},
+		LimitRange: common.KubernetesLimitRange{
+			Default: map[string]string{"memory": "1Gi"},
+			Max:     map[string]string{"cpu": "2"},
+		},
+		AllowedEgress: []common.KubernetesEgressRule{
+			{Ports: []int32{53}, Protocol: "udp"},
+			{CIDR: "0.0.0.0/0", Except: []string{"169.254.169.254/32"}, Ports: []int32{443}},
+		},
+	}
+
+	namespace, err := createEphemeralNamespace(context.Background(), client, "gitlab-123-", labels, config)
+	require.NoError(t, err)
+	assert.Equal(t, "gitlab-123-abcde", namespace.Name)
+	assert.Equal(t, labels, namespace.Labels)
+
+	ctx := context.Background()
+
+	quota, err := client.CoreV1().
+		ResourceQuotas(namespace.Name).
+		Get(ctx, ephemeralNamespaceResourceQuotaName, metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, api.ResourceList{
+		"requests.cpu": resource.MustParse("4"),
+		"pods":         resource.MustParse("10"),
+	}, quota.Spec.Hard)
+
+	limitRange, err := client.CoreV1().
+		LimitRanges(namespace.Name).
+		Get(ctx, ephemeralNamespaceLimitRangeName, metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, []api.LimitRangeItem{
+		{
+			Type:    api.LimitTypeContainer,
+			Default: api.ResourceList{api.ResourceMemory: resource.MustParse("1Gi")},
+			Max:     api.ResourceList{api.ResourceCPU: resource.MustParse("2")},
+		},
+	}, limitRange.Spec.Limits)
+
+	policy, err := client.NetworkingV1().
+		NetworkPolicies(namespace.Name).
+		Get(ctx, ephemeralNamespaceNetworkPolicyName, metav1.GetOptions{})
+	require.NoError(t, err)
+
+	udp, tcp := api.ProtocolUDP, api.ProtocolTCP
+	dns, https := intstr.FromInt(53), intstr.FromInt(443)
+	assert.Equal(t, networking.NetworkPolicySpec{
+		PolicyTypes: []networking.PolicyType{networking.PolicyTypeIngress, networking.PolicyTypeEgress},
+		Ingress: []networking.NetworkPolicyIngressRule{
+			{From: []networking.NetworkPolicyPeer{{PodSelector: &metav1.LabelSelector{}}}},
+		},
+		Egress: []networking.NetworkPolicyEgressRule{
+			{Ports: []networking.NetworkPolicyPort{{Protocol: &udp, Port: &dns}}},
+			{
+				Ports: []networking.NetworkPolicyPort{{Protocol: &tcp, Port: &https}},
+				To: []networking.NetworkPolicyPeer{
+					{IPBlock: &networking.IPBlock{CIDR: "0.0.0.0/0", Except: []string{"169.254.169.254/32"}}},
+				},
+			},
+		},
+	}, policy.Spec)
+}
+
+func TestCreateEphemeralNamespaceDeniesAllEgressByDefault(t *testing.T) {
+	client := newNamespaceFakeClientset()
+
+	namespace, err := createEphemeralNamespace(
+		context.Background(),
+		client,
+		"ci-job-",
+		nil,
+		&common.KubernetesEphemeralNamespace{Enabled: true},
+	)
+	require.NoError(t, err)
+
+	quotas, err := client.CoreV1().ResourceQuotas(namespace.Name).List(context.Background(), metav1.ListOptions{})
+	require.NoError(t, err)
+	assert.Empty(t, quotas.Items)
+
+	policy, err := client.NetworkingV1().
+		NetworkPolicies(namespace.Name).
+		Get(context.Background(), ephemeralNamespaceNetworkPolicyName, metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Contains(t, policy.Spec.PolicyTypes, networking.PolicyTypeEgress)
+	assert.Empty(t, policy.Spec.Egress)
+}
+
+func TestCreateEphemeralNamespaceErrors(t *testing.T) {
+	tests := map[string]struct {
+		config        common.KubernetesEphemeralNamespace
+		reactor       string
+		expectedError string
+	}{
+		"namespace creation": {
+			reactor:       "namespaces",
+			expectedError: "creating namespace: forbidden",
+		},
+		"invalid resource quota": {
+			config:        common.KubernetesEphemeralNamespace{ResourceQuota: map[string]string{"pods": "many"}},
+			expectedError: `invalid resource quota: parsing "many" of "pods"`,
+		},
+		"invalid protocol": {
+			config: common.KubernetesEphemeralNamespace{
+				AllowedEgress: []common.KubernetesEgressRule{{Protocol: "icmp"}},
+			},
+			expectedError: `invalid allowed egress: unsupported protocol "icmp"`,
+		},
+		"network policy creation": {
+			reactor:       "networkpolicies",
+			expectedError: "creating network policy: forbidden",
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			client := newNamespaceFakeClientset()
+			if tt.reactor != "" {
+				client.PrependReactor("create", tt.reactor, func(k8stesting.Action) (bool, runtime.Object, error) {
+					return true, nil, errors.New("forbidden")
+				})
+			}
+
+			namespace, err := createEphemeralNamespace(context.Background(), client, "ci-job-", nil, &tt.config)
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.expectedError)
+
+			if tt.reactor != "namespaces" {
+				assert.NotNil(t, namespace, "the namespace is returned to be cleaned up")
+			}
+		})
+	}
+}
+
+func TestWaitForServiceAccount(t *testing.T) {
+	config := &common.KubernetesConfig{PollInterval: 1, PollTimeout: 2}
+
+	client := fake.NewSimpleClientset(&api.ServiceAccount{
+		ObjectMeta: metav1.ObjectMeta{Name: "default", Namespace: "ci-job"},
+	})
+	assert.NoError(t, waitForServiceAccount(context.Background(), client, "ci-job", "default", config))
+
+	start := time.Now()
+	err := waitForServiceAccount(context.Background(), client, "ci-job", "missing", config)
+	assert.EqualError(t, err, `service account "missing" wasn't created in namespace "ci-job"`)
+	assert.True(t, time.Since(start) >= time.Second, "the service account is polled")
+}
+
+func TestReaperReapNamespaces(t *testing.T) {
+	old := time.Now().Add(-2 * time.Hour)
+	namespaceMeta := func(name, jobID string) metav1.ObjectMeta {
+		meta := reaperObjectMeta(name, "runner", jobID, old)
+		meta.Namespace = ""
+		return meta
+	}
+
+	client := fake.NewSimpleClientset(
+		&api.Namespace{ObjectMeta: namespaceMeta("ci-job-1-abcde", "1")},
+		&api.Namespace{ObjectMeta: namespaceMeta("ci-job-2-abcde", "2")},
+		&api.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default"}},
+	)
+
+	r := newReaper(client, "runner", time.Hour)
+	r.isRunning = func(jobID string) bool { return jobID == "2" }
+
+	reaped, err := r.reapRunner(
+		context.Background(),
+		&common.KubernetesConfig{EphemeralNamespace: common.KubernetesEphemeralNamespace{Enabled: true}},
+		[]string{"default"},
+	)
+	require.NoError(t, err)
+	assert.Equal(t, []ReapedResource{{Kind: "namespace", Name: "ci-job-1-abcde", JobID: "1"}}, reaped)
+
+	namespaces, err := client.CoreV1().Namespaces().List(context.Background(), metav1.ListOptions{})
+	require.NoError(t, err)
+	assert.Len(t, namespaces.Items, 2)
+}
@@ -70,6 +70,11 @@ const (
 	// HelperEphemeralStorageRequestOverwriteVariableValue is the key for the JobVariable containing user overwritten
 	// ephemeral storage
 	HelperMemoryRequestOverwriteVariableValue = "KUBERNETES_HELPER_MEMORY_REQUEST"
+	// PodSpecPatchOverwriteVariableValue is the key for the JobVariable containing user provided pod spec patch
+	PodSpecPatchOverwriteVariableValue = "KUBERNETES_POD_SPEC_PATCH"
+	// PodSpecPatchTypeOverwriteVariableValue is the key for the JobVariable containing the type of the user
+	// provided pod spec patch
+	PodSpecPatchTypeOverwriteVariableValue = "KUBERNETES_POD_SPEC_PATCH_TYPE"
 )
 
 type overwriteTooHighError struct {
@@ -101,18 +106,36 @@ func (m *malformedOverwriteError) Is(err error) bool {
 	return ok
 }
 
+type serviceResources struct {
+	requests api.ResourceList
+	limits   api.ResourceList
+}
+
 type overwrites struct {
 	namespace      string
 	serviceAccount string
 	bearerToken    string
 	podAnnotations map[string]string
 
+	// ephemeralNamespacePrefix is the prefix of the name of the namespace
+	// created for the job, it's empty when the jobs share the namespace
+	ephemeralNamespacePrefix string
+
 	buildLimits     api.ResourceList
 	serviceLimits   api.ResourceList
 	helperLimits    api.ResourceList
 	buildRequests   api.ResourceList
 	serviceRequests api.ResourceList
 	helperRequests  api.ResourceList
+
+	// servicesResources are the requests and limits of the services with
+	// their own settings, by index of the service. The other services use
+	// serviceRequests and serviceLimits.
+	servicesResources map[int]serviceResources
+
+	// podSpecPatch is the patch provided by the job, it can change only
+	// the fields of the pod spec allowed by the configuration
+	podSpecPatch *common.KubernetesPodSpec
 }
 
 //nolint:funlen
@@ -138,6 +161,10 @@ func createOverwrites(
 		return nil, err
 	}
 
+	if config.EphemeralNamespace.Enabled {
+		o.ephemeralNamespacePrefix = ephemeralNamespacePrefix(o.namespace, variables.Get("CI_JOB_ID"))
+	}
+
 	serviceAccountOverwrite := variables.Get(ServiceAccountOverwriteVariableName)
 	o.serviceAccount, err = o.evaluateOverwrite(
 		"ServiceAccount",
@@ -189,9 +216,35 @@ func createOverwrites(
 		return nil, err
 	}
 
+	o.podSpecPatch = o.evaluatePodSpecPatchOverwrite(config, variables, logger)
+
 	return o, nil
 }
 
+func (o *overwrites) evaluatePodSpecPatchOverwrite(
+	config *common.KubernetesConfig,
+	variables common.JobVariables,
+	logger common.BuildLogger,
+) *common.KubernetesPodSpec {
+	if len(config.PodSpecOverwriteAllowed) == 0 {
+		logger.Debugln("List of fields allowing overrides for PodSpec is empty, disabling override.")
+		return nil
+	}
+
+	patch := variables.Get(PodSpecPatchOverwriteVariableValue)
+	if patch == "" {
+		return nil
+	}
+
+	logger.Println(fmt.Sprintf("%q overwritten with the patch of %s", "PodSpec", PodSpecPatchOverwriteVariableValue))
+
+	return &common.KubernetesPodSpec{
+		Name:      PodSpecPatchOverwriteVariableValue,
+		Patch:     patch,
+		PatchType: common.KubernetesPodSpecPatchType(variables.Get(PodSpecPatchTypeOverwriteVariableValue)),
+	}
+}
+
 func (o *overwrites) evaluateMaxBuildResourcesOverwrite(
 	config *common.KubernetesConfig,
 	variables common.JobVariables,
@@ -284,6 +337,164 @@ func (o *overwrites) evaluateMaxServiceResourcesOverwrite(
 	return nil
 }
 
+// evaluateServicesResourcesOverwrite evaluates the requests and limits of
+// the services with their own settings. The settings of a service are set by
+// the service of the configuration with the same alias and overwritten by
+// the variables of the service definition of the job, or of the job itself.
+// The overwrites are limited by the maximal values allowed for all the
+// services.
+func (o *overwrites) evaluateServicesResourcesOverwrite(
+	config *common.KubernetesConfig,
+	services common.Services,
+	variables common.JobVariables,
+	logger common.BuildLogger,
+) error {
+	variables = variables.Expand()
+
+	for i, service := range services {
+		settings := findServiceSettings(config.Services, service, hasServiceResourcesSettings)
+		serviceVariables := service.Variables.Expand()
+		if settings == nil && !hasServiceResourcesOverwrite(serviceVariables) {
+			continue
+		}
+		if settings == nil {
+			settings = &common.Service{}
+		}
+
+		overwrite := func(key string) string {
+			if value := serviceVariables.Get(key); value != "" {
+				return value
+			}
+			return variables.Get(key)
+		}
+
+		fieldName := func(name string) string {
+			return fmt.Sprintf("%s of service %q", name, serviceName(service))
+		}
+
+		var resources serviceResources
+		var err error
+
+		resources.requests, err = o.evaluateMaxResourceListOverwrite(
+			fieldName("ServiceCPURequest"),
+			fieldName("ServiceMemoryRequest"),
+			fieldName("ServiceEphemeralStorageRequest"),
+			firstNonEmpty(settings.CPURequest, config.ServiceCPURequest),
+			firstNonEmpty(settings.MemoryRequest, config.ServiceMemoryRequest),
+			firstNonEmpty(settings.EphemeralStorageRequest, config.ServiceEphemeralStorageRequest),
+			config.ServiceCPURequestOverwriteMaxAllowed,
+			config.ServiceMemoryRequestOverwriteMaxAllowed,
+			config.ServiceEphemeralStorageRequestOverwriteMaxAllowed,
+			overwrite(ServiceCPURequestOverwriteVariableValue),
+			overwrite(ServiceMemoryRequestOverwriteVariableValue),
+			overwrite(ServiceEphemeralStorageRequestOverwriteVariableValue),
+			logger,
+		)
+		if err != nil {
+			return fmt.Errorf("invalid requests specified for service %q: %w", serviceName(service), err)
+		}
+
+		resources.limits, err = o.evaluateMaxResourceListOverwrite(
+			fieldName("ServiceCPULimit"),
+			fieldName("ServiceMemoryLimit"),
+			fieldName("ServiceEphemeralStorageLimit"),
+			firstNonEmpty(settings.CPULimit, config.ServiceCPULimit),
+			firstNonEmpty(settings.MemoryLimit, config.ServiceMemoryLimit),
+			firstNonEmpty(settings.EphemeralStorageLimit, config.ServiceEphemeralStorageLimit),
+			config.ServiceCPULimitOverwriteMaxAllowed,
+			config.ServiceMemoryLimitOverwriteMaxAllowed,
+			config.ServiceEphemeralStorageLimitOverwriteMaxAllowed,
+			overwrite(ServiceCPULimitOverwriteVariableValue),
+			overwrite(ServiceMemoryLimitOverwriteVariableValue),
+			overwrite(ServiceEphemeralStorageLimitOverwriteVariableValue),
+			logger,
+		)
+		if err != nil {
+			return fmt.Errorf("invalid limits specified for service %q: %w", serviceName(service), err)
+		}
+
+		if o.servicesResources == nil {
+			o.servicesResources = make(map[int]serviceResources)
+		}
+		o.servicesResources[i] = resources
+	}
+
+	return nil
+}
+
+// serviceResources returns the requests and limits of the service at the
+// index
+func (o *overwrites) serviceResources(index int) (api.ResourceList, api.ResourceList) {
+	if resources, ok := o.servicesResources[index]; ok {
+		return resources.requests, resources.limits
+	}
+
+	return o.serviceRequests, o.serviceLimits
+}
+
+// findServiceSettings returns the service of the configuration with the alias
+// of the service, or with its name when the service of the configuration has
+// no alias. Only the services of the configuration with the settings checked
+// by hasSettings are considered.
+func findServiceSettings(
+	settings []common.Service,
+	service common.Image,
+	hasSettings func(*common.Service) bool,
+) *common.Service {
+	for i, s := range settings {
+		if !hasSettings(&s) {
+			continue
+		}
+
+		if (s.Alias != "" && s.Alias == service.Alias) || (s.Alias == "" && s.Name != "" && s.Name == service.Name) {
+			return &settings[i]
+		}
+	}
+
+	return nil
+}
+
+func hasServiceResourcesSettings(s *common.Service) bool {
+	return s.CPULimit != "" || s.CPURequest != "" ||
+		s.MemoryLimit != "" || s.MemoryRequest != "" ||
+		s.EphemeralStorageLimit != "" || s.EphemeralStorageRequest != ""
+}
+
+func hasServiceResourcesOverwrite(variables common.JobVariables) bool {
+	for _, key := range []string{
+		ServiceCPULimitOverwriteVariableValue,
+		ServiceCPURequestOverwriteVariableValue,
+		ServiceMemoryLimitOverwriteVariableValue,
+		ServiceMemoryRequestOverwriteVariableValue,
+		ServiceEphemeralStorageLimitOverwriteVariableValue,
+		ServiceEphemeralStorageRequestOverwriteVariableValue,
+	} {
+		if variables.Get(key) != "" {
+			return true
+		}
+	}
+
+	return false
+}
+
+func serviceName(service common.Image) string {
+	if service.Alias != "" {
+		return service.Alias
+	}
+
+	return service.Name
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+
+	return ""
+}
+
 func (o *overwrites) evaluateMaxHelperResourcesOverwrite(
 	config *common.KubernetesConfig,
 	variables common.JobVariables,
@@ -500,3 +500,115 @@ type emptyTestError struct{}
 func (e *emptyTestError) Error() string {
 	return ""
 }
+
+func TestPodSpecPatchOverwrite(t *testing.T) {
+	variables := common.JobVariables{
+		{Key: PodSpecPatchOverwriteVariableValue, Value: `{"priorityClassName": "high"}`},
+		{Key: PodSpecPatchTypeOverwriteVariableValue, Value: "merge"},
+	}
+
+	o, err := createOverwrites(&common.KubernetesConfig{}, variables, stdoutLogger())
+	assert.NoError(t, err)
+	assert.Nil(t, o.podSpecPatch, "patch is ignored when no field can be overwritten")
+
+	config := &common.KubernetesConfig{PodSpecOverwriteAllowed: []string{"priorityClassName"}}
+	o, err = createOverwrites(config, variables, stdoutLogger())
+	assert.NoError(t, err)
+	assert.Equal(t, &common.KubernetesPodSpec{
+		Name:      PodSpecPatchOverwriteVariableValue,
+		Patch:     `{"priorityClassName": "high"}`,
+		PatchType: common.PatchTypeMergePatch,
+	}, o.podSpecPatch)
+}
+
+func TestEphemeralNamespaceOverwrite(t *testing.T) {
+	variables := common.JobVariables{
+		{Key: "CI_JOB_ID", Value: "123"},
+		{Key: NamespaceOverwriteVariableName, Value: "team-a"},
+	}
+
+	config := &common.KubernetesConfig{
+		Namespace:                 "gitlab",
+		NamespaceOverwriteAllowed: "^team-.*$",
+	}
+
+	o, err := createOverwrites(config, variables, stdoutLogger())
+	assert.NoError(t, err)
+	assert.Equal(t, "team-a", o.namespace)
+	assert.Empty(t, o.ephemeralNamespacePrefix, "jobs share the namespace by default")
+
+	config.EphemeralNamespace.Enabled = true
+	o, err = createOverwrites(config, variables, stdoutLogger())
+	assert.NoError(t, err)
+	assert.Equal(t, "team-a-123-", o.ephemeralNamespacePrefix, "overwritten namespace is the prefix")
+
+	config.NamespaceOverwriteAllowed = "^team-b$"
+	_, err = createOverwrites(config, variables, stdoutLogger())
+	assert.ErrorIs(t, err, new(malformedOverwriteError))
+}
+
+func TestServicesResourcesOverwrite(t *testing.T) {
+	config := &common.KubernetesConfig{
+		ServiceCPULimit:                       "500m",
+		ServiceMemoryLimit:                    "256Mi",
+		ServiceMemoryRequest:                  "128Mi",
+		ServiceMemoryLimitOverwriteMaxAllowed: "8Gi",
+		Services: []common.Service{
+			{Name: "redis:alpine", Alias: "cache"},
+			{Alias: "search", CPULimit: "2", MemoryLimit: "4Gi"},
+			{Name: "postgres:14", MemoryRequest: "1Gi"},
+		},
+	}
+
+	services := common.Services{
+		{Name: "redis:alpine", Alias: "cache"},
+		{Name: "postgres:14"},
+		{Name: "elasticsearch:8", Alias: "search"},
+		{
+			Name:      "mysql:8",
+			Alias:     "mysql",
+			Variables: common.JobVariables{{Key: ServiceMemoryLimitOverwriteVariableValue, Value: "2Gi"}},
+		},
+		{
+			Name:      "mongo:6",
+			Variables: common.JobVariables{{Key: ServiceMemoryLimitOverwriteVariableValue, Value: "16Gi"}},
+		},
+	}
+
+	o, err := createOverwrites(config, nil, stdoutLogger())
+	assert.NoError(t, err)
+
+	err = o.evaluateServicesResourcesOverwrite(config, services[:4], nil, stdoutLogger())
+	assert.NoError(t, err)
+
+	requests, limits := o.serviceResources(0)
+	assert.Equal(t, mustCreateResourceList(t, "", "128Mi", ""), requests, "no own settings")
+	assert.Equal(t, mustCreateResourceList(t, "500m", "256Mi", ""), limits, "no own settings")
+
+	requests, limits = o.serviceResources(1)
+	assert.Equal(t, mustCreateResourceList(t, "", "1Gi", ""), requests, "matched by name")
+	assert.Equal(t, mustCreateResourceList(t, "500m", "256Mi", ""), limits, "matched by name")
+
+	requests, limits = o.serviceResources(2)
+	assert.Equal(t, mustCreateResourceList(t, "", "128Mi", ""), requests, "matched by alias")
+	assert.Equal(t, mustCreateResourceList(t, "2", "4Gi", ""), limits, "matched by alias")
+
+	_, limits = o.serviceResources(3)
+	assert.Equal(t, mustCreateResourceList(t, "500m", "2Gi", ""), limits, "overwritten by service variables")
+
+	// the variables of the service definition take precedence over the job ones
+	variables := common.JobVariables{{Key: ServiceMemoryLimitOverwriteVariableValue, Value: "1Gi"}}
+	o, err = createOverwrites(config, variables, stdoutLogger())
+	assert.NoError(t, err)
+	err = o.evaluateServicesResourcesOverwrite(config, services[:4], variables, stdoutLogger())
+	assert.NoError(t, err)
+
+	_, limits = o.serviceResources(2)
+	assert.Equal(t, mustCreateResourceList(t, "2", "1Gi", ""), limits)
+	_, limits = o.serviceResources(3)
+	assert.Equal(t, mustCreateResourceList(t, "500m", "2Gi", ""), limits)
+
+	err = o.evaluateServicesResourcesOverwrite(config, services, nil, stdoutLogger())
+	assert.ErrorIs(t, err, new(overwriteTooHighError))
+	assert.Contains(t, err.Error(), `invalid limits specified for service "mongo:6"`)
+}
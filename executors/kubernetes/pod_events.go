@@ -0,0 +1,176 @@
+package kubernetes
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"regexp"
+	"sync"
+
+	api "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+
+	"gitlab.com/gitlab-org/gitlab-runner/common"
+)
+
+// missingClaimRegex matches the scheduling failures of pods using a
+// persistent volume claim that doesn't exist. Unlike an unbound claim, which
+// is bound once its volume is provisioned, such a pod is never scheduled.
+var missingClaimRegex = regexp.MustCompile(`persistentvolumeclaim "[^"]+" not found`)
+
+// watchPodEvents prints the events of the pod, like the scheduling failures
+// or the image pulls, into the job trace while the pod starts. The errors
+// of the events after which the pod can't start are sent to the returned
+// channel. Watching stops when the context is done.
+// The events already printed are tracked in printed, so that they aren't
+// printed again when the pod is watched another time.
+func watchPodEvents(
+	ctx context.Context,
+	client kubernetes.Interface,
+	pod *api.Pod,
+	out io.Writer,
+	printed *printedPodEvents,
+) (<-chan error, error) {
+	events := client.CoreV1().Events(pod.Namespace)
+	options := metav1.ListOptions{
+		FieldSelector: fields.Set{
+			"involvedObject.kind": "Pod",
+			"involvedObject.name": pod.Name,
+		}.String(),
+	}
+
+	// the events which occurred before watching are listed first
+	list, err := events.List(ctx, options)
+	if err != nil {
+		return nil, fmt.Errorf("listing pod events: %w", err)
+	}
+
+	options.ResourceVersion = list.ResourceVersion
+	w, err := events.Watch(ctx, options)
+	if err != nil {
+		return nil, fmt.Errorf("watching pod events: %w", err)
+	}
+
+	failures := make(chan error, 1)
+	handle := func(event *api.Event) {
+		if !isEventOfPod(event, pod) || !printed.add(event) {
+			return
+		}
+
+		printPodEvent(out, event)
+
+		if err := unrecoverablePodEventError(event); err != nil {
+			select {
+			case failures <- err:
+			default:
+			}
+		}
+	}
+
+	for i := range list.Items {
+		handle(&list.Items[i])
+	}
+
+	go func() {
+		defer w.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case result, ok := <-w.ResultChan():
+				if !ok {
+					return
+				}
+
+				event, isEvent := result.Object.(*api.Event)
+				if isEvent && result.Type != watch.Deleted {
+					handle(event)
+				}
+			}
+		}
+	}()
+
+	return failures, nil
+}
+
+// printedPodEvents tracks the events printed with the number of times they
+// occurred, as the events are updated with an increased count when they recur
+type printedPodEvents struct {
+	lock   sync.Mutex
+	counts map[types.UID]int32
+}
+
+// add returns whether the event, or its recurrence, wasn't printed yet
+func (p *printedPodEvents) add(event *api.Event) bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if p.counts == nil {
+		p.counts = make(map[types.UID]int32)
+	}
+
+	count, seen := p.counts[event.UID]
+	if seen && event.Count <= count {
+		return false
+	}
+
+	p.counts[event.UID] = event.Count
+
+	return true
+}
+
+func isEventOfPod(event *api.Event, pod *api.Pod) bool {
+	if event.InvolvedObject.Kind != "Pod" || event.InvolvedObject.Name != pod.Name {
+		return false
+	}
+
+	// events of a previous pod with the same name are skipped
+	return pod.UID == "" || event.InvolvedObject.UID == "" || event.InvolvedObject.UID == pod.UID
+}
+
+func printPodEvent(out io.Writer, event *api.Event) {
+	message := fmt.Sprintf("Pod event: %s %s: %s", event.Type, event.Reason, event.Message)
+	if event.Count > 1 {
+		message += fmt.Sprintf(" (x%d)", event.Count)
+	}
+
+	_, _ = fmt.Fprintln(out, message)
+}
+
+// unrecoverablePodEventError returns an error for the events after which the
+// pod is never started, so that the job fails without waiting for the
+// poll timeout
+func unrecoverablePodEventError(event *api.Event) error {
+	if event.Reason == "FailedScheduling" && missingClaimRegex.MatchString(event.Message) {
+		return &common.BuildError{Inner: fmt.Errorf("pod can't be scheduled: %s", event.Message)}
+	}
+
+	return nil
+}
+
+// watchPodEvents watches the events of the build pod. Watching the events
+// may be forbidden to the service account of the runner, in which case the
+// pod is only polled.
+func (s *executor) watchPodEvents(ctx context.Context) <-chan error {
+	failures, err := watchPodEvents(ctx, s.kubeClient, s.pod, s.Trace, &s.printedPodEvents)
+	if err != nil {
+		s.Debugln(fmt.Sprintf("Not printing the pod events: %v", err))
+		return nil
+	}
+
+	return failures
+}
+
+// waitForPodRunning waits for the build pod to be running while printing its
+// events
+func (s *executor) waitForPodRunning(ctx context.Context) (api.PodPhase, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	return waitForPodRunning(ctx, s.kubeClient, s.pod, s.Trace, s.Config.Kubernetes, s.watchPodEvents(ctx))
+}
@@ -0,0 +1,269 @@
+//go:build !integration
+// +build !integration
+
+package kubernetes
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	api "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+
+	"gitlab.com/gitlab-org/gitlab-runner/common"
+	"gitlab.com/gitlab-org/gitlab-runner/executors/kubernetes/internal/pull"
+)
+
+type syncBuffer struct {
+	lock sync.Mutex
+	buf  bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	return b.buf.String()
+}
+
+func testPodEvent(uid, podUID, eventType, reason, message string, count int32) *api.Event {
+	return &api.Event{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-pod." + uid, Namespace: "test-ns", UID: types.UID(uid)},
+		InvolvedObject: api.ObjectReference{
+			Kind: "Pod",
+			Name: "test-pod",
+			UID:  types.UID(podUID),
+		},
+		Type:    eventType,
+		Reason:  reason,
+		Message: message,
+		Count:   count,
+	}
+}
+
+func testEventsPod() *api.Pod {
+	return &api.Pod{ObjectMeta: metav1.ObjectMeta{Name: "test-pod", Namespace: "test-ns", UID: "pod"}}
+}
+
+func TestWatchPodEvents(t *testing.T) {
+	client := fake.NewSimpleClientset(
+		testPodEvent("1", "pod", api.EventTypeWarning, "FailedScheduling", "0/3 nodes are available", 1),
+		testPodEvent("2", "previous-pod", api.EventTypeWarning, "BackOff", "Back-off pulling image", 1),
+	)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	out := new(syncBuffer)
+	printed := new(printedPodEvents)
+	failures, err := watchPodEvents(ctx, client, testEventsPod(), out, printed)
+	require.NoError(t, err)
+
+	assert.Eventually(t, func() bool {
+		return out.String() == "Pod event: Warning FailedScheduling: 0/3 nodes are available\n"
+	}, time.Second, 10*time.Millisecond)
+
+	events := client.CoreV1().Events("test-ns")
+	_, err = events.Update(
+		ctx,
+		testPodEvent("1", "pod", api.EventTypeWarning, "FailedScheduling", "0/3 nodes are available", 2),
+		metav1.UpdateOptions{},
+	)
+	require.NoError(t, err)
+	_, err = events.Create(
+		ctx,
+		testPodEvent("3", "pod", api.EventTypeNormal, "Scheduled", "Successfully assigned test-ns/test-pod", 1),
+		metav1.CreateOptions{},
+	)
+	require.NoError(t, err)
+
+	expected := "Pod event: Warning FailedScheduling: 0/3 nodes are available\n" +
+		"Pod event: Warning FailedScheduling: 0/3 nodes are available (x2)\n" +
+		"Pod event: Normal Scheduled: Successfully assigned test-ns/test-pod\n"
+	assert.Eventually(t, func() bool { return out.String() == expected }, time.Second, 10*time.Millisecond)
+	assert.Empty(t, failures)
+
+	cancel()
+
+	// the events already printed aren't printed again by the next watch
+	out = new(syncBuffer)
+	_, err = watchPodEvents(context.Background(), client, testEventsPod(), out, printed)
+	require.NoError(t, err)
+	assert.Never(t, func() bool { return out.String() != "" }, 100*time.Millisecond, 10*time.Millisecond)
+}
+
+func TestWatchPodEventsUnrecoverableFailure(t *testing.T) {
+	client := fake.NewSimpleClientset(
+		testPodEvent(
+			"1",
+			"pod",
+			api.EventTypeWarning,
+			"FailedScheduling",
+			`0/3 nodes are available: persistentvolumeclaim "cache" not found.`,
+			1,
+		),
+	)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	failures, err := watchPodEvents(ctx, client, testEventsPod(), new(syncBuffer), new(printedPodEvents))
+	require.NoError(t, err)
+
+	select {
+	case err := <-failures:
+		var buildErr *common.BuildError
+		assert.ErrorAs(t, err, &buildErr)
+		assert.EqualError(
+			t,
+			err,
+			`pod can't be scheduled: 0/3 nodes are available: persistentvolumeclaim "cache" not found.`,
+		)
+	case <-time.After(time.Second):
+		t.Fatal("no failure received")
+	}
+}
+
+func TestWatchPodEventsForbidden(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	client.PrependReactor("list", "events", func(k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, errors.New("forbidden")
+	})
+
+	_, err := watchPodEvents(context.Background(), client, testEventsPod(), new(syncBuffer), new(printedPodEvents))
+	assert.EqualError(t, err, "listing pod events: forbidden")
+}
+
+func TestUnrecoverablePodEventError(t *testing.T) {
+	tests := map[string]struct {
+		reason        string
+		message       string
+		expectedError bool
+	}{
+		"missing claim": {
+			reason:        "FailedScheduling",
+			message:       `0/1 nodes are available: persistentvolumeclaim "builds" not found.`,
+			expectedError: true,
+		},
+		"unbound claim": {
+			reason:  "FailedScheduling",
+			message: "0/1 nodes are available: pod has unbound immediate PersistentVolumeClaims.",
+		},
+		"insufficient resources": {
+			reason:  "FailedScheduling",
+			message: "0/3 nodes are available: 3 Insufficient cpu.",
+		},
+		"exceeded quota": {
+			reason:  "FailedCreate",
+			message: `exceeded quota: gitlab-runner, requested: pods=1, used: pods=10, limited: pods=10`,
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			err := unrecoverablePodEventError(testPodEvent("1", "pod", api.EventTypeWarning, tt.reason, tt.message, 1))
+			assert.Equal(t, tt.expectedError, err != nil)
+		})
+	}
+}
+
+func TestGetPodPhaseImagePullErrors(t *testing.T) {
+	tests := map[string]struct {
+		reason             string
+		expectedPullError  bool
+		expectedBuildError bool
+	}{
+		"ErrImagePull": {
+			reason:            "ErrImagePull",
+			expectedPullError: true,
+		},
+		"ImagePullBackOff": {
+			reason:            "ImagePullBackOff",
+			expectedPullError: true,
+		},
+		"ErrImageNeverPull": {
+			reason:            "ErrImageNeverPull",
+			expectedPullError: true,
+		},
+		"InvalidImageName": {
+			reason:             "InvalidImageName",
+			expectedBuildError: true,
+		},
+		"ContainerCreating": {
+			reason: "ContainerCreating",
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			pod := testEventsPod()
+			pod.Status = api.PodStatus{
+				Phase: api.PodPending,
+				ContainerStatuses: []api.ContainerStatus{
+					{
+						Name:  "build",
+						Image: "alpine:missing",
+						State: api.ContainerState{
+							Waiting: &api.ContainerStateWaiting{Reason: tt.reason, Message: "not found"},
+						},
+					},
+				},
+			}
+
+			out := new(bytes.Buffer)
+			r := getPodPhase(fake.NewSimpleClientset(pod), pod, out)
+
+			var pullErr *pull.ImagePullError
+			assert.Equal(t, tt.expectedPullError, errors.As(r.err, &pullErr))
+			var buildErr *common.BuildError
+			assert.Equal(t, tt.expectedPullError || tt.expectedBuildError, errors.As(r.err, &buildErr))
+
+			if r.err != nil {
+				assert.True(t, r.done, "the pod isn't waited for")
+				assert.Empty(t, out.String())
+				return
+			}
+
+			assert.False(t, r.done)
+			assert.Contains(t, out.String(), "Waiting for pod test-ns/test-pod to be running, status is Pending")
+		})
+	}
+}
+
+func TestWaitForPodRunningFailure(t *testing.T) {
+	pod := testEventsPod()
+	pod.Status.Phase = api.PodPending
+
+	failures := make(chan error, 1)
+	failures <- errors.New("unrecoverable")
+
+	start := time.Now()
+	phase, err := waitForPodRunning(
+		context.Background(),
+		fake.NewSimpleClientset(pod),
+		pod,
+		new(bytes.Buffer),
+		&common.KubernetesConfig{PollInterval: 10, PollTimeout: 60},
+		failures,
+	)
+	assert.EqualError(t, err, "unrecoverable")
+	assert.Equal(t, api.PodUnknown, phase)
+	assert.Less(t, time.Since(start).Seconds(), float64(10), "the poll timeout isn't waited for")
+}
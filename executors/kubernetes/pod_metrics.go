@@ -0,0 +1,237 @@
+package kubernetes
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"github.com/sirupsen/logrus"
+	api "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/rest"
+
+	"gitlab.com/gitlab-org/gitlab-runner/referees"
+)
+
+const podMetricsAPIPath = "/apis/metrics.k8s.io/v1beta1"
+
+var errMetricsNotSampled = errors.New("the metrics of the pod weren't sampled")
+
+// podMetrics is the subset of the PodMetrics of the metrics.k8s.io API used
+// by the runner, so that the metrics client isn't required
+type podMetrics struct {
+	Timestamp  metav1.Time        `json:"timestamp"`
+	Containers []containerMetrics `json:"containers"`
+}
+
+type containerMetrics struct {
+	Name  string           `json:"name"`
+	Usage api.ResourceList `json:"usage"`
+}
+
+type podMetricsClient interface {
+	GetPodMetrics(ctx context.Context, namespace string, name string) (*podMetrics, error)
+}
+
+// restPodMetricsClient gets the metrics of the pods from the metrics.k8s.io
+// API, served by the metrics server of the cluster
+type restPodMetricsClient struct {
+	client rest.Interface
+}
+
+func (c *restPodMetricsClient) GetPodMetrics(ctx context.Context, namespace string, name string) (*podMetrics, error) {
+	data, err := c.client.
+		Get().
+		AbsPath(podMetricsAPIPath, "namespaces", namespace, "pods", name).
+		DoRaw(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var metrics podMetrics
+	err = json.Unmarshal(data, &metrics)
+	if err != nil {
+		return nil, fmt.Errorf("decoding pod metrics: %w", err)
+	}
+
+	return &metrics, nil
+}
+
+// podMetricsSampler queries the metrics of the containers of a pod at an
+// interval while the job runs, since the metrics API only returns the
+// current usage
+type podMetricsSampler struct {
+	client    podMetricsClient
+	namespace string
+	pod       string
+	interval  time.Duration
+	logger    logrus.FieldLogger
+
+	lock    sync.Mutex
+	samples map[string]*referees.ContainerMetrics
+	last    time.Time
+
+	cancel func()
+	done   chan struct{}
+}
+
+func newPodMetricsSampler(
+	client podMetricsClient,
+	pod *api.Pod,
+	interval time.Duration,
+	logger logrus.FieldLogger,
+) *podMetricsSampler {
+	return &podMetricsSampler{
+		client:    client,
+		namespace: pod.Namespace,
+		pod:       pod.Name,
+		interval:  interval,
+		logger:    logger,
+		samples:   make(map[string]*referees.ContainerMetrics),
+	}
+}
+
+func (p *podMetricsSampler) start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	p.cancel = cancel
+	p.done = make(chan struct{})
+
+	go func() {
+		defer close(p.done)
+
+		ticker := time.NewTicker(p.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			// the metrics aren't available until the metrics server
+			// scraped the pod, the sampling errors are only logged
+			err := p.sample(ctx)
+			if err != nil && ctx.Err() == nil {
+				p.logger.WithError(err).Debugln("Failed to sample the pod metrics")
+			}
+		}
+	}()
+}
+
+func (p *podMetricsSampler) stop() {
+	if p.cancel == nil {
+		return
+	}
+
+	p.cancel()
+	<-p.done
+}
+
+func (p *podMetricsSampler) sample(ctx context.Context) error {
+	metrics, err := p.client.GetPodMetrics(ctx, p.namespace, p.pod)
+	if err != nil {
+		return err
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	// the metrics server scrapes the pods at its own resolution, the
+	// same metrics are returned until the next scrape
+	if !metrics.Timestamp.After(p.last) {
+		return nil
+	}
+	p.last = metrics.Timestamp.Time
+
+	timestamp := model.TimeFromUnixNano(metrics.Timestamp.UnixNano())
+	for _, container := range metrics.Containers {
+		samples, ok := p.samples[container.Name]
+		if !ok {
+			samples = new(referees.ContainerMetrics)
+			p.samples[container.Name] = samples
+		}
+
+		cpu := container.Usage[api.ResourceCPU]
+		memory := container.Usage[api.ResourceMemory]
+
+		samples.CPU = append(samples.CPU, model.SamplePair{
+			Timestamp: timestamp,
+			Value:     model.SampleValue(float64(cpu.MilliValue()) / 1000),
+		})
+		samples.Memory = append(samples.Memory, model.SamplePair{
+			Timestamp: timestamp,
+			Value:     model.SampleValue(memory.Value()),
+		})
+	}
+
+	return nil
+}
+
+// metrics returns the samples of the containers between the start and the
+// end time
+func (p *podMetricsSampler) metrics(startTime, endTime time.Time) map[string]referees.ContainerMetrics {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	start := model.TimeFromUnixNano(startTime.UnixNano())
+	end := model.TimeFromUnixNano(endTime.UnixNano())
+	inRange := func(samples []model.SamplePair) []model.SamplePair {
+		var result []model.SamplePair
+		for _, sample := range samples {
+			if !sample.Timestamp.Before(start) && !sample.Timestamp.After(end) {
+				result = append(result, sample)
+			}
+		}
+		return result
+	}
+
+	metrics := make(map[string]referees.ContainerMetrics, len(p.samples))
+	for name, samples := range p.samples {
+		metrics[name] = referees.ContainerMetrics{
+			CPU:    inRange(samples.CPU),
+			Memory: inRange(samples.Memory),
+		}
+	}
+
+	return metrics
+}
+
+// startMetricsSampler samples the metrics of the build pod when the
+// Kubernetes metrics referee is configured
+func (s *executor) startMetricsSampler() {
+	if s.Config.Referees == nil || s.Config.Referees.KubernetesMetrics == nil || s.metricsSampler != nil {
+		return
+	}
+
+	s.metricsSampler = newPodMetricsSampler(
+		&restPodMetricsClient{client: s.kubeClient.CoreV1().RESTClient()},
+		s.pod,
+		s.Config.Referees.KubernetesMetrics.GetQueryInterval(),
+		s.Build.Log(),
+	)
+	s.metricsSampler.start()
+}
+
+func (s *executor) stopMetricsSampler() {
+	if s.metricsSampler == nil {
+		return
+	}
+
+	s.metricsSampler.stop()
+	s.metricsSampler = nil
+}
+
+// GetContainersMetrics returns the resources used by the containers of the
+// build pod during the job, for the Kubernetes metrics referee
+func (s *executor) GetContainersMetrics(startTime, endTime time.Time) (map[string]referees.ContainerMetrics, error) {
+	if s.metricsSampler == nil {
+		return nil, errMetricsNotSampled
+	}
+
+	return s.metricsSampler.metrics(startTime, endTime), nil
+}
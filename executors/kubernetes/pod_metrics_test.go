@@ -0,0 +1,122 @@
+//go:build !integration
+// +build !integration
+
+package kubernetes
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	api "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/rest/fake"
+
+	"gitlab.com/gitlab-org/gitlab-runner/referees"
+)
+
+type fakePodMetricsClient struct {
+	metrics []*podMetrics
+}
+
+func (c *fakePodMetricsClient) GetPodMetrics(_ context.Context, _ string, _ string) (*podMetrics, error) {
+	metrics := c.metrics[0]
+	if len(c.metrics) > 1 {
+		c.metrics = c.metrics[1:]
+	}
+
+	return metrics, nil
+}
+
+func testPodMetrics(timestamp time.Time, cpu string, memory string) *podMetrics {
+	return &podMetrics{
+		Timestamp: metav1.NewTime(timestamp),
+		Containers: []containerMetrics{
+			{
+				Name: "build",
+				Usage: api.ResourceList{
+					api.ResourceCPU:    resource.MustParse(cpu),
+					api.ResourceMemory: resource.MustParse(memory),
+				},
+			},
+		},
+	}
+}
+
+func TestPodMetricsSampler(t *testing.T) {
+	start := time.Unix(1600000000, 0)
+
+	client := &fakePodMetricsClient{
+		metrics: []*podMetrics{
+			testPodMetrics(start, "250m", "64Mi"),
+			testPodMetrics(start, "250m", "64Mi"),
+			testPodMetrics(start.Add(10*time.Second), "1500m", "128Mi"),
+			testPodMetrics(start.Add(20*time.Second), "2", "256Mi"),
+		},
+	}
+
+	pod := &api.Pod{ObjectMeta: metav1.ObjectMeta{Name: "test-pod", Namespace: "test-ns"}}
+	sampler := newPodMetricsSampler(client, pod, time.Second, logrus.New())
+	for i := 0; i < 4; i++ {
+		require.NoError(t, sampler.sample(context.Background()))
+	}
+
+	metrics := sampler.metrics(start, start.Add(10*time.Second))
+	assert.Equal(t, map[string]referees.ContainerMetrics{
+		"build": {
+			CPU: []model.SamplePair{
+				{Timestamp: model.TimeFromUnixNano(start.UnixNano()), Value: 0.25},
+				{Timestamp: model.TimeFromUnixNano(start.Add(10 * time.Second).UnixNano()), Value: 1.5},
+			},
+			Memory: []model.SamplePair{
+				{Timestamp: model.TimeFromUnixNano(start.UnixNano()), Value: 64 * 1024 * 1024},
+				{Timestamp: model.TimeFromUnixNano(start.Add(10 * time.Second).UnixNano()), Value: 128 * 1024 * 1024},
+			},
+		},
+	}, metrics)
+}
+
+func TestRestPodMetricsClient(t *testing.T) {
+	version, _ := testVersionAndCodec()
+
+	client := testKubernetesClient(version, fake.CreateHTTPClient(func(req *http.Request) (*http.Response, error) {
+		assert.Equal(t, "/apis/metrics.k8s.io/v1beta1/namespaces/test-ns/pods/test-pod", req.URL.Path)
+
+		body := `{
+			"timestamp": "2020-09-13T12:26:40Z",
+			"containers": [{"name": "build", "usage": {"cpu": "100m", "memory": "10Mi"}}]
+		}`
+
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Header:     http.Header{"Content-Type": []string{"application/json"}},
+			Body:       ioutil.NopCloser(bytes.NewBufferString(body)),
+		}, nil
+	}))
+
+	metricsClient := &restPodMetricsClient{client: client.CoreV1().RESTClient()}
+	metrics, err := metricsClient.GetPodMetrics(context.Background(), "test-ns", "test-pod")
+	require.NoError(t, err)
+
+	assert.Equal(t, time.Date(2020, 9, 13, 12, 26, 40, 0, time.UTC), metrics.Timestamp.UTC())
+	require.Len(t, metrics.Containers, 1)
+	assert.Equal(t, "build", metrics.Containers[0].Name)
+
+	cpu := metrics.Containers[0].Usage[api.ResourceCPU]
+	assert.Equal(t, int64(100), cpu.MilliValue())
+}
+
+func TestGetContainersMetricsNotSampled(t *testing.T) {
+	e := newExecutor()
+
+	_, err := e.GetContainersMetrics(time.Now(), time.Now())
+	assert.ErrorIs(t, err, errMetricsNotSampled)
+}
@@ -0,0 +1,268 @@
+package kubernetes
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	jsonpatch "github.com/evanphx/json-patch"
+	api "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/strategicpatch"
+	"sigs.k8s.io/yaml"
+
+	"gitlab.com/gitlab-org/gitlab-runner/common"
+)
+
+type podSpecFieldNotAllowedError struct {
+	patch  string
+	fields []string
+}
+
+func (e *podSpecFieldNotAllowedError) Error() string {
+	return fmt.Sprintf(
+		"pod spec patch %q changes fields that are not allowed to be overwritten: %s",
+		e.patch,
+		strings.Join(e.fields, ", "),
+	)
+}
+
+func (e *podSpecFieldNotAllowedError) Is(err error) bool {
+	_, ok := err.(*podSpecFieldNotAllowedError)
+	return ok
+}
+
+// applyPodSpecPatches applies the patches of the configuration and then the
+// patch of the job, which is allowed to change only the allowlisted fields
+func (s *executor) applyPodSpecPatches(spec api.PodSpec) (api.PodSpec, error) {
+	var err error
+
+	for i := range s.Config.Kubernetes.PodSpec {
+		patch := &s.Config.Kubernetes.PodSpec[i]
+
+		s.Debugln(fmt.Sprintf("Applying pod spec patch %q", patch.Name))
+		spec, err = patchPodSpec(spec, patch)
+		if err != nil {
+			return api.PodSpec{}, err
+		}
+	}
+
+	patch := s.configurationOverwrites.podSpecPatch
+	if patch == nil {
+		return spec, nil
+	}
+
+	patched, err := patchPodSpec(spec, patch)
+	if err != nil {
+		return api.PodSpec{}, err
+	}
+
+	err = verifyPodSpecChanges(patch.Name, spec, patched, s.Config.Kubernetes.PodSpecOverwriteAllowed)
+	if err != nil {
+		return api.PodSpec{}, err
+	}
+
+	return patched, nil
+}
+
+func patchPodSpec(spec api.PodSpec, podSpec *common.KubernetesPodSpec) (api.PodSpec, error) {
+	patch, patchType, err := podSpec.PatchAndType()
+	if err != nil {
+		return api.PodSpec{}, err
+	}
+
+	// JSON is a subset of YAML, so both formats are accepted
+	patch, err = yaml.YAMLToJSON(patch)
+	if err != nil {
+		return api.PodSpec{}, fmt.Errorf("pod spec patch %q: parsing patch: %w", podSpec.Name, err)
+	}
+
+	original, err := json.Marshal(spec)
+	if err != nil {
+		return api.PodSpec{}, err
+	}
+
+	var patched []byte
+	switch patchType {
+	case common.PatchTypeJSONPatch:
+		var p jsonpatch.Patch
+		p, err = jsonpatch.DecodePatch(patch)
+		if err == nil {
+			patched, err = p.Apply(original)
+		}
+	case common.PatchTypeMergePatch:
+		patched, err = jsonpatch.MergePatch(original, patch)
+	case common.PatchTypeStrategicMergePatch:
+		patched, err = strategicpatch.StrategicMergePatch(original, patch, api.PodSpec{})
+	default:
+		err = fmt.Errorf("unsupported patch type %q", patchType)
+	}
+	if err != nil {
+		return api.PodSpec{}, fmt.Errorf("pod spec patch %q: %w", podSpec.Name, err)
+	}
+
+	var result api.PodSpec
+	err = json.Unmarshal(patched, &result)
+	if err != nil {
+		return api.PodSpec{}, fmt.Errorf("pod spec patch %q: decoding patched pod spec: %w", podSpec.Name, err)
+	}
+
+	return result, nil
+}
+
+// verifyPodSpecChanges returns an error when the patched spec differs from
+// the original one in fields other than the allowed ones. A field is allowed
+// when its path, or the path of one of its parents, is on the list,
+// for example "containers" allows all the changes of the containers, while
+// "containers.env" allows only the changes of their env.
+func verifyPodSpecChanges(name string, original, patched api.PodSpec, allowed []string) error {
+	before, err := podSpecToMap(original)
+	if err != nil {
+		return err
+	}
+
+	after, err := podSpecToMap(patched)
+	if err != nil {
+		return err
+	}
+
+	var notAllowed []string
+	for _, field := range changedFields("", before, after) {
+		if !isFieldAllowed(field, allowed) {
+			notAllowed = append(notAllowed, field)
+		}
+	}
+
+	if len(notAllowed) > 0 {
+		sort.Strings(notAllowed)
+		return &podSpecFieldNotAllowedError{patch: name, fields: notAllowed}
+	}
+
+	return nil
+}
+
+func podSpecToMap(spec api.PodSpec) (map[string]interface{}, error) {
+	data, err := json.Marshal(spec)
+	if err != nil {
+		return nil, err
+	}
+
+	var m map[string]interface{}
+	err = json.Unmarshal(data, &m)
+
+	return m, err
+}
+
+// changedFields returns the paths of the fields that differ in the maps.
+// The items of the lists of named objects, like containers, volumes, or env,
+// are matched by name and compared field by field, so that a change of the
+// env of a container is reported as "containers.env". Adding or removing an
+// item is reported as a change of the list. Other lists are compared as
+// a whole.
+func changedFields(prefix string, before, after map[string]interface{}) []string {
+	keys := make(map[string]bool)
+	for key := range before {
+		keys[key] = true
+	}
+	for key := range after {
+		keys[key] = true
+	}
+
+	var changed []string
+	for key := range keys {
+		path := key
+		if prefix != "" {
+			path = prefix + "." + key
+		}
+
+		changed = append(changed, changedValue(path, before[key], after[key])...)
+	}
+
+	return changed
+}
+
+func changedValue(path string, before, after interface{}) []string {
+	beforeMap, beforeIsMap := before.(map[string]interface{})
+	afterMap, afterIsMap := after.(map[string]interface{})
+	if beforeIsMap && afterIsMap {
+		return changedFields(path, beforeMap, afterMap)
+	}
+
+	beforeItems, beforeIsNamed := namedItems(before)
+	afterItems, afterIsNamed := namedItems(after)
+	if beforeIsNamed && afterIsNamed {
+		return changedItems(path, beforeItems, afterItems)
+	}
+
+	if !reflect.DeepEqual(before, after) {
+		return []string{path}
+	}
+
+	return nil
+}
+
+func changedItems(path string, before, after map[string]map[string]interface{}) []string {
+	if len(before) != len(after) {
+		return []string{path}
+	}
+
+	changed := make(map[string]bool)
+	for name, beforeItem := range before {
+		afterItem, ok := after[name]
+		if !ok {
+			return []string{path}
+		}
+
+		for _, field := range changedFields(path, beforeItem, afterItem) {
+			changed[field] = true
+		}
+	}
+
+	fields := make([]string, 0, len(changed))
+	for field := range changed {
+		fields = append(fields, field)
+	}
+
+	return fields
+}
+
+// namedItems returns the items of the list by their names, when all of them
+// are objects with a unique name. A missing list has no items.
+func namedItems(value interface{}) (map[string]map[string]interface{}, bool) {
+	if value == nil {
+		return map[string]map[string]interface{}{}, true
+	}
+
+	list, ok := value.([]interface{})
+	if !ok {
+		return nil, false
+	}
+
+	items := make(map[string]map[string]interface{}, len(list))
+	for _, element := range list {
+		item, ok := element.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+
+		name, ok := item["name"].(string)
+		if !ok || items[name] != nil {
+			return nil, false
+		}
+
+		items[name] = item
+	}
+
+	return items, true
+}
+
+func isFieldAllowed(field string, allowed []string) bool {
+	for _, a := range allowed {
+		if field == a || strings.HasPrefix(field, a+".") {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,302 @@
+//go:build !integration
+// +build !integration
+
+package kubernetes
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	api "k8s.io/api/core/v1"
+
+	"gitlab.com/gitlab-org/gitlab-runner/common"
+	"gitlab.com/gitlab-org/gitlab-runner/executors"
+)
+
+func testPodSpec() api.PodSpec {
+	return api.PodSpec{
+		RestartPolicy: api.RestartPolicyNever,
+		Containers: []api.Container{
+			{Name: buildContainerName, Image: "alpine"},
+			{Name: helperContainerName, Image: "helper"},
+		},
+	}
+}
+
+func TestPatchPodSpec(t *testing.T) {
+	tests := map[string]struct {
+		patch         common.KubernetesPodSpec
+		assertSpec    func(t *testing.T, spec api.PodSpec)
+		expectedError string
+	}{
+		"strategic merge patch by default": {
+			patch: common.KubernetesPodSpec{
+				Name: "env",
+				Patch: `
+containers:
+- name: build
+  env:
+  - name: FOO
+    value: bar
+priorityClassName: high
+`,
+			},
+			assertSpec: func(t *testing.T, spec api.PodSpec) {
+				require.Len(t, spec.Containers, 2)
+				assert.Equal(t, "alpine", spec.Containers[0].Image)
+				assert.Equal(t, []api.EnvVar{{Name: "FOO", Value: "bar"}}, spec.Containers[0].Env)
+				assert.Equal(t, "high", spec.PriorityClassName)
+			},
+		},
+		"merge patch": {
+			patch: common.KubernetesPodSpec{
+				Name:      "scheduler",
+				Patch:     `{"schedulerName": "custom", "shareProcessNamespace": true}`,
+				PatchType: common.PatchTypeMergePatch,
+			},
+			assertSpec: func(t *testing.T, spec api.PodSpec) {
+				assert.Equal(t, "custom", spec.SchedulerName)
+				require.NotNil(t, spec.ShareProcessNamespace)
+				assert.True(t, *spec.ShareProcessNamespace)
+				assert.Len(t, spec.Containers, 2)
+			},
+		},
+		"json patch": {
+			patch: common.KubernetesPodSpec{
+				Name: "topology",
+				Patch: `[
+					{"op": "add", "path": "/topologySpreadConstraints", "value": [
+						{"maxSkew": 1, "topologyKey": "zone", "whenUnsatisfiable": "DoNotSchedule"}
+					]},
+					{"op": "replace", "path": "/containers/1/image", "value": "custom-helper"}
+				]`,
+				PatchType: common.PatchTypeJSONPatch,
+			},
+			assertSpec: func(t *testing.T, spec api.PodSpec) {
+				require.Len(t, spec.TopologySpreadConstraints, 1)
+				assert.Equal(t, "zone", spec.TopologySpreadConstraints[0].TopologyKey)
+				assert.Equal(t, "custom-helper", spec.Containers[1].Image)
+			},
+		},
+		"invalid json patch": {
+			patch: common.KubernetesPodSpec{
+				Name:      "invalid",
+				Patch:     `[{"op": "remove", "path": "/missing"}]`,
+				PatchType: common.PatchTypeJSONPatch,
+			},
+			expectedError: `pod spec patch "invalid"`,
+		},
+		"unsupported patch type": {
+			patch: common.KubernetesPodSpec{
+				Name:      "unknown",
+				Patch:     `{}`,
+				PatchType: "unknown",
+			},
+			expectedError: `pod spec patch "unknown": unsupported patch type "unknown"`,
+		},
+		"patch and patch path": {
+			patch: common.KubernetesPodSpec{
+				Name:      "both",
+				Patch:     `{}`,
+				PatchPath: "patch.yml",
+			},
+			expectedError: `pod spec patch "both": patch_path and patch can't be used together`,
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			spec, err := patchPodSpec(testPodSpec(), &tt.patch)
+			if tt.expectedError != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.expectedError)
+				return
+			}
+
+			require.NoError(t, err)
+			tt.assertSpec(t, spec)
+		})
+	}
+}
+
+func TestPatchPodSpecFromFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "patch.json")
+	require.NoError(t, ioutil.WriteFile(path, []byte(`{"hostname": "build"}`), 0600))
+
+	spec, err := patchPodSpec(testPodSpec(), &common.KubernetesPodSpec{Name: "file", PatchPath: path})
+	require.NoError(t, err)
+	assert.Equal(t, "build", spec.Hostname)
+}
+
+func TestVerifyPodSpecChanges(t *testing.T) {
+	original := testPodSpec()
+
+	patched := testPodSpec()
+	patched.PriorityClassName = "high"
+	patched.Containers[0].Env = []api.EnvVar{{Name: "FOO", Value: "bar"}}
+
+	assert.NoError(t, verifyPodSpecChanges("patch", original, patched, []string{"priorityClassName", "containers"}))
+
+	err := verifyPodSpecChanges("patch", original, patched, []string{"priorityClassName"})
+	assert.ErrorIs(t, err, new(podSpecFieldNotAllowedError))
+	assert.EqualError(
+		t,
+		err,
+		`pod spec patch "patch" changes fields that are not allowed to be overwritten: containers.env`,
+	)
+
+	privileged := testPodSpec()
+	privileged.HostNetwork = true
+	privileged.SecurityContext = &api.PodSecurityContext{RunAsUser: func(i int64) *int64 { return &i }(0)}
+
+	err = verifyPodSpecChanges("patch", original, privileged, []string{"priorityClassName"})
+	assert.EqualError(
+		t,
+		err,
+		`pod spec patch "patch" changes fields that are not allowed to be overwritten: hostNetwork, securityContext`,
+	)
+}
+
+func TestVerifyPodSpecContainerChanges(t *testing.T) {
+	allowed := []string{"containers.env"}
+
+	tests := map[string]struct {
+		patch         func(spec *api.PodSpec)
+		expectedError string
+	}{
+		"env added to a container": {
+			patch: func(spec *api.PodSpec) {
+				spec.Containers[1].Env = []api.EnvVar{{Name: "FOO", Value: "bar"}}
+			},
+		},
+		"env changed in reordered containers": {
+			patch: func(spec *api.PodSpec) {
+				spec.Containers[0], spec.Containers[1] = spec.Containers[1], spec.Containers[0]
+				spec.Containers[0].Env = []api.EnvVar{{Name: "FOO", Value: "baz"}}
+			},
+		},
+		"image changed": {
+			patch: func(spec *api.PodSpec) {
+				spec.Containers[0].Image = "attacker/image"
+			},
+			expectedError: "containers.image",
+		},
+		"env and privileged security context changed": {
+			patch: func(spec *api.PodSpec) {
+				privileged := true
+				spec.Containers[0].Env = []api.EnvVar{{Name: "FOO", Value: "bar"}}
+				spec.Containers[0].SecurityContext = &api.SecurityContext{Privileged: &privileged}
+			},
+			expectedError: "containers.securityContext",
+		},
+		"container added": {
+			patch: func(spec *api.PodSpec) {
+				spec.Containers = append(spec.Containers, api.Container{Name: "sidecar", Image: "alpine"})
+			},
+			expectedError: "containers",
+		},
+		"container renamed": {
+			patch: func(spec *api.PodSpec) {
+				spec.Containers[1].Name = "other"
+			},
+			expectedError: "containers",
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			original := testPodSpec()
+			original.Containers[1].Env = []api.EnvVar{{Name: "FOO", Value: "foo"}}
+
+			patched := testPodSpec()
+			patched.Containers[1].Env = []api.EnvVar{{Name: "FOO", Value: "foo"}}
+			tt.patch(&patched)
+
+			err := verifyPodSpecChanges("patch", original, patched, allowed)
+			if tt.expectedError == "" {
+				assert.NoError(t, err)
+				return
+			}
+
+			assert.EqualError(
+				t,
+				err,
+				`pod spec patch "patch" changes fields that are not allowed to be overwritten: `+tt.expectedError,
+			)
+		})
+	}
+}
+
+func TestApplyPodSpecPatches(t *testing.T) {
+	tests := map[string]struct {
+		podSpec       []common.KubernetesPodSpec
+		allowed       []string
+		jobPatch      *common.KubernetesPodSpec
+		expectedError error
+		expectedSpec  func(spec *api.PodSpec)
+	}{
+		"no patches": {
+			expectedSpec: func(spec *api.PodSpec) {},
+		},
+		"configuration patches applied in order": {
+			podSpec: []common.KubernetesPodSpec{
+				{Name: "first", Patch: `{"priorityClassName": "low"}`},
+				{Name: "second", Patch: `{"priorityClassName": "high", "schedulerName": "custom"}`},
+			},
+			expectedSpec: func(spec *api.PodSpec) {
+				spec.PriorityClassName = "high"
+				spec.SchedulerName = "custom"
+			},
+		},
+		"job patch with allowed fields": {
+			podSpec: []common.KubernetesPodSpec{
+				{Name: "config", Patch: `{"hostNetwork": true}`},
+			},
+			allowed:  []string{"priorityClassName"},
+			jobPatch: &common.KubernetesPodSpec{Name: PodSpecPatchOverwriteVariableValue, Patch: `{"priorityClassName": "high"}`},
+			expectedSpec: func(spec *api.PodSpec) {
+				spec.HostNetwork = true
+				spec.PriorityClassName = "high"
+			},
+		},
+		"job patch with not allowed fields": {
+			allowed:       []string{"priorityClassName"},
+			jobPatch:      &common.KubernetesPodSpec{Name: PodSpecPatchOverwriteVariableValue, Patch: `{"hostNetwork": true}`},
+			expectedError: new(podSpecFieldNotAllowedError),
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			e := &executor{
+				AbstractExecutor: executors.AbstractExecutor{
+					BuildLogger: stdoutLogger(),
+					Config: common.RunnerConfig{
+						RunnerSettings: common.RunnerSettings{
+							Kubernetes: &common.KubernetesConfig{
+								PodSpec:                 tt.podSpec,
+								PodSpecOverwriteAllowed: tt.allowed,
+							},
+						},
+					},
+				},
+				configurationOverwrites: &overwrites{podSpecPatch: tt.jobPatch},
+			}
+
+			spec, err := e.applyPodSpecPatches(testPodSpec())
+			if tt.expectedError != nil {
+				assert.ErrorIs(t, err, tt.expectedError)
+				return
+			}
+
+			require.NoError(t, err)
+
+			expected := testPodSpec()
+			tt.expectedSpec(&expected)
+			assert.Equal(t, expected, spec)
+		})
+	}
+}
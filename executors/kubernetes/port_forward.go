@@ -0,0 +1,121 @@
+package kubernetes
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+
+	"github.com/sirupsen/logrus"
+	api "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/httpstream"
+	"k8s.io/client-go/tools/portforward"
+	"k8s.io/client-go/transport/spdy"
+
+	"gitlab.com/gitlab-org/gitlab-runner/session/proxy"
+)
+
+var errServicesNotReady = errors.New("services are not ready yet")
+
+// ForwardPort opens a stream to the port of a service container with the
+// port forwarding of the pod, the containers of the pod share its ports
+func (s *executor) ForwardPort(settings *proxy.Settings, port proxy.Port) (io.ReadWriteCloser, error) {
+	if !s.servicesRunning() {
+		return nil, errServicesNotReady
+	}
+
+	config, err := getKubeClientConfig(s.Config.Kubernetes, s.configurationOverwrites)
+	if err != nil {
+		return nil, err
+	}
+
+	transport, upgrader, err := spdy.RoundTripperFor(config)
+	if err != nil {
+		return nil, err
+	}
+
+	u := s.kubeClient.CoreV1().RESTClient().Post().
+		Namespace(s.pod.Namespace).
+		Resource("pods").
+		Name(s.pod.Name).
+		SubResource("portforward").
+		URL()
+
+	dialer := spdy.NewDialer(upgrader, &http.Client{Transport: transport}, http.MethodPost, u)
+
+	conn, _, err := dialer.Dial(portforward.PortForwardProtocolV1Name)
+	if err != nil {
+		return nil, fmt.Errorf("dialing pod port forwarding: %w", err)
+	}
+
+	logger := logrus.WithFields(logrus.Fields{
+		"service": settings.ServiceName,
+		"port":    port.Number,
+	})
+
+	stream, err := newPortForwardStream(conn, port.Number, logger)
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+
+	return stream, nil
+}
+
+// portForwardStream is the data stream forwarded to a port of the pod, with
+// its connection to the Kubernetes API
+type portForwardStream struct {
+	httpstream.Stream
+
+	conn   httpstream.Connection
+	logger *logrus.Entry
+}
+
+func newPortForwardStream(conn httpstream.Connection, port int, logger *logrus.Entry) (*portForwardStream, error) {
+	headers := http.Header{}
+	headers.Set(api.StreamType, api.StreamTypeError)
+	headers.Set(api.PortHeader, strconv.Itoa(port))
+	headers.Set(api.PortForwardRequestIDHeader, "0")
+
+	errorStream, err := conn.CreateStream(headers)
+	if err != nil {
+		return nil, fmt.Errorf("creating port forwarding error stream: %w", err)
+	}
+	// the error stream is only read
+	_ = errorStream.Close()
+
+	headers.Set(api.StreamType, api.StreamTypeData)
+
+	dataStream, err := conn.CreateStream(headers)
+	if err != nil {
+		return nil, fmt.Errorf("creating port forwarding data stream: %w", err)
+	}
+
+	stream := &portForwardStream{
+		Stream: dataStream,
+		conn:   conn,
+		logger: logger,
+	}
+
+	go stream.watchErrors(errorStream)
+
+	return stream, nil
+}
+
+func (s *portForwardStream) watchErrors(errorStream io.Reader) {
+	message, err := ioutil.ReadAll(errorStream)
+	switch {
+	case err != nil:
+		s.logger.WithError(err).Debug("Failed to read the port forwarding errors")
+	case len(message) > 0:
+		s.logger.WithField("error", string(message)).Warning("Port forwarding failed")
+	}
+}
+
+func (s *portForwardStream) Close() error {
+	_ = s.Stream.Close()
+
+	return s.conn.Close()
+}
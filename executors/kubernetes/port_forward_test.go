@@ -0,0 +1,127 @@
+//go:build !integration
+// +build !integration
+
+package kubernetes
+
+import (
+	"bytes"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	api "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/httpstream"
+)
+
+type fakeStream struct {
+	*strings.Reader
+	bytes.Buffer
+
+	headers http.Header
+	closed  bool
+}
+
+func (s *fakeStream) Read(p []byte) (int, error) {
+	return s.Reader.Read(p)
+}
+
+func (s *fakeStream) Write(p []byte) (int, error) {
+	return s.Buffer.Write(p)
+}
+
+func (s *fakeStream) Close() error {
+	s.closed = true
+	return nil
+}
+
+func (s *fakeStream) Reset() error {
+	return nil
+}
+
+func (s *fakeStream) Headers() http.Header {
+	return s.headers
+}
+
+func (s *fakeStream) Identifier() uint32 {
+	return 0
+}
+
+type fakeStreamConnection struct {
+	lock     sync.Mutex
+	messages map[string]string
+	streams  []*fakeStream
+	closed   bool
+}
+
+func (c *fakeStreamConnection) CreateStream(headers http.Header) (httpstream.Stream, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	stream := &fakeStream{
+		Reader:  strings.NewReader(c.messages[headers.Get(api.StreamType)]),
+		headers: headers.Clone(),
+	}
+	c.streams = append(c.streams, stream)
+
+	return stream, nil
+}
+
+func (c *fakeStreamConnection) Close() error {
+	c.closed = true
+	return nil
+}
+
+func (c *fakeStreamConnection) CloseChan() <-chan bool {
+	return nil
+}
+
+func (c *fakeStreamConnection) SetIdleTimeout(timeout time.Duration) {}
+
+func (c *fakeStreamConnection) RemoveStreams(streams ...httpstream.Stream) {}
+
+func TestPortForwardStream(t *testing.T) {
+	conn := &fakeStreamConnection{
+		messages: map[string]string{
+			api.StreamTypeData:  "pong",
+			api.StreamTypeError: "connection refused",
+		},
+	}
+
+	logger, hook := test.NewNullLogger()
+
+	stream, err := newPortForwardStream(conn, 5432, logrus.NewEntry(logger))
+	require.NoError(t, err)
+
+	require.Len(t, conn.streams, 2)
+	for i, streamType := range []string{api.StreamTypeError, api.StreamTypeData} {
+		headers := conn.streams[i].headers
+		assert.Equal(t, streamType, headers.Get(api.StreamType))
+		assert.Equal(t, "5432", headers.Get(api.PortHeader))
+		assert.Equal(t, "0", headers.Get(api.PortForwardRequestIDHeader))
+	}
+	assert.True(t, conn.streams[0].closed, "the error stream is only read")
+
+	_, err = stream.Write([]byte("ping"))
+	require.NoError(t, err)
+	assert.Equal(t, "ping", conn.streams[1].Buffer.String())
+
+	data := make([]byte, 4)
+	_, err = stream.Read(data)
+	require.NoError(t, err)
+	assert.Equal(t, "pong", string(data))
+
+	assert.Eventually(t, func() bool {
+		entry := hook.LastEntry()
+		return entry != nil && entry.Data["error"] == "connection refused"
+	}, time.Second, 10*time.Millisecond)
+
+	require.NoError(t, stream.Close())
+	assert.True(t, conn.streams[1].closed)
+	assert.True(t, conn.closed)
+}
@@ -0,0 +1,366 @@
+package kubernetes
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	api "k8s.io/api/core/v1"
+	kubeerrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes"
+
+	"gitlab.com/gitlab-org/gitlab-runner/common"
+)
+
+const (
+	pvcPoolLabel      = "runner.gitlab.com/pvc-pool"
+	pvcPoolBuildsName = "builds"
+
+	// The lock of a claim is an annotation with the ID of the job using it.
+	// The lock expires, so that a claim locked by an interrupted job is
+	// reused by the next jobs.
+	pvcLockedByAnnotation    = "runner.gitlab.com/locked-by"
+	pvcLockExpiresAnnotation = "runner.gitlab.com/lock-expires"
+	pvcLastUsedAnnotation    = "runner.gitlab.com/last-used"
+
+	// pvcLockMargin extends the lock of a claim beyond the timeout of the job
+	// to cover the preparation and the cleanup of the job
+	pvcLockMargin = 10 * time.Minute
+	// pvcEvictionLockTTL is the duration of the lock taken on a claim while
+	// it's deleted
+	pvcEvictionLockTTL = time.Minute
+	pvcEvictionHolder  = "eviction"
+
+	// pvcMaxConflicts is the number of attempts to update a claim
+	// modified concurrently by other jobs
+	pvcMaxConflicts = 5
+)
+
+type pvcLockedError struct {
+	name   string
+	holder string
+}
+
+func (e *pvcLockedError) Error() string {
+	return fmt.Sprintf("claim %q is locked by job %s", e.name, e.holder)
+}
+
+func (e *pvcLockedError) Is(err error) bool {
+	_, ok := err.(*pvcLockedError)
+	return ok
+}
+
+// pvcPool manages the persistent volume claims of a runner. A claim is
+// locked by a single job at a time and the least recently used claims are
+// deleted when there are too many of them.
+type pvcPool struct {
+	client    kubernetes.Interface
+	namespace string
+	runnerID  string
+	config    *common.KubernetesPVCPool
+	now       func() time.Time
+}
+
+func newPVCPool(
+	client kubernetes.Interface,
+	namespace string,
+	runnerID string,
+	config *common.KubernetesPVCPool,
+) *pvcPool {
+	return &pvcPool{
+		client:    client,
+		namespace: namespace,
+		runnerID:  runnerID,
+		config:    config,
+		now:       time.Now,
+	}
+}
+
+// acquire locks the claim for the job, the claim is created when it doesn't
+// exist. A pvcLockedError is returned when the claim is used by another job.
+func (p *pvcPool) acquire(
+	ctx context.Context,
+	name string,
+	jobID string,
+	ttl time.Duration,
+) (*api.PersistentVolumeClaim, error) {
+	claims := p.client.CoreV1().PersistentVolumeClaims(p.namespace)
+
+	for attempt := 0; attempt < pvcMaxConflicts; attempt++ {
+		pvc, err := claims.Get(ctx, name, metav1.GetOptions{})
+		if kubeerrors.IsNotFound(err) {
+			pvc, err = p.newClaim(name, jobID, ttl)
+			if err != nil {
+				return nil, err
+			}
+
+			pvc, err = claims.Create(ctx, pvc, metav1.CreateOptions{})
+			if kubeerrors.IsAlreadyExists(err) {
+				continue
+			}
+			if err != nil {
+				return nil, fmt.Errorf("creating claim %q: %w", name, err)
+			}
+
+			return pvc, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("getting claim %q: %w", name, err)
+		}
+
+		if holder := p.lockHolder(pvc); holder != "" && holder != jobID {
+			return nil, &pvcLockedError{name: name, holder: holder}
+		}
+
+		p.lock(pvc, jobID, ttl)
+
+		pvc, err = claims.Update(ctx, pvc, metav1.UpdateOptions{})
+		if kubeerrors.IsConflict(err) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("locking claim %q: %w", name, err)
+		}
+
+		return pvc, nil
+	}
+
+	return nil, fmt.Errorf("locking claim %q: too many concurrent updates", name)
+}
+
+// release unlocks the claim, when it's still locked by the job, and marks
+// it as used now
+func (p *pvcPool) release(ctx context.Context, name string, jobID string) error {
+	claims := p.client.CoreV1().PersistentVolumeClaims(p.namespace)
+
+	for attempt := 0; attempt < pvcMaxConflicts; attempt++ {
+		pvc, err := claims.Get(ctx, name, metav1.GetOptions{})
+		if kubeerrors.IsNotFound(err) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("getting claim %q: %w", name, err)
+		}
+
+		if pvc.Annotations[pvcLockedByAnnotation] != jobID {
+			return nil
+		}
+
+		delete(pvc.Annotations, pvcLockedByAnnotation)
+		delete(pvc.Annotations, pvcLockExpiresAnnotation)
+		pvc.Annotations[pvcLastUsedAnnotation] = p.now().UTC().Format(time.RFC3339)
+
+		_, err = claims.Update(ctx, pvc, metav1.UpdateOptions{})
+		if kubeerrors.IsConflict(err) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("unlocking claim %q: %w", name, err)
+		}
+
+		return nil
+	}
+
+	return fmt.Errorf("unlocking claim %q: too many concurrent updates", name)
+}
+
+// evict deletes the least recently used unlocked claims of the runner above
+// the maximal number of claims and returns their names
+func (p *pvcPool) evict(ctx context.Context) ([]string, error) {
+	claims := p.client.CoreV1().PersistentVolumeClaims(p.namespace)
+
+	list, err := claims.List(ctx, metav1.ListOptions{
+		LabelSelector: labels.SelectorFromSet(labels.Set{
+			runnerIDLabel: p.runnerID,
+			pvcPoolLabel:  pvcPoolBuildsName,
+		}).String(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("listing claims: %w", err)
+	}
+
+	excess := len(list.Items) - p.config.GetMaxClaims()
+	if excess <= 0 {
+		return nil, nil
+	}
+
+	var candidates []api.PersistentVolumeClaim
+	for _, pvc := range list.Items {
+		if p.lockHolder(&pvc) == "" {
+			candidates = append(candidates, pvc)
+		}
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return p.lastUsed(&candidates[i]).Before(p.lastUsed(&candidates[j]))
+	})
+
+	var evicted []string
+	var errs []error
+	for i := 0; i < len(candidates) && len(evicted) < excess; i++ {
+		pvc := &candidates[i]
+
+		// The claim is locked before being deleted, so that it isn't
+		// deleted while a job acquires it
+		p.lock(pvc, pvcEvictionHolder, pvcEvictionLockTTL)
+		_, err = claims.Update(ctx, pvc, metav1.UpdateOptions{})
+		if kubeerrors.IsConflict(err) {
+			continue
+		}
+		if err == nil {
+			err = claims.Delete(ctx, pvc.Name, metav1.DeleteOptions{})
+		}
+		if err != nil && !kubeerrors.IsNotFound(err) {
+			errs = append(errs, fmt.Errorf("deleting claim %q: %w", pvc.Name, err))
+			continue
+		}
+
+		evicted = append(evicted, pvc.Name)
+	}
+
+	if len(errs) > 0 {
+		return evicted, errs[0]
+	}
+
+	return evicted, nil
+}
+
+func (p *pvcPool) newClaim(name string, jobID string, ttl time.Duration) (*api.PersistentVolumeClaim, error) {
+	size, err := resource.ParseQuantity(p.config.GetSize())
+	if err != nil {
+		return nil, fmt.Errorf("parsing size %q of claim: %w", p.config.GetSize(), err)
+	}
+
+	pvc := &api.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: p.namespace,
+			Labels: map[string]string{
+				runnerIDLabel: p.runnerID,
+				pvcPoolLabel:  pvcPoolBuildsName,
+			},
+		},
+		Spec: api.PersistentVolumeClaimSpec{
+			AccessModes: []api.PersistentVolumeAccessMode{api.ReadWriteOnce},
+			Resources: api.ResourceRequirements{
+				Requests: api.ResourceList{api.ResourceStorage: size},
+			},
+		},
+	}
+
+	if p.config.StorageClass != "" {
+		pvc.Spec.StorageClassName = &p.config.StorageClass
+	}
+
+	p.lock(pvc, jobID, ttl)
+
+	return pvc, nil
+}
+
+func (p *pvcPool) lock(pvc *api.PersistentVolumeClaim, holder string, ttl time.Duration) {
+	if pvc.Annotations == nil {
+		pvc.Annotations = make(map[string]string)
+	}
+
+	pvc.Annotations[pvcLockedByAnnotation] = holder
+	pvc.Annotations[pvcLockExpiresAnnotation] = p.now().Add(ttl).UTC().Format(time.RFC3339)
+}
+
+// lockHolder returns the holder of the lock of the claim or an empty string
+// when the claim isn't locked or its lock expired
+func (p *pvcPool) lockHolder(pvc *api.PersistentVolumeClaim) string {
+	holder := pvc.Annotations[pvcLockedByAnnotation]
+	if holder == "" {
+		return ""
+	}
+
+	expires, err := time.Parse(time.RFC3339, pvc.Annotations[pvcLockExpiresAnnotation])
+	if err != nil || p.now().After(expires) {
+		return ""
+	}
+
+	return holder
+}
+
+func (p *pvcPool) lastUsed(pvc *api.PersistentVolumeClaim) time.Time {
+	lastUsed, err := time.Parse(time.RFC3339, pvc.Annotations[pvcLastUsedAnnotation])
+	if err != nil {
+		return pvc.CreationTimestamp.Time
+	}
+
+	return lastUsed
+}
+
+func (s *executor) buildsDirPVCPool() *pvcPool {
+	return newPVCPool(
+		s.kubeClient,
+		s.configurationOverwrites.namespace,
+		s.Build.Runner.ShortDescription(),
+		&s.Config.Kubernetes.BuildsDirPVCPool,
+	)
+}
+
+func (s *executor) buildsDirPVCName() string {
+	return s.Build.ProjectUniqueName() + "-builds"
+}
+
+// setupBuildsDirPVC acquires the claim of the project used as the builds
+// directory. When the claim is used by another job, the job falls back to an
+// empty builds directory.
+func (s *executor) setupBuildsDirPVC() error {
+	if !s.Config.Kubernetes.BuildsDirPVCPool.Enabled || s.buildsDirPVC != nil {
+		return nil
+	}
+
+	if !s.isDefaultBuildsDirVolumeRequired() {
+		s.Warningln("A volume is mounted at the builds directory, not using the builds directory claims")
+		return nil
+	}
+
+	// TODO: handle the context properly with https://gitlab.com/gitlab-org/gitlab-runner/-/issues/27932
+	ctx := context.TODO()
+	pool := s.buildsDirPVCPool()
+
+	ttl := s.Build.GetBuildTimeout() + pvcLockMargin
+	pvc, err := pool.acquire(ctx, s.buildsDirPVCName(), jobIDLabelValue(s.Build.ID), ttl)
+	if errors.Is(err, new(pvcLockedError)) {
+		s.Warningln(fmt.Sprintf("Using an empty builds directory: %v", err))
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("acquiring builds directory claim: %w", err)
+	}
+
+	s.buildsDirPVC = pvc
+	s.Println("Using builds directory claim:", pvc.Name)
+
+	evicted, err := pool.evict(ctx)
+	for _, name := range evicted {
+		s.Debugln("Evicted least recently used builds directory claim:", name)
+	}
+	if err != nil {
+		s.Warningln(fmt.Sprintf("Evicting builds directory claims: %v", err))
+	}
+
+	return nil
+}
+
+// cleanupBuildsDirPVC releases the claim of the project for the next jobs
+func (s *executor) cleanupBuildsDirPVC() {
+	if s.buildsDirPVC == nil {
+		return
+	}
+
+	// TODO: handle the context properly with https://gitlab.com/gitlab-org/gitlab-runner/-/issues/27932
+	err := s.buildsDirPVCPool().release(context.TODO(), s.buildsDirPVC.Name, jobIDLabelValue(s.Build.ID))
+	if err != nil {
+		s.Errorln(fmt.Sprintf("Error releasing builds directory claim: %s", err.Error()))
+	}
+
+	s.buildsDirPVC = nil
+}
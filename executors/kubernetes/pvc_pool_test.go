@@ -0,0 +1,188 @@
+//go:build !integration
+// +build !integration
+
+package kubernetes
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	api "k8s.io/api/core/v1"
+	kubeerrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+
+	"gitlab.com/gitlab-org/gitlab-runner/common"
+)
+
+func testPVCPool(client *fake.Clientset, now time.Time, config *common.KubernetesPVCPool) *pvcPool {
+	pool := newPVCPool(client, "default", "runner", config)
+	pool.now = func() time.Time { return now }
+
+	return pool
+}
+
+func getTestPVC(t *testing.T, client *fake.Clientset, name string) *api.PersistentVolumeClaim {
+	pvc, err := client.CoreV1().PersistentVolumeClaims("default").Get(context.Background(), name, metav1.GetOptions{})
+	require.NoError(t, err)
+
+	return pvc
+}
+
+func TestPVCPoolAcquireAndRelease(t *testing.T) {
+	now := time.Now().Round(time.Second)
+	client := fake.NewSimpleClientset()
+	storageClass := "fast"
+	pool := testPVCPool(client, now, &common.KubernetesPVCPool{StorageClass: storageClass, Size: "50Gi"})
+	ctx := context.Background()
+
+	pvc, err := pool.acquire(ctx, "project-builds", "1", time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, "1", pvc.Annotations[pvcLockedByAnnotation])
+	assert.Equal(t, map[string]string{runnerIDLabel: "runner", pvcPoolLabel: pvcPoolBuildsName}, pvc.Labels)
+	assert.Equal(t, []api.PersistentVolumeAccessMode{api.ReadWriteOnce}, pvc.Spec.AccessModes)
+	assert.Equal(t, &storageClass, pvc.Spec.StorageClassName)
+	assert.Equal(t, resource.MustParse("50Gi"), pvc.Spec.Resources.Requests[api.ResourceStorage])
+
+	// the same job can acquire the claim again, for example after retrying the pod
+	_, err = pool.acquire(ctx, "project-builds", "1", time.Hour)
+	require.NoError(t, err)
+
+	_, err = pool.acquire(ctx, "project-builds", "2", time.Hour)
+	assert.ErrorIs(t, err, new(pvcLockedError))
+	assert.EqualError(t, err, `claim "project-builds" is locked by job 1`)
+
+	// only the job holding the lock releases the claim
+	require.NoError(t, pool.release(ctx, "project-builds", "2"))
+	assert.Equal(t, "1", getTestPVC(t, client, "project-builds").Annotations[pvcLockedByAnnotation])
+
+	require.NoError(t, pool.release(ctx, "project-builds", "1"))
+	pvc = getTestPVC(t, client, "project-builds")
+	assert.NotContains(t, pvc.Annotations, pvcLockedByAnnotation)
+	assert.Equal(t, now.UTC().Format(time.RFC3339), pvc.Annotations[pvcLastUsedAnnotation])
+
+	pvc, err = pool.acquire(ctx, "project-builds", "2", time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, "2", pvc.Annotations[pvcLockedByAnnotation])
+
+	assert.NoError(t, pool.release(ctx, "missing", "2"), "deleted claims are ignored")
+}
+
+func TestPVCPoolAcquireExpiredLock(t *testing.T) {
+	now := time.Now()
+	client := fake.NewSimpleClientset()
+	ctx := context.Background()
+
+	_, err := testPVCPool(client, now.Add(-2*time.Hour), &common.KubernetesPVCPool{}).
+		acquire(ctx, "project-builds", "1", time.Hour)
+	require.NoError(t, err)
+
+	pvc, err := testPVCPool(client, now, &common.KubernetesPVCPool{}).
+		acquire(ctx, "project-builds", "2", time.Hour)
+	require.NoError(t, err, "lock of an interrupted job expires")
+	assert.Equal(t, "2", pvc.Annotations[pvcLockedByAnnotation])
+}
+
+func TestPVCPoolAcquireRetriesConflicts(t *testing.T) {
+	client := fake.NewSimpleClientset(&api.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{Name: "project-builds", Namespace: "default"},
+	})
+
+	conflicts := 0
+	client.PrependReactor("update", "persistentvolumeclaims", func(k8stesting.Action) (bool, runtime.Object, error) {
+		if conflicts < 2 {
+			conflicts++
+			gr := schema.GroupResource{Resource: "persistentvolumeclaims"}
+			return true, nil, kubeerrors.NewConflict(gr, "project-builds", nil)
+		}
+		return false, nil, nil
+	})
+
+	pvc, err := testPVCPool(client, time.Now(), &common.KubernetesPVCPool{}).
+		acquire(context.Background(), "project-builds", "1", time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, 2, conflicts)
+	assert.Equal(t, "1", pvc.Annotations[pvcLockedByAnnotation])
+}
+
+func TestPVCPoolAcquireInvalidSize(t *testing.T) {
+	_, err := testPVCPool(fake.NewSimpleClientset(), time.Now(), &common.KubernetesPVCPool{Size: "large"}).
+		acquire(context.Background(), "project-builds", "1", time.Hour)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `parsing size "large" of claim`)
+}
+
+func TestPVCPoolEvict(t *testing.T) {
+	now := time.Now()
+	poolClaim := func(name string, lastUsed time.Time, lockedBy string) *api.PersistentVolumeClaim {
+		pvc := &api.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: "default",
+				Labels:    map[string]string{runnerIDLabel: "runner", pvcPoolLabel: pvcPoolBuildsName},
+				Annotations: map[string]string{
+					pvcLastUsedAnnotation: lastUsed.UTC().Format(time.RFC3339),
+				},
+			},
+		}
+		if lockedBy != "" {
+			pvc.Annotations[pvcLockedByAnnotation] = lockedBy
+			pvc.Annotations[pvcLockExpiresAnnotation] = now.Add(time.Hour).UTC().Format(time.RFC3339)
+		}
+		return pvc
+	}
+
+	client := fake.NewSimpleClientset(
+		poolClaim("oldest-locked", now.Add(-5*time.Hour), "1"),
+		poolClaim("oldest", now.Add(-4*time.Hour), ""),
+		poolClaim("older", now.Add(-3*time.Hour), ""),
+		poolClaim("recent", now.Add(-2*time.Hour), ""),
+		poolClaim("current", now.Add(-time.Hour), "2"),
+		&api.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Name: "other", Namespace: "default"}},
+	)
+
+	pool := testPVCPool(client, now, &common.KubernetesPVCPool{MaxClaims: 3})
+
+	evicted, err := pool.evict(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []string{"oldest", "older"}, evicted)
+
+	list, err := client.CoreV1().PersistentVolumeClaims("default").List(context.Background(), metav1.ListOptions{})
+	require.NoError(t, err)
+
+	var names []string
+	for _, pvc := range list.Items {
+		names = append(names, pvc.Name)
+	}
+	assert.ElementsMatch(t, []string{"oldest-locked", "recent", "current", "other"}, names)
+
+	evicted, err = pool.evict(context.Background())
+	require.NoError(t, err)
+	assert.Empty(t, evicted, "nothing is evicted below the maximal number of claims")
+}
+
+func TestGetVolumesWithBuildsDirPVC(t *testing.T) {
+	e := newExecutor()
+	e.Config.Kubernetes = &common.KubernetesConfig{}
+
+	volumes := e.getVolumes()
+	require.Len(t, volumes, 1)
+	assert.NotNil(t, volumes[0].EmptyDir)
+
+	e.buildsDirPVC = &api.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Name: "project-builds"}}
+	volumes = e.getVolumes()
+	require.Len(t, volumes, 1)
+	assert.Equal(t, "repo", volumes[0].Name)
+	assert.Equal(
+		t,
+		&api.PersistentVolumeClaimVolumeSource{ClaimName: "project-builds"},
+		volumes[0].PersistentVolumeClaim,
+	)
+}
@@ -0,0 +1,427 @@
+package kubernetes
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	kubeerrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+
+	"gitlab.com/gitlab-org/gitlab-runner/common"
+)
+
+const (
+	// runnerIDLabel and jobIDLabel are set on every resource created for a job,
+	// so that the resources left behind by an interrupted job can be found
+	runnerIDLabel = "runner.gitlab.com/id"
+	jobIDLabel    = "runner.gitlab.com/job-id"
+
+	// instanceIDLabel tells apart the resources of the runner processes
+	// sharing the runner token, which don't know the jobs of each other
+	instanceIDLabel = "runner.gitlab.com/instance"
+)
+
+// ReapedResource is a resource deleted by the reaper
+type ReapedResource struct {
+	Kind      string
+	Namespace string
+	Name      string
+	JobID     string
+}
+
+type reaperResourceKind struct {
+	kind   string
+	list   func() (runtime.Object, error)
+	delete func(name string) error
+}
+
+// reaper deletes the resources of a runner whose job isn't running anymore
+type reaper struct {
+	client      kubernetes.Interface
+	runnerID    string
+	instanceID  string
+	gracePeriod time.Duration
+	dryRun      bool
+	isRunning   func(jobID string) bool
+	now         func() time.Time
+	logger      logrus.FieldLogger
+}
+
+func newReaper(client kubernetes.Interface, runnerID string, gracePeriod time.Duration) *reaper {
+	return &reaper{
+		client:      client,
+		runnerID:    runnerID,
+		gracePeriod: gracePeriod,
+		isRunning:   func(string) bool { return false },
+		now:         time.Now,
+		logger:      logrus.WithField("runner", runnerID),
+	}
+}
+
+// reap deletes the orphaned resources of the runner from the namespace and
+// returns them. Resources that can't be listed or deleted are skipped, the
+// first error is returned.
+func (r *reaper) reap(ctx context.Context, namespace string) ([]ReapedResource, error) {
+	return r.reapKinds(namespace, r.resourceKinds(ctx, namespace))
+}
+
+// reapNamespaces deletes the orphaned ephemeral namespaces of the runner
+// together with all the resources in them
+func (r *reaper) reapNamespaces(ctx context.Context) ([]ReapedResource, error) {
+	namespaces := r.client.CoreV1().Namespaces()
+
+	return r.reapKinds("", []reaperResourceKind{
+		{
+			kind: "namespace",
+			list: func() (runtime.Object, error) { return namespaces.List(ctx, r.listOptions()) },
+			delete: func(name string) error {
+				return namespaces.Delete(ctx, name, metav1.DeleteOptions{PropagationPolicy: &PropagationPolicy})
+			},
+		},
+	})
+}
+
+func (r *reaper) reapKinds(namespace string, kinds []reaperResourceKind) ([]ReapedResource, error) {
+	var reaped []ReapedResource
+	var firstErr error
+	setErr := func(err error) {
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	for _, kind := range kinds {
+		list, err := kind.list()
+		if err != nil {
+			setErr(fmt.Errorf("listing %ss in %q: %w", kind.kind, namespace, err))
+			continue
+		}
+
+		items, err := meta.ExtractList(list)
+		if err != nil {
+			setErr(fmt.Errorf("listing %ss in %q: %w", kind.kind, namespace, err))
+			continue
+		}
+
+		for _, item := range items {
+			object, err := meta.Accessor(item)
+			if err != nil || !r.isOrphaned(object) {
+				continue
+			}
+
+			resource := ReapedResource{
+				Kind:      kind.kind,
+				Namespace: namespace,
+				Name:      object.GetName(),
+				JobID:     object.GetLabels()[jobIDLabel],
+			}
+
+			logger := r.logger.WithFields(logrus.Fields{
+				"kind":      resource.Kind,
+				"namespace": resource.Namespace,
+				"name":      resource.Name,
+				"job":       resource.JobID,
+			})
+
+			if r.dryRun {
+				logger.Infoln("Found orphaned resource")
+				reaped = append(reaped, resource)
+				continue
+			}
+
+			err = kind.delete(resource.Name)
+			if err != nil && !kubeerrors.IsNotFound(err) {
+				logger.WithError(err).Warningln("Failed to delete orphaned resource")
+				setErr(fmt.Errorf("deleting %s %q: %w", kind.kind, resource.Name, err))
+				continue
+			}
+
+			logger.Infoln("Deleted orphaned resource")
+			reaped = append(reaped, resource)
+		}
+	}
+
+	return reaped, firstErr
+}
+
+// resourceKinds lists the kinds of the resources created for a job. Pods are
+// deleted first, so that their services are deleted together with them.
+func (r *reaper) resourceKinds(ctx context.Context, namespace string) []reaperResourceKind {
+	core := r.client.CoreV1()
+	listOpts := r.listOptions()
+
+	return []reaperResourceKind{
+		{
+			kind: "pod",
+			list: func() (runtime.Object, error) { return core.Pods(namespace).List(ctx, listOpts) },
+			delete: func(name string) error {
+				return core.Pods(namespace).Delete(ctx, name, metav1.DeleteOptions{PropagationPolicy: &PropagationPolicy})
+			},
+		},
+		{
+			kind: "service",
+			list: func() (runtime.Object, error) { return core.Services(namespace).List(ctx, listOpts) },
+			delete: func(name string) error {
+				return core.Services(namespace).Delete(ctx, name, metav1.DeleteOptions{})
+			},
+		},
+		{
+			kind: "secret",
+			list: func() (runtime.Object, error) { return core.Secrets(namespace).List(ctx, listOpts) },
+			delete: func(name string) error {
+				return core.Secrets(namespace).Delete(ctx, name, metav1.DeleteOptions{})
+			},
+		},
+		{
+			kind: "configmap",
+			list: func() (runtime.Object, error) { return core.ConfigMaps(namespace).List(ctx, listOpts) },
+			delete: func(name string) error {
+				return core.ConfigMaps(namespace).Delete(ctx, name, metav1.DeleteOptions{})
+			},
+		},
+	}
+}
+
+// listOptions selects the resources of the runner, and of the runner process
+// when the instance ID is set
+func (r *reaper) listOptions() metav1.ListOptions {
+	set := labels.Set{runnerIDLabel: r.runnerID}
+	if r.instanceID != "" {
+		set[instanceIDLabel] = r.instanceID
+	}
+
+	return metav1.ListOptions{
+		LabelSelector: labels.SelectorFromSet(set).String(),
+	}
+}
+
+func (r *reaper) isOrphaned(object metav1.Object) bool {
+	if r.isRunning(object.GetLabels()[jobIDLabel]) {
+		return false
+	}
+
+	return r.now().Sub(object.GetCreationTimestamp().Time) >= r.gracePeriod
+}
+
+// runnerReaper tracks the jobs running on a runner and periodically reaps
+// the resources left behind by the jobs that aren't running anymore
+type runnerReaper struct {
+	lock       sync.Mutex
+	config     *common.RunnerConfig
+	jobs       map[string]bool
+	namespaces map[string]bool
+	running    bool
+}
+
+var (
+	runnerReapers     = map[string]*runnerReaper{}
+	runnerReapersLock sync.Mutex
+)
+
+func runnerReaperFor(runnerID string) *runnerReaper {
+	runnerReapersLock.Lock()
+	defer runnerReapersLock.Unlock()
+
+	r := runnerReapers[runnerID]
+	if r == nil {
+		r = &runnerReaper{
+			jobs:       make(map[string]bool),
+			namespaces: make(map[string]bool),
+		}
+		runnerReapers[runnerID] = r
+	}
+
+	return r
+}
+
+func (r *runnerReaper) addJob(jobID, namespace string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.jobs[jobID] = true
+	if namespace != "" {
+		r.namespaces[namespace] = true
+	}
+}
+
+func (r *runnerReaper) removeJob(jobID string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	delete(r.jobs, jobID)
+}
+
+func (r *runnerReaper) isRunning(jobID string) bool {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	return r.jobs[jobID]
+}
+
+// ensureRunning updates the configuration used by the loop and starts the
+// loop when the reaper is enabled and the loop isn't running yet
+func (r *runnerReaper) ensureRunning(config *common.RunnerConfig) {
+	if config.Kubernetes == nil {
+		return
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.config = config
+	if r.running || config.Kubernetes.Reaper.GetInterval() <= 0 {
+		return
+	}
+
+	r.running = true
+	go r.loop()
+}
+
+// settings returns the current configuration and the namespaces to reap
+// or nil when the reaper was disabled, in which case the loop stops
+func (r *runnerReaper) settings() (*common.RunnerConfig, []string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if r.config.Kubernetes.Reaper.GetInterval() <= 0 {
+		r.running = false
+		return nil, nil
+	}
+
+	configured := configuredNamespace(r.config.Kubernetes)
+	namespaces := []string{configured}
+	for namespace := range r.namespaces {
+		if namespace != configured {
+			namespaces = append(namespaces, namespace)
+		}
+	}
+	sort.Strings(namespaces[1:])
+
+	return r.config, namespaces
+}
+
+func (r *runnerReaper) loop() {
+	for {
+		config, namespaces := r.settings()
+		if config == nil {
+			return
+		}
+
+		r.reapOnce(config, namespaces)
+		time.Sleep(config.Kubernetes.Reaper.GetInterval())
+	}
+}
+
+func (r *runnerReaper) reapOnce(config *common.RunnerConfig, namespaces []string) {
+	logger := logrus.WithField("runner", config.ShortDescription())
+
+	kubeConfig, err := getKubeClientConfig(config.Kubernetes, new(overwrites))
+	if err != nil {
+		logger.WithError(err).Warningln("Failed to configure the Kubernetes client of the reaper")
+		return
+	}
+
+	client, err := kubernetes.NewForConfig(kubeConfig)
+	if err != nil {
+		logger.WithError(err).Warningln("Failed to connect the reaper to Kubernetes")
+		return
+	}
+	defer closeKubeClient(client)
+
+	// Only the jobs of this process are known, the resources of the other
+	// processes sharing the runner token are left to them
+	rp := newReaper(client, config.ShortDescription(), config.Kubernetes.Reaper.GetGracePeriod())
+	rp.instanceID = instanceIDLabelValue(config.Kubernetes)
+	rp.isRunning = r.isRunning
+
+	ctx, cancel := context.WithTimeout(context.Background(), config.Kubernetes.Reaper.GetInterval())
+	defer cancel()
+
+	_, err = rp.reapRunner(ctx, config.Kubernetes, namespaces)
+	if err != nil {
+		logger.WithError(err).Warningln("Failed to reap orphaned resources")
+	}
+}
+
+// CleanupOrphanedResources deletes the resources of the runner that are older
+// than the grace period from the configured namespace. When the instance ID is
+// set, only the resources of that runner process are deleted. It's meant to be
+// used while the runner isn't running, as all the jobs are considered finished.
+func CleanupOrphanedResources(
+	ctx context.Context,
+	config *common.RunnerConfig,
+	gracePeriod time.Duration,
+	instanceID string,
+	dryRun bool,
+) ([]ReapedResource, error) {
+	if config.Kubernetes == nil {
+		return nil, fmt.Errorf("runner %q doesn't use the Kubernetes executor", config.ShortDescription())
+	}
+
+	kubeConfig, err := getKubeClientConfig(config.Kubernetes, new(overwrites))
+	if err != nil {
+		return nil, fmt.Errorf("getting Kubernetes config: %w", err)
+	}
+
+	client, err := kubernetes.NewForConfig(kubeConfig)
+	if err != nil {
+		return nil, fmt.Errorf("connecting to Kubernetes: %w", err)
+	}
+	defer closeKubeClient(client)
+
+	rp := newReaper(client, config.ShortDescription(), gracePeriod)
+	rp.instanceID = sanitizeLabel(instanceID)
+	rp.dryRun = dryRun
+
+	return rp.reapRunner(ctx, config.Kubernetes, []string{configuredNamespace(config.Kubernetes)})
+}
+
+// reapRunner reaps the namespaces and, when the jobs run in ephemeral
+// namespaces, the orphaned ephemeral namespaces
+func (r *reaper) reapRunner(
+	ctx context.Context,
+	config *common.KubernetesConfig,
+	namespaces []string,
+) ([]ReapedResource, error) {
+	var reaped []ReapedResource
+	var firstErr error
+
+	if config.EphemeralNamespace.Enabled {
+		reaped, firstErr = r.reapNamespaces(ctx)
+	}
+
+	for _, namespace := range namespaces {
+		resources, err := r.reap(ctx, namespace)
+		reaped = append(reaped, resources...)
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	return reaped, firstErr
+}
+
+func configuredNamespace(config *common.KubernetesConfig) string {
+	if config.Namespace == "" {
+		return "default"
+	}
+
+	return config.Namespace
+}
+
+func jobIDLabelValue(id int64) string {
+	return strconv.FormatInt(id, 10)
+}
+
+func instanceIDLabelValue(config *common.KubernetesConfig) string {
+	return sanitizeLabel(config.Reaper.GetInstanceID())
+}
@@ -0,0 +1,190 @@
+//go:build !integration
+// +build !integration
+
+package kubernetes
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	api "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+
+	"gitlab.com/gitlab-org/gitlab-runner/common"
+)
+
+func reaperObjectMeta(name, runnerID, jobID string, created time.Time) metav1.ObjectMeta {
+	return metav1.ObjectMeta{
+		Name:              name,
+		Namespace:         "default",
+		CreationTimestamp: metav1.NewTime(created),
+		Labels: map[string]string{
+			runnerIDLabel: runnerID,
+			jobIDLabel:    jobID,
+		},
+	}
+}
+
+func TestReaperReap(t *testing.T) {
+	now := time.Now()
+	old := now.Add(-2 * time.Hour)
+
+	client := fake.NewSimpleClientset(
+		&api.Pod{ObjectMeta: reaperObjectMeta("orphaned-pod", "runner", "1", old)},
+		&api.Pod{ObjectMeta: reaperObjectMeta("running-pod", "runner", "2", old)},
+		&api.Pod{ObjectMeta: reaperObjectMeta("recent-pod", "runner", "3", now.Add(-time.Minute))},
+		&api.Pod{ObjectMeta: reaperObjectMeta("other-runner-pod", "other", "1", old)},
+		&api.Pod{ObjectMeta: metav1.ObjectMeta{Name: "unlabeled-pod", Namespace: "default"}},
+		&api.Secret{ObjectMeta: reaperObjectMeta("orphaned-secret", "runner", "1", old)},
+		&api.ConfigMap{ObjectMeta: reaperObjectMeta("orphaned-configmap", "runner", "1", old)},
+		&api.Service{ObjectMeta: reaperObjectMeta("orphaned-service", "runner", "1", old)},
+		&api.ConfigMap{ObjectMeta: reaperObjectMeta("running-configmap", "runner", "2", old)},
+	)
+
+	r := newReaper(client, "runner", time.Hour)
+	r.now = func() time.Time { return now }
+	r.isRunning = func(jobID string) bool { return jobID == "2" }
+
+	reaped, err := r.reap(context.Background(), "default")
+	require.NoError(t, err)
+	assert.Equal(t, []ReapedResource{
+		{Kind: "pod", Namespace: "default", Name: "orphaned-pod", JobID: "1"},
+		{Kind: "service", Namespace: "default", Name: "orphaned-service", JobID: "1"},
+		{Kind: "secret", Namespace: "default", Name: "orphaned-secret", JobID: "1"},
+		{Kind: "configmap", Namespace: "default", Name: "orphaned-configmap", JobID: "1"},
+	}, reaped)
+
+	pods, err := client.CoreV1().Pods("default").List(context.Background(), metav1.ListOptions{})
+	require.NoError(t, err)
+
+	var names []string
+	for _, pod := range pods.Items {
+		names = append(names, pod.Name)
+	}
+	assert.ElementsMatch(t, []string{"running-pod", "recent-pod", "other-runner-pod", "unlabeled-pod"}, names)
+
+	_, err = client.CoreV1().ConfigMaps("default").Get(context.Background(), "running-configmap", metav1.GetOptions{})
+	assert.NoError(t, err)
+}
+
+func TestReaperReapInstance(t *testing.T) {
+	old := time.Now().Add(-2 * time.Hour)
+
+	instancePod := func(name, instanceID string) *api.Pod {
+		meta := reaperObjectMeta(name, "runner", "1", old)
+		meta.Labels[instanceIDLabel] = instanceID
+
+		return &api.Pod{ObjectMeta: meta}
+	}
+
+	client := fake.NewSimpleClientset(
+		instancePod("own-pod", "runner-1"),
+		instancePod("replica-pod", "runner-2"),
+		&api.Pod{ObjectMeta: reaperObjectMeta("unlabeled-instance-pod", "runner", "1", old)},
+	)
+
+	// the job 1 of the replica isn't known to this process
+	r := newReaper(client, "runner", time.Hour)
+	r.instanceID = "runner-1"
+
+	reaped, err := r.reap(context.Background(), "default")
+	require.NoError(t, err)
+	assert.Equal(t, []ReapedResource{
+		{Kind: "pod", Namespace: "default", Name: "own-pod", JobID: "1"},
+	}, reaped)
+
+	pods, err := client.CoreV1().Pods("default").List(context.Background(), metav1.ListOptions{})
+	require.NoError(t, err)
+	require.Len(t, pods.Items, 2)
+}
+
+func TestInstanceIDLabelValue(t *testing.T) {
+	hostname, err := os.Hostname()
+	require.NoError(t, err)
+
+	config := &common.KubernetesConfig{}
+	assert.Equal(t, sanitizeLabel(hostname), instanceIDLabelValue(config))
+
+	config.Reaper.InstanceID = "runner 1"
+	assert.Equal(t, "runner_1", instanceIDLabelValue(config))
+}
+
+func TestReaperReapDryRun(t *testing.T) {
+	client := fake.NewSimpleClientset(
+		&api.Pod{ObjectMeta: reaperObjectMeta("orphaned-pod", "runner", "1", time.Now().Add(-2*time.Hour))},
+	)
+
+	r := newReaper(client, "runner", time.Hour)
+	r.dryRun = true
+
+	reaped, err := r.reap(context.Background(), "default")
+	require.NoError(t, err)
+	assert.Len(t, reaped, 1)
+
+	_, err = client.CoreV1().Pods("default").Get(context.Background(), "orphaned-pod", metav1.GetOptions{})
+	assert.NoError(t, err)
+}
+
+func TestReaperReapErrors(t *testing.T) {
+	old := time.Now().Add(-2 * time.Hour)
+
+	client := fake.NewSimpleClientset(
+		&api.Pod{ObjectMeta: reaperObjectMeta("orphaned-pod", "runner", "1", old)},
+		&api.ConfigMap{ObjectMeta: reaperObjectMeta("orphaned-configmap", "runner", "1", old)},
+	)
+	client.PrependReactor("list", "secrets", func(k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, errors.New("forbidden")
+	})
+	client.PrependReactor("delete", "pods", func(k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, errors.New("conflict")
+	})
+
+	reaped, err := newReaper(client, "runner", time.Hour).reap(context.Background(), "default")
+	assert.EqualError(t, err, `deleting pod "orphaned-pod": conflict`)
+	assert.Equal(t, []ReapedResource{
+		{Kind: "configmap", Namespace: "default", Name: "orphaned-configmap", JobID: "1"},
+	}, reaped, "other resources are reaped despite the errors")
+}
+
+func TestRunnerReaper(t *testing.T) {
+	r := runnerReaperFor("reaper-test")
+	assert.Same(t, r, runnerReaperFor("reaper-test"))
+
+	r.addJob("1", "jobs")
+	assert.True(t, r.isRunning("1"))
+	r.removeJob("1")
+	assert.False(t, r.isRunning("1"))
+
+	config := &common.RunnerConfig{
+		RunnerSettings: common.RunnerSettings{
+			Kubernetes: &common.KubernetesConfig{Namespace: "default"},
+		},
+	}
+
+	// the loop isn't started while the reaper is disabled
+	r.ensureRunning(config)
+	r.lock.Lock()
+	assert.False(t, r.running)
+	r.lock.Unlock()
+
+	config.Kubernetes.Reaper.Interval = 60
+	r.lock.Lock()
+	r.config = config
+	r.lock.Unlock()
+
+	cfg, namespaces := r.settings()
+	assert.Equal(t, config, cfg)
+	assert.Equal(t, []string{"default", "jobs"}, namespaces, "namespaces of the jobs are reaped too")
+
+	config.Kubernetes.Reaper.Interval = 0
+	cfg, _ = r.settings()
+	assert.Nil(t, cfg, "the loop stops when the reaper is disabled")
+}
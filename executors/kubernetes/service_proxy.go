@@ -28,6 +28,7 @@ func (s *executor) newProxy(serviceName string, ports []proxy.Port) *proxy.Proxy
 	return &proxy.Proxy{
 		Settings:          proxy.NewProxySettings(serviceName, ports),
 		ConnectionHandler: s,
+		PortForwarder:     s,
 	}
 }
 
@@ -0,0 +1,229 @@
+package kubernetes
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	api "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+
+	"gitlab.com/gitlab-org/gitlab-runner/common"
+)
+
+// serviceLogsTailLines is the number of lines of the logs of a service
+// printed when it isn't ready
+const serviceLogsTailLines = 20
+
+// serviceNotReadyError is returned when a service of the job failed before
+// being ready or wasn't ready before the poll timeout
+type serviceNotReadyError struct {
+	service   string
+	container string
+	reason    string
+}
+
+func (e *serviceNotReadyError) Error() string {
+	return fmt.Sprintf("service %q (container %s) isn't ready: %s", e.service, e.container, e.reason)
+}
+
+func (e *serviceNotReadyError) Is(err error) bool {
+	_, ok := err.(*serviceNotReadyError)
+	return ok
+}
+
+func hasServiceReadinessProbe(s *common.Service) bool {
+	return s.ReadinessProbe != nil
+}
+
+// serviceReadinessProbe returns the readiness probe of the service, set by
+// the service of the configuration with the same alias or name
+func (s *executor) serviceReadinessProbe(service common.Image) *api.Probe {
+	settings := findServiceSettings(s.Config.Kubernetes.Services, service, hasServiceReadinessProbe)
+	if settings == nil {
+		return nil
+	}
+
+	return settings.ReadinessProbe.ToKubernetesProbe()
+}
+
+// gatedServices returns the names of the services with a readiness probe, by
+// name of their container
+func (s *executor) gatedServices() map[string]string {
+	services := make(map[string]string)
+	for i, service := range s.options.Services {
+		if s.serviceReadinessProbe(service) != nil {
+			services[serviceContainerName(i)] = serviceName(service)
+		}
+	}
+
+	return services
+}
+
+func serviceContainerName(index int) string {
+	return fmt.Sprintf("svc-%d", index)
+}
+
+// waitForServicesReady waits for the services with a readiness probe to be
+// ready, so that the script of the job doesn't race against them. When a
+// service isn't ready, the end of its logs is printed.
+func (s *executor) waitForServicesReady(ctx context.Context) error {
+	services := s.gatedServices()
+	if len(services) == 0 {
+		return nil
+	}
+
+	err := waitForServicesReady(ctx, s.kubeClient, s.pod, services, s.Trace, s.Config.Kubernetes)
+	if err == nil {
+		s.Println("All services are ready")
+		return nil
+	}
+
+	var notReady *serviceNotReadyError
+	if errors.As(err, &notReady) {
+		s.Errorln(fmt.Sprintf("Service %s isn't ready, the last lines of its logs are:", notReady.service))
+		printContainerLogs(ctx, s.kubeClient, s.pod, notReady.container, s.Trace)
+	}
+
+	return err
+}
+
+// waitForServicesReady polls the pod until the containers of the services
+// are ready. It fails as soon as a container of a service terminates.
+func waitForServicesReady(
+	ctx context.Context,
+	client kubernetes.Interface,
+	pod *api.Pod,
+	services map[string]string,
+	out io.Writer,
+	config *common.KubernetesConfig,
+) error {
+	interval := time.Duration(config.GetPollInterval()) * time.Second
+
+	for attempt := config.GetPollAttempts(); ; attempt-- {
+		current, err := client.CoreV1().Pods(pod.Namespace).Get(ctx, pod.Name, metav1.GetOptions{})
+		if err != nil {
+			return fmt.Errorf("getting pod: %w", err)
+		}
+
+		pending, err := pendingServices(current, services)
+		if err != nil || len(pending) == 0 {
+			return err
+		}
+
+		if attempt <= 1 {
+			container := pending[0]
+			return &serviceNotReadyError{
+				service:   services[container],
+				container: container,
+				reason:    "readiness probe didn't succeed before the poll timeout",
+			}
+		}
+
+		names := make([]string, 0, len(pending))
+		for _, container := range pending {
+			names = append(names, services[container])
+		}
+		_, _ = fmt.Fprintf(out, "Waiting for services to be ready: %s\n", strings.Join(names, ", "))
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(interval):
+		}
+	}
+}
+
+// pendingServices returns the containers of the services which aren't ready
+// yet, sorted by name
+func pendingServices(pod *api.Pod, services map[string]string) ([]string, error) {
+	if pod.Status.Phase == api.PodFailed || pod.Status.Phase == api.PodSucceeded {
+		return nil, fmt.Errorf("pod status is %s", strings.ToLower(string(pod.Status.Phase)))
+	}
+
+	statuses := make(map[string]api.ContainerStatus)
+	for _, status := range pod.Status.ContainerStatuses {
+		statuses[status.Name] = status
+	}
+
+	containers := make([]string, 0, len(services))
+	for container := range services {
+		containers = append(containers, container)
+	}
+	sort.Strings(containers)
+
+	var pending []string
+	for _, container := range containers {
+		service := services[container]
+		status := statuses[container]
+
+		if terminated := status.State.Terminated; terminated != nil {
+			return nil, &serviceNotReadyError{
+				service:   service,
+				container: container,
+				reason: fmt.Sprintf(
+					"container terminated with exit code %d (%s)",
+					terminated.ExitCode,
+					terminated.Reason,
+				),
+			}
+		}
+
+		if !status.Ready {
+			pending = append(pending, container)
+		}
+	}
+
+	return pending, nil
+}
+
+func printContainerLogs(
+	ctx context.Context,
+	client kubernetes.Interface,
+	pod *api.Pod,
+	container string,
+	out io.Writer,
+) {
+	tailLines := int64(serviceLogsTailLines)
+
+	logs, err := client.CoreV1().Pods(pod.Namespace).GetLogs(pod.Name, &api.PodLogOptions{
+		Container: container,
+		TailLines: &tailLines,
+	}).Stream(ctx)
+	if err != nil {
+		_, _ = fmt.Fprintf(out, "Couldn't get the logs of the container %s: %v\n", container, err)
+		return
+	}
+	defer func() { _ = logs.Close() }()
+
+	_, _ = io.Copy(out, logs)
+	_, _ = fmt.Fprintln(out)
+}
+
+// waitForServicesReadyLegacy waits for the pod to be running before waiting
+// for the services, so that the pod failures are handled like with the attach
+// strategy
+func (s *executor) waitForServicesReadyLegacy() error {
+	if len(s.gatedServices()) == 0 {
+		return nil
+	}
+
+	// TODO: handle the context properly with https://gitlab.com/gitlab-org/gitlab-runner/-/issues/27932
+	ctx := context.TODO()
+
+	status, err := s.waitForPodRunning(ctx)
+	if err != nil {
+		return err
+	}
+
+	if status != api.PodRunning {
+		return fmt.Errorf("pod failed to enter running state: %s", status)
+	}
+
+	return s.waitForServicesReady(ctx)
+}
@@ -0,0 +1,145 @@
+//go:build !integration
+// +build !integration
+
+package kubernetes
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	api "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"gitlab.com/gitlab-org/gitlab-runner/common"
+)
+
+func testServicesPod(statuses ...api.ContainerStatus) *api.Pod {
+	return &api.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-pod", Namespace: "test-ns"},
+		Status: api.PodStatus{
+			Phase:             api.PodRunning,
+			ContainerStatuses: statuses,
+		},
+	}
+}
+
+func TestWaitForServicesReady(t *testing.T) {
+	services := map[string]string{"svc-0": "cache", "svc-1": "search"}
+	config := &common.KubernetesConfig{PollInterval: 1, PollTimeout: 1}
+
+	tests := map[string]struct {
+		pod           *api.Pod
+		expectedError string
+	}{
+		"ready": {
+			pod: testServicesPod(
+				api.ContainerStatus{Name: "build", Ready: true},
+				api.ContainerStatus{Name: "svc-0", Ready: true},
+				api.ContainerStatus{Name: "svc-1", Ready: true},
+			),
+		},
+		"not ready": {
+			pod: testServicesPod(
+				api.ContainerStatus{Name: "svc-0", Ready: true},
+				api.ContainerStatus{Name: "svc-1"},
+			),
+			expectedError: `service "search" (container svc-1) isn't ready: ` +
+				"readiness probe didn't succeed before the poll timeout",
+		},
+		"terminated": {
+			pod: testServicesPod(
+				api.ContainerStatus{Name: "svc-0", State: api.ContainerState{
+					Terminated: &api.ContainerStateTerminated{ExitCode: 137, Reason: "OOMKilled"},
+				}},
+				api.ContainerStatus{Name: "svc-1"},
+			),
+			expectedError: `service "cache" (container svc-0) isn't ready: ` +
+				"container terminated with exit code 137 (OOMKilled)",
+		},
+		"pod failed": {
+			pod: func() *api.Pod {
+				pod := testServicesPod()
+				pod.Status.Phase = api.PodFailed
+				return pod
+			}(),
+			expectedError: "pod status is failed",
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			out := new(bytes.Buffer)
+			err := waitForServicesReady(context.Background(), fake.NewSimpleClientset(tt.pod), tt.pod, services, out, config)
+			if tt.expectedError == "" {
+				assert.NoError(t, err)
+				assert.Empty(t, out.String())
+				return
+			}
+
+			assert.EqualError(t, err, tt.expectedError)
+		})
+	}
+}
+
+func TestWaitForServicesReadyPollsPendingServices(t *testing.T) {
+	pod := testServicesPod(api.ContainerStatus{Name: "svc-0"}, api.ContainerStatus{Name: "svc-1"})
+	config := &common.KubernetesConfig{PollInterval: 1, PollTimeout: 2}
+
+	out := new(bytes.Buffer)
+	err := waitForServicesReady(
+		context.Background(),
+		fake.NewSimpleClientset(pod),
+		pod,
+		map[string]string{"svc-0": "cache", "svc-1": "search"},
+		out,
+		config,
+	)
+	assert.ErrorIs(t, err, new(serviceNotReadyError))
+	assert.Equal(t, "Waiting for services to be ready: cache, search\n", out.String())
+}
+
+func TestExecutorServiceReadinessProbes(t *testing.T) {
+	probe := &common.KubernetesServiceReadinessProbe{
+		TCPSocket:     &common.KubernetesLifecycleTCPSocket{Port: 6379},
+		PeriodSeconds: 2,
+	}
+
+	e := newExecutor()
+	e.Config.Kubernetes = &common.KubernetesConfig{
+		Services: []common.Service{
+			{Name: "redis:alpine", Alias: "cache", ReadinessProbe: probe},
+			{Alias: "search", ReadinessProbe: &common.KubernetesServiceReadinessProbe{
+				HTTPGet: &common.KubernetesLifecycleHTTPGet{Path: "/_cluster/health", Port: 9200},
+			}},
+		},
+	}
+	e.options = &kubernetesOptions{
+		Services: common.Services{
+			{Name: "redis:alpine", Alias: "cache"},
+			{Name: "postgres:14"},
+			{Name: "elasticsearch:8", Alias: "search"},
+		},
+	}
+
+	assert.Equal(t, map[string]string{"svc-0": "cache", "svc-2": "search"}, e.gatedServices())
+
+	readinessProbe := e.serviceReadinessProbe(e.options.Services[0])
+	require.NotNil(t, readinessProbe)
+	require.NotNil(t, readinessProbe.TCPSocket)
+	assert.Equal(t, 6379, readinessProbe.TCPSocket.Port.IntValue())
+	assert.Equal(t, int32(2), readinessProbe.PeriodSeconds)
+
+	assert.Nil(t, e.serviceReadinessProbe(e.options.Services[1]))
+}
+
+func TestPrintContainerLogs(t *testing.T) {
+	pod := testServicesPod()
+
+	out := new(bytes.Buffer)
+	printContainerLogs(context.Background(), fake.NewSimpleClientset(pod), pod, "svc-0", out)
+	assert.Equal(t, "fake logs\n", out.String())
+}
@@ -142,7 +142,7 @@ type podPhaseResponse struct {
 	err   error
 }
 
-func getPodPhase(c *kubernetes.Clientset, pod *api.Pod, out io.Writer) podPhaseResponse {
+func getPodPhase(c kubernetes.Interface, pod *api.Pod, out io.Writer) podPhaseResponse {
 	// TODO: handle the context properly with https://gitlab.com/gitlab-org/gitlab-runner/-/issues/27932
 	pod, err := c.CoreV1().Pods(pod.Namespace).Get(context.TODO(), pod.Name, metav1.GetOptions{})
 	if err != nil {
@@ -168,7 +168,7 @@ func getPodPhase(c *kubernetes.Clientset, pod *api.Pod, out io.Writer) podPhaseR
 		case "InvalidImageName":
 			err = &common.BuildError{Inner: fmt.Errorf("image pull failed: %s", waiting.Message)}
 			return podPhaseResponse{true, api.PodUnknown, err}
-		case "ErrImagePull", "ImagePullBackOff":
+		case "ErrImagePull", "ImagePullBackOff", "ErrImageNeverPull":
 			msg := fmt.Sprintf("image pull failed: %s", waiting.Message)
 			imagePullErr := &pull.ImagePullError{Message: msg, Image: container.Image}
 			return podPhaseResponse{
@@ -204,7 +204,7 @@ func getPodPhase(c *kubernetes.Clientset, pod *api.Pod, out io.Writer) podPhaseR
 	return podPhaseResponse{false, pod.Status.Phase, nil}
 }
 
-func triggerPodPhaseCheck(c *kubernetes.Clientset, pod *api.Pod, out io.Writer) <-chan podPhaseResponse {
+func triggerPodPhaseCheck(c kubernetes.Interface, pod *api.Pod, out io.Writer) <-chan podPhaseResponse {
 	errc := make(chan podPhaseResponse)
 	go func() {
 		defer close(errc)
@@ -217,27 +217,36 @@ func triggerPodPhaseCheck(c *kubernetes.Clientset, pod *api.Pod, out io.Writer)
 // state. It returns the final PodPhase once either PodRunning, PodSucceeded or
 // PodFailed has been reached. In the case of PodRunning, it will also wait until
 // all containers within the pod are also Ready.
-// It returns error if the call to retrieve pod details fails or the timeout is
-// reached.
+// It returns error if the call to retrieve pod details fails, the timeout is
+// reached or an unrecoverable failure is received from failures.
 // The timeout and polling values are configurable through KubernetesConfig
 // parameters.
 func waitForPodRunning(
 	ctx context.Context,
-	c *kubernetes.Clientset,
+	c kubernetes.Interface,
 	pod *api.Pod,
 	out io.Writer,
 	config *common.KubernetesConfig,
+	failures <-chan error,
 ) (api.PodPhase, error) {
 	pollInterval := config.GetPollInterval()
 	pollAttempts := config.GetPollAttempts()
 	for i := 0; i <= pollAttempts; i++ {
 		select {
 		case r := <-triggerPodPhaseCheck(c, pod, out):
-			if !r.done {
-				time.Sleep(time.Duration(pollInterval) * time.Second)
-				continue
+			if r.done {
+				return r.phase, r.err
 			}
-			return r.phase, r.err
+		case err := <-failures:
+			return api.PodUnknown, err
+		case <-ctx.Done():
+			return api.PodUnknown, ctx.Err()
+		}
+
+		select {
+		case <-time.After(time.Duration(pollInterval) * time.Second):
+		case err := <-failures:
+			return api.PodUnknown, err
 		case <-ctx.Done():
 			return api.PodUnknown, ctx.Err()
 		}
@@ -347,7 +347,7 @@ func TestWaitForPodRunning(t *testing.T) {
 					return len(b), nil
 				},
 			}
-			phase, err := waitForPodRunning(context.Background(), c, test.Pod, fw, test.Config)
+			phase, err := waitForPodRunning(context.Background(), c, test.Pod, fw, test.Config, nil)
 
 			if err != nil && !test.Error {
 				t.Errorf("[%s] Expected success. Got: %s", test.Name, err.Error())
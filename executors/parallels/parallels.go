@@ -16,6 +16,7 @@ type executor struct {
 	vm.Executor
 	vmName          string
 	sshCommand      ssh.Client
+	verifyCommand   ssh.Client
 	provisioned     bool
 	ipAddress       string
 	machineVerified bool
@@ -48,6 +49,21 @@ func (s *executor) waitForIPAddress(vmName string, seconds int) (string, error)
 	return "", lastError
 }
 
+func (s *executor) newSSHCommand(ipAddr string) ssh.Client {
+	sshCommand := ssh.Client{
+		Config:  *s.Config.SSH,
+		Stdout:  s.Trace,
+		Stderr:  s.Trace,
+		Manager: ssh.DefaultConnectionManager,
+		Scope:   s.Build.ProjectUniqueName(),
+	}
+	sshCommand.Host = ipAddr
+	// the VM is stopped after the job
+	sshCommand.ReuseConnection = false
+
+	return sshCommand
+}
+
 func (s *executor) verifyMachine(vmName string) error {
 	if s.machineVerified {
 		return nil
@@ -58,21 +74,17 @@ func (s *executor) verifyMachine(vmName string) error {
 		return err
 	}
 
-	// Create SSH command
-	sshCommand := ssh.Client{
-		Config:         *s.Config.SSH,
-		Stdout:         s.Trace,
-		Stderr:         s.Trace,
-		ConnectRetries: 30,
-	}
-	sshCommand.Host = ipAddr
+	// Create SSH command, its connection is shared with the job
+	sshCommand := s.newSSHCommand(ipAddr)
+	sshCommand.ConnectRetries = 30
 
 	s.Debugln("Connecting to SSH...")
 	err = sshCommand.Connect()
 	if err != nil {
 		return err
 	}
-	defer sshCommand.Cleanup()
+	s.verifyCommand = sshCommand
+
 	err = sshCommand.Run(s.Context, ssh.Command{Command: "exit"})
 	if err != nil {
 		return err
@@ -352,12 +364,10 @@ func (s *executor) sshConnect() error {
 	}
 
 	s.Debugln("Starting SSH command...")
-	s.sshCommand = ssh.Client{
-		Config: *s.Config.SSH,
-		Stdout: s.Trace,
-		Stderr: s.Trace,
-	}
-	s.sshCommand.Host = ipAddr
+	s.sshCommand = s.newSSHCommand(ipAddr)
+
+	// the connection of the machine verification is released once shared
+	defer s.verifyCommand.Cleanup()
 
 	s.Debugln("Connecting to SSH server...")
 	return s.sshCommand.Connect()
@@ -376,6 +386,7 @@ func (s *executor) Run(cmd common.ExecutorCommand) error {
 }
 
 func (s *executor) Cleanup() {
+	s.verifyCommand.Cleanup()
 	s.sshCommand.Cleanup()
 
 	if s.vmName != "" {
@@ -0,0 +1,157 @@
+package shell
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/docker/go-units"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"gitlab.com/gitlab-org/gitlab-runner/helpers/process"
+)
+
+var newCgroup = process.NewCgroup
+
+// cgroupCollector exposes the resources used by the jobs run in cgroups
+type cgroupCollector struct {
+	memoryPeak prometheus.Histogram
+	cpuUsage   prometheus.Histogram
+	pidsPeak   prometheus.Histogram
+	oomKills   prometheus.Counter
+}
+
+func newCgroupCollector(executorName string) *cgroupCollector {
+	labels := prometheus.Labels{"executor": executorName}
+
+	return &cgroupCollector{
+		memoryPeak: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:        "gitlab_runner_shell_job_memory_peak_bytes",
+			Help:        "Histogram of the peak memory usage of the jobs.",
+			Buckets:     prometheus.ExponentialBuckets(64*units.MiB, 2, 10),
+			ConstLabels: labels,
+		}),
+		cpuUsage: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:        "gitlab_runner_shell_job_cpu_usage_seconds",
+			Help:        "Histogram of the CPU time used by the jobs.",
+			Buckets:     prometheus.ExponentialBuckets(1, 4, 10),
+			ConstLabels: labels,
+		}),
+		pidsPeak: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:        "gitlab_runner_shell_job_pids_peak",
+			Help:        "Histogram of the peak number of processes of the jobs.",
+			Buckets:     prometheus.ExponentialBuckets(4, 2, 10),
+			ConstLabels: labels,
+		}),
+		oomKills: prometheus.NewCounter(prometheus.CounterOpts{
+			Name:        "gitlab_runner_shell_job_oom_kills_total",
+			Help:        "Total number of processes of the jobs killed for exceeding the memory limit.",
+			ConstLabels: labels,
+		}),
+	}
+}
+
+func (c *cgroupCollector) observe(stats process.CgroupStats) {
+	if stats.MemoryPeak > 0 {
+		c.memoryPeak.Observe(float64(stats.MemoryPeak))
+	}
+	if stats.PidsPeak > 0 {
+		c.pidsPeak.Observe(float64(stats.PidsPeak))
+	}
+	c.cpuUsage.Observe(stats.CPUUsage.Seconds())
+	c.oomKills.Add(float64(stats.OOMKills))
+}
+
+// Describe implements prometheus.Collector.
+func (c *cgroupCollector) Describe(ch chan<- *prometheus.Desc) {
+	c.memoryPeak.Describe(ch)
+	c.cpuUsage.Describe(ch)
+	c.pidsPeak.Describe(ch)
+	c.oomKills.Describe(ch)
+}
+
+// Collect implements prometheus.Collector.
+func (c *cgroupCollector) Collect(ch chan<- prometheus.Metric) {
+	c.memoryPeak.Collect(ch)
+	c.cpuUsage.Collect(ch)
+	c.pidsPeak.Collect(ch)
+	c.oomKills.Collect(ch)
+}
+
+// createCgroup creates the cgroup of the job when the cgroups are configured,
+// all the stages of the job run in it
+func (s *executor) createCgroup() error {
+	config := s.Config.ShellCgroup
+	if config == nil {
+		return nil
+	}
+
+	if config.Parent == "" {
+		return fmt.Errorf("the parent cgroup of the jobs isn't set")
+	}
+
+	limits, err := config.GetLimits()
+	if err != nil {
+		return err
+	}
+
+	name := fmt.Sprintf("runner-%s-job-%d", s.Build.Runner.ShortDescription(), s.Build.ID)
+	s.cgroup, err = newCgroup(config.Parent, name, limits)
+	if err != nil {
+		return fmt.Errorf("creating the cgroup of the job: %w", err)
+	}
+
+	return nil
+}
+
+// printResourcesUsage prints the resources used by the job at the end of its
+// log
+func (s *executor) printResourcesUsage() {
+	if s.cgroup == nil {
+		return
+	}
+
+	stats, err := s.cgroup.Stats()
+	if err != nil {
+		s.BuildLogger.Warningln("Failed to get the resources usage of the job:", err)
+		return
+	}
+
+	if s.cgroupCollector != nil {
+		s.cgroupCollector.observe(stats)
+	}
+
+	usage := []string{fmt.Sprintf("CPU time %s", stats.CPUUsage.Round(time.Millisecond))}
+	if stats.MemoryPeak > 0 {
+		usage = append(usage, fmt.Sprintf("peak memory %s", units.BytesSize(float64(stats.MemoryPeak))))
+	}
+	if stats.PidsPeak > 0 {
+		usage = append(usage, fmt.Sprintf("peak processes %d", stats.PidsPeak))
+	}
+
+	s.Println("Job resources usage:", strings.Join(usage, ", "))
+
+	if stats.OOMKills > 0 {
+		s.Warningln(fmt.Sprintf("%d processes of the job were killed for exceeding the memory limit", stats.OOMKills))
+	}
+}
+
+// removeCgroup kills the processes left by the job, like the daemons it
+// started, and removes its cgroup
+func (s *executor) removeCgroup() {
+	if s.cgroup == nil {
+		return
+	}
+
+	err := s.cgroup.Kill()
+	if err != nil {
+		s.BuildLogger.Warningln("Failed to kill the processes of the job cgroup:", err)
+	}
+
+	err = s.cgroup.Remove()
+	if err != nil {
+		s.BuildLogger.Warningln("Failed to remove the job cgroup:", err)
+	}
+
+	s.cgroup = nil
+}
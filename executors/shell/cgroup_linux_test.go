@@ -0,0 +1,102 @@
+//go:build !integration && linux
+// +build !integration,linux
+
+package shell
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-runner/common"
+	"gitlab.com/gitlab-org/gitlab-runner/executors"
+)
+
+func newCgroupTestExecutor(t *testing.T, config *common.ShellCgroupConfig, output *bytes.Buffer) *executor {
+	runner := &common.RunnerConfig{
+		RunnerCredentials: common.RunnerCredentials{Token: "abcdefgh12345"},
+		RunnerSettings:    common.RunnerSettings{ShellCgroup: config},
+	}
+
+	return &executor{
+		AbstractExecutor: executors.AbstractExecutor{
+			Build: &common.Build{
+				JobResponse: common.JobResponse{ID: 1234},
+				Runner:      runner,
+			},
+			Config:      *runner,
+			BuildLogger: common.NewBuildLogger(&common.Trace{Writer: output}, logrus.WithField("test", t.Name())),
+		},
+		cgroupCollector: newCgroupCollector("shell"),
+	}
+}
+
+func TestExecutor_CreateCgroup(t *testing.T) {
+	parent := t.TempDir()
+
+	e := newCgroupTestExecutor(t, &common.ShellCgroupConfig{
+		Parent:    parent,
+		MemoryMax: "1g",
+		CPUMax:    "2",
+		PidsMax:   100,
+	}, new(bytes.Buffer))
+
+	require.NoError(t, e.createCgroup())
+	require.NotNil(t, e.cgroup)
+	assert.Equal(t, filepath.Join(parent, "runner-abcdefgh-job-1234"), e.cgroup.Path())
+
+	memoryMax, err := ioutil.ReadFile(filepath.Join(e.cgroup.Path(), "memory.max"))
+	require.NoError(t, err)
+	assert.Equal(t, "1073741824", string(memoryMax))
+}
+
+func TestExecutor_CreateCgroupInvalidConfig(t *testing.T) {
+	tests := map[string]struct {
+		config        *common.ShellCgroupConfig
+		expectedError string
+	}{
+		"no parent": {
+			config:        &common.ShellCgroupConfig{},
+			expectedError: "the parent cgroup of the jobs isn't set",
+		},
+		"invalid cpu_max": {
+			config:        &common.ShellCgroupConfig{Parent: "/sys/fs/cgroup/gitlab-runner", CPUMax: "-1"},
+			expectedError: `invalid cpu_max "-1", a positive number of CPUs is expected`,
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			e := newCgroupTestExecutor(t, tt.config, new(bytes.Buffer))
+			assert.EqualError(t, e.createCgroup(), tt.expectedError)
+		})
+	}
+}
+
+func TestExecutor_PrintResourcesUsage(t *testing.T) {
+	output := new(bytes.Buffer)
+	e := newCgroupTestExecutor(t, &common.ShellCgroupConfig{Parent: t.TempDir()}, output)
+	require.NoError(t, e.createCgroup())
+
+	files := map[string]string{
+		"cpu.stat":      "usage_usec 61500000\n",
+		"memory.events": "oom_kill 2\n",
+		"memory.peak":   "536870912\n",
+		"pids.peak":     "42\n",
+	}
+	for name, content := range files {
+		require.NoError(t, ioutil.WriteFile(filepath.Join(e.cgroup.Path(), name), []byte(content), 0o644))
+	}
+
+	e.printResourcesUsage()
+
+	assert.Contains(t, output.String(), "Job resources usage: CPU time 1m1.5s, peak memory 512 MiB, peak processes 42")
+	assert.Contains(t, output.String(), "2 processes of the job were killed for exceeding the memory limit")
+	assert.Equal(t, float64(2), testutil.ToFloat64(e.cgroupCollector.oomKills))
+}
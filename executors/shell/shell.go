@@ -24,10 +24,21 @@ var newCommander = process.NewOSCmd
 
 type executor struct {
 	executors.AbstractExecutor
+
+	// sandbox runs the stages of the jobs isolated in Linux namespaces
+	sandbox bool
+
+	cgroup          *process.Cgroup
+	cgroupCollector *cgroupCollector
 }
 
 func (s *executor) Prepare(options common.ExecutorPrepareOptions) error {
 	if options.User != "" {
+		// the other users aren't mapped in the user namespace of the sandbox
+		if s.sandbox {
+			return errors.New("the shell-sandbox executor doesn't support running the jobs as another user")
+		}
+
 		s.Shell().User = options.User
 	}
 
@@ -55,6 +66,16 @@ func (s *executor) Prepare(options common.ExecutorPrepareOptions) error {
 		return err
 	}
 
+	err = s.createCgroup()
+	if err != nil {
+		return err
+	}
+
+	if s.sandbox {
+		s.Println("Using Shell executor in a sandbox...")
+		return nil
+	}
+
 	s.Println("Using Shell executor...")
 	return nil
 }
@@ -76,6 +97,15 @@ func (s *executor) Run(cmd common.ExecutorCommand) error {
 	defer cleanup()
 
 	cmdOpts.Stdin = stdin
+	cmdOpts.Cgroup = s.cgroup
+
+	if s.sandbox {
+		cmdOpts.Env = s.sandboxEnvironment(os.Environ())
+		cmdOpts.Sandbox, err = s.sandboxOptions(cmd)
+		if err != nil {
+			return err
+		}
+	}
 
 	// Create execution command
 	c := newCommander(s.BuildShell.Command, args, cmdOpts)
@@ -113,7 +143,18 @@ func (s *executor) shellScriptArgs(cmd common.ExecutorCommand, args []string) (i
 		return strings.NewReader(cmd.Script), args, func() {}, nil
 	}
 
-	scriptDir, err := ioutil.TempDir("", "build_script")
+	// the temporary directory of the host isn't visible in the sandbox, which
+	// has a private one
+	tmpDir := ""
+	if s.sandbox {
+		tmpDir = s.Build.TmpProjectDir()
+		err := os.MkdirAll(tmpDir, 0o700)
+		if err != nil {
+			return nil, nil, func() {}, fmt.Errorf("creating tmp project dir: %w", err)
+		}
+	}
+
+	scriptDir, err := ioutil.TempDir(tmpDir, "build_script")
 	if err != nil {
 		return nil, nil, func() {}, fmt.Errorf("creating tmp build script dir: %w", err)
 	}
@@ -134,6 +175,85 @@ func (s *executor) shellScriptArgs(cmd common.ExecutorCommand, args []string) (i
 	return nil, append(args, scriptFile), cleanup, nil
 }
 
+func (s *executor) Finish(err error) {
+	s.printResourcesUsage()
+	s.AbstractExecutor.Finish(err)
+}
+
+func (s *executor) Cleanup() {
+	s.removeCgroup()
+	s.AbstractExecutor.Cleanup()
+}
+
+// sandboxOptions returns the sandbox of the stage. The builds and the cache
+// directories of the job are writable and the scripts of the job don't have
+// network access when disabled.
+func (s *executor) sandboxOptions(cmd common.ExecutorCommand) (*process.SandboxOptions, error) {
+	config := s.Config.ShellSandbox
+	if config == nil {
+		config = new(common.ShellSandboxConfig)
+	}
+
+	paths := append([]string{s.Build.RootDir, s.Build.CacheDir}, config.WritablePaths...)
+	writablePaths := make([]string, 0, len(paths))
+	for _, path := range paths {
+		absPath, err := filepath.Abs(path)
+		if err != nil {
+			return nil, fmt.Errorf("resolving sandbox writable path %s: %w", path, err)
+		}
+		writablePaths = append(writablePaths, absPath)
+	}
+
+	return &process.SandboxOptions{
+		InitCommand:   s.ExecutorOptions.Shell.RunnerCommand,
+		WritablePaths: writablePaths,
+		// the stages of the runner, like fetching the sources or uploading
+		// the artifacts, keep the network
+		DisableNetwork: config.DisableNetwork && !cmd.Predefined,
+	}, nil
+}
+
+// sandboxEnvironmentAllowed are the variables of the environment of the
+// runner passed to the sandbox, the variables with the LC_ prefix are
+// passed too
+var sandboxEnvironmentAllowed = []string{
+	"PATH",
+	"HOME",
+	"USER",
+	"LOGNAME",
+	"SHELL",
+	"LANG",
+	"LANGUAGE",
+	"TZ",
+	"TERM",
+}
+
+// sandboxEnvironment returns the variables of the environment of the runner
+// that are allowed in the sandbox, so that the secrets of the runner don't
+// reach the jobs. The variables of the job are exported by its script.
+func (s *executor) sandboxEnvironment(environ []string) []string {
+	allowed := make(map[string]bool)
+	for _, name := range sandboxEnvironmentAllowed {
+		allowed[name] = true
+	}
+
+	if s.Config.ShellSandbox != nil {
+		for _, name := range s.Config.ShellSandbox.PassEnv {
+			allowed[name] = true
+		}
+	}
+
+	env := make([]string, 0, len(allowed))
+	for _, variable := range environ {
+		name := strings.SplitN(variable, "=", 2)[0]
+		if allowed[name] || strings.HasPrefix(name, "LC_") {
+			env = append(env, variable)
+		}
+	}
+
+	return env
+}
+
 func init() {
 	// Look for self
 	runnerCommand, err := os.Executable()
@@ -142,9 +262,18 @@ func init() {
 	}
 
 	RegisterExecutor("shell", runnerCommand)
+
+	// the sandbox uses Linux namespaces
+	if runtime.GOOS == "linux" {
+		registerExecutor("shell-sandbox", runnerCommand, true)
+	}
 }
 
 func RegisterExecutor(executorName string, runnerCommandPath string) {
+	registerExecutor(executorName, runnerCommandPath, false)
+}
+
+func registerExecutor(executorName string, runnerCommandPath string, sandbox bool) {
 	options := executors.ExecutorOptions{
 		DefaultCustomBuildsDirEnabled: false,
 		DefaultBuildsDir:              "$PWD/builds",
@@ -158,11 +287,15 @@ func RegisterExecutor(executorName string, runnerCommandPath string) {
 		ShowHostname: false,
 	}
 
+	collector := newCgroupCollector(executorName)
+
 	creator := func() common.Executor {
 		return &executor{
 			AbstractExecutor: executors.AbstractExecutor{
 				ExecutorOptions: options,
 			},
+			sandbox:         sandbox,
+			cgroupCollector: collector,
 		}
 	}
 
@@ -170,15 +303,25 @@ func RegisterExecutor(executorName string, runnerCommandPath string) {
 		features.Variables = true
 		features.Shared = true
 
-		if runtime.GOOS != "windows" {
+		// the terminal would run on the host, out of the sandbox
+		if runtime.GOOS != "windows" && !sandbox {
 			features.Session = true
 			features.Terminal = true
 		}
 	}
 
-	common.RegisterExecutorProvider(executorName, executors.DefaultExecutorProvider{
-		Creator:          creator,
-		FeaturesUpdater:  featuresUpdater,
-		DefaultShellName: options.Shell.Shell,
+	common.RegisterExecutorProvider(executorName, &executorProvider{
+		DefaultExecutorProvider: executors.DefaultExecutorProvider{
+			Creator:          creator,
+			FeaturesUpdater:  featuresUpdater,
+			DefaultShellName: options.Shell.Shell,
+		},
+		cgroupCollector: collector,
 	})
 }
+
+// executorProvider exposes the resources used by the jobs of the executor
+type executorProvider struct {
+	executors.DefaultExecutorProvider
+	*cgroupCollector
+}
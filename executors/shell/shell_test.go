@@ -6,6 +6,7 @@ package shell
 import (
 	"context"
 	"errors"
+	"os"
 	"os/exec"
 	"testing"
 	"time"
@@ -156,3 +157,157 @@ func setupProcessMocks(t *testing.T) (*process.MockKillWaiter, *process.MockComm
 		newCommander = oldCmd
 	}
 }
+
+func TestExecutor_RunInSandbox(t *testing.T) {
+	tests := map[string]struct {
+		config                 *common.ShellSandboxConfig
+		predefined             bool
+		expectedWritablePaths  []string
+		expectedDisableNetwork bool
+	}{
+		"default sandbox": {
+			expectedWritablePaths: []string{"/builds/project-0", "/cache/project"},
+		},
+		"network disabled for the scripts of the job": {
+			config: &common.ShellSandboxConfig{
+				WritablePaths:  []string{"/opt/toolchains"},
+				DisableNetwork: true,
+			},
+			expectedWritablePaths:  []string{"/builds/project-0", "/cache/project", "/opt/toolchains"},
+			expectedDisableNetwork: true,
+		},
+		"network kept for the stages of the runner": {
+			config:                &common.ShellSandboxConfig{DisableNetwork: true},
+			predefined:            true,
+			expectedWritablePaths: []string{"/builds/project-0", "/cache/project"},
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			mCmd := new(process.MockCommander)
+			defer mCmd.AssertExpectations(t)
+			mCmd.On("Start").Return(nil).Once()
+			mCmd.On("Wait").Return(nil).Once()
+
+			oldCmd := newCommander
+			defer func() { newCommander = oldCmd }()
+
+			var options process.CommandOptions
+			newCommander = func(executable string, args []string, o process.CommandOptions) process.Commander {
+				options = o
+				return mCmd
+			}
+
+			executor := executor{
+				AbstractExecutor: executors.AbstractExecutor{
+					ExecutorOptions: executors.ExecutorOptions{
+						Shell: common.ShellScriptInfo{RunnerCommand: "/usr/bin/gitlab-runner"},
+					},
+					Build: &common.Build{
+						Runner:   &common.RunnerConfig{},
+						RootDir:  "/builds/project-0",
+						CacheDir: "/cache/project",
+					},
+					Config: common.RunnerConfig{
+						RunnerSettings: common.RunnerSettings{ShellSandbox: tt.config},
+					},
+					BuildShell: &common.ShellConfiguration{Command: "bash"},
+				},
+				sandbox: true,
+			}
+
+			err := executor.Run(common.ExecutorCommand{
+				Script:     "echo hello",
+				Predefined: tt.predefined,
+				Context:    context.Background(),
+			})
+			assert.NoError(t, err)
+
+			assert.Equal(t, &process.SandboxOptions{
+				InitCommand:    "/usr/bin/gitlab-runner",
+				WritablePaths:  tt.expectedWritablePaths,
+				DisableNetwork: tt.expectedDisableNetwork,
+			}, options.Sandbox)
+		})
+	}
+}
+
+func TestExecutor_RunInSandboxEnvironment(t *testing.T) {
+	t.Setenv("RUNNER_SECRET_TOKEN", "secret")
+	t.Setenv("TOOLCHAIN_HOME", "/opt/toolchain")
+	t.Setenv("LC_ALL", "C.UTF-8")
+
+	tests := map[string]struct {
+		sandbox     bool
+		config      *common.ShellSandboxConfig
+		expectedEnv []string
+		excludedEnv []string
+	}{
+		"sandbox passes only the allowed variables": {
+			sandbox:     true,
+			expectedEnv: []string{"LC_ALL=C.UTF-8"},
+			excludedEnv: []string{"RUNNER_SECRET_TOKEN=secret", "TOOLCHAIN_HOME=/opt/toolchain"},
+		},
+		"sandbox passes the configured variables": {
+			sandbox:     true,
+			config:      &common.ShellSandboxConfig{PassEnv: []string{"TOOLCHAIN_HOME"}},
+			expectedEnv: []string{"LC_ALL=C.UTF-8", "TOOLCHAIN_HOME=/opt/toolchain"},
+			excludedEnv: []string{"RUNNER_SECRET_TOKEN=secret"},
+		},
+		"shell passes the whole environment": {
+			expectedEnv: []string{"RUNNER_SECRET_TOKEN=secret", "TOOLCHAIN_HOME=/opt/toolchain"},
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			mCmd := new(process.MockCommander)
+			defer mCmd.AssertExpectations(t)
+			mCmd.On("Start").Return(nil).Once()
+			mCmd.On("Wait").Return(nil).Once()
+
+			oldCmd := newCommander
+			defer func() { newCommander = oldCmd }()
+
+			var options process.CommandOptions
+			newCommander = func(executable string, args []string, o process.CommandOptions) process.Commander {
+				options = o
+				return mCmd
+			}
+
+			executor := executor{
+				AbstractExecutor: executors.AbstractExecutor{
+					Build: &common.Build{
+						Runner:   &common.RunnerConfig{},
+						RootDir:  "/builds/project-0",
+						CacheDir: "/cache/project",
+					},
+					Config: common.RunnerConfig{
+						RunnerSettings: common.RunnerSettings{ShellSandbox: tt.config},
+					},
+					BuildShell: &common.ShellConfiguration{Command: "bash"},
+				},
+				sandbox: tt.sandbox,
+			}
+
+			err := executor.Run(common.ExecutorCommand{Script: "echo hello", Context: context.Background()})
+			assert.NoError(t, err)
+
+			for _, variable := range tt.expectedEnv {
+				assert.Contains(t, options.Env, variable)
+			}
+			for _, variable := range tt.excludedEnv {
+				assert.NotContains(t, options.Env, variable)
+			}
+			assert.Contains(t, options.Env, "PATH="+os.Getenv("PATH"))
+		})
+	}
+}
+
+func TestExecutor_PrepareSandboxWithUser(t *testing.T) {
+	executor := executor{sandbox: true}
+
+	err := executor.Prepare(common.ExecutorPrepareOptions{User: "gitlab-runner"})
+	assert.EqualError(t, err, "the shell-sandbox executor doesn't support running the jobs as another user")
+}
@@ -0,0 +1,301 @@
+package ssh
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"gitlab.com/gitlab-org/gitlab-runner/common"
+	"gitlab.com/gitlab-org/gitlab-runner/executors"
+	"gitlab.com/gitlab-org/gitlab-runner/helpers/ssh"
+)
+
+var errNoFreeHosts = &common.NoFreeExecutorError{Message: "no free SSH hosts that can process builds"}
+
+// checkHostHealth connects and authenticates to the host
+var checkHostHealth = func(ctx context.Context, config ssh.Config) error {
+	errCh := make(chan error, 1)
+
+	go func() {
+		client := ssh.Client{
+			Config:         config,
+			ConnectRetries: 1,
+		}
+
+		err := client.Connect()
+		client.Cleanup()
+
+		errCh <- err
+	}()
+
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+type poolHost struct {
+	address string
+	host    string
+	port    string
+
+	jobs int
+
+	healthy   bool
+	checkedAt time.Time
+}
+
+// config returns the SSH configuration of the host
+func (h *poolHost) config(base ssh.Config) ssh.Config {
+	base.Host = h.host
+	if h.port != "" {
+		base.Port = h.port
+	}
+
+	return base
+}
+
+// hostSlot is the executor data of the jobs of a pool, it holds a job slot of
+// the host until it's released
+type hostSlot struct {
+	pool *hostPool
+	host *poolHost
+}
+
+type hostPool struct {
+	lock  sync.Mutex
+	hosts []*poolHost
+	next  int
+}
+
+// update updates the hosts of the pool with the hosts of the configuration,
+// keeping the state of the hosts which are still configured
+func (p *hostPool) update(addresses []string) {
+	existing := make(map[string]*poolHost, len(p.hosts))
+	for _, h := range p.hosts {
+		existing[h.address] = h
+	}
+
+	hosts := make([]*poolHost, 0, len(addresses))
+	for _, address := range addresses {
+		if h, ok := existing[address]; ok {
+			hosts = append(hosts, h)
+			continue
+		}
+
+		h := &poolHost{address: address}
+		h.host, h.port = splitHostPort(address)
+		hosts = append(hosts, h)
+	}
+
+	p.hosts = hosts
+}
+
+func splitHostPort(address string) (string, string) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return address, ""
+	}
+
+	return host, port
+}
+
+// candidates returns the hosts with a free job slot in the order of the
+// selection, the hosts which failed their last health check are out of
+// rotation until it's outdated
+func (p *hostPool) candidates(selection string, maxJobs int, interval time.Duration) []*poolHost {
+	now := time.Now()
+
+	var hosts []*poolHost
+	for i := range p.hosts {
+		// the hosts are visited in order from the next host of the rotation
+		h := p.hosts[(p.next+i)%len(p.hosts)]
+
+		if maxJobs > 0 && h.jobs >= maxJobs {
+			continue
+		}
+
+		if !h.healthy && now.Sub(h.checkedAt) < interval {
+			continue
+		}
+
+		hosts = append(hosts, h)
+	}
+
+	switch selection {
+	case common.SSHPoolSelectionLeastBusy:
+		sort.SliceStable(hosts, func(i, j int) bool {
+			return hosts[i].jobs < hosts[j].jobs
+		})
+	case common.SSHPoolSelectionRandom:
+		rand.Shuffle(len(hosts), func(i, j int) {
+			hosts[i], hosts[j] = hosts[j], hosts[i]
+		})
+	}
+
+	return hosts
+}
+
+// acquire reserves a job slot on a healthy host. The health of the hosts is
+// checked before their use when their last check is outdated.
+func (p *hostPool) acquire(config *common.RunnerConfig, check func(h *poolHost) error) (*hostSlot, error) {
+	poolConfig := config.SSHPool
+
+	selection, err := poolConfig.GetSelection()
+	if err != nil {
+		return nil, err
+	}
+
+	interval := poolConfig.GetHealthCheckInterval()
+
+	p.lock.Lock()
+	p.update(poolConfig.Hosts)
+	if len(p.hosts) == 0 {
+		p.lock.Unlock()
+		return nil, errors.New("the SSH pool has no hosts")
+	}
+
+	candidates := p.candidates(selection, poolConfig.MaxJobsPerHost, interval)
+	p.lock.Unlock()
+
+	for _, h := range candidates {
+		if p.reserve(h, poolConfig.MaxJobsPerHost, interval, check) {
+			return &hostSlot{pool: p, host: h}, nil
+		}
+	}
+
+	return nil, errNoFreeHosts
+}
+
+// reserve takes a job slot of the host, the slot is given back when the
+// health check of the host fails
+func (p *hostPool) reserve(h *poolHost, maxJobs int, interval time.Duration, check func(h *poolHost) error) bool {
+	p.lock.Lock()
+	if maxJobs > 0 && h.jobs >= maxJobs {
+		p.lock.Unlock()
+		return false
+	}
+
+	h.jobs++
+	p.advance(h)
+
+	needsCheck := time.Since(h.checkedAt) >= interval
+	p.lock.Unlock()
+
+	if !needsCheck {
+		return true
+	}
+
+	err := check(h)
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	h.checkedAt = time.Now()
+	h.healthy = err == nil
+	if err != nil {
+		h.jobs--
+		logrus.WithError(err).WithField("host", h.address).Warningln("SSH host failed the health check")
+	}
+
+	return h.healthy
+}
+
+// advance moves the rotation after the host
+func (p *hostPool) advance(h *poolHost) {
+	for i, host := range p.hosts {
+		if host == h {
+			p.next = (i + 1) % len(p.hosts)
+			return
+		}
+	}
+}
+
+// release gives the job slot back
+func (p *hostPool) release(h *poolHost) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if h.jobs > 0 {
+		h.jobs--
+	}
+}
+
+// markUnhealthy takes the host out of rotation, when the connection of a job
+// to the host failed
+func (p *hostPool) markUnhealthy(h *poolHost) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	h.healthy = false
+	h.checkedAt = time.Now()
+}
+
+// executorProvider acquires a job slot of a host of the pool of the runner
+// before requesting the jobs, when the pool is configured
+type executorProvider struct {
+	executors.DefaultExecutorProvider
+
+	lock  sync.Mutex
+	pools map[string]*hostPool
+}
+
+func (p *executorProvider) pool(config *common.RunnerConfig) *hostPool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	key := config.UniqueID()
+
+	pool, ok := p.pools[key]
+	if !ok {
+		pool = new(hostPool)
+		p.pools[key] = pool
+	}
+
+	return pool
+}
+
+func (p *executorProvider) Acquire(config *common.RunnerConfig) (common.ExecutorData, error) {
+	if config.SSHPool == nil {
+		return p.DefaultExecutorProvider.Acquire(config)
+	}
+
+	if config.SSH == nil {
+		return nil, errors.New("missing SSH configuration")
+	}
+
+	sshConfig := *config.SSH
+	timeout := config.SSHPool.GetHealthCheckTimeout()
+	check := func(h *poolHost) error {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+
+		return checkHostHealth(ctx, h.config(sshConfig))
+	}
+
+	slot, err := p.pool(config).acquire(config, check)
+	if err != nil {
+		return nil, fmt.Errorf("acquiring SSH host: %w", err)
+	}
+
+	return slot, nil
+}
+
+func (p *executorProvider) Release(config *common.RunnerConfig, data common.ExecutorData) {
+	slot, ok := data.(*hostSlot)
+	if !ok {
+		p.DefaultExecutorProvider.Release(config, data)
+		return
+	}
+
+	slot.pool.release(slot.host)
+}
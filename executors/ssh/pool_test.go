@@ -0,0 +1,324 @@
+//go:build !integration
+// +build !integration
+
+package ssh
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-runner/common"
+	"gitlab.com/gitlab-org/gitlab-runner/executors"
+	sshHelpers "gitlab.com/gitlab-org/gitlab-runner/helpers/ssh"
+)
+
+func newPoolConfig(pool common.SSHPoolConfig) *common.RunnerConfig {
+	return &common.RunnerConfig{
+		RunnerSettings: common.RunnerSettings{
+			Executor: "ssh",
+			SSH:      &sshHelpers.Config{User: "user", Password: "pass"},
+			SSHPool:  &pool,
+		},
+	}
+}
+
+func healthyHosts(h *poolHost) error {
+	return nil
+}
+
+func acquireAddresses(t *testing.T, p *hostPool, config *common.RunnerConfig, count int) []string {
+	var addresses []string
+	for i := 0; i < count; i++ {
+		slot, err := p.acquire(config, healthyHosts)
+		require.NoError(t, err)
+
+		addresses = append(addresses, slot.host.address)
+	}
+
+	return addresses
+}
+
+func TestHostPool_RoundRobin(t *testing.T) {
+	config := newPoolConfig(common.SSHPoolConfig{
+		Hosts:     []string{"host-1", "host-2", "host-3"},
+		Selection: common.SSHPoolSelectionRoundRobin,
+	})
+
+	p := new(hostPool)
+	addresses := acquireAddresses(t, p, config, 4)
+
+	assert.Equal(t, []string{"host-1", "host-2", "host-3", "host-1"}, addresses)
+}
+
+func TestHostPool_LeastBusy(t *testing.T) {
+	config := newPoolConfig(common.SSHPoolConfig{
+		Hosts: []string{"host-1", "host-2", "host-3"},
+	})
+
+	p := new(hostPool)
+
+	slot, err := p.acquire(config, healthyHosts)
+	require.NoError(t, err)
+	assert.Equal(t, "host-1", slot.host.address)
+
+	addresses := acquireAddresses(t, p, config, 2)
+	assert.Equal(t, []string{"host-2", "host-3"}, addresses)
+
+	p.release(slot.host)
+
+	addresses = acquireAddresses(t, p, config, 1)
+	assert.Equal(t, []string{"host-1"}, addresses)
+}
+
+func TestHostPool_Random(t *testing.T) {
+	config := newPoolConfig(common.SSHPoolConfig{
+		Hosts:          []string{"host-1", "host-2", "host-3"},
+		Selection:      common.SSHPoolSelectionRandom,
+		MaxJobsPerHost: 1,
+	})
+
+	p := new(hostPool)
+	addresses := acquireAddresses(t, p, config, 3)
+
+	assert.ElementsMatch(t, []string{"host-1", "host-2", "host-3"}, addresses)
+}
+
+func TestHostPool_InvalidSelection(t *testing.T) {
+	config := newPoolConfig(common.SSHPoolConfig{
+		Hosts:     []string{"host-1"},
+		Selection: "fastest",
+	})
+
+	_, err := new(hostPool).acquire(config, healthyHosts)
+	assert.Error(t, err)
+}
+
+func TestHostPool_NoHosts(t *testing.T) {
+	config := newPoolConfig(common.SSHPoolConfig{})
+
+	_, err := new(hostPool).acquire(config, healthyHosts)
+	assert.EqualError(t, err, "the SSH pool has no hosts")
+}
+
+func TestHostPool_MaxJobsPerHost(t *testing.T) {
+	config := newPoolConfig(common.SSHPoolConfig{
+		Hosts:          []string{"host-1", "host-2"},
+		MaxJobsPerHost: 2,
+	})
+
+	p := new(hostPool)
+	slots := make([]*hostSlot, 0, 4)
+	for i := 0; i < 4; i++ {
+		slot, err := p.acquire(config, healthyHosts)
+		require.NoError(t, err)
+
+		slots = append(slots, slot)
+	}
+
+	_, err := p.acquire(config, healthyHosts)
+
+	var noFreeErr *common.NoFreeExecutorError
+	assert.True(t, errors.As(err, &noFreeErr), "expected a NoFreeExecutorError, got %v", err)
+
+	p.release(slots[0].host)
+
+	slot, err := p.acquire(config, healthyHosts)
+	require.NoError(t, err)
+	assert.Equal(t, slots[0].host, slot.host)
+}
+
+func TestHostPool_HealthCheck(t *testing.T) {
+	config := newPoolConfig(common.SSHPoolConfig{
+		Hosts:               []string{"host-1", "host-2"},
+		Selection:           common.SSHPoolSelectionRoundRobin,
+		HealthCheckInterval: 3600,
+	})
+
+	checks := make(map[string]int)
+	check := func(h *poolHost) error {
+		checks[h.address]++
+		if h.address == "host-1" {
+			return errors.New("connection refused")
+		}
+
+		return nil
+	}
+
+	p := new(hostPool)
+	for i := 0; i < 3; i++ {
+		slot, err := p.acquire(config, check)
+		require.NoError(t, err)
+		assert.Equal(t, "host-2", slot.host.address)
+	}
+
+	// the hosts are checked once per interval, the failing host is out of
+	// rotation until its next check
+	assert.Equal(t, map[string]int{"host-1": 1, "host-2": 1}, checks)
+	assert.Equal(t, 0, p.hosts[0].jobs)
+	assert.Equal(t, 3, p.hosts[1].jobs)
+
+	// the failing host is checked again once its last check is outdated
+	p.hosts[0].checkedAt = p.hosts[0].checkedAt.Add(-2 * config.SSHPool.GetHealthCheckInterval())
+	p.markUnhealthy(p.hosts[1])
+
+	_, err := p.acquire(config, check)
+
+	var noFreeErr *common.NoFreeExecutorError
+	assert.True(t, errors.As(err, &noFreeErr), "expected a NoFreeExecutorError, got %v", err)
+	assert.Equal(t, map[string]int{"host-1": 2, "host-2": 1}, checks)
+}
+
+func TestHostPool_UpdateKeepsState(t *testing.T) {
+	config := newPoolConfig(common.SSHPoolConfig{
+		Hosts: []string{"host-1", "host-2:2222"},
+	})
+
+	p := new(hostPool)
+	slot, err := p.acquire(config, healthyHosts)
+	require.NoError(t, err)
+
+	config.SSHPool.Hosts = []string{"host-3", "host-1"}
+	p.lock.Lock()
+	p.update(config.SSHPool.Hosts)
+	p.lock.Unlock()
+
+	require.Len(t, p.hosts, 2)
+	assert.Equal(t, "host-3", p.hosts[0].address)
+	assert.Equal(t, slot.host, p.hosts[1])
+	assert.Equal(t, 1, p.hosts[1].jobs)
+}
+
+func TestPoolHost_Config(t *testing.T) {
+	p := new(hostPool)
+	p.update([]string{"host-1", "host-2:2222", "[::1]:2200"})
+
+	base := sshHelpers.Config{User: "user", Host: "ignored", Port: "22"}
+
+	assert.Equal(t, sshHelpers.Config{User: "user", Host: "host-1", Port: "22"}, p.hosts[0].config(base))
+	assert.Equal(t, sshHelpers.Config{User: "user", Host: "host-2", Port: "2222"}, p.hosts[1].config(base))
+	assert.Equal(t, sshHelpers.Config{User: "user", Host: "::1", Port: "2200"}, p.hosts[2].config(base))
+}
+
+func newTestExecutorProvider() *executorProvider {
+	return &executorProvider{
+		DefaultExecutorProvider: executors.DefaultExecutorProvider{
+			Creator: func() common.Executor { return nil },
+		},
+		pools: make(map[string]*hostPool),
+	}
+}
+
+func TestExecutorProvider_AcquireRelease(t *testing.T) {
+	server, err := sshHelpers.NewStubServer("user", "pass")
+	require.NoError(t, err)
+
+	defer server.Stop()
+
+	// nothing listens on the closed listener address
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	downHost := listener.Addr().String()
+	require.NoError(t, listener.Close())
+
+	upHost := net.JoinHostPort("127.0.0.1", server.Port())
+
+	config := newPoolConfig(common.SSHPoolConfig{
+		Hosts:          []string{downHost, upHost},
+		Selection:      common.SSHPoolSelectionRoundRobin,
+		MaxJobsPerHost: 1,
+	})
+
+	provider := newTestExecutorProvider()
+
+	data, err := provider.Acquire(config)
+	require.NoError(t, err)
+
+	slot, ok := data.(*hostSlot)
+	require.True(t, ok)
+	assert.Equal(t, upHost, slot.host.address)
+
+	_, err = provider.Acquire(config)
+
+	var noFreeErr *common.NoFreeExecutorError
+	assert.True(t, errors.As(err, &noFreeErr), "expected a NoFreeExecutorError, got %v", err)
+
+	provider.Release(config, data)
+
+	data, err = provider.Acquire(config)
+	require.NoError(t, err)
+	assert.Equal(t, slot, data)
+}
+
+func TestExecutorProvider_AcquireWithoutPool(t *testing.T) {
+	config := &common.RunnerConfig{
+		RunnerSettings: common.RunnerSettings{
+			Executor: "ssh",
+		},
+	}
+
+	data, err := newTestExecutorProvider().Acquire(config)
+	assert.NoError(t, err)
+	assert.Nil(t, data)
+}
+
+func TestPrepareWithPool(t *testing.T) {
+	server, err := sshHelpers.NewStubServer("user", "pass")
+	require.NoError(t, err)
+
+	defer server.Stop()
+
+	address := net.JoinHostPort("127.0.0.1", server.Port())
+	runnerConfig := newPoolConfig(common.SSHPoolConfig{
+		Hosts: []string{address},
+	})
+
+	p := new(hostPool)
+	slot, err := p.acquire(runnerConfig, healthyHosts)
+	require.NoError(t, err)
+
+	build := &common.Build{
+		JobResponse: common.JobResponse{
+			GitInfo: common.GitInfo{
+				Sha: "1234567890",
+			},
+		},
+		Runner:       &common.RunnerConfig{},
+		ExecutorData: slot,
+	}
+
+	e := &executor{
+		AbstractExecutor: executors.AbstractExecutor{
+			ExecutorOptions: executorOptions,
+		},
+	}
+
+	err = e.Prepare(common.ExecutorPrepareOptions{
+		Config:  runnerConfig,
+		Build:   build,
+		Context: context.TODO(),
+	})
+	require.NoError(t, err)
+	assert.Equal(t, address, build.Hostname)
+	assert.Equal(t, "127.0.0.1", e.sshCommand.Host)
+	assert.Equal(t, server.Port(), e.sshCommand.Port)
+}
+
+func TestPrepareWithPoolWithoutHost(t *testing.T) {
+	e := &executor{
+		AbstractExecutor: executors.AbstractExecutor{
+			ExecutorOptions: executorOptions,
+		},
+	}
+
+	err := e.Prepare(common.ExecutorPrepareOptions{
+		Config:  newPoolConfig(common.SSHPoolConfig{Hosts: []string{"host-1"}}),
+		Build:   &common.Build{Runner: &common.RunnerConfig{}},
+		Context: context.TODO(),
+	})
+	assert.EqualError(t, err, "no SSH host of the pool acquired for the job")
+}
@@ -12,15 +12,31 @@ import (
 type executor struct {
 	executors.AbstractExecutor
 	sshCommand ssh.Client
+
+	// hostSlot is the host of the job, when the hosts pool is configured
+	hostSlot *hostSlot
 }
 
 func (s *executor) Prepare(options common.ExecutorPrepareOptions) error {
+	if options.Config.SSHPool != nil {
+		s.hostSlot, _ = options.Build.ExecutorData.(*hostSlot)
+		if s.hostSlot == nil {
+			return errors.New("no SSH host of the pool acquired for the job")
+		}
+
+		options.Build.Hostname = s.hostSlot.host.address
+	}
+
 	err := s.AbstractExecutor.Prepare(options)
 	if err != nil {
 		return fmt.Errorf("prearing AbstractExecutor: %w", err)
 	}
 
-	s.Println("Using SSH executor...")
+	if s.hostSlot != nil {
+		s.Println("Using SSH executor on host", s.hostSlot.host.address+"...")
+	} else {
+		s.Println("Using SSH executor...")
+	}
 	if s.BuildShell.PassFile {
 		return errors.New("SSH doesn't support shells that require script file")
 	}
@@ -31,16 +47,28 @@ func (s *executor) Prepare(options common.ExecutorPrepareOptions) error {
 
 	s.Debugln("Starting SSH command...")
 
-	// Create SSH command
+	config := *s.Config.SSH
+	if s.hostSlot != nil {
+		config = s.hostSlot.host.config(config)
+	}
+
+	// Create SSH command, its connection is shared with the other jobs only
+	// when it's reused
 	s.sshCommand = ssh.Client{
-		Config: *s.Config.SSH,
-		Stdout: s.Trace,
-		Stderr: s.Trace,
+		Config:  config,
+		Stdout:  s.Trace,
+		Stderr:  s.Trace,
+		Manager: ssh.DefaultConnectionManager,
+		Scope:   s.Build.ProjectUniqueName(),
 	}
 
 	s.Debugln("Connecting to SSH server...")
 	err = s.sshCommand.Connect()
 	if err != nil {
+		if s.hostSlot != nil {
+			s.hostSlot.pool.markUnhealthy(s.hostSlot.host)
+		}
+
 		return fmt.Errorf("ssh command Connect() error: %w", err)
 	}
 
@@ -91,9 +119,12 @@ func init() {
 		features.Shared = true
 	}
 
-	common.RegisterExecutorProvider("ssh", executors.DefaultExecutorProvider{
-		Creator:          creator,
-		FeaturesUpdater:  featuresUpdater,
-		DefaultShellName: options.Shell.Shell,
+	common.RegisterExecutorProvider("ssh", &executorProvider{
+		DefaultExecutorProvider: executors.DefaultExecutorProvider{
+			Creator:          creator,
+			FeaturesUpdater:  featuresUpdater,
+			DefaultShellName: options.Shell.Shell,
+		},
+		pools: make(map[string]*hostPool),
 	})
 }
@@ -16,32 +16,44 @@ type executor struct {
 	vm.Executor
 	vmName          string
 	sshCommand      ssh.Client
+	verifyCommand   ssh.Client
 	sshPort         string
 	provisioned     bool
 	machineVerified bool
 }
 
+func (s *executor) newSSHCommand(sshPort string) ssh.Client {
+	sshCommand := ssh.Client{
+		Config:  *s.Config.SSH,
+		Stdout:  s.Trace,
+		Stderr:  s.Trace,
+		Manager: ssh.DefaultConnectionManager,
+		Scope:   s.Build.ProjectUniqueName(),
+	}
+	sshCommand.Port = sshPort
+	sshCommand.Host = "localhost"
+	// the VM is stopped after the job
+	sshCommand.ReuseConnection = false
+
+	return sshCommand
+}
+
 func (s *executor) verifyMachine(sshPort string) error {
 	if s.machineVerified {
 		return nil
 	}
 
-	// Create SSH command
-	sshCommand := ssh.Client{
-		Config:         *s.Config.SSH,
-		Stdout:         s.Trace,
-		Stderr:         s.Trace,
-		ConnectRetries: 30,
-	}
-	sshCommand.Port = sshPort
-	sshCommand.Host = "localhost"
+	// Create SSH command, its connection is shared with the job
+	sshCommand := s.newSSHCommand(sshPort)
+	sshCommand.ConnectRetries = 30
 
 	s.Debugln("Connecting to SSH...")
 	err := sshCommand.Connect()
 	if err != nil {
 		return err
 	}
-	defer sshCommand.Cleanup()
+	s.verifyCommand = sshCommand
+
 	err = sshCommand.Run(s.Context, ssh.Command{Command: "exit"})
 	if err != nil {
 		return err
@@ -303,13 +315,10 @@ func (s *executor) ensureVMStarted() error {
 
 func (s *executor) sshConnect() error {
 	s.Println("Starting SSH command...")
-	s.sshCommand = ssh.Client{
-		Config: *s.Config.SSH,
-		Stdout: s.Trace,
-		Stderr: s.Trace,
-	}
-	s.sshCommand.Port = s.sshPort
-	s.sshCommand.Host = "localhost"
+	s.sshCommand = s.newSSHCommand(s.sshPort)
+
+	// the connection of the machine verification is released once shared
+	defer s.verifyCommand.Cleanup()
 
 	s.Debugln("Connecting to SSH server...")
 	return s.sshCommand.Connect()
@@ -328,6 +337,7 @@ func (s *executor) Run(cmd common.ExecutorCommand) error {
 }
 
 func (s *executor) Cleanup() {
+	s.verifyCommand.Cleanup()
 	s.sshCommand.Cleanup()
 
 	if s.vmName != "" {
@@ -21,6 +21,7 @@ require (
 	github.com/dvyukov/go-fuzz v0.0.0-20210914135545-4980593459a1
 	github.com/elazarl/go-bindata-assetfs v1.0.1 // indirect
 	github.com/elazarl/goproxy v0.0.0-20191011121108-aa519ddbe484 // indirect
+	github.com/evanphx/json-patch v4.12.0+incompatible
 	github.com/fullsailor/pkcs7 v0.0.0-20190404230743-d7302db945fa
 	github.com/getsentry/sentry-go v0.11.0
 	github.com/golang/mock v1.4.4
@@ -73,6 +74,7 @@ require (
 	k8s.io/api v0.21.1
 	k8s.io/apimachinery v0.21.1
 	k8s.io/client-go v0.21.1
+	sigs.k8s.io/yaml v1.2.0
 )
 
 require (
@@ -138,9 +140,9 @@ require (
 	gopkg.in/inf.v0 v0.9.1 // indirect
 	gopkg.in/square/go-jose.v2 v2.3.1 // indirect
 	k8s.io/klog/v2 v2.8.0 // indirect
+	k8s.io/kube-openapi v0.0.0-20210305001622-591a79e4bda7 // indirect
 	k8s.io/utils v0.0.0-20201110183641-67b214c5f920 // indirect
 	sigs.k8s.io/structured-merge-diff/v4 v4.1.0 // indirect
-	sigs.k8s.io/yaml v1.2.0 // indirect
 )
 
 replace golang.org/x/sys => golang.org/x/sys v0.0.0-20220209214540-3681064d5158
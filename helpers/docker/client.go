@@ -23,6 +23,12 @@ type Client interface {
 		ref string,
 		options types.ImageImportOptions,
 	) error
+	ImageList(ctx context.Context, options types.ImageListOptions) ([]types.ImageSummary, error)
+	ImageRemove(
+		ctx context.Context,
+		imageID string,
+		options types.ImageRemoveOptions,
+	) ([]types.ImageDeleteResponseItem, error)
 
 	ContainerList(ctx context.Context, options types.ContainerListOptions) ([]types.Container, error)
 	ContainerCreate(
@@ -41,6 +47,8 @@ type Client interface {
 		options types.ContainerAttachOptions,
 	) (types.HijackedResponse, error)
 	ContainerRemove(ctx context.Context, containerID string, options types.ContainerRemoveOptions) error
+	ContainerRename(ctx context.Context, containerID string, newContainerName string) error
+	ContainerCommit(ctx context.Context, containerID string, options types.ContainerCommitOptions) (types.IDResponse, error)
 	ContainerWait(
 		ctx context.Context,
 		containerID string,
@@ -56,6 +64,7 @@ type Client interface {
 		options types.NetworkCreate,
 	) (types.NetworkCreateResponse, error)
 	NetworkRemove(ctx context.Context, networkID string) error
+	NetworkConnect(ctx context.Context, networkID, containerID string, config *network.EndpointSettings) error
 	NetworkDisconnect(ctx context.Context, networkID, containerID string, force bool) error
 	NetworkList(ctx context.Context, options types.NetworkListOptions) ([]types.NetworkResource, error)
 	NetworkInspect(ctx context.Context, networkID string) (types.NetworkResource, error)
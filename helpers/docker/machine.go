@@ -15,3 +15,9 @@ type Machine interface {
 	CanConnect(name string, skipCache bool) bool
 	Credentials(name string) (Credentials, error)
 }
+
+// MachineDiskSpace is implemented by the Machine backends able to check
+// the free disk space of a machine
+type MachineDiskSpace interface {
+	DiskFree(name, path string) (uint64, error)
+}
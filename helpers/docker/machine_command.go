@@ -10,6 +10,7 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -219,6 +220,33 @@ func (m *machineCommand) Status(name string) (string, error) {
 	return m.get("status", name)
 }
 
+// DiskFree returns the number of bytes available on the filesystem of the
+// path on the machine
+func (m *machineCommand) DiskFree(name, path string) (uint64, error) {
+	out, err := m.get("ssh", name, "df", "-Pk", path)
+	if err != nil {
+		return 0, err
+	}
+
+	return parseDiskFree(out)
+}
+
+// parseDiskFree reads the available space from the POSIX output of `df -Pk`
+func parseDiskFree(out string) (uint64, error) {
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	fields := strings.Fields(lines[len(lines)-1])
+	if len(lines) < 2 || len(fields) < 6 {
+		return 0, fmt.Errorf("unexpected df output: %q", out)
+	}
+
+	available, err := strconv.ParseUint(fields[3], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("unexpected df output: %w", err)
+	}
+
+	return available * 1024, nil
+}
+
 func (m *machineCommand) Exist(name string) bool {
 	configPath := filepath.Join(mcndirs.GetMachineDir(), name, "config.json")
 	_, err := os.Stat(configPath)
@@ -157,3 +157,44 @@ func TestNewDockerMachineCommandCtx(t *testing.T) {
 		})
 	}
 }
+
+func TestParseDiskFree(t *testing.T) {
+	tests := map[string]struct {
+		out           string
+		expected      uint64
+		expectedError bool
+	}{
+		"valid output": {
+			out: `Filesystem     1024-blocks    Used Available Capacity Mounted on
+/dev/sda1         25227048 9071152  16139512      36% /var/lib/docker`,
+			expected: 16139512 * 1024,
+		},
+		"long device name": {
+			out: `Filesystem                                    1024-blocks  Used Available Capacity Mounted on
+/dev/disk/by-uuid/5d9b5b6e-0000-4c2b-a1f5-000000000000    1000    900       100      90% /`,
+			expected: 100 * 1024,
+		},
+		"header only": {
+			out:           "Filesystem     1024-blocks    Used Available Capacity Mounted on",
+			expectedError: true,
+		},
+		"invalid number": {
+			out: `Filesystem     1024-blocks    Used Available Capacity Mounted on
+/dev/sda1         25227048 9071152  unknown      36% /`,
+			expectedError: true,
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			free, err := parseDiskFree(tt.out)
+			if tt.expectedError {
+				assert.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, free)
+		})
+	}
+}
@@ -74,6 +74,27 @@ func (_m *MockClient) ContainerAttach(ctx context.Context, _a1 string, options t
 	return r0, r1
 }
 
+// ContainerCommit provides a mock function with given fields: ctx, containerID, options
+func (_m *MockClient) ContainerCommit(ctx context.Context, containerID string, options types.ContainerCommitOptions) (types.IDResponse, error) {
+	ret := _m.Called(ctx, containerID, options)
+
+	var r0 types.IDResponse
+	if rf, ok := ret.Get(0).(func(context.Context, string, types.ContainerCommitOptions) types.IDResponse); ok {
+		r0 = rf(ctx, containerID, options)
+	} else {
+		r0 = ret.Get(0).(types.IDResponse)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string, types.ContainerCommitOptions) error); ok {
+		r1 = rf(ctx, containerID, options)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // ContainerCreate provides a mock function with given fields: ctx, config, hostConfig, networkingConfig, containerName
 func (_m *MockClient) ContainerCreate(ctx context.Context, config *container.Config, hostConfig *container.HostConfig, networkingConfig *network.NetworkingConfig, containerName string) (container.ContainerCreateCreatedBody, error) {
 	ret := _m.Called(ctx, config, hostConfig, networkingConfig, containerName)
@@ -232,6 +253,20 @@ func (_m *MockClient) ContainerRemove(ctx context.Context, containerID string, o
 	return r0
 }
 
+// ContainerRename provides a mock function with given fields: ctx, containerID, newContainerName
+func (_m *MockClient) ContainerRename(ctx context.Context, containerID string, newContainerName string) error {
+	ret := _m.Called(ctx, containerID, newContainerName)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, string) error); ok {
+		r0 = rf(ctx, containerID, newContainerName)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
 // ContainerStart provides a mock function with given fields: ctx, containerID, options
 func (_m *MockClient) ContainerStart(ctx context.Context, containerID string, options types.ContainerStartOptions) error {
 	ret := _m.Called(ctx, containerID, options)
@@ -329,6 +364,29 @@ func (_m *MockClient) ImageInspectWithRaw(ctx context.Context, imageID string) (
 	return r0, r1, r2
 }
 
+// ImageList provides a mock function with given fields: ctx, options
+func (_m *MockClient) ImageList(ctx context.Context, options types.ImageListOptions) ([]types.ImageSummary, error) {
+	ret := _m.Called(ctx, options)
+
+	var r0 []types.ImageSummary
+	if rf, ok := ret.Get(0).(func(context.Context, types.ImageListOptions) []types.ImageSummary); ok {
+		r0 = rf(ctx, options)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]types.ImageSummary)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, types.ImageListOptions) error); ok {
+		r1 = rf(ctx, options)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // ImagePullBlocking provides a mock function with given fields: ctx, ref, options
 func (_m *MockClient) ImagePullBlocking(ctx context.Context, ref string, options types.ImagePullOptions) error {
 	ret := _m.Called(ctx, ref, options)
@@ -343,6 +401,29 @@ func (_m *MockClient) ImagePullBlocking(ctx context.Context, ref string, options
 	return r0
 }
 
+// ImageRemove provides a mock function with given fields: ctx, imageID, options
+func (_m *MockClient) ImageRemove(ctx context.Context, imageID string, options types.ImageRemoveOptions) ([]types.ImageDeleteResponseItem, error) {
+	ret := _m.Called(ctx, imageID, options)
+
+	var r0 []types.ImageDeleteResponseItem
+	if rf, ok := ret.Get(0).(func(context.Context, string, types.ImageRemoveOptions) []types.ImageDeleteResponseItem); ok {
+		r0 = rf(ctx, imageID, options)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]types.ImageDeleteResponseItem)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string, types.ImageRemoveOptions) error); ok {
+		r1 = rf(ctx, imageID, options)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // Info provides a mock function with given fields: ctx
 func (_m *MockClient) Info(ctx context.Context) (types.Info, error) {
 	ret := _m.Called(ctx)
@@ -364,6 +445,20 @@ func (_m *MockClient) Info(ctx context.Context) (types.Info, error) {
 	return r0, r1
 }
 
+// NetworkConnect provides a mock function with given fields: ctx, networkID, containerID, config
+func (_m *MockClient) NetworkConnect(ctx context.Context, networkID string, containerID string, config *network.EndpointSettings) error {
+	ret := _m.Called(ctx, networkID, containerID, config)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, string, *network.EndpointSettings) error); ok {
+		r0 = rf(ctx, networkID, containerID, config)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
 // NetworkCreate provides a mock function with given fields: ctx, networkName, options
 func (_m *MockClient) NetworkCreate(ctx context.Context, networkName string, options types.NetworkCreate) (types.NetworkCreateResponse, error) {
 	ret := _m.Called(ctx, networkName, options)
@@ -182,6 +182,26 @@ func (c *officialDockerClient) ContainerRemove(
 	return wrapError("ContainerRemove", err, started)
 }
 
+func (c *officialDockerClient) ContainerRename(
+	ctx context.Context,
+	containerID string,
+	newContainerName string,
+) error {
+	started := time.Now()
+	err := c.client.ContainerRename(ctx, containerID, newContainerName)
+	return wrapError("ContainerRename", err, started)
+}
+
+func (c *officialDockerClient) ContainerCommit(
+	ctx context.Context,
+	containerID string,
+	options types.ContainerCommitOptions,
+) (types.IDResponse, error) {
+	started := time.Now()
+	resp, err := c.client.ContainerCommit(ctx, containerID, options)
+	return resp, wrapError("ContainerCommit", err, started)
+}
+
 func (c *officialDockerClient) ContainerWait(
 	ctx context.Context,
 	containerID string,
@@ -236,6 +256,17 @@ func (c *officialDockerClient) NetworkRemove(ctx context.Context, networkID stri
 	return wrapError("NetworkRemove", err, started)
 }
 
+func (c *officialDockerClient) NetworkConnect(
+	ctx context.Context,
+	networkID string,
+	containerID string,
+	config *network.EndpointSettings,
+) error {
+	started := time.Now()
+	err := c.client.NetworkConnect(ctx, networkID, containerID, config)
+	return wrapError("NetworkConnect", err, started)
+}
+
 func (c *officialDockerClient) NetworkDisconnect(ctx context.Context, networkID, containerID string, force bool) error {
 	started := time.Now()
 	err := c.client.NetworkDisconnect(ctx, networkID, containerID, force)
@@ -299,6 +330,25 @@ func (c *officialDockerClient) ImageImportBlocking(
 	return wrapError("ImageImport", c.handleEventStream(rc), started)
 }
 
+func (c *officialDockerClient) ImageList(
+	ctx context.Context,
+	options types.ImageListOptions,
+) ([]types.ImageSummary, error) {
+	started := time.Now()
+	images, err := c.client.ImageList(ctx, options)
+	return images, wrapError("ImageList", err, started)
+}
+
+func (c *officialDockerClient) ImageRemove(
+	ctx context.Context,
+	imageID string,
+	options types.ImageRemoveOptions,
+) ([]types.ImageDeleteResponseItem, error) {
+	started := time.Now()
+	items, err := c.client.ImageRemove(ctx, imageID, options)
+	return items, wrapError("ImageRemove", err, started)
+}
+
 func (c *officialDockerClient) ImagePullBlocking(
 	ctx context.Context,
 	ref string,
@@ -0,0 +1,161 @@
+package egress
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
+
+	"gitlab.com/gitlab-org/gitlab-runner/helpers/dns"
+)
+
+// Policy describes the destinations that a job is allowed to connect to.
+// It's passed to the egress proxy serialized as JSON.
+type Policy struct {
+	// Allowed is a list of CIDRs, IP addresses and hostnames that all containers of the job can connect to.
+	// Hostnames may start with "*." to match all subdomains.
+	Allowed []string `json:"allowed"`
+	// Exceptions maps the credential of a service container to the additional destinations it can connect to.
+	// The runner passes the credential only to the containers started from an image that has an exception,
+	// and they send it to the proxy in the Proxy-Authorization header.
+	Exceptions map[string][]string `json:"exceptions,omitempty"`
+}
+
+// ParsePolicy decodes and validates a JSON encoded policy
+func ParsePolicy(data string) (Policy, error) {
+	var policy Policy
+
+	err := json.Unmarshal([]byte(data), &policy)
+	if err != nil {
+		return Policy{}, fmt.Errorf("decoding egress policy: %w", err)
+	}
+
+	return policy, policy.Validate()
+}
+
+// Encode returns the JSON representation of the policy
+func (p Policy) Encode() (string, error) {
+	data, err := json.Marshal(p)
+	if err != nil {
+		return "", fmt.Errorf("encoding egress policy: %w", err)
+	}
+
+	return string(data), nil
+}
+
+// Validate checks whether every destination of the policy can be compiled
+func (p Policy) Validate() error {
+	_, err := Compile(p.Allowed)
+	if err != nil {
+		return err
+	}
+
+	for credential, allowed := range p.Exceptions {
+		if credential == "" {
+			return fmt.Errorf("egress policy exception without a credential")
+		}
+
+		_, err = Compile(allowed)
+		if err != nil {
+			return fmt.Errorf("egress policy exception: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// Rules is a compiled list of allowed destinations
+type Rules struct {
+	networks []*net.IPNet
+	hosts    []string
+	suffixes []string
+}
+
+// Compile parses a list of CIDRs, IP addresses and hostnames into Rules
+func Compile(allowed []string) (*Rules, error) {
+	rules := new(Rules)
+
+	for _, entry := range allowed {
+		err := rules.add(strings.TrimSpace(entry))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return rules, nil
+}
+
+func (r *Rules) add(entry string) error {
+	if _, ipNet, err := net.ParseCIDR(entry); err == nil {
+		r.networks = append(r.networks, ipNet)
+		return nil
+	}
+
+	if ip := net.ParseIP(entry); ip != nil {
+		bits := 8 * net.IPv6len
+		if ip.To4() != nil {
+			ip = ip.To4()
+			bits = 8 * net.IPv4len
+		}
+
+		r.networks = append(r.networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+		return nil
+	}
+
+	host := strings.ToLower(entry)
+	if strings.HasPrefix(host, "*.") {
+		suffix := strings.TrimPrefix(host, "*.")
+		if err := dns.ValidateDNS1123Subdomain(suffix); err != nil {
+			return fmt.Errorf("invalid egress destination %q: %w", entry, err)
+		}
+
+		r.suffixes = append(r.suffixes, "."+suffix)
+		return nil
+	}
+
+	if err := dns.ValidateDNS1123Subdomain(host); err != nil {
+		return fmt.Errorf("invalid egress destination %q: %w", entry, err)
+	}
+
+	r.hosts = append(r.hosts, host)
+
+	return nil
+}
+
+// AllowsHost checks whether the hostname is explicitly allowed
+func (r *Rules) AllowsHost(host string) bool {
+	if r == nil {
+		return false
+	}
+
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+
+	for _, h := range r.hosts {
+		if h == host {
+			return true
+		}
+	}
+
+	for _, suffix := range r.suffixes {
+		if strings.HasSuffix(host, suffix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// AllowsIP checks whether the IP address belongs to one of the allowed networks
+func (r *Rules) AllowsIP(ip net.IP) bool {
+	if r == nil {
+		return false
+	}
+
+	for _, n := range r.networks {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
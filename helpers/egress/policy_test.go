@@ -0,0 +1,87 @@
+//go:build !integration
+// +build !integration
+
+package egress
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompile(t *testing.T) {
+	tests := map[string]struct {
+		allowed     []string
+		expectedErr bool
+	}{
+		"empty": {},
+		"valid destinations": {
+			allowed: []string{"10.0.0.0/8", "192.0.2.1", "2001:db8::/32", "gitlab.example.com", "*.example.org"},
+		},
+		"invalid hostname": {
+			allowed:     []string{"not a hostname"},
+			expectedErr: true,
+		},
+		"invalid wildcard": {
+			allowed:     []string{"*.-invalid"},
+			expectedErr: true,
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			_, err := Compile(tt.allowed)
+			if tt.expectedErr {
+				assert.Error(t, err)
+				return
+			}
+
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestRules(t *testing.T) {
+	rules, err := Compile([]string{"10.0.0.0/8", "192.0.2.1", "gitlab.example.com", "*.example.org"})
+	require.NoError(t, err)
+
+	assert.True(t, rules.AllowsIP(net.ParseIP("10.1.2.3")))
+	assert.True(t, rules.AllowsIP(net.ParseIP("192.0.2.1")))
+	assert.False(t, rules.AllowsIP(net.ParseIP("192.0.2.2")))
+	assert.False(t, rules.AllowsIP(net.ParseIP("2001:db8::1")))
+
+	assert.True(t, rules.AllowsHost("gitlab.example.com"))
+	assert.True(t, rules.AllowsHost("GitLab.Example.com."))
+	assert.True(t, rules.AllowsHost("registry.example.org"))
+	assert.False(t, rules.AllowsHost("example.org"))
+	assert.False(t, rules.AllowsHost("example.com"))
+
+	var nilRules *Rules
+	assert.False(t, nilRules.AllowsHost("gitlab.example.com"))
+	assert.False(t, nilRules.AllowsIP(net.ParseIP("10.1.2.3")))
+}
+
+func TestPolicy_EncodeParse(t *testing.T) {
+	policy := Policy{
+		Allowed:    []string{"10.0.0.0/8"},
+		Exceptions: map[string][]string{"service-credential": {"packages.example.com"}},
+	}
+
+	data, err := policy.Encode()
+	require.NoError(t, err)
+
+	parsed, err := ParsePolicy(data)
+	require.NoError(t, err)
+	assert.Equal(t, policy, parsed)
+
+	_, err = ParsePolicy(`{"allowed": ["not valid"]}`)
+	assert.Error(t, err)
+
+	_, err = ParsePolicy(`{"exceptions": {"": ["10.0.0.0/8"]}}`)
+	assert.Error(t, err)
+
+	_, err = ParsePolicy(`not json`)
+	assert.Error(t, err)
+}
@@ -0,0 +1,314 @@
+package egress
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	dialTimeout = 30 * time.Second
+
+	// ExceptionUser is the user name of the proxy URL of the services that
+	// have an exception, the password being their credential
+	ExceptionUser = "service"
+)
+
+var ErrDestinationNotAllowed = errors.New("destination not allowed by the egress policy")
+
+type resolver interface {
+	LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error)
+}
+
+type dialer interface {
+	DialContext(ctx context.Context, network, address string) (net.Conn, error)
+}
+
+// Proxy is an HTTP proxy that only lets connections through to the
+// destinations allowed by a Policy. It supports both the CONNECT method,
+// which is used for HTTPS and any other TCP traffic, and plain HTTP requests.
+type Proxy struct {
+	logger   logrus.FieldLogger
+	resolver resolver
+	dialer   dialer
+
+	allowed    *Rules
+	exceptions map[string]*Rules
+}
+
+func NewProxy(policy Policy, logger logrus.FieldLogger) (*Proxy, error) {
+	allowed, err := Compile(policy.Allowed)
+	if err != nil {
+		return nil, err
+	}
+
+	exceptions := make(map[string]*Rules, len(policy.Exceptions))
+	for credential, destinations := range policy.Exceptions {
+		rules, err := Compile(destinations)
+		if err != nil {
+			return nil, fmt.Errorf("egress policy exception: %w", err)
+		}
+
+		exceptions[credential] = rules
+	}
+
+	return &Proxy{
+		logger:     logger,
+		resolver:   net.DefaultResolver,
+		dialer:     &net.Dialer{Timeout: dialTimeout},
+		allowed:    allowed,
+		exceptions: exceptions,
+	}, nil
+}
+
+func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodConnect {
+		p.serveConnect(w, r)
+		return
+	}
+
+	if r.URL.Host == "" {
+		http.Error(w, "only proxy requests are supported", http.StatusBadRequest)
+		return
+	}
+
+	p.serveHTTP(w, r)
+}
+
+func (p *Proxy) serveConnect(w http.ResponseWriter, r *http.Request) {
+	upstream, err := p.dial(r.Context(), p.rulesForClient(r), r.Host)
+	if err != nil {
+		p.writeDialError(w, r, err)
+		return
+	}
+
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		_ = upstream.Close()
+		http.Error(w, "connection hijacking not supported", http.StatusInternalServerError)
+		return
+	}
+
+	client, buf, err := hijacker.Hijack()
+	if err != nil {
+		_ = upstream.Close()
+		p.logger.WithError(err).Errorln("Failed to hijack connection")
+		return
+	}
+
+	_, err = client.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n"))
+	if err != nil {
+		_ = client.Close()
+		_ = upstream.Close()
+		return
+	}
+
+	go pipe(upstream, buf)
+	pipe(client, upstream)
+}
+
+func pipe(dst io.WriteCloser, src io.Reader) {
+	_, _ = io.Copy(dst, src)
+	_ = dst.Close()
+}
+
+func (p *Proxy) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	host := r.URL.Host
+	if r.URL.Port() == "" {
+		port := "80"
+		if r.URL.Scheme == "https" {
+			port = "443"
+		}
+
+		host = net.JoinHostPort(r.URL.Hostname(), port)
+	}
+
+	upstream, err := p.dial(r.Context(), p.rulesForClient(r), host)
+	if err != nil {
+		p.writeDialError(w, r, err)
+		return
+	}
+
+	transport := &http.Transport{
+		DisableKeepAlives: true,
+		DialContext: func(context.Context, string, string) (net.Conn, error) {
+			return upstream, nil
+		},
+	}
+	defer transport.CloseIdleConnections()
+
+	outReq := r.Clone(r.Context())
+	outReq.RequestURI = ""
+	outReq.Header.Del("Proxy-Connection")
+	outReq.Header.Del("Proxy-Authorization")
+
+	resp, err := transport.RoundTrip(outReq)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	for key, values := range resp.Header {
+		for _, value := range values {
+			w.Header().Add(key, value)
+		}
+	}
+
+	w.WriteHeader(resp.StatusCode)
+	_, _ = io.Copy(w, resp.Body)
+}
+
+func (p *Proxy) writeDialError(w http.ResponseWriter, r *http.Request, err error) {
+	logger := p.logger.WithFields(logrus.Fields{
+		"client":      r.RemoteAddr,
+		"destination": r.Host,
+	})
+
+	if errors.Is(err, ErrDestinationNotAllowed) {
+		logger.Warningln("Blocked connection")
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	logger.WithError(err).Warningln("Failed to connect")
+	http.Error(w, err.Error(), http.StatusBadGateway)
+}
+
+// dial connects to the address when it's allowed by the rules. Hostnames
+// are resolved by the proxy itself and only the allowed IP addresses are
+// dialed, so that a DNS answer can't be used to escape the policy.
+func (p *Proxy) dial(ctx context.Context, rules ruleSet, address string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, fmt.Errorf("invalid destination %q: %w", address, err)
+	}
+
+	if ip := net.ParseIP(host); ip != nil {
+		if !rules.allowsIP(ip) {
+			return nil, fmt.Errorf("%w: %s", ErrDestinationNotAllowed, address)
+		}
+
+		return p.dialer.DialContext(ctx, "tcp", address)
+	}
+
+	hostAllowed := rules.allowsHost(host)
+
+	addrs, err := p.resolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		if !hostAllowed {
+			return nil, fmt.Errorf("%w: %s", ErrDestinationNotAllowed, address)
+		}
+
+		return nil, fmt.Errorf("resolving %q: %w", host, err)
+	}
+
+	var lastErr error = fmt.Errorf("%w: %s", ErrDestinationNotAllowed, address)
+	for _, addr := range addrs {
+		if !hostAllowed && !rules.allowsIP(addr.IP) {
+			continue
+		}
+
+		conn, err := p.dialer.DialContext(ctx, "tcp", net.JoinHostPort(addr.IP.String(), port))
+		if err == nil {
+			return conn, nil
+		}
+
+		lastErr = err
+	}
+
+	return nil, lastErr
+}
+
+// rulesForClient returns the rules applying to the client. Services with an
+// exception are identified by the credential that the runner passed only to
+// the containers started from the excepted image, and not by their network
+// address or alias, which the job controls.
+func (p *Proxy) rulesForClient(r *http.Request) ruleSet {
+	rules := ruleSet{p.allowed}
+
+	credential, ok := proxyCredential(r)
+	if !ok {
+		return rules
+	}
+
+	for exceptionCredential, exception := range p.exceptions {
+		if subtle.ConstantTimeCompare([]byte(credential), []byte(exceptionCredential)) == 1 {
+			rules = append(rules, exception)
+		}
+	}
+
+	return rules
+}
+
+// proxyCredential returns the password of the Basic Proxy-Authorization
+// header of the request
+func proxyCredential(r *http.Request) (string, bool) {
+	authorization := r.Header.Get("Proxy-Authorization")
+	if authorization == "" {
+		return "", false
+	}
+
+	// the Basic authentication of the Authorization header is parsed the same way
+	_, password, ok := (&http.Request{Header: http.Header{"Authorization": {authorization}}}).BasicAuth()
+
+	return password, ok && password != ""
+}
+
+type ruleSet []*Rules
+
+func (s ruleSet) allowsHost(host string) bool {
+	for _, r := range s {
+		if r.AllowsHost(host) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (s ruleSet) allowsIP(ip net.IP) bool {
+	for _, r := range s {
+		if r.AllowsIP(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ProxyURL returns the URL of the egress proxy that passes the credential of
+// a service exception, when one is given
+func ProxyURL(host string, port int, credential string) string {
+	u := url.URL{Scheme: "http", Host: net.JoinHostPort(host, strconv.Itoa(port))}
+	if credential != "" {
+		u.User = url.UserPassword(ExceptionUser, credential)
+	}
+
+	return u.String()
+}
+
+// ProxyEnvironment returns the environment variables that point the common
+// tools to the egress proxy
+func ProxyEnvironment(proxyURL string, noProxy []string) []string {
+	noProxyValue := strings.Join(append([]string{"localhost", "127.0.0.1"}, noProxy...), ",")
+
+	return []string{
+		"HTTP_PROXY=" + proxyURL,
+		"HTTPS_PROXY=" + proxyURL,
+		"http_proxy=" + proxyURL,
+		"https_proxy=" + proxyURL,
+		"NO_PROXY=" + noProxyValue,
+		"no_proxy=" + noProxyValue,
+	}
+}
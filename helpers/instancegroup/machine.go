@@ -0,0 +1,126 @@
+package instancegroup
+
+import (
+	"fmt"
+	"time"
+
+	"gitlab.com/gitlab-org/gitlab-runner/helpers/docker"
+)
+
+// Machine exposes an InstanceGroup as docker.Machine, so that the autoscaling
+// provider can manage the instances of a plugin the same way it manages the
+// machines created with docker-machine
+type Machine struct {
+	group InstanceGroup
+}
+
+func NewMachine(group InstanceGroup) *Machine {
+	return &Machine{group: group}
+}
+
+// Create asks the plugin for a new instance. The driver and the options are
+// specific to docker-machine and are not used.
+func (m *Machine) Create(_, name string, _ ...string) error {
+	created, err := m.group.Increase([]string{name})
+	if err != nil {
+		return err
+	}
+
+	if !contains(created, name) {
+		return fmt.Errorf("instance %q was not created", name)
+	}
+
+	return nil
+}
+
+// Provision does nothing, the instances returned by the plugin are always
+// ready to be used
+func (m *Machine) Provision(string) error {
+	return nil
+}
+
+func (m *Machine) Remove(name string) error {
+	removed, err := m.group.Decrease([]string{name})
+	if err != nil {
+		return err
+	}
+
+	if !contains(removed, name) {
+		return fmt.Errorf("instance %q was not removed", name)
+	}
+
+	return nil
+}
+
+// Stop does nothing, the instances are stopped by the plugin while being removed
+func (m *Machine) Stop(string, time.Duration) error {
+	return nil
+}
+
+func (m *Machine) List() ([]string, error) {
+	instances, err := m.group.List()
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(instances))
+	for _, instance := range instances {
+		if instance.State == InstanceStateRemoving {
+			continue
+		}
+
+		names = append(names, instance.Name)
+	}
+
+	return names, nil
+}
+
+func (m *Machine) Exist(name string) bool {
+	instances, err := m.group.List()
+	if err != nil {
+		return false
+	}
+
+	for _, instance := range instances {
+		if instance.Name == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// CanConnect checks whether the plugin can provide the connection details
+// of the instance. The plugin is always asked, so skipCache has no effect.
+func (m *Machine) CanConnect(name string, _ bool) bool {
+	_, err := m.group.ConnectInfo(name)
+
+	return err == nil
+}
+
+func (m *Machine) Credentials(name string) (docker.Credentials, error) {
+	info, err := m.group.ConnectInfo(name)
+	if err != nil {
+		return docker.Credentials{}, err
+	}
+
+	if info.Host == "" {
+		return docker.Credentials{}, fmt.Errorf("no Docker host provided for instance %q", name)
+	}
+
+	return docker.Credentials{
+		Host:      info.Host,
+		CertPath:  info.CertPath,
+		TLSVerify: info.TLSVerify,
+	}, nil
+}
+
+func contains(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,116 @@
+//go:build !integration
+// +build !integration
+
+package instancegroup
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-runner/helpers/docker"
+)
+
+func TestMachine_Create(t *testing.T) {
+	tests := map[string]struct {
+		created     []string
+		err         error
+		expectedErr string
+	}{
+		"created": {
+			created: []string{"instance"},
+		},
+		"not created": {
+			created:     []string{},
+			expectedErr: `instance "instance" was not created`,
+		},
+		"plugin error": {
+			err:         errors.New("quota exceeded"),
+			expectedErr: "quota exceeded",
+		},
+	}
+
+	for tn, tt := range tests {
+		t.Run(tn, func(t *testing.T) {
+			group := new(MockInstanceGroup)
+			defer group.AssertExpectations(t)
+
+			group.On("Increase", []string{"instance"}).
+				Return(tt.created, tt.err).
+				Once()
+
+			err := NewMachine(group).Create("driver", "instance", "--option")
+			if tt.expectedErr != "" {
+				assert.EqualError(t, err, tt.expectedErr)
+				return
+			}
+
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestMachine_Remove(t *testing.T) {
+	group := new(MockInstanceGroup)
+	defer group.AssertExpectations(t)
+
+	group.On("Decrease", []string{"instance-1"}).
+		Return([]string{"instance-1"}, nil).
+		Once()
+	group.On("Decrease", []string{"instance-2"}).
+		Return([]string{}, nil).
+		Once()
+
+	m := NewMachine(group)
+	assert.NoError(t, m.Remove("instance-1"))
+	assert.EqualError(t, m.Remove("instance-2"), `instance "instance-2" was not removed`)
+}
+
+func TestMachine_List(t *testing.T) {
+	group := new(MockInstanceGroup)
+	defer group.AssertExpectations(t)
+
+	group.On("List").
+		Return([]Instance{
+			{Name: "instance-1", State: InstanceStateRunning},
+			{Name: "instance-2", State: InstanceStateCreating},
+			{Name: "instance-3", State: InstanceStateRemoving},
+		}, nil)
+
+	m := NewMachine(group)
+
+	names, err := m.List()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"instance-1", "instance-2"}, names)
+
+	assert.True(t, m.Exist("instance-3"))
+	assert.False(t, m.Exist("instance-4"))
+}
+
+func TestMachine_Credentials(t *testing.T) {
+	group := new(MockInstanceGroup)
+	defer group.AssertExpectations(t)
+
+	group.On("ConnectInfo", "instance-1").
+		Return(ConnectInfo{Host: "tcp://10.0.0.1:2376", CertPath: "/certs", TLSVerify: true}, nil)
+	group.On("ConnectInfo", "instance-2").
+		Return(ConnectInfo{}, errors.New("not running"))
+	group.On("ConnectInfo", "instance-3").
+		Return(ConnectInfo{}, nil)
+
+	m := NewMachine(group)
+
+	credentials, err := m.Credentials("instance-1")
+	require.NoError(t, err)
+	assert.Equal(t, docker.Credentials{Host: "tcp://10.0.0.1:2376", CertPath: "/certs", TLSVerify: true}, credentials)
+	assert.True(t, m.CanConnect("instance-1", true))
+
+	_, err = m.Credentials("instance-2")
+	assert.Error(t, err)
+	assert.False(t, m.CanConnect("instance-2", false))
+
+	_, err = m.Credentials("instance-3")
+	assert.Error(t, err)
+}
@@ -0,0 +1,318 @@
+package instancegroup
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/rpc"
+	"net/rpc/jsonrpc"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"gitlab.com/gitlab-org/gitlab-runner/helpers/process"
+)
+
+// DefaultCallTimeout is the time after which a call to the plugin fails
+// when no other timeout is configured
+const DefaultCallTimeout = 5 * time.Minute
+
+const (
+	// gracefulKillTimeout is the time a stuck plugin process is given to exit
+	// after it's terminated, before it's killed
+	gracefulKillTimeout = 10 * time.Second
+	forceKillTimeout    = process.KillTimeout
+)
+
+var (
+	errCallTimeout   = errors.New("call timed out")
+	errPluginStopped = errors.New("plugin process stopped after a call timed out")
+)
+
+var (
+	newProcessCommander  = process.NewOSCmd
+	newProcessKillWaiter = process.NewOSKillWait
+)
+
+// Plugin is an InstanceGroup backed by a plugin process. The process is
+// started with the first call and started again with the next call after
+// it exits or doesn't answer a call in time.
+type Plugin struct {
+	path    string
+	args    []string
+	timeout time.Duration
+	logger  *logrus.Entry
+
+	gracefulKillTimeout time.Duration
+	forceKillTimeout    time.Duration
+
+	connect func() (*pluginProcess, error)
+
+	lock    sync.Mutex
+	client  *rpc.Client
+	process *pluginProcess
+}
+
+// pluginProcess is a started plugin process and the connection to it
+type pluginProcess struct {
+	conn io.ReadWriteCloser
+	cmd  process.Commander
+	done chan struct{}
+	err  error
+
+	// stopped is set, with the lock of the plugin, when the process is
+	// stopped because a call timed out
+	stopped bool
+}
+
+// kill terminates the process and kills it when it doesn't exit before the
+// graceful kill timeout
+func (pp *pluginProcess) kill(logger *logrus.Entry, gracefulKillTimeout, forceKillTimeout time.Duration) error {
+	if pp.cmd == nil {
+		return nil
+	}
+
+	waitCh := make(chan error, 1)
+	go func() {
+		<-pp.done
+		waitCh <- pp.err
+	}()
+
+	return newProcessKillWaiter(processLogger{logger}, gracefulKillTimeout, forceKillTimeout).
+		KillAndWait(pp.cmd, waitCh)
+}
+
+// processLogger adapts a logrus entry to the logger of the process killers
+type processLogger struct {
+	*logrus.Entry
+}
+
+func (l processLogger) WithFields(fields logrus.Fields) process.Logger {
+	return processLogger{l.Entry.WithFields(fields)}
+}
+
+func NewPlugin(path string, timeout time.Duration, args ...string) *Plugin {
+	if timeout <= 0 {
+		timeout = DefaultCallTimeout
+	}
+
+	p := &Plugin{
+		path:    path,
+		args:    args,
+		timeout: timeout,
+		logger:  logrus.WithField("plugin", path),
+
+		gracefulKillTimeout: gracefulKillTimeout,
+		forceKillTimeout:    forceKillTimeout,
+	}
+	p.connect = p.startProcess
+
+	return p
+}
+
+func (p *Plugin) startProcess() (*pluginProcess, error) {
+	stdinReader, stdinWriter, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+
+	stdoutReader, stdoutWriter, err := os.Pipe()
+	if err != nil {
+		_ = stdinReader.Close()
+		_ = stdinWriter.Close()
+		return nil, err
+	}
+
+	stderr := p.logger.WriterLevel(logrus.InfoLevel)
+
+	cmd := newProcessCommander(p.path, p.args, process.CommandOptions{
+		Stdin:  stdinReader,
+		Stdout: stdoutWriter,
+		Stderr: stderr,
+	})
+
+	err = cmd.Start()
+
+	// the ends used by the plugin process are not needed anymore
+	_ = stdinReader.Close()
+	_ = stdoutWriter.Close()
+
+	if err != nil {
+		_ = stdinWriter.Close()
+		_ = stdoutReader.Close()
+		_ = stderr.Close()
+		return nil, fmt.Errorf("starting instance group plugin: %w", err)
+	}
+
+	p.logger.WithField("pid", cmd.Process().Pid).Infoln("Instance group plugin started")
+
+	pp := &pluginProcess{
+		conn: &stdioConn{reader: stdoutReader, writer: stdinWriter},
+		cmd:  cmd,
+		done: make(chan struct{}),
+	}
+
+	go func() {
+		pp.err = cmd.Wait()
+		_ = stderr.Close()
+		p.logger.WithError(pp.err).Infoln("Instance group plugin exited")
+		close(pp.done)
+	}()
+
+	return pp, nil
+}
+
+func (p *Plugin) getClient() (*rpc.Client, *pluginProcess, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if p.client != nil {
+		return p.client, p.process, nil
+	}
+
+	pp, err := p.connect()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	p.client = jsonrpc.NewClient(pp.conn)
+	p.process = pp
+
+	return p.client, p.process, nil
+}
+
+// resetClient closes the client, so that the plugin process is started again
+// with the next call
+func (p *Plugin) resetClient(client *rpc.Client) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if p.client != client {
+		return
+	}
+
+	_ = p.client.Close()
+	p.client = nil
+	p.process = nil
+}
+
+// stopProcess closes the client and kills the stuck plugin process. The other
+// calls waiting for the process fail, as it would never answer them
+func (p *Plugin) stopProcess(client *rpc.Client, pp *pluginProcess) {
+	p.lock.Lock()
+	pp.stopped = true
+	p.lock.Unlock()
+
+	p.resetClient(client)
+
+	err := pp.kill(p.logger, p.gracefulKillTimeout, p.forceKillTimeout)
+	if errors.Is(err, &process.KillProcessError{}) {
+		p.logger.WithError(err).Warningln("Failed to kill the instance group plugin")
+	}
+}
+
+func (p *Plugin) stoppedProcess(pp *pluginProcess) bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	return pp.stopped
+}
+
+func (p *Plugin) call(method string, args interface{}, reply interface{}) error {
+	client, pp, err := p.getClient()
+	if err != nil {
+		return err
+	}
+
+	// the reply is decoded only once the call is done, so that a late
+	// answer to a timed out call can't be written into it
+	var result json.RawMessage
+	call := client.Go(serviceName+"."+method, args, &result, make(chan *rpc.Call, 1))
+
+	timer := time.NewTimer(p.timeout)
+	defer timer.Stop()
+
+	select {
+	case <-call.Done:
+		err = call.Error
+	case <-timer.C:
+		// the plugin process is stuck, it's started again with the next call
+		p.logger.WithField("method", method).Warningln("Instance group plugin call timed out")
+		p.stopProcess(client, pp)
+
+		return fmt.Errorf("instance group plugin %s: %w after %v", method, errCallTimeout, p.timeout)
+	}
+
+	if err != nil && p.stoppedProcess(pp) {
+		return fmt.Errorf("instance group plugin %s: %w", method, errPluginStopped)
+	}
+
+	if isConnectionError(err) {
+		// the plugin process is gone, it's started again with the next call
+		p.resetClient(client)
+	}
+
+	if err != nil {
+		return fmt.Errorf("instance group plugin %s: %w", method, err)
+	}
+
+	err = json.Unmarshal(result, reply)
+	if err != nil {
+		return fmt.Errorf("instance group plugin %s: decoding reply: %w", method, err)
+	}
+
+	return nil
+}
+
+func isConnectionError(err error) bool {
+	return errors.Is(err, rpc.ErrShutdown) ||
+		errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+func (p *Plugin) Increase(names []string) ([]string, error) {
+	var resp IncreaseResponse
+	err := p.call("Increase", IncreaseRequest{Names: names}, &resp)
+
+	return resp.Created, err
+}
+
+func (p *Plugin) Decrease(names []string) ([]string, error) {
+	var resp DecreaseResponse
+	err := p.call("Decrease", DecreaseRequest{Names: names}, &resp)
+
+	return resp.Removed, err
+}
+
+func (p *Plugin) List() ([]Instance, error) {
+	var resp ListResponse
+	err := p.call("List", ListRequest{}, &resp)
+
+	return resp.Instances, err
+}
+
+func (p *Plugin) ConnectInfo(name string) (ConnectInfo, error) {
+	var resp ConnectInfoResponse
+	err := p.call("ConnectInfo", ConnectInfoRequest{Name: name}, &resp)
+
+	return resp.ConnectInfo, err
+}
+
+// Close stops the plugin process by closing its standard input
+func (p *Plugin) Close() error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if p.client == nil {
+		return nil
+	}
+
+	err := p.client.Close()
+	p.client = nil
+	p.process = nil
+
+	return err
+}
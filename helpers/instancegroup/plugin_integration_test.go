@@ -0,0 +1,66 @@
+//go:build integration
+// +build integration
+
+package instancegroup_test
+
+import (
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-runner/helpers/instancegroup"
+)
+
+func buildFakePlugin(t *testing.T) string {
+	t.Helper()
+
+	binaryPath := filepath.Join(t.TempDir(), "fake-plugin")
+	if runtime.GOOS == "windows" {
+		binaryPath += ".exe"
+	}
+
+	_, currentTestFile, _, _ := runtime.Caller(0) // nolint:dogsled
+	source := filepath.Join(filepath.Dir(currentTestFile), "testdata", "fake_plugin", "main.go")
+
+	command := exec.Command("go", "build", "-o", binaryPath, source)
+	output, err := command.CombinedOutput()
+	require.NoError(t, err, string(output))
+
+	return binaryPath
+}
+
+func TestFakePlugin(t *testing.T) {
+	stateFile := filepath.Join(t.TempDir(), "state.json")
+	plugin := instancegroup.NewPlugin(
+		buildFakePlugin(t),
+		instancegroup.DefaultCallTimeout,
+		"-docker-host", "tcp://127.0.0.1:2375",
+		"-state-file", stateFile,
+		"-fail-create", "broken",
+	)
+	defer plugin.Close()
+
+	machine := instancegroup.NewMachine(plugin)
+
+	require.NoError(t, machine.Create("", "instance-1"))
+	assert.Error(t, machine.Create("", "broken-1"))
+
+	names, err := machine.List()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"instance-1"}, names)
+
+	credentials, err := machine.Credentials("instance-1")
+	require.NoError(t, err)
+	assert.Equal(t, "tcp://127.0.0.1:2375", credentials.Host)
+
+	// the instances are kept in the state file when the plugin is restarted
+	require.NoError(t, plugin.Close())
+	assert.True(t, machine.Exist("instance-1"))
+
+	require.NoError(t, machine.Remove("instance-1"))
+	assert.False(t, machine.CanConnect("instance-1", true))
+}
@@ -0,0 +1,222 @@
+//go:build !integration
+// +build !integration
+
+package instancegroup
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"runtime"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-runner/helpers/process"
+)
+
+func newTestPlugin(t *testing.T, group InstanceGroup) (*Plugin, *int) {
+	connections := 0
+
+	p := NewPlugin("fake-plugin", 0)
+	p.connect = func() (*pluginProcess, error) {
+		connections++
+
+		client, server := net.Pipe()
+		go func() {
+			assert.NoError(t, Serve(group, server))
+		}()
+
+		return &pluginProcess{conn: client}, nil
+	}
+
+	return p, &connections
+}
+
+func TestPlugin(t *testing.T) {
+	group := new(MockInstanceGroup)
+	defer group.AssertExpectations(t)
+
+	group.On("Increase", []string{"instance-1", "instance-2"}).
+		Return([]string{"instance-1"}, nil).
+		Once()
+	group.On("Decrease", []string{"instance-1"}).
+		Return([]string{"instance-1"}, nil).
+		Once()
+	group.On("List").
+		Return([]Instance{{Name: "instance-1", State: InstanceStateRunning}}, nil).
+		Once()
+	group.On("ConnectInfo", "instance-1").
+		Return(ConnectInfo{Host: "tcp://10.0.0.1:2376", TLSVerify: true}, nil).
+		Once()
+	group.On("ConnectInfo", "instance-2").
+		Return(ConnectInfo{}, errors.New("instance not found")).
+		Once()
+
+	p, connections := newTestPlugin(t, group)
+	defer p.Close()
+
+	created, err := p.Increase([]string{"instance-1", "instance-2"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"instance-1"}, created)
+
+	instances, err := p.List()
+	require.NoError(t, err)
+	assert.Equal(t, []Instance{{Name: "instance-1", State: InstanceStateRunning}}, instances)
+
+	info, err := p.ConnectInfo("instance-1")
+	require.NoError(t, err)
+	assert.Equal(t, ConnectInfo{Host: "tcp://10.0.0.1:2376", TLSVerify: true}, info)
+
+	_, err = p.ConnectInfo("instance-2")
+	assert.EqualError(t, err, "instance group plugin ConnectInfo: instance not found")
+
+	removed, err := p.Decrease([]string{"instance-1"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"instance-1"}, removed)
+
+	assert.Equal(t, 1, *connections)
+}
+
+func TestPluginReconnect(t *testing.T) {
+	group := new(MockInstanceGroup)
+	defer group.AssertExpectations(t)
+
+	group.On("List").
+		Return([]Instance{}, nil).
+		Twice()
+
+	p, connections := newTestPlugin(t, group)
+	defer p.Close()
+
+	_, err := p.List()
+	require.NoError(t, err)
+
+	// simulate the plugin process exiting
+	_ = p.client.Close()
+
+	_, err = p.List()
+	assert.Error(t, err)
+
+	_, err = p.List()
+	assert.NoError(t, err)
+
+	assert.Equal(t, 2, *connections)
+}
+
+func TestPluginCallTimeout(t *testing.T) {
+	group := new(MockInstanceGroup)
+	defer group.AssertExpectations(t)
+
+	group.On("List").
+		Return([]Instance{{Name: "instance-1", State: InstanceStateRunning}}, nil).
+		Once()
+
+	connections := 0
+	p := NewPlugin("fake-plugin", 50*time.Millisecond)
+	defer p.Close()
+
+	p.connect = func() (*pluginProcess, error) {
+		connections++
+
+		client, server := net.Pipe()
+		if connections == 1 {
+			// the first plugin process never answers
+			go func() { _, _ = io.Copy(ioutil.Discard, server) }()
+			return &pluginProcess{conn: client}, nil
+		}
+
+		go func() {
+			assert.NoError(t, Serve(group, server))
+		}()
+
+		return &pluginProcess{conn: client}, nil
+	}
+
+	_, err := p.List()
+	assert.ErrorIs(t, err, errCallTimeout)
+
+	instances, err := p.List()
+	require.NoError(t, err)
+	assert.Equal(t, []Instance{{Name: "instance-1", State: InstanceStateRunning}}, instances)
+
+	assert.Equal(t, 2, connections, "the plugin is started again after the timeout")
+}
+
+func TestNewPluginDefaultTimeout(t *testing.T) {
+	assert.Equal(t, DefaultCallTimeout, NewPlugin("fake-plugin", 0).timeout)
+	assert.Equal(t, time.Minute, NewPlugin("fake-plugin", time.Minute).timeout)
+}
+
+func TestPluginConnectFailure(t *testing.T) {
+	p := NewPlugin("fake-plugin", 0)
+	p.connect = func() (*pluginProcess, error) {
+		return nil, errors.New("no such file")
+	}
+
+	_, err := p.List()
+	assert.EqualError(t, err, "no such file")
+}
+
+func TestPluginCallTimeoutKillsProcess(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the hung plugin is a sleep command")
+	}
+
+	var cmd process.Commander
+	oldNewProcessCommander := newProcessCommander
+	defer func() { newProcessCommander = oldNewProcessCommander }()
+	newProcessCommander = func(executable string, args []string, options process.CommandOptions) process.Commander {
+		cmd = process.NewOSCmd(executable, args, options)
+		return cmd
+	}
+
+	// the plugin process never answers
+	p := NewPlugin("sleep", 50*time.Millisecond, "60")
+	p.gracefulKillTimeout = time.Second
+	defer p.Close()
+
+	_, err := p.List()
+	assert.ErrorIs(t, err, errCallTimeout)
+
+	require.NotNil(t, cmd)
+	assert.Error(t, cmd.Process().Signal(syscall.Signal(0)), "the hung plugin process is killed")
+}
+
+func TestPluginStoppedProcessFailsPendingCalls(t *testing.T) {
+	p := NewPlugin("fake-plugin", time.Minute)
+	defer p.Close()
+
+	received := make(chan struct{})
+	p.connect = func() (*pluginProcess, error) {
+		client, server := net.Pipe()
+		go func() {
+			// the plugin process receives the call but never answers
+			_, _ = server.Read(make([]byte, 1))
+			close(received)
+			_, _ = io.Copy(ioutil.Discard, server)
+		}()
+
+		return &pluginProcess{conn: client}, nil
+	}
+
+	pending := make(chan error, 1)
+	go func() {
+		_, err := p.List()
+		pending <- err
+	}()
+
+	<-received
+
+	// another call timed out
+	p.lock.Lock()
+	client, pp := p.client, p.process
+	p.lock.Unlock()
+	p.stopProcess(client, pp)
+
+	assert.ErrorIs(t, <-pending, errPluginStopped)
+}
@@ -0,0 +1,157 @@
+// Package instancegroup implements the protocol used by the autoscaling
+// provider to manage a group of instances through an external plugin.
+//
+// A plugin is a separate binary that the runner starts and talks to using
+// JSON-RPC over the standard input and output of the process. The standard
+// error of the plugin is forwarded to the runner logs. Plugins written in Go
+// can use Serve to expose an InstanceGroup implementation. Plugins written in
+// other languages must handle the InstanceGroup.Increase, InstanceGroup.Decrease,
+// InstanceGroup.List and InstanceGroup.ConnectInfo JSON-RPC 1.0 methods, each
+// taking a single request object as the only parameter.
+package instancegroup
+
+import (
+	"io"
+	"net/rpc"
+	"net/rpc/jsonrpc"
+	"os"
+)
+
+const serviceName = "InstanceGroup"
+
+const (
+	// InstanceStateCreating is the state of an instance that is requested but not usable yet
+	InstanceStateCreating = "creating"
+	// InstanceStateRunning is the state of an instance that accepts connections
+	InstanceStateRunning = "running"
+	// InstanceStateRemoving is the state of an instance that is being removed
+	InstanceStateRemoving = "removing"
+)
+
+// InstanceGroup is the set of calls that a plugin has to implement
+type InstanceGroup interface {
+	// Increase creates the instances with the given names. It returns once
+	// the instances accept connections, with the names of the instances that
+	// were created successfully.
+	Increase(names []string) ([]string, error)
+	// Decrease removes the instances with the given names and returns the
+	// names of the instances that were removed.
+	Decrease(names []string) ([]string, error)
+	// List returns all instances managed by the plugin.
+	List() ([]Instance, error)
+	// ConnectInfo returns the details needed to connect to the Docker Engine
+	// of a running instance.
+	ConnectInfo(name string) (ConnectInfo, error)
+}
+
+type Instance struct {
+	Name  string `json:"name"`
+	State string `json:"state"`
+}
+
+type ConnectInfo struct {
+	// Host is the address of the Docker Engine, for example tcp://10.0.0.1:2376
+	Host string `json:"host"`
+	// CertPath is a directory with the ca.pem, cert.pem and key.pem files
+	CertPath  string `json:"tls_cert_path,omitempty"`
+	TLSVerify bool   `json:"tls_verify,omitempty"`
+}
+
+type IncreaseRequest struct {
+	Names []string `json:"names"`
+}
+
+type IncreaseResponse struct {
+	Created []string `json:"created"`
+}
+
+type DecreaseRequest struct {
+	Names []string `json:"names"`
+}
+
+type DecreaseResponse struct {
+	Removed []string `json:"removed"`
+}
+
+type ListRequest struct{}
+
+type ListResponse struct {
+	Instances []Instance `json:"instances"`
+}
+
+type ConnectInfoRequest struct {
+	Name string `json:"name"`
+}
+
+type ConnectInfoResponse struct {
+	ConnectInfo ConnectInfo `json:"connect_info"`
+}
+
+// service adapts an InstanceGroup to the method signatures required by net/rpc
+type service struct {
+	group InstanceGroup
+}
+
+func (s *service) Increase(req IncreaseRequest, resp *IncreaseResponse) (err error) {
+	resp.Created, err = s.group.Increase(req.Names)
+	return err
+}
+
+func (s *service) Decrease(req DecreaseRequest, resp *DecreaseResponse) (err error) {
+	resp.Removed, err = s.group.Decrease(req.Names)
+	return err
+}
+
+func (s *service) List(_ ListRequest, resp *ListResponse) (err error) {
+	resp.Instances, err = s.group.List()
+	return err
+}
+
+func (s *service) ConnectInfo(req ConnectInfoRequest, resp *ConnectInfoResponse) (err error) {
+	resp.ConnectInfo, err = s.group.ConnectInfo(req.Name)
+	return err
+}
+
+// Serve handles the requests sent over conn until it's closed
+func Serve(group InstanceGroup, conn io.ReadWriteCloser) error {
+	server := rpc.NewServer()
+
+	err := server.RegisterName(serviceName, &service{group: group})
+	if err != nil {
+		return err
+	}
+
+	server.ServeCodec(jsonrpc.NewServerCodec(conn))
+
+	return nil
+}
+
+// ServeStdio handles the requests sent by the runner on the standard input
+// of the plugin process
+func ServeStdio(group InstanceGroup) error {
+	return Serve(group, &stdioConn{reader: os.Stdin, writer: os.Stdout})
+}
+
+// stdioConn joins a pair of streams into a single connection
+type stdioConn struct {
+	reader io.ReadCloser
+	writer io.WriteCloser
+}
+
+func (c *stdioConn) Read(p []byte) (int, error) {
+	return c.reader.Read(p)
+}
+
+func (c *stdioConn) Write(p []byte) (int, error) {
+	return c.writer.Write(p)
+}
+
+func (c *stdioConn) Close() error {
+	werr := c.writer.Close()
+	rerr := c.reader.Close()
+	if werr != nil {
+		return werr
+	}
+
+	return rerr
+}
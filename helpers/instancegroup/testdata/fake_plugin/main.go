@@ -0,0 +1,158 @@
+// fake_plugin is an instance group plugin that doesn't create any real
+// instances. Every instance points to the same Docker Engine, which makes
+// it possible to run the autoscaling provider against a local Docker:
+//
+//	go build -o fake-plugin ./helpers/instancegroup/testdata/fake_plugin
+//
+//	[runners.machine]
+//	  MachineName = "fake-%s"
+//	  Plugin = "/path/to/fake-plugin"
+//	  PluginOptions = ["-docker-host", "unix:///var/run/docker.sock"]
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"gitlab.com/gitlab-org/gitlab-runner/helpers/instancegroup"
+)
+
+type fakeGroup struct {
+	dockerHost  string
+	stateFile   string
+	createDelay time.Duration
+	failCreate  string
+
+	lock      sync.Mutex
+	instances map[string]string
+}
+
+func (g *fakeGroup) Increase(names []string) ([]string, error) {
+	time.Sleep(g.createDelay)
+
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	var created []string
+	for _, name := range names {
+		if g.failCreate != "" && strings.Contains(name, g.failCreate) {
+			log.Println("Failing creation of", name)
+			continue
+		}
+
+		log.Println("Creating", name)
+		g.instances[name] = instancegroup.InstanceStateRunning
+		created = append(created, name)
+	}
+
+	return created, g.save()
+}
+
+func (g *fakeGroup) Decrease(names []string) ([]string, error) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	var removed []string
+	for _, name := range names {
+		if _, ok := g.instances[name]; !ok {
+			continue
+		}
+
+		log.Println("Removing", name)
+		delete(g.instances, name)
+		removed = append(removed, name)
+	}
+
+	return removed, g.save()
+}
+
+func (g *fakeGroup) List() ([]instancegroup.Instance, error) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	instances := make([]instancegroup.Instance, 0, len(g.instances))
+	for name, state := range g.instances {
+		instances = append(instances, instancegroup.Instance{Name: name, State: state})
+	}
+
+	sort.Slice(instances, func(i, j int) bool {
+		return instances[i].Name < instances[j].Name
+	})
+
+	return instances, nil
+}
+
+func (g *fakeGroup) ConnectInfo(name string) (instancegroup.ConnectInfo, error) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	if g.instances[name] != instancegroup.InstanceStateRunning {
+		return instancegroup.ConnectInfo{}, fmt.Errorf("instance %q is not running", name)
+	}
+
+	return instancegroup.ConnectInfo{Host: g.dockerHost}, nil
+}
+
+func (g *fakeGroup) load() error {
+	if g.stateFile == "" {
+		return nil
+	}
+
+	data, err := ioutil.ReadFile(g.stateFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(data, &g.instances)
+}
+
+func (g *fakeGroup) save() error {
+	if g.stateFile == "" {
+		return nil
+	}
+
+	data, err := json.Marshal(g.instances)
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(g.stateFile, data, 0600)
+}
+
+func main() {
+	defaultDockerHost := os.Getenv("DOCKER_HOST")
+	if defaultDockerHost == "" {
+		defaultDockerHost = "unix:///var/run/docker.sock"
+	}
+
+	group := &fakeGroup{instances: make(map[string]string)}
+
+	flag.StringVar(&group.dockerHost, "docker-host", defaultDockerHost, "Docker Engine used by all instances")
+	flag.StringVar(&group.stateFile, "state-file", "", "File keeping the instances between restarts")
+	flag.DurationVar(&group.createDelay, "create-delay", 0, "Time it takes to create instances")
+	flag.StringVar(&group.failCreate, "fail-create", "", "Fail creation of instances with names containing the value")
+	flag.Parse()
+
+	log.SetOutput(os.Stderr)
+
+	err := group.load()
+	if err != nil {
+		log.Fatalln("Loading state:", err)
+	}
+
+	err = instancegroup.ServeStdio(group)
+	if err != nil {
+		log.Fatalln(err)
+	}
+}
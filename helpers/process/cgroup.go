@@ -0,0 +1,51 @@
+package process
+
+import (
+	"errors"
+	"time"
+)
+
+var ErrCgroupNotSupported = errors.New("cgroups are only supported on Linux")
+
+// CgroupLimits are the limits of the resources of a cgroup, the zero values
+// are unlimited
+type CgroupLimits struct {
+	// MemoryMax is the memory limit in bytes
+	MemoryMax int64
+	// CPUMax is the number of CPUs
+	CPUMax float64
+	// PidsMax is the maximum number of processes
+	PidsMax int64
+}
+
+// CgroupStats are the resources used by the processes of a cgroup. The peaks
+// are zero when the kernel doesn't report them.
+type CgroupStats struct {
+	MemoryPeak int64
+	CPUUsage   time.Duration
+	PidsPeak   int64
+	OOMKills   int64
+}
+
+// Cgroup is a cgroup v2 in which the processes of a job run, see NewCgroup
+type Cgroup struct {
+	path string
+}
+
+func (c *Cgroup) Path() string {
+	return c.path
+}
+
+// cgroupCommander is implemented by the commands which can run in a cgroup
+type cgroupCommander interface {
+	cgroup() *Cgroup
+}
+
+func commandCgroup(cmd Commander) *Cgroup {
+	c, ok := cmd.(cgroupCommander)
+	if !ok {
+		return nil
+	}
+
+	return c.cgroup()
+}
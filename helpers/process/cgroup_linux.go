@@ -0,0 +1,259 @@
+package process
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// cgroupCPUPeriod is the period of cpu.max, in microseconds
+const cgroupCPUPeriod = 100000
+
+// cgroupRemoveTimeout is the time given to the killed processes to exit
+// before the cgroup is removed
+const cgroupRemoveTimeout = 10 * time.Second
+
+var cgroupControllers = []string{"cpu", "memory", "pids"}
+
+// NewCgroup creates the cgroup name in the parent cgroup, which must be
+// delegated to the user of the runner, and sets its limits
+func NewCgroup(parent string, name string, limits CgroupLimits) (*Cgroup, error) {
+	// the controllers of the limits must be enabled for the children of the
+	// parent cgroup
+	enable := make([]string, 0, len(cgroupControllers))
+	for _, controller := range cgroupControllers {
+		enable = append(enable, "+"+controller)
+	}
+
+	err := writeCgroupFile(parent, "cgroup.subtree_control", strings.Join(enable, " "))
+	if err != nil {
+		return nil, fmt.Errorf("enabling the cgroup controllers: %w", err)
+	}
+
+	c := &Cgroup{path: filepath.Join(parent, name)}
+
+	err = os.Mkdir(c.path, 0o755)
+	if err != nil && !os.IsExist(err) {
+		return nil, fmt.Errorf("creating cgroup: %w", err)
+	}
+
+	err = c.setLimits(limits)
+	if err != nil {
+		_ = os.Remove(c.path)
+		return nil, err
+	}
+
+	return c, nil
+}
+
+func (c *Cgroup) setLimits(limits CgroupLimits) error {
+	if limits.MemoryMax > 0 {
+		err := writeCgroupFile(c.path, "memory.max", strconv.FormatInt(limits.MemoryMax, 10))
+		if err != nil {
+			return fmt.Errorf("setting memory.max: %w", err)
+		}
+	}
+
+	if limits.CPUMax > 0 {
+		quota := int64(limits.CPUMax * cgroupCPUPeriod)
+		err := writeCgroupFile(c.path, "cpu.max", fmt.Sprintf("%d %d", quota, cgroupCPUPeriod))
+		if err != nil {
+			return fmt.Errorf("setting cpu.max: %w", err)
+		}
+	}
+
+	if limits.PidsMax > 0 {
+		err := writeCgroupFile(c.path, "pids.max", strconv.FormatInt(limits.PidsMax, 10))
+		if err != nil {
+			return fmt.Errorf("setting pids.max: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// AddProcess moves the process to the cgroup, its children started
+// afterwards are created in the cgroup
+func (c *Cgroup) AddProcess(pid int) error {
+	err := writeCgroupFile(c.path, "cgroup.procs", strconv.Itoa(pid))
+	if err != nil {
+		return fmt.Errorf("adding process %d to cgroup: %w", pid, err)
+	}
+
+	return nil
+}
+
+// Signal sends the signal to every process of the cgroup
+func (c *Cgroup) Signal(sig syscall.Signal) error {
+	pids, err := c.pids()
+	if err != nil {
+		return err
+	}
+
+	for _, pid := range pids {
+		err = syscall.Kill(pid, sig)
+		if err != nil && !errors.Is(err, syscall.ESRCH) {
+			return fmt.Errorf("signaling process %d: %w", pid, err)
+		}
+	}
+
+	return nil
+}
+
+// Kill kills every process of the cgroup, including the processes which left
+// the process group of the command
+func (c *Cgroup) Kill() error {
+	err := writeCgroupFile(c.path, "cgroup.kill", "1")
+	if err == nil || !os.IsNotExist(err) {
+		return err
+	}
+
+	// cgroup.kill is only available since Linux 5.14, the processes forked
+	// while being killed are killed by the next iteration
+	for i := 0; i < 10; i++ {
+		pids, err := c.pids()
+		if err != nil || len(pids) == 0 {
+			return err
+		}
+
+		err = c.Signal(syscall.SIGKILL)
+		if err != nil {
+			return err
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	return nil
+}
+
+// Stats returns the resources used by the processes of the cgroup
+func (c *Cgroup) Stats() (CgroupStats, error) {
+	var stats CgroupStats
+
+	cpuStat, err := readCgroupKeyedFile(c.path, "cpu.stat")
+	if err != nil {
+		return stats, err
+	}
+	stats.CPUUsage = time.Duration(cpuStat["usage_usec"]) * time.Microsecond
+
+	memoryEvents, err := readCgroupKeyedFile(c.path, "memory.events")
+	if err != nil && !os.IsNotExist(err) {
+		return stats, err
+	}
+	stats.OOMKills = memoryEvents["oom_kill"]
+
+	// memory.peak and pids.peak are only available since Linux 5.19 and 6.1
+	stats.MemoryPeak, err = readCgroupIntFile(c.path, "memory.peak")
+	if err != nil && !os.IsNotExist(err) {
+		return stats, err
+	}
+
+	stats.PidsPeak, err = readCgroupIntFile(c.path, "pids.peak")
+	if err != nil && !os.IsNotExist(err) {
+		return stats, err
+	}
+
+	return stats, nil
+}
+
+// Remove waits for the processes of the cgroup to exit and removes it
+func (c *Cgroup) Remove() error {
+	deadline := time.Now().Add(cgroupRemoveTimeout)
+	for {
+		events, err := readCgroupKeyedFile(c.path, "cgroup.events")
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if events["populated"] == 0 {
+			break
+		}
+
+		if time.Now().After(deadline) {
+			return fmt.Errorf("cgroup %s still has processes", c.path)
+		}
+
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	err := os.Remove(c.path)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("removing cgroup: %w", err)
+	}
+
+	return nil
+}
+
+func (c *Cgroup) pids() ([]int, error) {
+	data, err := ioutil.ReadFile(filepath.Join(c.path, "cgroup.procs"))
+	if err != nil {
+		return nil, err
+	}
+
+	var pids []int
+	for _, field := range strings.Fields(string(data)) {
+		pid, err := strconv.Atoi(field)
+		if err != nil {
+			return nil, fmt.Errorf("parsing cgroup.procs: %w", err)
+		}
+		pids = append(pids, pid)
+	}
+
+	return pids, nil
+}
+
+func writeCgroupFile(dir string, name string, value string) error {
+	return ioutil.WriteFile(filepath.Join(dir, name), []byte(value), 0o644)
+}
+
+func readCgroupIntFile(dir string, name string) (int64, error) {
+	data, err := ioutil.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		return 0, err
+	}
+
+	value, err := strconv.ParseInt(string(bytes.TrimSpace(data)), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("parsing %s: %w", name, err)
+	}
+
+	return value, nil
+}
+
+// readCgroupKeyedFile reads the files of "key value" lines, like cpu.stat
+func readCgroupKeyedFile(dir string, name string) (map[string]int64, error) {
+	data, err := ioutil.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		return nil, err
+	}
+
+	values := make(map[string]int64)
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 {
+			continue
+		}
+
+		value, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("parsing %s: %w", name, err)
+		}
+		values[fields[0]] = value
+	}
+
+	return values, scanner.Err()
+}
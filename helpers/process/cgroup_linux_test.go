@@ -0,0 +1,106 @@
+//go:build !integration && linux
+// +build !integration,linux
+
+package process
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func readTestCgroupFile(t *testing.T, dir string, name string) string {
+	data, err := ioutil.ReadFile(filepath.Join(dir, name))
+	require.NoError(t, err)
+
+	return string(data)
+}
+
+func writeTestCgroupFile(t *testing.T, dir string, name string, content string) {
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0o644))
+}
+
+func TestNewCgroup(t *testing.T) {
+	parent := t.TempDir()
+
+	cgroup, err := NewCgroup(parent, "job-1", CgroupLimits{
+		MemoryMax: 512 * 1024 * 1024,
+		CPUMax:    1.5,
+		PidsMax:   256,
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, filepath.Join(parent, "job-1"), cgroup.Path())
+	assert.Equal(t, "+cpu +memory +pids", readTestCgroupFile(t, parent, "cgroup.subtree_control"))
+	assert.Equal(t, "536870912", readTestCgroupFile(t, cgroup.Path(), "memory.max"))
+	assert.Equal(t, "150000 100000", readTestCgroupFile(t, cgroup.Path(), "cpu.max"))
+	assert.Equal(t, "256", readTestCgroupFile(t, cgroup.Path(), "pids.max"))
+}
+
+func TestNewCgroupUnlimited(t *testing.T) {
+	cgroup, err := NewCgroup(t.TempDir(), "job-1", CgroupLimits{})
+	require.NoError(t, err)
+
+	for _, name := range []string{"memory.max", "cpu.max", "pids.max"} {
+		assert.NoFileExists(t, filepath.Join(cgroup.Path(), name))
+	}
+}
+
+func TestNewCgroupWithoutParent(t *testing.T) {
+	_, err := NewCgroup(filepath.Join(t.TempDir(), "missing"), "job-1", CgroupLimits{})
+	assert.Error(t, err)
+}
+
+func TestCgroupStats(t *testing.T) {
+	cgroup := &Cgroup{path: t.TempDir()}
+	writeTestCgroupFile(t, cgroup.path, "cpu.stat", "usage_usec 2500000\nuser_usec 2000000\n")
+	writeTestCgroupFile(t, cgroup.path, "memory.events", "low 0\nhigh 0\nmax 4\noom 1\noom_kill 1\n")
+	writeTestCgroupFile(t, cgroup.path, "memory.peak", "104857600\n")
+
+	stats, err := cgroup.Stats()
+	require.NoError(t, err)
+
+	assert.Equal(t, CgroupStats{
+		MemoryPeak: 104857600,
+		CPUUsage:   2500 * time.Millisecond,
+		OOMKills:   1,
+	}, stats)
+}
+
+func TestCgroupRemove(t *testing.T) {
+	cgroup := &Cgroup{path: filepath.Join(t.TempDir(), "job-1")}
+
+	// removing an already removed cgroup succeeds
+	assert.NoError(t, cgroup.Remove())
+
+	require.NoError(t, os.Mkdir(cgroup.path, 0o755))
+	writeTestCgroupFile(t, cgroup.path, "cgroup.events", "populated 1\nfrozen 0\n")
+
+	go func() {
+		time.Sleep(200 * time.Millisecond)
+		_ = os.RemoveAll(cgroup.path)
+	}()
+
+	assert.NoError(t, cgroup.Remove())
+}
+
+func TestCgroupForceKill(t *testing.T) {
+	cgroup := &Cgroup{path: t.TempDir()}
+	writeTestCgroupFile(t, cgroup.path, "cgroup.kill", "")
+
+	cmd := &osCmd{internal: exec.Command("sleep", "60"), options: CommandOptions{Cgroup: cgroup}}
+	setProcessGroup(cmd.internal, false)
+	require.NoError(t, cmd.internal.Start())
+
+	killer := newKiller(new(MockLogger), cmd)
+	killer.ForceKill()
+	_ = cmd.Wait()
+
+	assert.Equal(t, "1", readTestCgroupFile(t, cgroup.path, "cgroup.kill"))
+}
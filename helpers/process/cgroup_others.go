@@ -0,0 +1,33 @@
+//go:build !linux
+// +build !linux
+
+package process
+
+import (
+	"syscall"
+)
+
+// NewCgroup isn't supported outside of Linux
+func NewCgroup(_ string, _ string, _ CgroupLimits) (*Cgroup, error) {
+	return nil, ErrCgroupNotSupported
+}
+
+func (c *Cgroup) AddProcess(_ int) error {
+	return ErrCgroupNotSupported
+}
+
+func (c *Cgroup) Signal(_ syscall.Signal) error {
+	return ErrCgroupNotSupported
+}
+
+func (c *Cgroup) Kill() error {
+	return ErrCgroupNotSupported
+}
+
+func (c *Cgroup) Stats() (CgroupStats, error) {
+	return CgroupStats{}, ErrCgroupNotSupported
+}
+
+func (c *Cgroup) Remove() error {
+	return ErrCgroupNotSupported
+}
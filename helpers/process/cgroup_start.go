@@ -0,0 +1,14 @@
+//go:build !linux || !go1.20
+// +build !linux !go1.20
+
+package process
+
+import (
+	"os/exec"
+)
+
+// setCgroup can't start the command in the cgroup with the Go versions older
+// than 1.20, the command is moved to the cgroup once it started
+func setCgroup(_ *exec.Cmd, _ *Cgroup) (func(), error) {
+	return nil, nil
+}
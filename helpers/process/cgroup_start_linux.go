@@ -0,0 +1,29 @@
+//go:build go1.20
+// +build go1.20
+
+package process
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// setCgroup starts the command in the cgroup, so that the command and its
+// children run in the cgroup from their first instruction. It requires
+// Linux 5.7. The returned function closes the cgroup once the command started
+func setCgroup(c *exec.Cmd, cgroup *Cgroup) (func(), error) {
+	dir, err := os.Open(cgroup.path)
+	if err != nil {
+		return nil, fmt.Errorf("opening cgroup: %w", err)
+	}
+
+	if c.SysProcAttr == nil {
+		c.SysProcAttr = new(syscall.SysProcAttr)
+	}
+	c.SysProcAttr.UseCgroupFD = true
+	c.SysProcAttr.CgroupFD = int(dir.Fd())
+
+	return func() { _ = dir.Close() }, nil
+}
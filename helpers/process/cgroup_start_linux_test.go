@@ -0,0 +1,74 @@
+//go:build !integration && go1.20
+// +build !integration,go1.20
+
+package process
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestCgroup creates a cgroup in the cgroup v2 hierarchy, the test is
+// skipped when the hierarchy isn't mounted or writable
+func newTestCgroup(t *testing.T) *Cgroup {
+	mounts, err := os.Open("/proc/self/mounts")
+	require.NoError(t, err)
+	defer mounts.Close()
+
+	root := ""
+	scanner := bufio.NewScanner(mounts)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) > 2 && fields[2] == "cgroup2" {
+			root = fields[1]
+			break
+		}
+	}
+
+	if root == "" {
+		t.Skip("cgroup v2 isn't mounted")
+	}
+
+	path := filepath.Join(root, fmt.Sprintf("gitlab-runner-test-%d", os.Getpid()))
+	err = os.Mkdir(path, 0o755)
+	if err != nil {
+		t.Skip("cgroup v2 isn't writable:", err)
+	}
+	t.Cleanup(func() { _ = os.Remove(path) })
+
+	return &Cgroup{path: path}
+}
+
+func TestCommanderStartsInCgroup(t *testing.T) {
+	cgroup := newTestCgroup(t)
+
+	output := new(bytes.Buffer)
+	cmd := NewOSCmd("cat", []string{"/proc/self/cgroup"}, CommandOptions{
+		Stdout: output,
+		Cgroup: cgroup,
+	})
+
+	require.NoError(t, cmd.Start())
+	require.NoError(t, cmd.Wait())
+
+	// the first instruction of the process runs in the cgroup
+	assert.Contains(t, output.String(), "0::/"+filepath.Base(cgroup.Path())+"\n")
+}
+
+func TestCommanderStartInMissingCgroup(t *testing.T) {
+	cgroup := &Cgroup{path: filepath.Join(t.TempDir(), "missing")}
+
+	cmd := NewOSCmd("true", nil, CommandOptions{Cgroup: cgroup})
+
+	err := cmd.Start()
+	assert.Error(t, err)
+	assert.Nil(t, cmd.Process(), "the process isn't started outside of the cgroup")
+}
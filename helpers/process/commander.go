@@ -1,6 +1,7 @@
 package process
 
 import (
+	"fmt"
 	"io"
 	"os"
 	"os/exec"
@@ -27,6 +28,12 @@ type CommandOptions struct {
 	ForceKillTimeout    time.Duration
 
 	UseWindowsLegacyProcessStrategy bool
+
+	// Sandbox runs the command isolated in Linux namespaces, when set
+	Sandbox *SandboxOptions
+
+	// Cgroup is the cgroup in which the command runs, when set
+	Cgroup *Cgroup
 }
 
 type osCmd struct {
@@ -53,7 +60,43 @@ func NewOSCmd(executable string, args []string, options CommandOptions) Commande
 func (c *osCmd) Start() error {
 	setProcessGroup(c.internal, c.options.UseWindowsLegacyProcessStrategy)
 
-	return c.internal.Start()
+	if c.options.Sandbox != nil {
+		err := setSandbox(c.internal, c.options.Sandbox)
+		if err != nil {
+			return fmt.Errorf("setting the sandbox up: %w", err)
+		}
+	}
+
+	var closeCgroup func()
+	if c.options.Cgroup != nil {
+		var err error
+		closeCgroup, err = setCgroup(c.internal, c.options.Cgroup)
+		if err != nil {
+			return fmt.Errorf("setting the cgroup up: %w", err)
+		}
+	}
+
+	err := c.internal.Start()
+	if closeCgroup != nil {
+		closeCgroup()
+		return err
+	}
+
+	if err != nil || c.options.Cgroup == nil {
+		return err
+	}
+
+	// the process couldn't be started in the cgroup, it's moved there right
+	// after it started, what it runs until then isn't limited by the cgroup
+	err = c.options.Cgroup.AddProcess(c.internal.Process.Pid)
+	if err != nil {
+		_ = c.internal.Process.Kill()
+		_ = c.internal.Wait()
+
+		return err
+	}
+
+	return nil
 }
 
 func (c *osCmd) Wait() error {
@@ -63,3 +106,7 @@ func (c *osCmd) Wait() error {
 func (c *osCmd) Process() *os.Process {
 	return c.internal.Process
 }
+
+func (c *osCmd) cgroup() *Cgroup {
+	return c.options.Cgroup
+}
@@ -42,6 +42,15 @@ func (pk *unixKiller) ForceKill() {
 	if err != nil {
 		pk.logger.Warn("Failed to force-kill:", err)
 	}
+
+	// the processes which left the process group, like the daemons started
+	// by the job, are only killed with the cgroup
+	if cgroup := commandCgroup(pk.cmd); cgroup != nil {
+		err = cgroup.Kill()
+		if err != nil {
+			pk.logger.Warn("Failed to kill the cgroup:", err)
+		}
+	}
 }
 
 // getPID will return the negative PID (-PID) which is the process group. The
@@ -0,0 +1,41 @@
+package process
+
+import (
+	"errors"
+)
+
+// SandboxInitCommand is the command of the runner binary executed as the
+// init process of the sandbox
+const SandboxInitCommand = "sandbox-init"
+
+var ErrSandboxNotSupported = errors.New("the sandbox is only supported on Linux")
+
+// SandboxOptions isolate a command in unprivileged user, mount, PID and
+// optionally network namespaces. The root filesystem is read-only except for
+// the writable paths, and /tmp is private to the command.
+type SandboxOptions struct {
+	// InitCommand is the path of the runner binary, which sets the sandbox
+	// up and runs the command
+	InitCommand string
+
+	WritablePaths  []string
+	DisableNetwork bool
+}
+
+// initArgs returns the arguments of the init command running the executable
+// in the sandbox
+func (o *SandboxOptions) initArgs(executable string, args []string) []string {
+	// the logs of the runner would be printed in the job log
+	initArgs := []string{"--log-level", "error", SandboxInitCommand}
+	for _, path := range o.WritablePaths {
+		initArgs = append(initArgs, "--writable-path", path)
+	}
+
+	if o.DisableNetwork {
+		initArgs = append(initArgs, "--disable-network")
+	}
+
+	initArgs = append(initArgs, "--", executable)
+
+	return append(initArgs, args...)
+}
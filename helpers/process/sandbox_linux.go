@@ -0,0 +1,331 @@
+package process
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+const sandboxTmpDir = "/tmp"
+
+// sandboxHostMounts are kept as they are, /proc is mounted again for the PID
+// namespace and the devices must stay writable
+var sandboxHostMounts = []string{"/proc", "/dev", "/sys"}
+
+var sandboxMountOptions = map[string]uintptr{
+	"nosuid":      unix.MS_NOSUID,
+	"nodev":       unix.MS_NODEV,
+	"noexec":      unix.MS_NOEXEC,
+	"noatime":     unix.MS_NOATIME,
+	"nodiratime":  unix.MS_NODIRATIME,
+	"relatime":    unix.MS_RELATIME,
+	"strictatime": unix.MS_STRICTATIME,
+}
+
+func setSandbox(c *exec.Cmd, options *SandboxOptions) error {
+	if options.InitCommand == "" {
+		return errors.New("the sandbox init command isn't set")
+	}
+
+	c.Args = append([]string{options.InitCommand}, options.initArgs(c.Args[0], c.Args[1:])...)
+	c.Path = options.InitCommand
+
+	cloneFlags := uintptr(unix.CLONE_NEWUSER | unix.CLONE_NEWNS | unix.CLONE_NEWPID)
+	if options.DisableNetwork {
+		cloneFlags |= unix.CLONE_NEWNET
+	}
+
+	if c.SysProcAttr == nil {
+		c.SysProcAttr = new(syscall.SysProcAttr)
+	}
+	setUserNamespace(c.SysProcAttr, cloneFlags)
+
+	return nil
+}
+
+// setUserNamespace maps the user and the group of the runner to themselves,
+// so that the files written in the sandbox are owned by the runner user
+func setUserNamespace(attr *syscall.SysProcAttr, cloneFlags uintptr) {
+	uid, gid := os.Getuid(), os.Getgid()
+
+	attr.Cloneflags = cloneFlags
+	attr.UidMappings = []syscall.SysProcIDMap{{ContainerID: uid, HostID: uid, Size: 1}}
+	attr.GidMappings = []syscall.SysProcIDMap{{ContainerID: gid, HostID: gid, Size: 1}}
+	attr.GidMappingsEnableSetgroups = false
+}
+
+// RunSandboxInit sets the sandbox up and runs the command in it. It's called
+// by the init process of the sandbox, started with the namespaces set by
+// CommandOptions.Sandbox, and returns the exit code of the command.
+func RunSandboxInit(options SandboxOptions, command []string) (int, error) {
+	if len(command) == 0 {
+		return 0, errors.New("no command to run in the sandbox")
+	}
+
+	// the mounts of the sandbox would change the mounts of the host when not
+	// running in the namespaces of the sandbox
+	if os.Getpid() != 1 {
+		return 0, errors.New("the sandbox init must be the init process of the sandbox")
+	}
+
+	err := setupSandboxMounts(options.WritablePaths)
+	if err != nil {
+		return 0, err
+	}
+
+	if options.DisableNetwork {
+		// the network namespace only has the loopback interface, which is
+		// down when the namespace is created
+		err = setLoopbackUp()
+		if err != nil {
+			return 0, fmt.Errorf("setting the loopback interface up: %w", err)
+		}
+	}
+
+	return runSandboxed(command)
+}
+
+func setupSandboxMounts(writablePaths []string) error {
+	// the mounts of the sandbox mustn't propagate to the host
+	err := unix.Mount("", "/", "", unix.MS_REC|unix.MS_PRIVATE, "")
+	if err != nil {
+		return fmt.Errorf("making the mounts private: %w", err)
+	}
+
+	paths, err := openWritablePaths(writablePaths)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		for _, f := range paths {
+			_ = f.Close()
+		}
+	}()
+
+	err = unix.Mount("tmpfs", sandboxTmpDir, "tmpfs", unix.MS_NOSUID|unix.MS_NODEV, "mode=1777")
+	if err != nil {
+		return fmt.Errorf("mounting %s: %w", sandboxTmpDir, err)
+	}
+
+	// the writable paths are mounted from their opened descriptors, since
+	// the paths in /tmp are hidden by the private /tmp
+	for path, f := range paths {
+		err = os.MkdirAll(path, 0o755)
+		if err != nil {
+			return fmt.Errorf("creating writable path %s: %w", path, err)
+		}
+
+		err = unix.Mount(fmt.Sprintf("/proc/self/fd/%d", f.Fd()), path, "", unix.MS_BIND|unix.MS_REC, "")
+		if err != nil {
+			return fmt.Errorf("mounting writable path %s: %w", path, err)
+		}
+	}
+
+	err = unix.Mount("proc", "/proc", "proc", unix.MS_NOSUID|unix.MS_NODEV|unix.MS_NOEXEC, "")
+	if err != nil {
+		return fmt.Errorf("mounting /proc: %w", err)
+	}
+
+	return remountReadOnly(writablePaths)
+}
+
+func openWritablePaths(writablePaths []string) (map[string]*os.File, error) {
+	paths := make(map[string]*os.File, len(writablePaths))
+	for _, path := range writablePaths {
+		if !filepath.IsAbs(path) {
+			return nil, fmt.Errorf("writable path %s isn't absolute", path)
+		}
+		path = filepath.Clean(path)
+
+		err := os.MkdirAll(path, 0o755)
+		if err != nil {
+			return nil, fmt.Errorf("creating writable path %s: %w", path, err)
+		}
+
+		f, err := os.OpenFile(path, unix.O_PATH|unix.O_DIRECTORY, 0)
+		if err != nil {
+			return nil, fmt.Errorf("opening writable path %s: %w", path, err)
+		}
+		paths[path] = f
+	}
+
+	return paths, nil
+}
+
+// remountReadOnly remounts every mount of the sandbox read-only, except for
+// the writable paths, the private /tmp and the mounts of the host devices
+// and kernel interfaces
+func remountReadOnly(writablePaths []string) error {
+	mounts, err := readMountInfo("/proc/self/mountinfo")
+	if err != nil {
+		return err
+	}
+
+	keep := append([]string{sandboxTmpDir}, sandboxHostMounts...)
+	for _, path := range writablePaths {
+		keep = append(keep, filepath.Clean(path))
+	}
+
+	for _, m := range mounts {
+		if isWithinAny(m.point, keep) {
+			continue
+		}
+
+		err = unix.Mount("", m.point, "", unix.MS_REMOUNT|unix.MS_BIND|unix.MS_RDONLY|m.flags, "")
+		// the mounts which can't be accessed by the runner user can't be
+		// accessed by the job either
+		if errors.Is(err, unix.EACCES) || errors.Is(err, unix.ENOENT) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("remounting %s read-only: %w", m.point, err)
+		}
+	}
+
+	return nil
+}
+
+type mountInfo struct {
+	point string
+	flags uintptr
+}
+
+func readMountInfo(path string) ([]mountInfo, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading mounts: %w", err)
+	}
+	defer func() { _ = f.Close() }()
+
+	return parseMountInfo(f)
+}
+
+// parseMountInfo parses the mount points and the per-mount options of the
+// mountinfo file, see proc(5)
+func parseMountInfo(r io.Reader) ([]mountInfo, error) {
+	var mounts []mountInfo
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 6 {
+			return nil, fmt.Errorf("invalid mountinfo line %q", scanner.Text())
+		}
+
+		var flags uintptr
+		for _, option := range strings.Split(fields[5], ",") {
+			flags |= sandboxMountOptions[option]
+		}
+
+		mounts = append(mounts, mountInfo{point: unescapeMountPoint(fields[4]), flags: flags})
+	}
+
+	return mounts, scanner.Err()
+}
+
+// unescapeMountPoint replaces the octal escapes of the spaces, tabs, new
+// lines and backslashes of the mount points
+func unescapeMountPoint(point string) string {
+	var b strings.Builder
+	for i := 0; i < len(point); i++ {
+		if point[i] == '\\' && i+4 <= len(point) {
+			if c, err := strconv.ParseUint(point[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(c))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(point[i])
+	}
+
+	return b.String()
+}
+
+func isWithinAny(path string, parents []string) bool {
+	for _, parent := range parents {
+		if path == parent || strings.HasPrefix(path, strings.TrimSuffix(parent, "/")+"/") {
+			return true
+		}
+	}
+
+	return false
+}
+
+func setLoopbackUp() error {
+	fd, err := unix.Socket(unix.AF_INET, unix.SOCK_DGRAM|unix.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = unix.Close(fd) }()
+
+	ifreq, err := unix.NewIfreq("lo")
+	if err != nil {
+		return err
+	}
+
+	err = unix.IoctlIfreq(fd, unix.SIOCGIFFLAGS, ifreq)
+	if err != nil {
+		return err
+	}
+
+	ifreq.SetUint16(ifreq.Uint16() | unix.IFF_UP)
+
+	return unix.IoctlIfreq(fd, unix.SIOCSIFFLAGS, ifreq)
+}
+
+// runSandboxed runs the command as a child of the init process, forwarding
+// the termination signals, since the init process of a PID namespace ignores
+// the signals it doesn't handle
+func runSandboxed(command []string) (int, error) {
+	cmd := exec.Command(command[0], command[1:]...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	// the command runs in a nested user namespace, in which the mounts of the
+	// sandbox are locked and can't be made writable or unmounted
+	cmd.SysProcAttr = new(syscall.SysProcAttr)
+	setUserNamespace(cmd.SysProcAttr, unix.CLONE_NEWUSER|unix.CLONE_NEWNS)
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, unix.SIGTERM, unix.SIGINT, unix.SIGHUP, unix.SIGQUIT)
+	defer func() {
+		signal.Stop(signals)
+		close(signals)
+	}()
+
+	err := cmd.Start()
+	if err != nil {
+		return 0, fmt.Errorf("starting %s: %w", command[0], err)
+	}
+
+	go func() {
+		for sig := range signals {
+			_ = cmd.Process.Signal(sig)
+		}
+	}()
+
+	err = cmd.Wait()
+
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		return 0, err
+	}
+
+	status, ok := exitErr.Sys().(syscall.WaitStatus)
+	if ok && status.Signaled() {
+		return 128 + int(status.Signal()), nil
+	}
+
+	return exitErr.ExitCode(), nil
+}
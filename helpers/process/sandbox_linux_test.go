@@ -0,0 +1,82 @@
+//go:build !integration && linux
+// +build !integration,linux
+
+package process
+
+import (
+	"os/exec"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+)
+
+func TestSetSandbox(t *testing.T) {
+	c := exec.Command("bash", "--login")
+	setProcessGroup(c, false)
+
+	err := setSandbox(c, &SandboxOptions{
+		InitCommand:    "/usr/bin/gitlab-runner",
+		WritablePaths:  []string{"/builds/project-0", "/cache/project"},
+		DisableNetwork: true,
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, "/usr/bin/gitlab-runner", c.Path)
+	assert.Equal(t, []string{
+		"/usr/bin/gitlab-runner", "--log-level", "error", "sandbox-init",
+		"--writable-path", "/builds/project-0",
+		"--writable-path", "/cache/project",
+		"--disable-network",
+		"--", "bash", "--login",
+	}, c.Args)
+
+	assert.True(t, c.SysProcAttr.Setpgid)
+	assert.Equal(
+		t,
+		uintptr(unix.CLONE_NEWUSER|unix.CLONE_NEWNS|unix.CLONE_NEWPID|unix.CLONE_NEWNET),
+		c.SysProcAttr.Cloneflags,
+	)
+	require.Len(t, c.SysProcAttr.UidMappings, 1)
+	assert.Equal(t, c.SysProcAttr.UidMappings[0].HostID, c.SysProcAttr.UidMappings[0].ContainerID)
+	assert.False(t, c.SysProcAttr.GidMappingsEnableSetgroups)
+}
+
+func TestSetSandboxWithoutInitCommand(t *testing.T) {
+	err := setSandbox(exec.Command("bash"), &SandboxOptions{})
+	assert.EqualError(t, err, "the sandbox init command isn't set")
+}
+
+func TestRunSandboxInitOutsideOfSandbox(t *testing.T) {
+	_, err := RunSandboxInit(SandboxOptions{}, []string{"true"})
+	assert.EqualError(t, err, "the sandbox init must be the init process of the sandbox")
+}
+
+func TestParseMountInfo(t *testing.T) {
+	content := `28 1 254:0 / / rw,relatime - ext4 /dev/vda rw
+29 28 254:16 / /mnt/with\040space ro,nosuid,nodev,relatime - ext4 /dev/vdb ro
+43 28 0:39 / /tmp rw,nosuid,nodev,noexec - tmpfs tmpfs rw
+`
+
+	mounts, err := parseMountInfo(strings.NewReader(content))
+	require.NoError(t, err)
+	assert.Equal(t, []mountInfo{
+		{point: "/", flags: unix.MS_RELATIME},
+		{point: "/mnt/with space", flags: unix.MS_NOSUID | unix.MS_NODEV | unix.MS_RELATIME},
+		{point: "/tmp", flags: unix.MS_NOSUID | unix.MS_NODEV | unix.MS_NOEXEC},
+	}, mounts)
+
+	_, err = parseMountInfo(strings.NewReader("invalid line\n"))
+	assert.Error(t, err)
+}
+
+func TestIsWithinAny(t *testing.T) {
+	parents := []string{"/tmp", "/builds/project-0"}
+
+	assert.True(t, isWithinAny("/tmp", parents))
+	assert.True(t, isWithinAny("/builds/project-0/group/project", parents))
+	assert.False(t, isWithinAny("/builds/project-01", parents))
+	assert.False(t, isWithinAny("/", parents))
+}
@@ -0,0 +1,17 @@
+//go:build !linux
+// +build !linux
+
+package process
+
+import (
+	"os/exec"
+)
+
+func setSandbox(_ *exec.Cmd, _ *SandboxOptions) error {
+	return ErrSandboxNotSupported
+}
+
+// RunSandboxInit isn't supported outside of Linux
+func RunSandboxInit(_ SandboxOptions, _ []string) (int, error) {
+	return 0, ErrSandboxNotSupported
+}
@@ -0,0 +1,291 @@
+package ssh
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/ssh"
+)
+
+// keepaliveCountMax is the number of unanswered keepalives after which the
+// connection is considered lost, like the ServerAliveCountMax of OpenSSH
+const keepaliveCountMax = 3
+
+const keepaliveRequest = "keepalive@openssh.com"
+
+// keepaliveCheckTimeout is the timeout of the keepalive checking whether the
+// connection is lost, when a session can't be opened
+const keepaliveCheckTimeout = 10 * time.Second
+
+var errKeepaliveTimeout = errors.New("keepalive timeout")
+
+// DefaultConnectionManager is the connection manager shared by the executors
+var DefaultConnectionManager = NewConnectionManager()
+
+// connection is an authenticated connection to a host, shared by the clients
+// which have the same configuration
+type connection struct {
+	key         string
+	client      *ssh.Client
+	jumpClients []*ssh.Client
+
+	users int
+	reuse bool
+
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func (c *connection) close() {
+	c.closeOnce.Do(func() {
+		close(c.done)
+		_ = c.client.Close()
+		closeClients(c.jumpClients)
+	})
+}
+
+func (c *connection) closed() bool {
+	select {
+	case <-c.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// ConnectionManager shares the authenticated connections to a host between
+// the clients of the same scope, for example between the stages of a job.
+// The connection is closed once released by its last client, unless it's
+// reused across the jobs. A reused connection is shared by at most
+// max_sessions clients, more connections are opened for the other ones. The
+// connections are checked with keepalives, and the lost ones are replaced by
+// the next client connecting.
+type ConnectionManager struct {
+	lock        sync.Mutex
+	connections map[string][]*connection
+
+	keepalive func(client *ssh.Client, timeout time.Duration) error
+}
+
+func NewConnectionManager() *ConnectionManager {
+	return &ConnectionManager{
+		connections: make(map[string][]*connection),
+		keepalive:   sendKeepalive,
+	}
+}
+
+// connectionKey identifies the connections which can be shared, they must
+// have the same host, authentication and settings, and the same scope unless
+// they are reused across the jobs
+func connectionKey(config Config, scope string) (string, error) {
+	if config.ReuseConnection {
+		scope = ""
+	}
+
+	data, err := json.Marshal(struct {
+		Config
+		Scope string
+	}{Config: config, Scope: scope})
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(data)
+
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// acquire returns a connection of the configuration and scope, connecting
+// with connect when all of them are used by max_sessions clients
+func (m *ConnectionManager) acquire(
+	config Config,
+	scope string,
+	connect func() (*ssh.Client, []*ssh.Client, error),
+) (*connection, error) {
+	key, err := connectionKey(config, scope)
+	if err != nil {
+		return nil, err
+	}
+
+	maxUsers := config.GetMaxSessions()
+	if conn := m.use(key, maxUsers); conn != nil {
+		return conn, nil
+	}
+
+	client, jumpClients, err := connect()
+	if err != nil {
+		return nil, err
+	}
+
+	conn := &connection{
+		key:         key,
+		client:      client,
+		jumpClients: jumpClients,
+		users:       1,
+		reuse:       config.ReuseConnection,
+		done:        make(chan struct{}),
+	}
+
+	m.lock.Lock()
+	if existing := m.available(key, maxUsers); existing != nil {
+		// another client connected to the host meanwhile
+		existing.users++
+		m.lock.Unlock()
+
+		conn.close()
+
+		return existing, nil
+	}
+
+	m.connections[key] = append(m.connections[key], conn)
+	m.lock.Unlock()
+
+	go m.watch(conn, config.GetKeepaliveInterval())
+
+	return conn, nil
+}
+
+func (m *ConnectionManager) use(key string, maxUsers int) *connection {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	conn := m.available(key, maxUsers)
+	if conn == nil {
+		return nil
+	}
+
+	conn.users++
+
+	return conn
+}
+
+// available returns a connection of the key with less than maxUsers clients
+func (m *ConnectionManager) available(key string, maxUsers int) *connection {
+	for _, conn := range m.connections[key] {
+		if !conn.closed() && conn.users < maxUsers {
+			return conn
+		}
+	}
+
+	return nil
+}
+
+// release is called by the clients which don't use the connection anymore,
+// the connection is closed after its last client unless it's reused
+func (m *ConnectionManager) release(conn *connection) {
+	m.lock.Lock()
+
+	conn.users--
+	if conn.users > 0 || (conn.reuse && !conn.closed()) {
+		m.lock.Unlock()
+		return
+	}
+
+	m.remove(conn)
+	m.lock.Unlock()
+
+	conn.close()
+}
+
+// drop closes the connection for all its clients, when it's lost
+func (m *ConnectionManager) drop(conn *connection) {
+	m.lock.Lock()
+	m.remove(conn)
+	m.lock.Unlock()
+
+	conn.close()
+}
+
+func (m *ConnectionManager) remove(conn *connection) {
+	connections := m.connections[conn.key]
+	for i, c := range connections {
+		if c != conn {
+			continue
+		}
+
+		connections = append(connections[:i:i], connections[i+1:]...)
+		break
+	}
+
+	if len(connections) == 0 {
+		delete(m.connections, conn.key)
+		return
+	}
+
+	m.connections[conn.key] = connections
+}
+
+// alive checks with a keepalive whether the connection still works
+func (m *ConnectionManager) alive(conn *connection) bool {
+	return !conn.closed() && m.keepalive(conn.client, keepaliveCheckTimeout) == nil
+}
+
+// watch sends the keepalives of the connection, and drops it when it's lost
+func (m *ConnectionManager) watch(conn *connection, interval time.Duration) {
+	lost := make(chan struct{})
+	go func() {
+		_ = conn.client.Wait()
+		close(lost)
+	}()
+
+	var ticks <-chan time.Time
+	if interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		ticks = ticker.C
+	}
+
+	failures := 0
+	for {
+		select {
+		case <-conn.done:
+			return
+		case <-lost:
+			m.drop(conn)
+			return
+		case <-ticks:
+		}
+
+		err := m.keepalive(conn.client, interval)
+		if err == nil {
+			failures = 0
+			continue
+		}
+
+		failures++
+		if failures >= keepaliveCountMax {
+			logrus.WithError(err).
+				WithField("address", conn.client.RemoteAddr().String()).
+				Warningln("SSH connection lost, no answer to the keepalives")
+
+			m.drop(conn)
+			return
+		}
+	}
+}
+
+// sendKeepalive sends a keepalive request, any answer of the server is a
+// sign of life
+func sendKeepalive(client *ssh.Client, timeout time.Duration) error {
+	errCh := make(chan error, 1)
+	go func() {
+		_, _, err := client.SendRequest(keepaliveRequest, true, nil)
+		errCh <- err
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case err := <-errCh:
+		return err
+	case <-timer.C:
+		return errKeepaliveTimeout
+	}
+}
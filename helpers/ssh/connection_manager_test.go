@@ -0,0 +1,273 @@
+//go:build !integration
+// +build !integration
+
+package ssh
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+)
+
+func newManagedClient(s *StubSSHServer, m *ConnectionManager, reuse bool) Client {
+	c := s.Client()
+	c.Manager = m
+	c.Scope = "job"
+	c.ConnectRetries = 1
+	c.ReuseConnection = reuse
+
+	return c
+}
+
+func connectManaged(t *testing.T, s *StubSSHServer, m *ConnectionManager, reuse bool) *Client {
+	c := newManagedClient(s, m, reuse)
+	require.NoError(t, c.Connect())
+
+	return &c
+}
+
+func waitClosed(t *testing.T, conn *connection) {
+	select {
+	case <-conn.done:
+	case <-time.After(5 * time.Second):
+		require.Fail(t, "the connection wasn't closed")
+	}
+}
+
+func TestConnectionManager_SharedConnection(t *testing.T) {
+	s, err := NewStubServer("user", "pass")
+	require.NoError(t, err)
+	defer s.Stop()
+
+	m := NewConnectionManager()
+
+	c1 := connectManaged(t, s, m, false)
+	c2 := connectManaged(t, s, m, false)
+
+	assert.Same(t, c1.shared, c2.shared)
+	assert.Equal(t, 2, c1.shared.users)
+
+	require.NoError(t, c1.Run(context.Background(), Command{Command: "true"}))
+	require.NoError(t, c2.Exec("true"))
+
+	conn := c1.shared
+
+	c1.Cleanup()
+	assert.False(t, conn.closed())
+
+	c2.Cleanup()
+	waitClosed(t, conn)
+	assert.Empty(t, m.connections)
+}
+
+func TestConnectionManager_DifferentConfigurations(t *testing.T) {
+	s, err := NewStubServer("user", "pass")
+	require.NoError(t, err)
+	defer s.Stop()
+
+	m := NewConnectionManager()
+
+	c1 := connectManaged(t, s, m, false)
+	defer c1.Cleanup()
+
+	c2 := newManagedClient(s, m, false)
+	c2.KeepaliveInterval = 60
+	require.NoError(t, c2.Connect())
+	defer c2.Cleanup()
+
+	assert.NotSame(t, c1.shared, c2.shared)
+}
+
+func TestConnectionManager_Scopes(t *testing.T) {
+	s, err := NewStubServer("user", "pass")
+	require.NoError(t, err)
+	defer s.Stop()
+
+	m := NewConnectionManager()
+
+	c1 := connectManaged(t, s, m, false)
+	defer c1.Cleanup()
+
+	// the clients of another job don't share the connection
+	c2 := newManagedClient(s, m, false)
+	c2.Scope = "other-job"
+	require.NoError(t, c2.Connect())
+	defer c2.Cleanup()
+
+	assert.NotSame(t, c1.shared, c2.shared)
+
+	// the reused connections are shared across the jobs
+	c3 := connectManaged(t, s, m, true)
+	defer c3.Cleanup()
+
+	c4 := newManagedClient(s, m, true)
+	c4.Scope = "other-job"
+	require.NoError(t, c4.Connect())
+	defer c4.Cleanup()
+
+	assert.Same(t, c3.shared, c4.shared)
+}
+
+func TestConnectionManager_MaxSessions(t *testing.T) {
+	s, err := NewStubServer("user", "pass")
+	require.NoError(t, err)
+	defer s.Stop()
+
+	m := NewConnectionManager()
+
+	var clients []*Client
+	for i := 0; i < 3; i++ {
+		c := newManagedClient(s, m, true)
+		c.MaxSessions = 2
+		require.NoError(t, c.Connect())
+		defer c.Cleanup()
+
+		clients = append(clients, &c)
+	}
+
+	assert.Same(t, clients[0].shared, clients[1].shared)
+	assert.NotSame(t, clients[0].shared, clients[2].shared, "a connection is shared by at most max_sessions clients")
+
+	for _, c := range clients {
+		require.NoError(t, c.Exec("true"))
+	}
+
+	// the released slot of the first connection is used again
+	conn := clients[1].shared
+	clients[1].Cleanup()
+
+	c := newManagedClient(s, m, true)
+	c.MaxSessions = 2
+	require.NoError(t, c.Connect())
+	defer c.Cleanup()
+
+	assert.Same(t, conn, c.shared)
+}
+
+func TestConnectionManager_ReuseConnection(t *testing.T) {
+	s, err := NewStubServer("user", "pass")
+	require.NoError(t, err)
+	defer s.Stop()
+
+	m := NewConnectionManager()
+
+	c1 := connectManaged(t, s, m, true)
+	conn := c1.shared
+	c1.Cleanup()
+
+	assert.False(t, conn.closed())
+	assert.Equal(t, 0, conn.users)
+
+	c2 := connectManaged(t, s, m, true)
+	defer c2.Cleanup()
+
+	assert.Same(t, conn, c2.shared)
+}
+
+func TestConnectionManager_LostConnection(t *testing.T) {
+	s, err := NewStubServer("user", "pass")
+	require.NoError(t, err)
+	defer s.Stop()
+
+	m := NewConnectionManager()
+
+	c1 := connectManaged(t, s, m, true)
+	defer c1.Cleanup()
+
+	conn := c1.shared
+	_ = conn.client.Close()
+	waitClosed(t, conn)
+
+	// the next client gets a new connection, and the clients of the lost
+	// connection reconnect with their next session
+	c2 := connectManaged(t, s, m, true)
+	defer c2.Cleanup()
+
+	assert.NotSame(t, conn, c2.shared)
+
+	require.NoError(t, c1.Run(context.Background(), Command{Command: "true"}))
+	assert.Same(t, c2.shared, c1.shared)
+	assert.Equal(t, 2, c1.shared.users)
+}
+
+func TestConnectionManager_Alive(t *testing.T) {
+	s, err := NewStubServer("user", "pass")
+	require.NoError(t, err)
+	defer s.Stop()
+
+	m := NewConnectionManager()
+
+	c1 := connectManaged(t, s, m, true)
+	defer c1.Cleanup()
+
+	// a session error of a client doesn't drop a working connection
+	conn := c1.shared
+	assert.True(t, m.alive(conn))
+
+	_ = conn.client.Close()
+	assert.False(t, m.alive(conn), "a closed connection isn't alive")
+}
+
+func TestConnectionManager_Keepalives(t *testing.T) {
+	s, err := NewStubServer("user", "pass")
+	require.NoError(t, err)
+	defer s.Stop()
+
+	var lock sync.Mutex
+	answered := true
+	keepalives := 0
+
+	m := NewConnectionManager()
+	m.keepalive = func(client *ssh.Client, timeout time.Duration) error {
+		lock.Lock()
+		defer lock.Unlock()
+
+		keepalives++
+		if !answered {
+			return errKeepaliveTimeout
+		}
+
+		return sendKeepalive(client, timeout)
+	}
+
+	// the keepalives are sent by the watch of the test
+	c := s.Client()
+	c.ConnectRetries = 1
+	c.KeepaliveInterval = -1
+
+	conn, err := m.acquire(c.Config, "", c.connect)
+	require.NoError(t, err)
+
+	go m.watch(conn, 10*time.Millisecond)
+
+	assert.Eventually(t, func() bool {
+		lock.Lock()
+		defer lock.Unlock()
+
+		return keepalives >= keepaliveCountMax+1
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.False(t, conn.closed(), "the answered keepalives keep the connection")
+
+	lock.Lock()
+	answered = false
+	lock.Unlock()
+
+	waitClosed(t, conn)
+	assert.Empty(t, m.connections)
+}
+
+func TestConnectionManager_ConnectError(t *testing.T) {
+	m := NewConnectionManager()
+
+	_, err := m.acquire(Config{}, "", func() (*ssh.Client, []*ssh.Client, error) {
+		return nil, nil, errors.New("connection refused")
+	})
+	assert.EqualError(t, err, "connection refused")
+	assert.Empty(t, m.connections)
+}
@@ -0,0 +1,164 @@
+package ssh
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+const (
+	markerCertAuthority = "cert-authority"
+	markerRevoked       = "revoked"
+)
+
+type hostAuthority struct {
+	patterns []string
+	key      ssh.PublicKey
+}
+
+// hostAuthorities are the @cert-authority and @revoked entries of a
+// known_hosts file. Their patterns are matched like OpenSSH does, against the
+// host for the port 22 and against [host]:port for the other ports, which the
+// knownhosts package doesn't support.
+type hostAuthorities struct {
+	authorities []hostAuthority
+	revoked     []ssh.PublicKey
+}
+
+func readHostAuthorities(knownHostsFile string) (*hostAuthorities, error) {
+	data, err := ioutil.ReadFile(knownHostsFile)
+	if err != nil {
+		return nil, err
+	}
+
+	authorities := new(hostAuthorities)
+	for len(data) > 0 {
+		var (
+			marker string
+			hosts  []string
+			key    ssh.PublicKey
+		)
+
+		marker, hosts, key, _, data, err = ssh.ParseKnownHosts(data)
+		if err != nil {
+			return nil, fmt.Errorf("parsing %s: %w", knownHostsFile, err)
+		}
+
+		switch marker {
+		case markerCertAuthority:
+			authorities.authorities = append(authorities.authorities, hostAuthority{patterns: hosts, key: key})
+		case markerRevoked:
+			authorities.revoked = append(authorities.revoked, key)
+		}
+	}
+
+	return authorities, nil
+}
+
+func (a *hostAuthorities) IsHostAuthority(auth ssh.PublicKey, address string) bool {
+	if a.isRevokedKey(auth) {
+		return false
+	}
+
+	host := knownhosts.Normalize(address)
+
+	for _, authority := range a.authorities {
+		if bytes.Equal(authority.key.Marshal(), auth.Marshal()) && matchHostPatterns(authority.patterns, host) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (a *hostAuthorities) IsRevoked(cert *ssh.Certificate) bool {
+	return a.isRevokedKey(cert.Key) || a.isRevokedKey(cert)
+}
+
+func (a *hostAuthorities) isRevokedKey(key ssh.PublicKey) bool {
+	for _, revoked := range a.revoked {
+		if bytes.Equal(revoked.Marshal(), key.Marshal()) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// matchHostPatterns matches the host against the comma separated patterns of
+// a known_hosts entry, a negated pattern rejects the host
+func matchHostPatterns(patterns []string, host string) bool {
+	matched := false
+	for _, pattern := range patterns {
+		negated := strings.HasPrefix(pattern, "!")
+		pattern = strings.TrimPrefix(pattern, "!")
+
+		if !wildcardMatch(pattern, host) {
+			continue
+		}
+
+		if negated {
+			return false
+		}
+
+		matched = true
+	}
+
+	return matched
+}
+
+// wildcardMatch matches the string against a pattern where * matches any
+// characters and ? a single one
+func wildcardMatch(pattern string, str string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for i := 0; i <= len(str); i++ {
+				if wildcardMatch(pattern[1:], str[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if len(str) == 0 {
+				return false
+			}
+		default:
+			if len(str) == 0 || pattern[0] != str[0] {
+				return false
+			}
+		}
+
+		pattern = pattern[1:]
+		str = str[1:]
+	}
+
+	return len(str) == 0
+}
+
+// newKnownHostsCallback checks the host certificates against the
+// @cert-authority entries of the known_hosts file, and the other host keys
+// against its host entries
+func newKnownHostsCallback(knownHostsFile string) (ssh.HostKeyCallback, error) {
+	knownHostsCallback, err := knownhosts.New(knownHostsFile)
+	if err != nil {
+		return nil, err
+	}
+
+	authorities, err := readHostAuthorities(knownHostsFile)
+	if err != nil {
+		return nil, err
+	}
+
+	checker := &ssh.CertChecker{
+		IsHostAuthority: authorities.IsHostAuthority,
+		IsRevoked:       authorities.IsRevoked,
+		HostKeyFallback: knownHostsCallback,
+	}
+
+	return checker.CheckHostKey, nil
+}
@@ -6,13 +6,14 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"net"
 	"os"
 	"path/filepath"
 	"strings"
 	"time"
 
 	"golang.org/x/crypto/ssh"
-	"golang.org/x/crypto/ssh/knownhosts"
+	"golang.org/x/crypto/ssh/agent"
 )
 
 type Client struct {
@@ -22,7 +23,17 @@ type Client struct {
 	Stderr         io.Writer
 	ConnectRetries int
 
-	client *ssh.Client
+	// Manager shares the connection with the other clients of the host, when
+	// it's set
+	Manager *ConnectionManager
+	// Scope limits the sharing of the connection to the clients of the same
+	// scope, for example the stages of a job. It's ignored when the connection
+	// is reused across the jobs.
+	Scope string
+
+	client      *ssh.Client
+	jumpClients []*ssh.Client
+	shared      *connection
 }
 
 type Command struct {
@@ -58,18 +69,100 @@ func (s *Client) getSSHKey(identityFile string) (key ssh.Signer, err error) {
 	return key, err
 }
 
-func (s *Client) getSSHAuthMethods() ([]ssh.AuthMethod, error) {
+func (s *Client) getSSHCertificate(certificateFile string) (*ssh.Certificate, error) {
+	buf, err := ioutil.ReadFile(certificateFile)
+	if err != nil {
+		return nil, err
+	}
+
+	key, _, _, _, err := ssh.ParseAuthorizedKey(buf)
+	if err != nil {
+		return nil, err
+	}
+
+	cert, ok := key.(*ssh.Certificate)
+	if !ok {
+		return nil, fmt.Errorf("%s isn't an SSH certificate", certificateFile)
+	}
+
+	return cert, nil
+}
+
+func (s *Client) getSSHSigners() ([]ssh.Signer, error) {
+	if s.IdentityFile == "" {
+		if s.CertificateFile != "" {
+			return nil, errors.New("the certificate file requires an identity file")
+		}
+
+		return nil, nil
+	}
+
+	key, err := s.getSSHKey(s.IdentityFile)
+	if err != nil {
+		return nil, err
+	}
+
+	if s.CertificateFile == "" {
+		return []ssh.Signer{key}, nil
+	}
+
+	cert, err := s.getSSHCertificate(s.CertificateFile)
+	if err != nil {
+		return nil, fmt.Errorf("reading certificate: %w", err)
+	}
+
+	certSigner, err := ssh.NewCertSigner(cert, key)
+	if err != nil {
+		return nil, fmt.Errorf("reading certificate: %w", err)
+	}
+
+	// the certificate is offered first, as the key alone may not be authorized
+	return []ssh.Signer{certSigner, key}, nil
+}
+
+func (s *Client) connectAgent() (net.Conn, error) {
+	socket := s.AgentSocket
+	if socket == "" {
+		socket = os.Getenv("SSH_AUTH_SOCK")
+	}
+
+	if socket == "" {
+		return nil, errors.New("no ssh-agent socket, SSH_AUTH_SOCK is empty")
+	}
+
+	return net.Dial("unix", socket)
+}
+
+// getSSHAuthMethods returns the authentication methods, the keys of the agent
+// are offered after the identity file ones when agentClient is set
+func (s *Client) getSSHAuthMethods(agentClient agent.Agent) ([]ssh.AuthMethod, error) {
 	var methods []ssh.AuthMethod
 	methods = append(methods, ssh.Password(s.Password))
 
-	if s.IdentityFile != "" {
-		key, err := s.getSSHKey(s.IdentityFile)
-		if err != nil {
-			return nil, err
+	signers, err := s.getSSHSigners()
+	if err != nil {
+		return nil, err
+	}
+
+	if agentClient == nil {
+		if len(signers) > 0 {
+			methods = append(methods, ssh.PublicKeys(signers...))
 		}
-		methods = append(methods, ssh.PublicKeys(key))
+
+		return methods, nil
 	}
 
+	// the public key method is tried only once, so the keys of the agent are
+	// offered by the same method
+	methods = append(methods, ssh.PublicKeysCallback(func() ([]ssh.Signer, error) {
+		agentSigners, err := agentClient.Signers()
+		if err != nil {
+			return nil, fmt.Errorf("ssh-agent signers: %w", err)
+		}
+
+		return append(signers, agentSigners...), nil
+	}))
+
 	return methods, nil
 }
 
@@ -87,7 +180,7 @@ func getHostKeyCallback(config Config) (ssh.HostKeyCallback, error) {
 		config.KnownHostsFile = filepath.Join(homeDir, ".ssh", "known_hosts")
 	}
 
-	return knownhosts.New(config.KnownHostsFile)
+	return newKnownHostsCallback(config.KnownHostsFile)
 }
 
 func (s *Client) Connect() error {
@@ -101,9 +194,64 @@ func (s *Client) Connect() error {
 		s.Port = "22"
 	}
 
-	methods, err := s.getSSHAuthMethods()
+	if s.Manager != nil {
+		return s.connectShared()
+	}
+
+	client, jumpClients, err := s.connect()
+	if err != nil {
+		return err
+	}
+
+	s.client = client
+	s.jumpClients = jumpClients
+
+	return nil
+}
+
+func (s *Client) connectShared() error {
+	conn, err := s.Manager.acquire(s.Config, s.Scope, s.connect)
+	if err != nil {
+		return err
+	}
+
+	s.shared = conn
+	s.client = conn.client
+
+	return nil
+}
+
+// reconnect replaces the shared connection, when it's lost
+func (s *Client) reconnect() error {
+	s.Manager.drop(s.shared)
+	s.Manager.release(s.shared)
+	s.shared = nil
+	s.client = nil
+
+	return s.connectShared()
+}
+
+func (s *Client) connect() (*ssh.Client, []*ssh.Client, error) {
+	var agentClient agent.Agent
+	if s.ShouldUseAgent() {
+		agentConn, err := s.connectAgent()
+		if err != nil {
+			return nil, nil, fmt.Errorf("connecting to ssh-agent: %w", err)
+		}
+		// the agent is needed only for the authentication
+		defer func() { _ = agentConn.Close() }()
+
+		agentClient = agent.NewClient(agentConn)
+	}
+
+	methods, err := s.getSSHAuthMethods(agentClient)
 	if err != nil {
-		return fmt.Errorf("getting SSH authentication methods: %w", err)
+		return nil, nil, fmt.Errorf("getting SSH authentication methods: %w", err)
+	}
+
+	jumpHosts, err := s.GetJumpHosts()
+	if err != nil {
+		return nil, nil, fmt.Errorf("getting jump hosts: %w", err)
 	}
 
 	config := &ssh.ClientConfig{
@@ -113,7 +261,7 @@ func (s *Client) Connect() error {
 
 	hostKeyCallback, err := getHostKeyCallback(s.Config)
 	if err != nil {
-		return fmt.Errorf("getting host key callback: %w", err)
+		return nil, nil, fmt.Errorf("getting host key callback: %w", err)
 	}
 	config.HostKeyCallback = hostKeyCallback
 
@@ -125,25 +273,119 @@ func (s *Client) Connect() error {
 	var finalError error
 
 	for i := 0; i < connectRetries; i++ {
-		client, err := ssh.Dial("tcp", s.Host+":"+s.Port, config)
+		if i > 0 {
+			time.Sleep(sshRetryInterval * time.Second)
+		}
+
+		client, jumpClients, err := s.dial(config, jumpHosts)
 		if err == nil {
-			s.client = client
-			return nil
+			return client, jumpClients, nil
 		}
 
-		time.Sleep(sshRetryInterval * time.Second)
 		finalError = fmt.Errorf("ssh Dial() error: %w", err)
 	}
 
-	return finalError
+	return nil, nil, finalError
 }
 
-func (s *Client) Exec(cmd string) error {
+// dial connects to the host through the jump hosts, each hop is connected
+// through the connection to the previous one
+func (s *Client) dial(config *ssh.ClientConfig, jumpHosts []JumpHost) (*ssh.Client, []*ssh.Client, error) {
+	hops := make([]JumpHost, 0, len(jumpHosts)+1)
+	hops = append(hops, jumpHosts...)
+	hops = append(hops, JumpHost{Host: s.Host, Port: s.Port})
+
+	var clients []*ssh.Client
+	for i, hop := range hops {
+		hopConfig := *config
+		if hop.User != "" {
+			hopConfig.User = hop.User
+		}
+
+		port := hop.Port
+		if port == "" {
+			port = "22"
+		}
+
+		var previous *ssh.Client
+		if i > 0 {
+			previous = clients[i-1]
+		}
+
+		address := net.JoinHostPort(hop.Host, port)
+		client, err := dialHop(previous, address, &hopConfig)
+		if err != nil {
+			closeClients(clients)
+
+			if i < len(jumpHosts) {
+				return nil, nil, fmt.Errorf("jump host %s: %w", address, err)
+			}
+
+			return nil, nil, err
+		}
+
+		clients = append(clients, client)
+	}
+
+	return clients[len(clients)-1], clients[:len(clients)-1], nil
+}
+
+func dialHop(previous *ssh.Client, address string, config *ssh.ClientConfig) (*ssh.Client, error) {
+	if previous == nil {
+		return ssh.Dial("tcp", address, config)
+	}
+
+	conn, err := previous.Dial("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+
+	clientConn, chans, reqs, err := ssh.NewClientConn(conn, address, config)
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+
+	return ssh.NewClient(clientConn, chans, reqs), nil
+}
+
+// closeClients closes the clients from the last hop
+func closeClients(clients []*ssh.Client) {
+	for i := len(clients) - 1; i >= 0; i-- {
+		_ = clients[i].Close()
+	}
+}
+
+// newSession opens a session, the shared connection is replaced when it was
+// lost since the previous session
+func (s *Client) newSession() (*ssh.Session, error) {
 	if s.client == nil {
-		return errors.New("not connected")
+		return nil, errors.New("not connected")
 	}
 
 	session, err := s.client.NewSession()
+
+	var openChannelErr *ssh.OpenChannelError
+	if err == nil || s.shared == nil || errors.As(err, &openChannelErr) {
+		// the session was rejected by a working connection
+		return session, err
+	}
+
+	// the connection is shared, it's replaced only when it's really lost
+	if s.Manager.alive(s.shared) {
+		return nil, err
+	}
+
+	reconnectErr := s.reconnect()
+	if reconnectErr != nil {
+		return nil, fmt.Errorf("reconnecting after %v: %w", err, reconnectErr)
+	}
+
+	return s.client.NewSession()
+}
+
+func (s *Client) Exec(cmd string) error {
+	session, err := s.newSession()
 	if err != nil {
 		return err
 	}
@@ -155,11 +397,7 @@ func (s *Client) Exec(cmd string) error {
 }
 
 func (s *Client) Run(ctx context.Context, cmd Command) error {
-	if s.client == nil {
-		return errors.New("not connected")
-	}
-
-	session, err := s.client.NewSession()
+	session, err := s.newSession()
 	if err != nil {
 		return err
 	}
@@ -194,7 +432,17 @@ func (s *Client) Run(ctx context.Context, cmd Command) error {
 }
 
 func (s *Client) Cleanup() {
+	if s.shared != nil {
+		s.Manager.release(s.shared)
+		s.shared = nil
+		s.client = nil
+		return
+	}
+
 	if s.client != nil {
 		_ = s.client.Close()
 	}
+
+	closeClients(s.jumpClients)
+	s.jumpClients = nil
 }
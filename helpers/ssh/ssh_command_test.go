@@ -4,14 +4,23 @@
 package ssh_test
 
 import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
 	"fmt"
 	"io/ioutil"
+	"net"
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	cryptoSSH "golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+
 	"gitlab.com/gitlab-org/gitlab-runner/helpers/ssh"
 )
 
@@ -85,6 +94,412 @@ func TestStrictHostCheckingWithKnownHostsFile(t *testing.T) {
 	}
 }
 
+func newTestKey(t *testing.T) (ed25519.PrivateKey, cryptoSSH.Signer) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	signer, err := cryptoSSH.NewSignerFromKey(key)
+	require.NoError(t, err)
+
+	return key, signer
+}
+
+func writeTestFile(t *testing.T, name string, data []byte) string {
+	file := filepath.Join(t.TempDir(), name)
+	require.NoError(t, ioutil.WriteFile(file, data, 0600))
+
+	return file
+}
+
+func writeTestKey(t *testing.T, key ed25519.PrivateKey) string {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+
+	return writeTestFile(t, "id_ed25519", pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+}
+
+func newUserCertificate(t *testing.T, ca cryptoSSH.Signer, key cryptoSSH.PublicKey, principals ...string) *cryptoSSH.Certificate {
+	cert := &cryptoSSH.Certificate{
+		Key:             key,
+		CertType:        cryptoSSH.UserCert,
+		KeyId:           "test",
+		ValidPrincipals: principals,
+		ValidAfter:      uint64(time.Now().Add(-time.Hour).Unix()),
+		ValidBefore:     uint64(time.Now().Add(time.Hour).Unix()),
+	}
+	require.NoError(t, cert.SignCert(rand.Reader, ca))
+
+	return cert
+}
+
+func TestCertificateAuthentication(t *testing.T) {
+	user := "testuser"
+
+	_, ca := newTestKey(t)
+	_, otherCA := newTestKey(t)
+
+	s, err := ssh.NewStubServer(user, "testpass", ssh.WithUserCertificateAuthority(ca.PublicKey()))
+	require.NoError(t, err)
+	defer s.Stop()
+
+	key, signer := newTestKey(t)
+	identityFile := writeTestKey(t, key)
+
+	testCases := map[string]struct {
+		certificate *cryptoSSH.Certificate
+		expectedErr bool
+	}{
+		"certificate signed by the authority": {
+			certificate: newUserCertificate(t, ca, signer.PublicKey(), user),
+		},
+		"certificate signed by another authority": {
+			certificate: newUserCertificate(t, otherCA, signer.PublicKey(), user),
+			expectedErr: true,
+		},
+		"certificate of another user": {
+			certificate: newUserCertificate(t, ca, signer.PublicKey(), "other"),
+			expectedErr: true,
+		},
+		"no certificate": {
+			expectedErr: true,
+		},
+	}
+
+	for tn, tc := range testCases {
+		t.Run(tn, func(t *testing.T) {
+			c := s.Client()
+			c.Password = "wrong"
+			c.IdentityFile = identityFile
+			c.ConnectRetries = 1
+
+			if tc.certificate != nil {
+				c.CertificateFile = writeTestFile(t, "id_ed25519-cert.pub", cryptoSSH.MarshalAuthorizedKey(tc.certificate))
+			}
+
+			err := c.Connect()
+			defer c.Cleanup()
+
+			if tc.expectedErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestCertificateWithoutIdentityFile(t *testing.T) {
+	c := ssh.Client{Config: ssh.Config{CertificateFile: "id_ed25519-cert.pub"}}
+
+	err := c.Connect()
+	assert.EqualError(t, err, "getting SSH authentication methods: the certificate file requires an identity file")
+}
+
+func startTestAgent(t *testing.T, keys ...agent.AddedKey) string {
+	keyring := agent.NewKeyring()
+	for _, key := range keys {
+		require.NoError(t, keyring.Add(key))
+	}
+
+	socket := filepath.Join(t.TempDir(), "agent.sock")
+	listener, err := net.Listen("unix", socket)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			go func() {
+				_ = agent.ServeAgent(keyring, conn)
+				_ = conn.Close()
+			}()
+		}
+	}()
+
+	return socket
+}
+
+func TestAgentAuthentication(t *testing.T) {
+	user := "testuser"
+
+	_, ca := newTestKey(t)
+
+	s, err := ssh.NewStubServer(user, "testpass", ssh.WithUserCertificateAuthority(ca.PublicKey()))
+	require.NoError(t, err)
+	defer s.Stop()
+
+	testKey, err := cryptoSSH.ParseRawPrivateKey([]byte(ssh.TestSSHKeyPair.PrivateKey))
+	require.NoError(t, err)
+
+	key, signer := newTestKey(t)
+
+	testCases := map[string]struct {
+		keys        []agent.AddedKey
+		useAgent    bool
+		socketEnv   bool
+		expectedErr string
+	}{
+		"authorized key from the agent socket": {
+			keys: []agent.AddedKey{{PrivateKey: testKey}},
+		},
+		"authorized key from SSH_AUTH_SOCK": {
+			keys:      []agent.AddedKey{{PrivateKey: testKey}},
+			useAgent:  true,
+			socketEnv: true,
+		},
+		"certificate from the agent": {
+			keys: []agent.AddedKey{{
+				PrivateKey:  key,
+				Certificate: newUserCertificate(t, ca, signer.PublicKey(), user),
+			}},
+		},
+		"unknown key": {
+			keys:        []agent.AddedKey{{PrivateKey: key}},
+			expectedErr: "ssh Dial() error: ssh: handshake failed: ssh: unable to authenticate",
+		},
+		"agent without socket": {
+			useAgent:    true,
+			socketEnv:   true,
+			expectedErr: "connecting to ssh-agent: no ssh-agent socket, SSH_AUTH_SOCK is empty",
+		},
+	}
+
+	for tn, tc := range testCases {
+		t.Run(tn, func(t *testing.T) {
+			socket := ""
+			if len(tc.keys) > 0 {
+				socket = startTestAgent(t, tc.keys...)
+			}
+
+			c := s.Client()
+			c.Password = "wrong"
+			c.IdentityFile = ""
+			c.ConnectRetries = 1
+			c.UseAgent = tc.useAgent
+
+			if tc.socketEnv {
+				t.Setenv("SSH_AUTH_SOCK", socket)
+			} else {
+				c.AgentSocket = socket
+			}
+
+			err := c.Connect()
+			defer c.Cleanup()
+
+			if tc.expectedErr != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tc.expectedErr)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestHostCertificateAuthority(t *testing.T) {
+	user, pass := "testuser", "testpass"
+
+	_, ca := newTestKey(t)
+	_, otherCA := newTestKey(t)
+
+	caEntry := func(marker string, patterns string, ca cryptoSSH.Signer) string {
+		return marker + " " + patterns + " " + string(cryptoSSH.MarshalAuthorizedKey(ca.PublicKey()))
+	}
+
+	testCases := map[string]struct {
+		principal   string
+		knownHosts  string
+		expectedErr bool
+	}{
+		"certificate signed by the authority": {
+			principal:  "127.0.0.1",
+			knownHosts: caEntry("@cert-authority", "*", ca),
+		},
+		"authority of the hosts with any port": {
+			principal:  "127.0.0.1",
+			knownHosts: caEntry("@cert-authority", "example.com,[127.0.0.*]:*", ca),
+		},
+		"authority of the hosts on port 22": {
+			principal:   "127.0.0.1",
+			knownHosts:  caEntry("@cert-authority", "127.0.0.1", ca),
+			expectedErr: true,
+		},
+		"host excluded from the authority": {
+			principal:   "127.0.0.1",
+			knownHosts:  caEntry("@cert-authority", "*,![127.0.0.1]:*", ca),
+			expectedErr: true,
+		},
+		"revoked authority": {
+			principal:   "127.0.0.1",
+			knownHosts:  caEntry("@cert-authority", "*", ca) + caEntry("@revoked", "*", ca),
+			expectedErr: true,
+		},
+		"certificate signed by another authority": {
+			principal:   "127.0.0.1",
+			knownHosts:  caEntry("@cert-authority", "*", otherCA),
+			expectedErr: true,
+		},
+		"certificate of another host": {
+			principal:   "example.com",
+			knownHosts:  caEntry("@cert-authority", "*", ca),
+			expectedErr: true,
+		},
+	}
+
+	for tn, tc := range testCases {
+		t.Run(tn, func(t *testing.T) {
+			s, err := ssh.NewStubServer(user, pass, ssh.WithHostCertificate(ca, tc.principal))
+			require.NoError(t, err)
+			defer s.Stop()
+
+			disableHostChecking := false
+
+			c := s.Client()
+			c.DisableStrictHostKeyChecking = &disableHostChecking
+			c.KnownHostsFile = writeTestFile(t, "known_hosts", []byte(tc.knownHosts))
+			c.ConnectRetries = 1
+
+			err = c.Connect()
+			defer c.Cleanup()
+
+			if tc.expectedErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestJumpHosts(t *testing.T) {
+	user, pass := "testuser", "testpass"
+
+	_, ca := newTestKey(t)
+
+	servers := make([]*ssh.StubSSHServer, 3)
+	for i := range servers {
+		s, err := ssh.NewStubServer(user, pass, ssh.WithHostCertificate(ca, "127.0.0.1"))
+		require.NoError(t, err)
+		defer s.Stop()
+
+		servers[i] = s
+	}
+
+	// nothing listens on the closed listener address
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	downHost := listener.Addr().String()
+	require.NoError(t, listener.Close())
+
+	knownHostsFile := writeTestFile(
+		t,
+		"known_hosts",
+		[]byte("@cert-authority * "+string(cryptoSSH.MarshalAuthorizedKey(ca.PublicKey()))),
+	)
+
+	testCases := map[string]struct {
+		proxyJump   string
+		expectedErr string
+	}{
+		"single jump host": {
+			proxyJump: "127.0.0.1:" + servers[0].Port(),
+		},
+		"multiple jump hosts": {
+			proxyJump: fmt.Sprintf("%s@127.0.0.1:%s, 127.0.0.1:%s", user, servers[0].Port(), servers[1].Port()),
+		},
+		"jump host with another user": {
+			proxyJump:   "other@127.0.0.1:" + servers[0].Port(),
+			expectedErr: "jump host 127.0.0.1:" + servers[0].Port() + ": ssh: handshake failed",
+		},
+		"unreachable jump host": {
+			proxyJump:   fmt.Sprintf("127.0.0.1:%s,%s", servers[0].Port(), downHost),
+			expectedErr: "jump host " + downHost + ": ssh: rejected: connect failed",
+		},
+		"invalid jump host": {
+			proxyJump:   "user@",
+			expectedErr: `getting jump hosts: invalid jump host "user@": missing host`,
+		},
+	}
+
+	for tn, tc := range testCases {
+		t.Run(tn, func(t *testing.T) {
+			disableHostChecking := false
+
+			c := servers[2].Client()
+			c.DisableStrictHostKeyChecking = &disableHostChecking
+			c.KnownHostsFile = knownHostsFile
+			c.ProxyJump = tc.proxyJump
+			c.ConnectRetries = 1
+
+			err := c.Connect()
+			defer c.Cleanup()
+
+			if tc.expectedErr != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tc.expectedErr)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestGetJumpHosts(t *testing.T) {
+	testCases := map[string]struct {
+		proxyJump   string
+		expected    []ssh.JumpHost
+		expectedErr bool
+	}{
+		"no jump hosts": {},
+		"host": {
+			proxyJump: "bastion",
+			expected:  []ssh.JumpHost{{Host: "bastion"}},
+		},
+		"user, host and port": {
+			proxyJump: "admin@bastion:2222",
+			expected:  []ssh.JumpHost{{User: "admin", Host: "bastion", Port: "2222"}},
+		},
+		"IPv6 hosts": {
+			proxyJump: "[::1]:2222,fe80::1",
+			expected:  []ssh.JumpHost{{Host: "::1", Port: "2222"}, {Host: "fe80::1"}},
+		},
+		"multiple hops": {
+			proxyJump: "admin@bastion-1, bastion-2:2222",
+			expected: []ssh.JumpHost{
+				{User: "admin", Host: "bastion-1"},
+				{Host: "bastion-2", Port: "2222"},
+			},
+		},
+		"empty hop": {
+			proxyJump:   "bastion-1,,bastion-2",
+			expectedErr: true,
+		},
+		"invalid port": {
+			proxyJump:   "[::1]2222",
+			expectedErr: true,
+		},
+	}
+
+	for tn, tc := range testCases {
+		t.Run(tn, func(t *testing.T) {
+			c := ssh.Config{ProxyJump: tc.proxyJump}
+
+			jumpHosts, err := c.GetJumpHosts()
+			if tc.expectedErr {
+				assert.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, jumpHosts)
+		})
+	}
+}
+
 //nolint:lll
 var knownHostsWithGitlabOnly = `gitlab.com ssh-rsa AAAAB3NzaC1yc2EAAAADAQABAAABAQCsj2bNKTBSpIYDEGk9KxsGh3mySTRgMtXL583qmBpzeQ+jqCMRgBqB98u3z++J1sKlXHWfM9dyhSevkMwSbhoR8XIq/U0tCNyokEi/ueaBMCvbcTHhO7FcwzY92WK4Yt0aGROY5qX2UKSeOvuP4D6TPqKF1onrSzH9bx9XUf2lEdWT/ia1NEKjunUqu1xOB/StKDHMoX4/OKyIzuS0q/T1zOATthvasJFoPrAjkohTyaDUz2LN5JoH839hViyEG82yB+MjcFV5MU3N1l1QL3cVUCh93xSaua1N85qivl+siMkPGbO5xR/En4iEY6K2XPASUEMaieWVNTRCtJ4S8H+9
 gitlab.com ecdsa-sha2-nistp256 AAAAE2VjZHNhLXNoYTItbmlzdHAyNTYAAAAIbmlzdHAyNTYAAABBBFSMqzJeV9rUzU4kWitGjeR4PWSa29SPqJ1fVkhtj3Hw9xjLVXVYrU9QlYWrOLXBpQ6KWjbjTDTdDkoohFzgbEY=
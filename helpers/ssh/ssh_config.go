@@ -1,5 +1,17 @@
 package ssh
 
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+const defaultKeepaliveInterval = 30 * time.Second
+
+// defaultMaxSessions is the default MaxSessions of the OpenSSH server
+const defaultMaxSessions = 10
+
 //nolint:lll
 type Config struct {
 	User                         string `toml:"user,omitempty" json:"user" long:"user" env:"SSH_USER" description:"User name"`
@@ -8,9 +20,97 @@ type Config struct {
 	Port                         string `toml:"port,omitempty" json:"port" long:"port" env:"SSH_PORT" description:"Remote host port"`
 	IdentityFile                 string `toml:"identity_file,omitempty" json:"identity_file" long:"identity-file" env:"SSH_IDENTITY_FILE" description:"Identity file to be used"`
 	DisableStrictHostKeyChecking *bool  `toml:"disable_strict_host_key_checking,omitempty" json:"disable_strict_host_key_checking" long:"disable-strict-host-key-checking" env:"DISABLE_STRICT_HOST_KEY_CHECKING" description:"Disable SSH strict host key checking"`
-	KnownHostsFile               string `toml:"known_hosts_file,omitempty" json:"known_hosts_file" long:"known-hosts-file" env:"KNOWN_HOSTS_FILE" description:"Location of known_hosts file. Defaults to ~/.ssh/known_hosts"`
+	KnownHostsFile               string `toml:"known_hosts_file,omitempty" json:"known_hosts_file" long:"known-hosts-file" env:"KNOWN_HOSTS_FILE" description:"Location of known_hosts file. Defaults to ~/.ssh/known_hosts. The host certificates are checked against its @cert-authority entries"`
+	CertificateFile              string `toml:"certificate_file,omitempty" json:"certificate_file" long:"certificate-file" env:"SSH_CERTIFICATE_FILE" description:"Certificate of the identity file key, signed by a certificate authority"`
+	UseAgent                     bool   `toml:"use_agent,omitzero" json:"use_agent" long:"use-agent" env:"SSH_USE_AGENT" description:"Authenticate with the keys of the ssh-agent"`
+	AgentSocket                  string `toml:"agent_socket,omitempty" json:"agent_socket" long:"agent-socket" env:"SSH_AGENT_SOCKET" description:"Location of the ssh-agent socket. Defaults to SSH_AUTH_SOCK"`
+	ProxyJump                    string `toml:"proxy_jump,omitempty" json:"proxy_jump" long:"proxy-jump" env:"SSH_PROXY_JUMP" description:"Comma-separated list of jump hosts, as [user@]host[:port], to connect through. They use the authentication and host key checking settings of the host"`
+	ReuseConnection              bool   `toml:"reuse_connection,omitzero" json:"reuse_connection" long:"reuse-connection" env:"SSH_REUSE_CONNECTION" description:"Keep the connection to the host open after the job, to be reused by the next jobs"`
+	KeepaliveInterval            int    `toml:"keepalive_interval,omitzero" json:"keepalive_interval" long:"keepalive-interval" env:"SSH_KEEPALIVE_INTERVAL" description:"Interval, in seconds, of the keepalives of the connection, which is considered lost after 3 unanswered ones. Defaults to 30 seconds, -1 disables the keepalives"`
+	MaxSessions                  int    `toml:"max_sessions,omitzero" json:"max_sessions" long:"max-sessions" env:"SSH_MAX_SESSIONS" description:"Maximal number of jobs sharing a reused connection, at most the MaxSessions of the SSH server. Defaults to 10"`
+}
+
+// JumpHost is a hop of the connection to the host
+type JumpHost struct {
+	User string
+	Host string
+	Port string
 }
 
 func (c *Config) ShouldDisableStrictHostKeyChecking() bool {
 	return c.DisableStrictHostKeyChecking == nil || *c.DisableStrictHostKeyChecking
 }
+
+// GetKeepaliveInterval returns the interval of the keepalives, 0 when they
+// are disabled
+func (c *Config) GetKeepaliveInterval() time.Duration {
+	if c.KeepaliveInterval < 0 {
+		return 0
+	}
+
+	if c.KeepaliveInterval == 0 {
+		return defaultKeepaliveInterval
+	}
+
+	return time.Duration(c.KeepaliveInterval) * time.Second
+}
+
+// GetMaxSessions returns the maximal number of clients sharing a connection
+func (c *Config) GetMaxSessions() int {
+	if c.MaxSessions <= 0 {
+		return defaultMaxSessions
+	}
+
+	return c.MaxSessions
+}
+
+func (c *Config) ShouldUseAgent() bool {
+	return c.UseAgent || c.AgentSocket != ""
+}
+
+// GetJumpHosts returns the jump hosts of the proxy_jump setting, in the order
+// of the connection
+func (c *Config) GetJumpHosts() ([]JumpHost, error) {
+	if strings.TrimSpace(c.ProxyJump) == "" {
+		return nil, nil
+	}
+
+	var jumpHosts []JumpHost
+	for _, entry := range strings.Split(c.ProxyJump, ",") {
+		jumpHost, err := parseJumpHost(strings.TrimSpace(entry))
+		if err != nil {
+			return nil, err
+		}
+
+		jumpHosts = append(jumpHosts, jumpHost)
+	}
+
+	return jumpHosts, nil
+}
+
+func parseJumpHost(entry string) (JumpHost, error) {
+	var jumpHost JumpHost
+
+	address := entry
+	if i := strings.LastIndex(entry, "@"); i >= 0 {
+		jumpHost.User = entry[:i]
+		address = entry[i+1:]
+	}
+
+	jumpHost.Host = address
+	if strings.HasPrefix(address, "[") || strings.Count(address, ":") == 1 {
+		host, port, err := net.SplitHostPort(address)
+		if err != nil {
+			return JumpHost{}, fmt.Errorf("invalid jump host %q: %w", entry, err)
+		}
+
+		jumpHost.Host = host
+		jumpHost.Port = port
+	}
+
+	if jumpHost.Host == "" {
+		return JumpHost{}, fmt.Errorf("invalid jump host %q: missing host", entry)
+	}
+
+	return jumpHost, nil
+}
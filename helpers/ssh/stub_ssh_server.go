@@ -1,11 +1,16 @@
 package ssh
 
 import (
+	"bytes"
+	"crypto/rand"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net"
 	"os"
 	"path/filepath"
+	"strconv"
+	"time"
 
 	"github.com/tevino/abool"
 	cryptoSSH "golang.org/x/crypto/ssh"
@@ -19,6 +24,8 @@ type StubSSHServer struct {
 	host               string
 	port               string
 	privateKeyLocation string
+	hostKey            cryptoSSH.Signer
+	userCAs            []cryptoSSH.PublicKey
 	stop               chan bool
 	shouldExit         *abool.AtomicBool
 	cleanup            func()
@@ -48,7 +55,48 @@ tlxpSUh4YUnfTGi4kAAAAham9obmNhaUBKb2hucy1NYWNCb29rLVByby0zLmxvY2FsAQI=
 	PublicKey: `ssh-rsa AAAAB3NzaC1yc2EAAAADAQABAAAAgQDYWe6ER/dsK1J7p7KDn8iveTOMbHeAWKPUfdCZ6sYjPPslb6jFVZ+v7HsjHrV1lwT/xVejDgLYEU5dE2hrwoU3WBCH6NttlOBzWxYJPKvqIpkgOgpHn1bilx5M9OcBDhEc00nCEJMMOxiWSUJEGknv13qe32vk7aRtWO7f/BMJSw==`,
 }
 
-func NewStubServer(user, pass string) (*StubSSHServer, error) {
+// StubServerOption configures the stub server before it starts
+type StubServerOption func(s *StubSSHServer) error
+
+// WithUserCertificateAuthority accepts the user certificates signed by the
+// certificate authority
+func WithUserCertificateAuthority(ca cryptoSSH.PublicKey) StubServerOption {
+	return func(s *StubSSHServer) error {
+		s.userCAs = append(s.userCAs, ca)
+		return nil
+	}
+}
+
+// WithHostCertificate presents a certificate of the host key, signed by the
+// certificate authority for the principals
+func WithHostCertificate(ca cryptoSSH.Signer, principals ...string) StubServerOption {
+	return func(s *StubSSHServer) error {
+		cert := &cryptoSSH.Certificate{
+			Key:             s.hostKey.PublicKey(),
+			CertType:        cryptoSSH.HostCert,
+			KeyId:           "stub-server",
+			ValidPrincipals: principals,
+			ValidAfter:      uint64(time.Now().Add(-time.Hour).Unix()),
+			ValidBefore:     uint64(time.Now().Add(time.Hour).Unix()),
+		}
+
+		err := cert.SignCert(rand.Reader, ca)
+		if err != nil {
+			return err
+		}
+
+		certSigner, err := cryptoSSH.NewCertSigner(cert, s.hostKey)
+		if err != nil {
+			return err
+		}
+
+		s.Config.AddHostKey(certSigner)
+
+		return nil
+	}
+}
+
+func NewStubServer(user, pass string, options ...StubServerOption) (*StubSSHServer, error) {
 	server := &StubSSHServer{
 		User:     user,
 		Password: pass,
@@ -63,6 +111,7 @@ func NewStubServer(user, pass string) (*StubSSHServer, error) {
 		stop:       make(chan bool),
 		shouldExit: abool.New(),
 	}
+	server.Config.PublicKeyCallback = server.checkPublicKey
 
 	tempDir, err := ioutil.TempDir("", "ssh-stub-server")
 	if err != nil {
@@ -90,8 +139,16 @@ func NewStubServer(user, pass string) (*StubSSHServer, error) {
 	}
 
 	server.privateKeyLocation = privateKeyLocation
+	server.hostKey = key
 	server.Config.AddHostKey(key)
 
+	for _, option := range options {
+		if err := option(server); err != nil {
+			server.cleanup()
+			return nil, err
+		}
+	}
+
 	if err := server.start(); err != nil {
 		return nil, err
 	}
@@ -151,10 +208,128 @@ func (s *StubSSHServer) mainLoop(listener net.Listener) {
 			return
 		}
 
-		// upgrade to ssh connection
-		_, _, _, _ = cryptoSSH.NewServerConn(conn, s.Config)
-		// This is enough just for handling incoming connections
+		go s.serve(conn)
+	}
+}
+
+// checkPublicKey accepts the test key pair and the certificates signed by the
+// user certificate authorities
+func (s *StubSSHServer) checkPublicKey(conn cryptoSSH.ConnMetadata, key cryptoSSH.PublicKey) (*cryptoSSH.Permissions, error) {
+	checker := &cryptoSSH.CertChecker{
+		IsUserAuthority: func(auth cryptoSSH.PublicKey) bool {
+			for _, ca := range s.userCAs {
+				if bytes.Equal(auth.Marshal(), ca.Marshal()) {
+					return true
+				}
+			}
+			return false
+		},
+		UserKeyFallback: func(conn cryptoSSH.ConnMetadata, key cryptoSSH.PublicKey) (*cryptoSSH.Permissions, error) {
+			authorized, _, _, _, err := cryptoSSH.ParseAuthorizedKey([]byte(TestSSHKeyPair.PublicKey))
+			if err != nil {
+				return nil, err
+			}
+
+			if conn.User() != s.User || !bytes.Equal(key.Marshal(), authorized.Marshal()) {
+				return nil, fmt.Errorf("unknown public key for %q", conn.User())
+			}
+
+			return nil, nil
+		},
+	}
+
+	return checker.Authenticate(conn, key)
+}
+
+// serve handles the connection, the commands aren't run: their sessions exit
+// with 0. The forwarding of TCP connections is supported to be used as a jump
+// host.
+func (s *StubSSHServer) serve(conn net.Conn) {
+	// upgrade to ssh connection
+	serverConn, chans, reqs, err := cryptoSSH.NewServerConn(conn, s.Config)
+	if err != nil {
+		_ = conn.Close()
+		return
+	}
+	defer func() { _ = serverConn.Close() }()
+
+	go cryptoSSH.DiscardRequests(reqs)
+
+	for newChannel := range chans {
+		switch newChannel.ChannelType() {
+		case "session":
+			go s.session(newChannel)
+		case "direct-tcpip":
+			go s.forward(newChannel)
+		default:
+			_ = newChannel.Reject(cryptoSSH.UnknownChannelType, "unsupported channel type")
+		}
+	}
+}
+
+func (s *StubSSHServer) session(newChannel cryptoSSH.NewChannel) {
+	channel, reqs, err := newChannel.Accept()
+	if err != nil {
+		return
+	}
+	defer func() { _ = channel.Close() }()
+
+	for req := range reqs {
+		if req.Type != "exec" {
+			_ = req.Reply(false, nil)
+			continue
+		}
+
+		_ = req.Reply(true, nil)
+		_, _ = io.Copy(ioutil.Discard, channel)
+
+		status := struct{ Status uint32 }{0}
+		_, _ = channel.SendRequest("exit-status", false, cryptoSSH.Marshal(&status))
+
+		return
+	}
+}
+
+func (s *StubSSHServer) forward(newChannel cryptoSSH.NewChannel) {
+	var target struct {
+		Host       string
+		Port       uint32
+		OriginHost string
+		OriginPort uint32
 	}
+
+	err := cryptoSSH.Unmarshal(newChannel.ExtraData(), &target)
+	if err != nil {
+		_ = newChannel.Reject(cryptoSSH.ConnectionFailed, err.Error())
+		return
+	}
+
+	targetConn, err := net.Dial("tcp", net.JoinHostPort(target.Host, strconv.Itoa(int(target.Port))))
+	if err != nil {
+		_ = newChannel.Reject(cryptoSSH.ConnectionFailed, err.Error())
+		return
+	}
+	defer func() { _ = targetConn.Close() }()
+
+	channel, reqs, err := newChannel.Accept()
+	if err != nil {
+		return
+	}
+	defer func() { _ = channel.Close() }()
+
+	go cryptoSSH.DiscardRequests(reqs)
+
+	done := make(chan struct{}, 2)
+	go func() {
+		_, _ = io.Copy(targetConn, channel)
+		done <- struct{}{}
+	}()
+	go func() {
+		_, _ = io.Copy(channel, targetConn)
+		done <- struct{}{}
+	}()
+
+	<-done
 }
 
 func (s *StubSSHServer) Client() Client {
@@ -54,6 +54,12 @@ func (b *Buffer) SetMasked(values []string) {
 		b.w.Close()
 	}
 
+	b.w = transform.NewWriter(b.lw, NewMaskTransformer(values))
+}
+
+// NewMaskTransformer returns the transformer masking the values in the job
+// trace, it also replaces the invalid UTF-8 sequences
+func NewMaskTransformer(values []string) transform.Transformer {
 	defaultTransformers := []transform.Transformer{
 		encoding.Replacement.NewEncoder(),
 	}
@@ -73,7 +79,7 @@ func (b *Buffer) SetMasked(values []string) {
 
 	transformers = append(transformers, defaultTransformers...)
 
-	b.w = transform.NewWriter(b.lw, transform.Chain(transformers...))
+	return transform.Chain(transformers...)
 }
 
 func (b *Buffer) SetLimit(size int) {
@@ -0,0 +1,99 @@
+package referees
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"github.com/sirupsen/logrus"
+)
+
+// DefaultKubernetesMetricsQueryInterval is the default interval between the
+// queries of the Kubernetes metrics API
+const DefaultKubernetesMetricsQueryInterval = 10 * time.Second
+
+// KubernetesMetricsReferee uploads the resources used by the containers of
+// the job, sampled by the executor with the Kubernetes metrics API
+type KubernetesMetricsReferee struct {
+	executor KubernetesMetricsExecutor
+	logger   logrus.FieldLogger
+}
+
+//nolint:lll
+type KubernetesMetricsRefereeConfig struct {
+	QueryInterval int `toml:"query_interval,omitempty" json:"query_interval" description:"Query interval (in seconds) of the Kubernetes metrics API. Defaults to 10"`
+}
+
+// GetQueryInterval returns the interval between the queries of the
+// Kubernetes metrics API
+func (c *KubernetesMetricsRefereeConfig) GetQueryInterval() time.Duration {
+	if c.QueryInterval <= 0 {
+		return DefaultKubernetesMetricsQueryInterval
+	}
+
+	return time.Duration(c.QueryInterval) * time.Second
+}
+
+// ContainerMetrics are the time series of the CPU (in cores) and the memory
+// (in bytes) used by a container
+type ContainerMetrics struct {
+	CPU    []model.SamplePair `json:"cpu"`
+	Memory []model.SamplePair `json:"memory"`
+}
+
+// KubernetesMetricsExecutor is implemented by the executors sampling the
+// resources used by the containers of the job during the job
+type KubernetesMetricsExecutor interface {
+	GetContainersMetrics(startTime, endTime time.Time) (map[string]ContainerMetrics, error)
+}
+
+func (kr *KubernetesMetricsReferee) ArtifactBaseName() string {
+	return "kubernetes_metrics_referee.json"
+}
+
+// ArtifactType is the referee type accepted by GitLab. The samples are told
+// apart from the ones of the MetricsReferee by the name of the artifact
+func (kr *KubernetesMetricsReferee) ArtifactType() string {
+	return "metrics_referee"
+}
+
+func (kr *KubernetesMetricsReferee) ArtifactFormat() string {
+	return "gzip"
+}
+
+func (kr *KubernetesMetricsReferee) Execute(_ context.Context, startTime, endTime time.Time) (*bytes.Reader, error) {
+	metrics, err := kr.executor.GetContainersMetrics(startTime.UTC(), endTime.UTC())
+	if err != nil {
+		kr.logger.WithError(err).Error("Failed to get the containers metrics")
+		return nil, err
+	}
+
+	// convert metrics sample pairs to JSON
+	output, err := json.Marshal(metrics)
+	if err != nil {
+		return nil, err
+	}
+
+	return bytes.NewReader(output), nil
+}
+
+func newKubernetesMetricsReferee(executor interface{}, config *Config, log logrus.FieldLogger) Referee {
+	logger := log.WithField("referee", "kubernetes_metrics")
+	if config.KubernetesMetrics == nil {
+		return nil
+	}
+
+	// see if executor supports kubernetes metrics refereeing
+	refereed, ok := executor.(KubernetesMetricsExecutor)
+	if !ok {
+		logger.Info("executor not supported")
+		return nil
+	}
+
+	return &KubernetesMetricsReferee{
+		executor: refereed,
+		logger:   logger,
+	}
+}
@@ -0,0 +1,79 @@
+//go:build !integration
+// +build !integration
+
+package referees
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewKubernetesMetricsReferee(t *testing.T) {
+	log := logrus.WithField("test", t.Name())
+	config := &Config{KubernetesMetrics: &KubernetesMetricsRefereeConfig{}}
+
+	assert.Nil(t, newKubernetesMetricsReferee(new(MockKubernetesMetricsExecutor), &Config{}, log), "no config")
+	assert.Nil(t, newKubernetesMetricsReferee(struct{}{}, config, log), "unsupported executor")
+
+	referee := newKubernetesMetricsReferee(new(MockKubernetesMetricsExecutor), config, log)
+	require.IsType(t, &KubernetesMetricsReferee{}, referee)
+	assert.Equal(t, "kubernetes_metrics_referee.json", referee.ArtifactBaseName())
+	assert.Equal(t, "metrics_referee", referee.ArtifactType())
+	assert.NotEqual(t, new(MetricsReferee).ArtifactBaseName(), referee.ArtifactBaseName())
+	assert.Equal(t, "gzip", referee.ArtifactFormat())
+}
+
+func TestKubernetesMetricsRefereeConfigGetQueryInterval(t *testing.T) {
+	assert.Equal(t, DefaultKubernetesMetricsQueryInterval, (&KubernetesMetricsRefereeConfig{}).GetQueryInterval())
+	assert.Equal(t, 30*time.Second, (&KubernetesMetricsRefereeConfig{QueryInterval: 30}).GetQueryInterval())
+}
+
+func TestKubernetesMetricsRefereeExecute(t *testing.T) {
+	startTime := time.Now()
+	endTime := startTime.Add(time.Minute)
+
+	executor := new(MockKubernetesMetricsExecutor)
+	defer executor.AssertExpectations(t)
+
+	executor.On("GetContainersMetrics", startTime.UTC(), endTime.UTC()).
+		Return(map[string]ContainerMetrics{
+			"build": {
+				CPU:    []model.SamplePair{{Timestamp: 1000, Value: 0.25}},
+				Memory: []model.SamplePair{{Timestamp: 1000, Value: 1024}},
+			},
+		}, nil).
+		Once()
+
+	referee := &KubernetesMetricsReferee{executor: executor, logger: logrus.WithField("test", t.Name())}
+
+	reader, err := referee.Execute(context.Background(), startTime, endTime)
+	require.NoError(t, err)
+
+	output, err := ioutil.ReadAll(reader)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"build":{"cpu":[[1,"0.25"]],"memory":[[1,"1024"]]}}`, string(output))
+}
+
+func TestKubernetesMetricsRefereeExecuteError(t *testing.T) {
+	executor := new(MockKubernetesMetricsExecutor)
+	defer executor.AssertExpectations(t)
+
+	executor.On("GetContainersMetrics", mock.Anything, mock.Anything).
+		Return(nil, errors.New("metrics not sampled")).
+		Once()
+
+	referee := &KubernetesMetricsReferee{executor: executor, logger: logrus.WithField("test", t.Name())}
+
+	reader, err := referee.Execute(context.Background(), time.Now(), time.Now())
+	assert.EqualError(t, err, "metrics not sampled")
+	assert.Nil(t, reader)
+}
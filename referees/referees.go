@@ -21,12 +21,15 @@ type Referee interface {
 
 type refereeFactory func(executor interface{}, config *Config, log logrus.FieldLogger) Referee
 
+//nolint:lll
 type Config struct {
-	Metrics *MetricsRefereeConfig `toml:"metrics,omitempty" json:"metrics" namespace:"metrics"`
+	Metrics           *MetricsRefereeConfig           `toml:"metrics,omitempty" json:"metrics" namespace:"metrics"`
+	KubernetesMetrics *KubernetesMetricsRefereeConfig `toml:"kubernetes_metrics,omitempty" json:"kubernetes_metrics" namespace:"kubernetes_metrics"`
 }
 
 var refereeFactories = []refereeFactory{
 	newMetricsReferee,
+	newKubernetesMetricsReferee,
 }
 
 func CreateReferees(executor interface{}, config *Config, log logrus.FieldLogger) []Referee {
@@ -36,11 +39,22 @@ func CreateReferees(executor interface{}, config *Config, log logrus.FieldLogger
 	}
 
 	var referees []Referee
+	types := make(map[string]bool)
 	for _, factory := range refereeFactories {
 		referee := factory(executor, config, log)
-		if referee != nil {
-			referees = append(referees, referee)
+		if referee == nil {
+			continue
 		}
+
+		// GitLab accepts a single artifact of each type for a job
+		if types[referee.ArtifactType()] {
+			log.WithField("artifact", referee.ArtifactBaseName()).
+				Warning("Skipping the referee, its artifact type is already used by another referee of the job")
+			continue
+		}
+
+		types[referee.ArtifactType()] = true
+		referees = append(referees, referee)
 	}
 
 	return referees
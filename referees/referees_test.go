@@ -11,6 +11,15 @@ import (
 	"github.com/stretchr/testify/mock"
 )
 
+type mockBothMetricsExecutor struct {
+	MockMetricsExecutor
+	MockKubernetesMetricsExecutor
+}
+
+func (m *mockBothMetricsExecutor) AssertExpectations(t mock.TestingT) bool {
+	return m.MockMetricsExecutor.AssertExpectations(t)
+}
+
 func Test_CreateReferees(t *testing.T) {
 	fakeMockMetricsExecutor := func(t *testing.T) (interface{}, func(t mock.TestingT) bool) {
 		return struct{}{}, func(t mock.TestingT) bool { return false }
@@ -39,6 +48,29 @@ func Test_CreateReferees(t *testing.T) {
 			config:           &Config{Metrics: &MetricsRefereeConfig{QueryInterval: 0}},
 			expectedReferees: []Referee{&MetricsReferee{}},
 		},
+		"Executor supports kubernetes metrics referee": {
+			mockExecutor: func(t *testing.T) (interface{}, func(t mock.TestingT) bool) {
+				m := new(MockKubernetesMetricsExecutor)
+				return m, m.AssertExpectations
+			},
+			config: &Config{
+				Metrics:           &MetricsRefereeConfig{QueryInterval: 0},
+				KubernetesMetrics: &KubernetesMetricsRefereeConfig{},
+			},
+			expectedReferees: []Referee{&KubernetesMetricsReferee{}},
+		},
+		"Executor supports both metrics referees": {
+			mockExecutor: func(t *testing.T) (interface{}, func(t mock.TestingT) bool) {
+				m := new(mockBothMetricsExecutor)
+				m.MockMetricsExecutor.On("GetMetricsSelector").Return(`name="value"`).Maybe()
+				return m, m.AssertExpectations
+			},
+			config: &Config{
+				Metrics:           &MetricsRefereeConfig{QueryInterval: 0},
+				KubernetesMetrics: &KubernetesMetricsRefereeConfig{},
+			},
+			expectedReferees: []Referee{&MetricsReferee{}},
+		},
 		"No config provided": {
 			mockExecutor:     mockMetricsExecutor,
 			config:           nil,
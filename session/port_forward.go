@@ -0,0 +1,182 @@
+package session
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"gitlab.com/gitlab-org/gitlab-runner/session/proxy"
+)
+
+const (
+	portForwardBufferSize   = 32 * 1024
+	portForwardCloseTimeout = 5 * time.Second
+)
+
+func (s *Session) portForwardHandler(w http.ResponseWriter, r *http.Request) {
+	serviceName, port, requestedURI, ok := parseProxyParams(strings.TrimPrefix(r.URL.Path, s.Endpoint+"/port-forward/"))
+	if !ok || requestedURI != "" {
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+
+	logger := s.log.WithField("uri", r.RequestURI)
+	logger.Debug("Port forward session request")
+
+	if !websocket.IsWebSocketUpgrade(r) {
+		logger.Error("Request is not a web socket connection")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	serviceProxy := s.proxyPool[serviceName]
+	if serviceProxy == nil || serviceProxy.PortForwarder == nil {
+		logger.Warn("Port forwarder not found")
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+
+	portSettings, err := serviceProxy.Settings.PortByNameOrNumber(port)
+	if err != nil {
+		logger.WithError(err).Warn("Port not found")
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+
+	stream, err := serviceProxy.PortForwarder.ForwardPort(serviceProxy.Settings, portSettings)
+	if err != nil {
+		logger.WithError(err).Error("Failed to connect to service port")
+		http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+		return
+	}
+	defer func() { _ = stream.Close() }()
+
+	upgrader := websocket.Upgrader{}
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		logger.WithError(err).Error("Failed to upgrade port forward connection")
+		return
+	}
+	defer func() { _ = conn.Close() }()
+
+	logger.Debugln("Starting port forwarding")
+
+	err = ForwardWebSocket(conn, stream)
+	if err != nil {
+		logger.WithError(err).Debug("Port forwarding finished")
+	}
+}
+
+// ForwardWebSocket copies the binary messages of the WebSocket connection to
+// the stream and the data of the stream to the connection, until one of them
+// is closed. The caller closes both of them.
+func ForwardWebSocket(conn *websocket.Conn, stream io.ReadWriter) error {
+	errCh := make(chan error, 2)
+
+	go func() { errCh <- copyToWebSocket(conn, stream) }()
+	go func() { errCh <- copyFromWebSocket(stream, conn) }()
+
+	return <-errCh
+}
+
+func copyToWebSocket(conn *websocket.Conn, stream io.Reader) error {
+	buf := make([]byte, portForwardBufferSize)
+
+	for {
+		n, err := stream.Read(buf)
+		if n > 0 {
+			writeErr := conn.WriteMessage(websocket.BinaryMessage, buf[:n])
+			if writeErr != nil {
+				return writeErr
+			}
+		}
+
+		if errors.Is(err, io.EOF) {
+			message := websocket.FormatCloseMessage(websocket.CloseNormalClosure, "")
+			return conn.WriteControl(websocket.CloseMessage, message, time.Now().Add(portForwardCloseTimeout))
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func copyFromWebSocket(stream io.Writer, conn *websocket.Conn) error {
+	for {
+		messageType, reader, err := conn.NextReader()
+		if websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if messageType != websocket.BinaryMessage {
+			continue
+		}
+
+		_, err = io.Copy(stream, reader)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// PortForwardClient connects to the port of a service through the session
+// of a job
+type PortForwardClient struct {
+	// URL is the URL of the session, with its endpoint
+	URL     string
+	Token   string
+	Service string
+	Port    string
+
+	TLSConfig *tls.Config
+}
+
+func (c *PortForwardClient) endpoint() (string, error) {
+	u, err := url.Parse(strings.TrimSuffix(c.URL, "/"))
+	if err != nil {
+		return "", fmt.Errorf("parsing session URL: %w", err)
+	}
+
+	if u.Scheme != "https" && u.Scheme != "http" {
+		return "", fmt.Errorf("invalid session URL scheme %q", u.Scheme)
+	}
+
+	u.Scheme = proxy.WebsocketProtocolFor(u.Scheme)
+	u.RawPath = u.EscapedPath() + "/port-forward/" + url.PathEscape(c.Service) + "/" + url.PathEscape(c.Port)
+	u.Path += "/port-forward/" + c.Service + "/" + c.Port
+
+	return u.String(), nil
+}
+
+// Dial opens a WebSocket connection forwarded to the port of the service
+func (c *PortForwardClient) Dial() (*websocket.Conn, error) {
+	endpoint, err := c.endpoint()
+	if err != nil {
+		return nil, err
+	}
+
+	dialer := websocket.Dialer{
+		Proxy:            http.ProxyFromEnvironment,
+		HandshakeTimeout: 45 * time.Second,
+		TLSClientConfig:  c.TLSConfig,
+	}
+
+	conn, resp, err := dialer.Dial(endpoint, http.Header{"Authorization": []string{c.Token}})
+	if err != nil && resp != nil {
+		return nil, fmt.Errorf("port forward to %s:%s: %s", c.Service, c.Port, resp.Status)
+	}
+
+	return conn, err
+}
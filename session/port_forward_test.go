@@ -0,0 +1,257 @@
+//go:build !integration
+// +build !integration
+
+package session
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-runner/session/proxy"
+)
+
+// startEchoServer starts a TCP server writing back the data of its clients
+func startEchoServer(t *testing.T) net.Listener {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			go func() {
+				defer conn.Close()
+				_, _ = io.Copy(conn, conn)
+			}()
+		}
+	}()
+
+	return listener
+}
+
+type echoPooler struct {
+	pool proxy.Pool
+}
+
+func (p *echoPooler) Pool() proxy.Pool {
+	return p.pool
+}
+
+type portForwardServer struct {
+	session   *Session
+	server    *httptest.Server
+	forwarder *proxy.MockPortForwarder
+}
+
+func newPortForwardServer(t *testing.T) *portForwardServer {
+	sess, err := NewSession(nil)
+	require.NoError(t, err)
+
+	forwarder := new(proxy.MockPortForwarder)
+
+	settings := proxy.NewProxySettings("postgres", []proxy.Port{{Number: 5432, Name: "db", Protocol: "tcp"}})
+	sess.SetProxyPool(&echoPooler{
+		pool: proxy.Pool{
+			"postgres": {Settings: settings, PortForwarder: forwarder},
+			"web":      {Settings: proxy.NewProxySettings("web", []proxy.Port{{Number: 80}})},
+		},
+	})
+
+	return &portForwardServer{
+		session:   sess,
+		server:    httptest.NewTLSServer(sess.Handler()),
+		forwarder: forwarder,
+	}
+}
+
+func (s *portForwardServer) client(service, port string) *PortForwardClient {
+	pool := x509.NewCertPool()
+	pool.AddCert(s.server.Certificate())
+
+	return &PortForwardClient{
+		URL:       s.server.URL + s.session.Endpoint,
+		Token:     s.session.Token,
+		Service:   service,
+		Port:      port,
+		TLSConfig: &tls.Config{RootCAs: pool},
+	}
+}
+
+func TestPortForward(t *testing.T) {
+	echo := startEchoServer(t)
+	defer echo.Close()
+
+	s := newPortForwardServer(t)
+	defer s.server.Close()
+	defer s.forwarder.AssertExpectations(t)
+
+	s.forwarder.
+		On("ForwardPort", mock.Anything, proxy.Port{Number: 5432, Name: "db", Protocol: "tcp"}).
+		Return(func(*proxy.Settings, proxy.Port) io.ReadWriteCloser {
+			conn, err := net.Dial("tcp", echo.Addr().String())
+			require.NoError(t, err)
+
+			return conn
+		}, nil).
+		Twice()
+
+	for _, port := range []string{"5432", "db"} {
+		conn, err := s.client("postgres", port).Dial()
+		require.NoError(t, err)
+
+		require.NoError(t, conn.WriteMessage(websocket.BinaryMessage, []byte("SELECT 1;")))
+
+		messageType, data, err := conn.ReadMessage()
+		require.NoError(t, err)
+		assert.Equal(t, websocket.BinaryMessage, messageType)
+		assert.Equal(t, "SELECT 1;", string(data))
+
+		require.NoError(t, conn.Close())
+	}
+}
+
+func TestPortForwardClosedByService(t *testing.T) {
+	s := newPortForwardServer(t)
+	defer s.server.Close()
+
+	serviceConn, forwardedConn := net.Pipe()
+	s.forwarder.On("ForwardPort", mock.Anything, mock.Anything).Return(forwardedConn, nil).Once()
+
+	conn, err := s.client("postgres", "db").Dial()
+	require.NoError(t, err)
+	defer conn.Close()
+
+	_, err = serviceConn.Write([]byte("ready"))
+	require.NoError(t, err)
+	require.NoError(t, serviceConn.Close())
+
+	_, data, err := conn.ReadMessage()
+	require.NoError(t, err)
+	assert.Equal(t, "ready", string(data))
+
+	_, _, err = conn.ReadMessage()
+	assert.True(t, websocket.IsCloseError(err, websocket.CloseNormalClosure), "unexpected error %v", err)
+}
+
+func TestPortForwardFailedRequest(t *testing.T) {
+	tests := map[string]struct {
+		service        string
+		port           string
+		token          string
+		forwardErr     error
+		expectedStatus string
+	}{
+		"invalid token": {
+			service:        "postgres",
+			port:           "db",
+			token:          "invalid",
+			expectedStatus: "401 Unauthorized",
+		},
+		"unknown service": {
+			service:        "redis",
+			port:           "6379",
+			expectedStatus: "404 Not Found",
+		},
+		"service without port forwarding": {
+			service:        "web",
+			port:           "80",
+			expectedStatus: "404 Not Found",
+		},
+		"unknown port": {
+			service:        "postgres",
+			port:           "5433",
+			expectedStatus: "404 Not Found",
+		},
+		"service not reachable": {
+			service:        "postgres",
+			port:           "db",
+			forwardErr:     errors.New("services are not ready yet"),
+			expectedStatus: "503 Service Unavailable",
+		},
+	}
+
+	for tn, tc := range tests {
+		t.Run(tn, func(t *testing.T) {
+			s := newPortForwardServer(t)
+			defer s.server.Close()
+
+			if tc.forwardErr != nil {
+				s.forwarder.On("ForwardPort", mock.Anything, mock.Anything).Return(nil, tc.forwardErr).Once()
+			}
+
+			client := s.client(tc.service, tc.port)
+			if tc.token != "" {
+				client.Token = tc.token
+			}
+
+			_, err := client.Dial()
+			assert.EqualError(t, err, "port forward to "+tc.service+":"+tc.port+": "+tc.expectedStatus)
+		})
+	}
+}
+
+func TestPortForwardNotWebSocket(t *testing.T) {
+	s := newPortForwardServer(t)
+	defer s.server.Close()
+
+	req := httptest.NewRequest(http.MethodGet, s.session.Endpoint+"/port-forward/postgres/db", nil)
+	req.Header.Add("Authorization", s.session.Token)
+
+	w := httptest.NewRecorder()
+	s.session.Handler().ServeHTTP(w, req)
+
+	resp := w.Result()
+	defer resp.Body.Close()
+
+	assert.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+}
+
+func TestPortForwardClientEndpoint(t *testing.T) {
+	tests := map[string]struct {
+		url              string
+		expectedEndpoint string
+		expectedErr      string
+	}{
+		"https": {
+			url:              "https://runner.example.com:8093/session/1234/",
+			expectedEndpoint: "wss://runner.example.com:8093/session/1234/port-forward/my%20service/db",
+		},
+		"http": {
+			url:              "http://127.0.0.1:8093/session/1234",
+			expectedEndpoint: "ws://127.0.0.1:8093/session/1234/port-forward/my%20service/db",
+		},
+		"invalid scheme": {
+			url:         "ftp://runner.example.com/session/1234",
+			expectedErr: `invalid session URL scheme "ftp"`,
+		},
+	}
+
+	for tn, tc := range tests {
+		t.Run(tn, func(t *testing.T) {
+			c := &PortForwardClient{URL: tc.url, Service: "my service", Port: "db"}
+
+			endpoint, err := c.endpoint()
+			if tc.expectedErr != "" {
+				assert.EqualError(t, err, tc.expectedErr)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tc.expectedEndpoint, endpoint)
+		})
+	}
+}
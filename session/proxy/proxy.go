@@ -2,6 +2,7 @@ package proxy
 
 import (
 	"errors"
+	"io"
 	"net/http"
 	"strconv"
 )
@@ -15,6 +16,7 @@ type Pooler interface {
 type Proxy struct {
 	Settings          *Settings
 	ConnectionHandler Requester
+	PortForwarder     PortForwarder
 }
 
 type Settings struct {
@@ -32,6 +34,12 @@ type Requester interface {
 	ProxyRequest(w http.ResponseWriter, r *http.Request, requestedURI, port string, settings *Settings)
 }
 
+// PortForwarder opens raw TCP streams to the ports of the services, for the
+// port forwarding of the session
+type PortForwarder interface {
+	ForwardPort(settings *Settings, port Port) (io.ReadWriteCloser, error)
+}
+
 func NewPool() Pool {
 	return Pool{}
 }
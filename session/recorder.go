@@ -0,0 +1,337 @@
+package session
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"golang.org/x/text/transform"
+
+	"gitlab.com/gitlab-org/gitlab-runner/helpers/trace"
+)
+
+const (
+	asciicastVersion = 2
+	asciicastWidth   = 80
+	asciicastHeight  = 24
+
+	asciicastInput  = "i"
+	asciicastOutput = "o"
+)
+
+var errRecorderClosed = errors.New("the terminal session recorder is closed")
+
+//nolint:lll
+type RecordingConfig struct {
+	Directory        string `toml:"directory,omitempty" json:"directory" description:"Directory where the terminal sessions are recorded as asciicast v2 files"`
+	UploadAsArtifact bool   `toml:"upload_as_artifact,omitempty" json:"upload_as_artifact" description:"Upload the recordings of the terminal sessions of a job as its artifacts archive, readable by the users who can download the artifacts of the job"`
+}
+
+// Enabled returns whether the terminal sessions are recorded
+func (c *RecordingConfig) Enabled() bool {
+	return c != nil && (c.Directory != "" || c.UploadAsArtifact)
+}
+
+// RecordingMetadata identifies the job of the recordings
+type RecordingMetadata struct {
+	JobID       int64  `json:"job_id"`
+	JobURL      string `json:"job_url,omitempty"`
+	ProjectPath string `json:"project_path,omitempty"`
+	// JobUser is the user who started the job, GitLab doesn't send the user
+	// of the terminal
+	JobUser string `json:"job_user,omitempty"`
+}
+
+// Recorder records the terminal sessions of a job as asciicast v2 files, the
+// masked values are redacted like in the job trace
+type Recorder struct {
+	dir       string
+	temporary bool
+	metadata  RecordingMetadata
+	masked    []string
+
+	lock   sync.Mutex
+	wg     sync.WaitGroup
+	files  []string
+	closed bool
+}
+
+func NewRecorder(config *RecordingConfig, metadata RecordingMetadata, masked []string) (*Recorder, error) {
+	r := &Recorder{
+		dir:      config.Directory,
+		metadata: metadata,
+		masked:   masked,
+	}
+
+	if r.dir == "" {
+		dir, err := ioutil.TempDir("", "terminal-sessions")
+		if err != nil {
+			return nil, fmt.Errorf("creating temporary directory: %w", err)
+		}
+
+		r.dir = dir
+		r.temporary = true
+
+		return r, nil
+	}
+
+	err := os.MkdirAll(r.dir, 0700)
+	if err != nil {
+		return nil, fmt.Errorf("creating recordings directory: %w", err)
+	}
+
+	return r, nil
+}
+
+// start creates the recording of a terminal session
+func (r *Recorder) start(req *http.Request) (*recording, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if r.closed {
+		return nil, errRecorderClosed
+	}
+
+	name := fmt.Sprintf("job-%d-terminal-%d.cast", r.metadata.JobID, len(r.files)+1)
+	path := filepath.Join(r.dir, name)
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("creating recording: %w", err)
+	}
+
+	rec := newRecording(file, r.masked)
+
+	err = rec.writeHeader(r.metadata, req)
+	if err != nil {
+		_ = file.Close()
+		return nil, fmt.Errorf("writing recording header: %w", err)
+	}
+
+	r.files = append(r.files, path)
+	r.wg.Add(1)
+
+	rec.done = r.wg.Done
+
+	return rec, nil
+}
+
+// Close stops the recording of new sessions and waits for the end of the
+// current ones, it returns the files of the recordings
+func (r *Recorder) Close() []string {
+	r.lock.Lock()
+	r.closed = true
+	r.lock.Unlock()
+
+	r.wg.Wait()
+
+	return r.files
+}
+
+// WriteArchive writes a zip archive of the recordings, it must be called
+// after Close
+func (r *Recorder) WriteArchive(w io.Writer) error {
+	archive := zip.NewWriter(w)
+
+	for _, path := range r.files {
+		err := addArchiveFile(archive, path)
+		if err != nil {
+			return err
+		}
+	}
+
+	return archive.Close()
+}
+
+func addArchiveFile(archive *zip.Writer, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = file.Close() }()
+
+	fw, err := archive.Create(filepath.Join("terminal-sessions", filepath.Base(path)))
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(fw, file)
+
+	return err
+}
+
+// Directory returns the directory of the recordings
+func (r *Recorder) Directory() string {
+	return r.dir
+}
+
+// Cleanup removes the recordings when they were kept only to be uploaded
+func (r *Recorder) Cleanup() error {
+	if !r.temporary {
+		return nil
+	}
+
+	return os.RemoveAll(r.dir)
+}
+
+// recording writes the events of a terminal session, the input and the
+// output are masked separately as they are independent streams
+type recording struct {
+	lock    sync.Mutex
+	file    io.WriteCloser
+	encoder *json.Encoder
+	start   time.Time
+
+	input  *recordingStream
+	output *recordingStream
+
+	done func()
+	err  error
+}
+
+func newRecording(file io.WriteCloser, masked []string) *recording {
+	rec := &recording{
+		file:    file,
+		encoder: json.NewEncoder(file),
+		start:   time.Now(),
+	}
+
+	rec.input = newRecordingStream(rec, asciicastInput, masked)
+	rec.output = newRecordingStream(rec, asciicastOutput, masked)
+
+	return rec
+}
+
+type asciicastHeader struct {
+	Version   int               `json:"version"`
+	Width     int               `json:"width"`
+	Height    int               `json:"height"`
+	Timestamp int64             `json:"timestamp"`
+	Title     string            `json:"title,omitempty"`
+	Env       map[string]string `json:"env,omitempty"`
+	GitLab    recordingInfo     `json:"gitlab"`
+}
+
+type recordingInfo struct {
+	RecordingMetadata
+
+	ClientAddress string `json:"client_address,omitempty"`
+	ForwardedFor  string `json:"forwarded_for,omitempty"`
+	UserAgent     string `json:"user_agent,omitempty"`
+}
+
+func (r *recording) writeHeader(metadata RecordingMetadata, req *http.Request) error {
+	return r.encoder.Encode(asciicastHeader{
+		Version:   asciicastVersion,
+		Width:     asciicastWidth,
+		Height:    asciicastHeight,
+		Timestamp: r.start.Unix(),
+		Title:     fmt.Sprintf("Terminal of job %d", metadata.JobID),
+		Env:       map[string]string{"TERM": "xterm"},
+		GitLab: recordingInfo{
+			RecordingMetadata: metadata,
+			ClientAddress:     req.RemoteAddr,
+			ForwardedFor:      req.Header.Get("X-Forwarded-For"),
+			UserAgent:         req.UserAgent(),
+		},
+	})
+}
+
+func (r *recording) writeEvent(kind string, data []byte) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if r.err != nil {
+		return r.err
+	}
+
+	elapsed := time.Since(r.start).Seconds()
+	r.err = r.encoder.Encode([]interface{}{elapsed, kind, string(data)})
+
+	return r.err
+}
+
+func (r *recording) recordInput(data []byte) {
+	r.input.write(data)
+}
+
+func (r *recording) recordOutput(data []byte) {
+	r.output.write(data)
+}
+
+// close flushes the data kept by the masking and closes the file
+func (r *recording) close() error {
+	defer r.done()
+
+	inputErr := r.input.close()
+	outputErr := r.output.close()
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	fileErr := r.file.Close()
+
+	for _, err := range []error{r.err, inputErr, outputErr, fileErr} {
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// recordingStream masks the data of one direction of the terminal, it can
+// still be written by the terminal connection after the end of the recording
+type recordingStream struct {
+	lock   sync.Mutex
+	writer io.WriteCloser
+	closed bool
+}
+
+func newRecordingStream(rec *recording, kind string, masked []string) *recordingStream {
+	return &recordingStream{
+		writer: transform.NewWriter(&eventWriter{recording: rec, kind: kind}, trace.NewMaskTransformer(masked)),
+	}
+}
+
+func (s *recordingStream) write(data []byte) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.closed {
+		return
+	}
+
+	_, _ = s.writer.Write(data)
+}
+
+func (s *recordingStream) close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.closed = true
+
+	return s.writer.Close()
+}
+
+type eventWriter struct {
+	recording *recording
+	kind      string
+}
+
+func (w *eventWriter) Write(p []byte) (int, error) {
+	err := w.recording.writeEvent(w.kind, p)
+	if err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
@@ -0,0 +1,339 @@
+//go:build !integration
+// +build !integration
+
+package session
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	terminal "gitlab.com/gitlab-org/gitlab-terminal"
+
+	terminalsession "gitlab.com/gitlab-org/gitlab-runner/session/terminal"
+)
+
+const recordedSecret = "secret-value"
+
+// echoTerminal writes back the messages of the client, it decodes them like
+// the terminals proxied by gitlab-terminal
+type echoTerminal struct{}
+
+func (echoTerminal) Connect() (terminalsession.Conn, error) {
+	return echoTerminalConn{}, nil
+}
+
+type echoTerminalConn struct{}
+
+func (echoTerminalConn) Start(w http.ResponseWriter, r *http.Request, timeoutCh, disconnectCh chan error) {
+	upgrader := websocket.Upgrader{
+		Subprotocols: []string{"terminal.gitlab.com", "base64.terminal.gitlab.com"},
+	}
+
+	ws, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer ws.Close()
+
+	conn := terminal.Wrap(ws, ws.Subprotocol())
+	for {
+		messageType, data, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+
+		err = conn.WriteMessage(messageType, data)
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (echoTerminalConn) Close() error {
+	return nil
+}
+
+func readRecording(t *testing.T, path string) (map[string]interface{}, string, string) {
+	file, err := os.Open(path)
+	require.NoError(t, err)
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	require.True(t, scanner.Scan())
+
+	var header map[string]interface{}
+	require.NoError(t, json.Unmarshal(scanner.Bytes(), &header))
+
+	var input, output strings.Builder
+	for scanner.Scan() {
+		var event []interface{}
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &event))
+		require.Len(t, event, 3)
+
+		switch event[1] {
+		case asciicastInput:
+			input.WriteString(event[2].(string))
+		case asciicastOutput:
+			output.WriteString(event[2].(string))
+		default:
+			assert.Fail(t, "unexpected event type", event[1])
+		}
+	}
+	require.NoError(t, scanner.Err())
+
+	return header, input.String(), output.String()
+}
+
+func TestRecordTerminalSession(t *testing.T) {
+	tests := map[string]struct {
+		subprotocol string
+		encode      func(string) (int, []byte)
+		decode      func(*testing.T, []byte) string
+	}{
+		"binary": {
+			subprotocol: "terminal.gitlab.com",
+			encode: func(data string) (int, []byte) {
+				return websocket.BinaryMessage, []byte(data)
+			},
+			decode: func(t *testing.T, data []byte) string {
+				return string(data)
+			},
+		},
+		"base64": {
+			subprotocol: "base64.terminal.gitlab.com",
+			encode: func(data string) (int, []byte) {
+				return websocket.TextMessage, []byte(base64.StdEncoding.EncodeToString([]byte(data)))
+			},
+			decode: func(t *testing.T, data []byte) string {
+				decoded, err := base64.StdEncoding.DecodeString(string(data))
+				require.NoError(t, err)
+
+				return string(decoded)
+			},
+		},
+	}
+
+	for tn, tc := range tests {
+		t.Run(tn, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "recordings")
+			require.NoError(t, err)
+			defer os.RemoveAll(dir)
+
+			metadata := RecordingMetadata{JobID: 10, ProjectPath: "group/project", JobUser: "root"}
+			recorder, err := NewRecorder(&RecordingConfig{Directory: dir}, metadata, []string{recordedSecret})
+			require.NoError(t, err)
+
+			sess, err := NewSession(nil)
+			require.NoError(t, err)
+			sess.SetInteractiveTerminal(echoTerminal{})
+			sess.SetRecorder(recorder)
+
+			server := httptest.NewServer(sess.Handler())
+			defer server.Close()
+
+			dialer := websocket.Dialer{Subprotocols: []string{tc.subprotocol}}
+			header := http.Header{"Authorization": []string{sess.Token}}
+
+			url := "ws" + strings.TrimPrefix(server.URL, "http") + sess.Endpoint + "/exec"
+			conn, _, err := dialer.Dial(url, header)
+			require.NoError(t, err)
+
+			const command = "echo " + recordedSecret + "\n"
+
+			// the terminal input is sent in two messages, the masking applies
+			// across them
+			require.NoError(t, conn.WriteMessage(tc.encode("echo secret-")))
+			require.NoError(t, conn.WriteMessage(tc.encode("value\n")))
+
+			var echoed string
+			for len(echoed) < len(command) {
+				_, data, err := conn.ReadMessage()
+				require.NoError(t, err)
+
+				echoed += tc.decode(t, data)
+			}
+			assert.Equal(t, command, echoed)
+
+			require.NoError(t, conn.Close())
+
+			files := recorder.Close()
+			require.Len(t, files, 1)
+			assert.Equal(t, filepath.Join(dir, "job-10-terminal-1.cast"), files[0])
+
+			info, err := os.Stat(files[0])
+			require.NoError(t, err)
+			assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+			castHeader, input, output := readRecording(t, files[0])
+			assert.Equal(t, float64(asciicastVersion), castHeader["version"])
+
+			gitlabInfo, ok := castHeader["gitlab"].(map[string]interface{})
+			require.True(t, ok)
+			assert.Equal(t, float64(10), gitlabInfo["job_id"])
+			assert.Equal(t, "group/project", gitlabInfo["project_path"])
+			assert.Equal(t, "root", gitlabInfo["job_user"])
+			assert.Contains(t, gitlabInfo["client_address"], "127.0.0.1:")
+
+			assert.Equal(t, "echo [MASKED]\n", input)
+			assert.Equal(t, "echo [MASKED]\n", output)
+		})
+	}
+}
+
+func TestRecordTerminalSessionAfterClose(t *testing.T) {
+	recorder, err := NewRecorder(&RecordingConfig{UploadAsArtifact: true}, RecordingMetadata{JobID: 1}, nil)
+	require.NoError(t, err)
+	defer recorder.Cleanup()
+
+	assert.Empty(t, recorder.Close())
+
+	sess, err := NewSession(nil)
+	require.NoError(t, err)
+	sess.SetInteractiveTerminal(echoTerminal{})
+	sess.SetRecorder(recorder)
+
+	req := httptest.NewRequest(http.MethodPost, sess.Endpoint+"/exec", nil)
+	req.Header.Add("Connection", "upgrade")
+	req.Header.Add("Upgrade", "websocket")
+	req.Header.Add("Authorization", sess.Token)
+
+	w := httptest.NewRecorder()
+	sess.Handler().ServeHTTP(w, req)
+
+	resp := w.Result()
+	defer resp.Body.Close()
+
+	assert.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+	assert.False(t, sess.Connected(), "the terminal connection is closed")
+}
+
+func TestRecorderArchive(t *testing.T) {
+	recorder, err := NewRecorder(&RecordingConfig{UploadAsArtifact: true}, RecordingMetadata{JobID: 5}, nil)
+	require.NoError(t, err)
+
+	rec, err := recorder.start(httptest.NewRequest(http.MethodGet, "/exec", nil))
+	require.NoError(t, err)
+
+	rec.recordOutput([]byte("$ "))
+	require.NoError(t, rec.close())
+
+	files := recorder.Close()
+	require.Len(t, files, 1)
+
+	archive := new(bytes.Buffer)
+	require.NoError(t, recorder.WriteArchive(archive))
+
+	reader, err := zip.NewReader(bytes.NewReader(archive.Bytes()), int64(archive.Len()))
+	require.NoError(t, err)
+	require.Len(t, reader.File, 1)
+	assert.Equal(t, "terminal-sessions/job-5-terminal-1.cast", reader.File[0].Name)
+
+	require.NoError(t, recorder.Cleanup())
+
+	_, err = os.Stat(filepath.Dir(files[0]))
+	assert.True(t, os.IsNotExist(err), "the temporary directory is removed")
+}
+
+func frame(opcode byte, final bool, mask []byte, payload []byte) []byte {
+	header := []byte{opcode, 0}
+	if final {
+		header[0] |= finalBit
+	}
+
+	switch {
+	case len(payload) > 0xffff:
+		header[1] = 127
+		header = append(header, make([]byte, 8)...)
+		binary.BigEndian.PutUint64(header[2:], uint64(len(payload)))
+	case len(payload) > 125:
+		header[1] = 126
+		header = append(header, make([]byte, 2)...)
+		binary.BigEndian.PutUint16(header[2:], uint16(len(payload)))
+	default:
+		header[1] = byte(len(payload))
+	}
+
+	data := append([]byte(nil), payload...)
+	if mask != nil {
+		header[1] |= maskBit
+		header = append(header, mask...)
+
+		for i := range data {
+			data[i] ^= mask[i%4]
+		}
+	}
+
+	return append(header, data...)
+}
+
+func TestFrameParser(t *testing.T) {
+	mask := []byte{0x12, 0x34, 0x56, 0x78}
+	long := strings.Repeat("a", 300)
+
+	tests := map[string]struct {
+		base64   bool
+		stream   [][]byte
+		expected string
+	}{
+		"fragmented message with control frames": {
+			stream: [][]byte{
+				frame(opcodeBinary, false, nil, []byte("hello ")),
+				frame(0x9, true, nil, []byte("ping")),
+				frame(opcodeContinuation, true, nil, []byte("world")),
+				frame(0x8, true, nil, []byte{0x03, 0xe8}),
+			},
+			expected: "hello world",
+		},
+		"masked frames": {
+			stream: [][]byte{
+				frame(opcodeText, true, mask, []byte("ls -la\n")),
+				frame(opcodeBinary, true, mask, nil),
+				frame(opcodeBinary, true, mask, []byte(long)),
+			},
+			expected: "ls -la\n" + long,
+		},
+		"base64 messages": {
+			base64: true,
+			stream: [][]byte{
+				frame(opcodeText, false, mask, []byte(base64.StdEncoding.EncodeToString([]byte("hello"))[:3])),
+				frame(opcodeContinuation, true, mask, []byte(base64.StdEncoding.EncodeToString([]byte("hello"))[3:])),
+				frame(opcodeText, true, mask, []byte(base64.StdEncoding.EncodeToString([]byte(" world")))),
+			},
+			expected: "hello world",
+		},
+	}
+
+	for tn, tc := range tests {
+		t.Run(tn, func(t *testing.T) {
+			var received bytes.Buffer
+
+			p := &frameParser{
+				emit:     func(data []byte) { received.Write(data) },
+				isBase64: func() bool { return tc.base64 },
+			}
+
+			// the frames are split at any position by the connection
+			for _, data := range tc.stream {
+				for i := range data {
+					p.write(data[i : i+1])
+				}
+			}
+
+			assert.Equal(t, tc.expected, received.String())
+		})
+	}
+}
@@ -0,0 +1,336 @@
+package session
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// maxHandshakeSize limits the HTTP response of the WebSocket handshake kept
+// to find the subprotocol of the terminal
+const maxHandshakeSize = 16 * 1024
+
+const (
+	opcodeContinuation = 0x0
+	opcodeText         = 0x1
+	opcodeBinary       = 0x2
+
+	finalBit   = 0x80
+	opcodeMask = 0x0f
+	maskBit    = 0x80
+	lengthMask = 0x7f
+)
+
+const base64SubprotocolPrefix = "base64."
+
+// recordingResponseWriter records the WebSocket connection hijacked by the
+// terminal from the response writer
+type recordingResponseWriter struct {
+	http.ResponseWriter
+
+	recording *recording
+}
+
+func (w *recordingResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("the response writer doesn't support hijacking")
+	}
+
+	conn, brw, err := hijacker.Hijack()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var reader io.Reader = conn
+	if buffered := brw.Reader.Buffered(); buffered > 0 {
+		data, _ := brw.Reader.Peek(buffered)
+		reader = io.MultiReader(bytes.NewReader(data), conn)
+	}
+
+	rc := newRecordingConn(conn, reader, w.recording)
+
+	return rc, bufio.NewReadWriter(bufio.NewReader(rc), bufio.NewWriter(rc)), nil
+}
+
+// recordingConn decodes the WebSocket frames of the terminal, the ones read
+// are the input of the user and the ones written the output of the terminal
+type recordingConn struct {
+	net.Conn
+
+	reader io.Reader
+
+	handshake     []byte
+	handshakeDone bool
+
+	lock        sync.Mutex
+	subprotocol string
+
+	input  *frameParser
+	output *frameParser
+}
+
+func newRecordingConn(conn net.Conn, reader io.Reader, rec *recording) *recordingConn {
+	c := &recordingConn{
+		Conn:   conn,
+		reader: reader,
+	}
+
+	c.input = &frameParser{emit: rec.recordInput, isBase64: c.isBase64}
+	c.output = &frameParser{emit: rec.recordOutput, isBase64: c.isBase64}
+
+	return c
+}
+
+func (c *recordingConn) Read(p []byte) (int, error) {
+	n, err := c.reader.Read(p)
+	if n > 0 {
+		c.input.write(p[:n])
+	}
+
+	return n, err
+}
+
+func (c *recordingConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	if n > 0 {
+		c.writeOutput(p[:n])
+	}
+
+	return n, err
+}
+
+// writeOutput skips the handshake response written before the frames
+func (c *recordingConn) writeOutput(p []byte) {
+	if c.handshakeDone {
+		c.output.write(p)
+		return
+	}
+
+	c.handshake = append(c.handshake, p...)
+
+	end := bytes.Index(c.handshake, []byte("\r\n\r\n"))
+	if end < 0 {
+		if len(c.handshake) > maxHandshakeSize {
+			c.handshake = c.handshake[len(c.handshake)-3:]
+		}
+
+		return
+	}
+
+	end += 4
+	c.setSubprotocol(c.handshake[:end])
+
+	frames := c.handshake[end:]
+	c.handshake = nil
+	c.handshakeDone = true
+
+	c.output.write(frames)
+}
+
+func (c *recordingConn) setSubprotocol(handshake []byte) {
+	resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(handshake)), nil)
+	if err != nil {
+		return
+	}
+	_ = resp.Body.Close()
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.subprotocol = resp.Header.Get("Sec-WebSocket-Protocol")
+}
+
+// isBase64 returns whether the terminal data is sent encoded in base64 text
+func (c *recordingConn) isBase64() bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return strings.HasPrefix(c.subprotocol, base64SubprotocolPrefix)
+}
+
+// frameParser decodes the data messages of a stream of WebSocket frames as
+// they come, without keeping the frames in memory. The control frames are
+// skipped.
+type frameParser struct {
+	emit     func([]byte)
+	isBase64 func() bool
+
+	header []byte
+
+	final     bool
+	data      bool
+	remaining uint64
+	mask      []byte
+	maskPos   int
+
+	message bool
+	base64  bool
+	pending []byte
+}
+
+func (p *frameParser) write(b []byte) {
+	for len(b) > 0 {
+		if p.remaining == 0 {
+			b = p.readHeader(b)
+			continue
+		}
+
+		n := uint64(len(b))
+		if n > p.remaining {
+			n = p.remaining
+		}
+
+		p.readPayload(b[:n])
+		b = b[n:]
+
+		p.remaining -= n
+		if p.remaining == 0 {
+			p.endFrame()
+		}
+	}
+}
+
+func frameHeaderSize(header []byte) int {
+	size := 2
+
+	switch header[1] & lengthMask {
+	case 126:
+		size += 2
+	case 127:
+		size += 8
+	}
+
+	if header[1]&maskBit != 0 {
+		size += 4
+	}
+
+	return size
+}
+
+func (p *frameParser) readHeader(b []byte) []byte {
+	size := 2
+	if len(p.header) >= 2 {
+		size = frameHeaderSize(p.header)
+	}
+
+	n := size - len(p.header)
+	if n > len(b) {
+		n = len(b)
+	}
+
+	p.header = append(p.header, b[:n]...)
+	b = b[n:]
+
+	if len(p.header) < 2 || len(p.header) < frameHeaderSize(p.header) {
+		return b
+	}
+
+	p.startFrame()
+	p.header = p.header[:0]
+
+	return b
+}
+
+func (p *frameParser) startFrame() {
+	h := p.header
+	pos := 2
+
+	var length uint64
+	switch h[1] & lengthMask {
+	case 126:
+		length = uint64(binary.BigEndian.Uint16(h[pos:]))
+		pos += 2
+	case 127:
+		length = binary.BigEndian.Uint64(h[pos:])
+		pos += 8
+	default:
+		length = uint64(h[1] & lengthMask)
+	}
+
+	p.mask = nil
+	if h[1]&maskBit != 0 {
+		p.mask = append(p.mask, h[pos:pos+4]...)
+	}
+
+	p.final = h[0]&finalBit != 0
+	p.maskPos = 0
+	p.remaining = length
+
+	switch h[0] & opcodeMask {
+	case opcodeContinuation:
+		p.data = p.message
+	case opcodeText, opcodeBinary:
+		p.data = true
+		p.message = true
+		p.base64 = p.isBase64()
+		p.pending = nil
+	default:
+		// the control frames can be sent between the frames of a message
+		p.data = false
+	}
+
+	if length == 0 {
+		p.endFrame()
+	}
+}
+
+func (p *frameParser) readPayload(b []byte) {
+	if !p.data {
+		return
+	}
+
+	payload := make([]byte, len(b))
+	copy(payload, b)
+
+	if p.mask != nil {
+		for i := range payload {
+			payload[i] ^= p.mask[p.maskPos%4]
+			p.maskPos++
+		}
+	}
+
+	if !p.base64 {
+		p.emit(payload)
+		return
+	}
+
+	p.pending = append(p.pending, payload...)
+	p.decodePending(len(p.pending) / 4 * 4)
+}
+
+func (p *frameParser) endFrame() {
+	if !p.data || !p.final {
+		return
+	}
+
+	if p.base64 {
+		p.decodePending(len(p.pending))
+	}
+
+	p.message = false
+	p.pending = nil
+}
+
+// decodePending decodes the base64 data received so far, by blocks of four
+// characters until the end of the message
+func (p *frameParser) decodePending(size int) {
+	if size == 0 {
+		return
+	}
+
+	decoded := make([]byte, base64.StdEncoding.DecodedLen(size))
+	n, _ := base64.StdEncoding.Decode(decoded, p.pending[:size])
+	if n > 0 {
+		p.emit(decoded[:n])
+	}
+
+	p.pending = append([]byte(nil), p.pending[size:]...)
+}
@@ -31,6 +31,8 @@ type Session struct {
 
 	proxyPool proxy.Pool
 
+	recorder *Recorder
+
 	// Signal when client disconnects from terminal.
 	DisconnectCh chan error
 	// Signal when terminal session timeout.
@@ -111,6 +113,7 @@ func (s *Session) setMux() {
 	s.mux = http.NewServeMux()
 	s.mux.Handle(s.Endpoint+"/proxy/", s.withAuthorization(http.HandlerFunc(s.proxyHandler)))
 	s.mux.Handle(s.Endpoint+"/exec", s.withAuthorization(http.HandlerFunc(s.execHandler)))
+	s.mux.Handle(s.Endpoint+"/port-forward/", s.withAuthorization(http.HandlerFunc(s.portForwardHandler)))
 }
 
 func (s *Session) proxyHandler(w http.ResponseWriter, r *http.Request) {
@@ -168,11 +171,47 @@ func (s *Session) execHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	w, stopRecording, err := s.startRecording(w, r)
+	if err != nil {
+		s.closeTerminalConn(terminalConn)
+
+		logger.WithError(err).Error("Failed to record terminal session")
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	defer stopRecording()
 	defer s.closeTerminalConn(terminalConn)
 	logger.Debugln("Starting terminal session")
 	terminalConn.Start(w, r, s.TimeoutCh, s.DisconnectCh)
 }
 
+// startRecording records the terminal session when a recorder is set, the
+// terminal is denied when it can't be recorded
+func (s *Session) startRecording(w http.ResponseWriter, r *http.Request) (http.ResponseWriter, func(), error) {
+	s.lock.Lock()
+	recorder := s.recorder
+	s.lock.Unlock()
+
+	if recorder == nil {
+		return w, func() {}, nil
+	}
+
+	rec, err := recorder.start(r)
+	if err != nil {
+		return w, nil, err
+	}
+
+	stop := func() {
+		err := rec.close()
+		if err != nil {
+			s.log.WithError(err).Warn("Failed to write terminal session recording")
+		}
+	}
+
+	return &recordingResponseWriter{ResponseWriter: w, recording: rec}, stop, nil
+}
+
 func (s *Session) terminalAvailable() bool {
 	s.lock.Lock()
 	defer s.lock.Unlock()
@@ -219,6 +258,13 @@ func (s *Session) SetInteractiveTerminal(interactiveTerminal terminal.Interactiv
 	s.interactiveTerminal = interactiveTerminal
 }
 
+// SetRecorder records the next terminal sessions with the recorder
+func (s *Session) SetRecorder(recorder *Recorder) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.recorder = recorder
+}
+
 func (s *Session) SetProxyPool(pooler proxy.Pooler) {
 	s.lock.Lock()
 	defer s.lock.Unlock()